@@ -0,0 +1,189 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+const (
+	logFormatJSON = "json"
+	logFormatCLF  = "clf"
+	logFormatNone = "none"
+)
+
+var (
+	accessLogFormat = flags.String("access-log", logFormatJSON, "access log format, one of: json, clf, none")
+	accessLogSample = flags.Float64("access-log-sample", 1, "fraction of successful requests written to the access log, errors are always logged")
+	logLevel        = flags.String("log-level", levelInfo, "minimum level of logged messages, one of: debug, info, warn, error")
+)
+
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+var levels = map[string]int{levelDebug: 0, levelInfo: 1, levelWarn: 2, levelError: 3}
+
+var accessLogger = log.New(os.Stdout, "", 0)
+
+func validateLogFlags() error {
+	if _, ok := levels[*logLevel]; !ok {
+		return fmt.Errorf("unknown log level %q", *logLevel)
+	}
+	switch *accessLogFormat {
+	case logFormatJSON, logFormatCLF, logFormatNone:
+	default:
+		return fmt.Errorf("unknown access log format %q", *accessLogFormat)
+	}
+	if *accessLogSample < 0 || *accessLogSample > 1 {
+		return fmt.Errorf("access log sample must be between 0 and 1")
+	}
+	return nil
+}
+
+func enabled(level string) bool {
+	return levels[level] >= levels[*logLevel]
+}
+
+// debugf logs a message only when the debug level is enabled.
+func debugf(format string, args ...any) {
+	if enabled(levelDebug) {
+		log.Printf(format, args...)
+	}
+}
+
+// accessEntry collects what is known about a request while it is
+// handled and is written to the access log when it completes.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"requestId"`
+	TraceId   string    `json:"traceId,omitempty"`
+	Client    string    `json:"client"`
+	Method    string    `json:"method"`
+	Uri       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Backend   string    `json:"backend,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"durationMs"`
+	Retries   int       `json:"retries"`
+}
+
+type accessEntryKey struct{}
+
+// currentEntry returns the access log entry of the request, or a
+// throwaway one if the request is not logged.
+func currentEntry(r *http.Request) *accessEntry {
+	if e, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		return e
+	}
+	return new(accessEntry)
+}
+
+// accessRecorder captures the status and the size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog logs the request once it is handled.
+func withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if *accessLogFormat == logFormatNone {
+			next(rw, r)
+			return
+		}
+
+		entry := &accessEntry{
+			Time:      time.Now(),
+			RequestId: requestId(r),
+			Client:    getRemoteIp(r),
+			Method:    r.Method,
+			Uri:       r.RequestURI,
+			Proto:     r.Proto,
+		}
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			entry.TraceId = span.Context().TraceID.String()
+		}
+		rec := &accessRecorder{ResponseWriter: rw}
+		next(rec, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Bytes = rec.bytes
+		entry.Duration = float64(time.Since(entry.Time).Microseconds()) / 1000
+		writeAccessLog(entry)
+	}
+}
+
+func writeAccessLog(e *accessEntry) {
+	level := levelInfo
+	if e.Status >= http.StatusInternalServerError {
+		level = levelWarn
+	}
+	if !enabled(level) || (level == levelInfo && mathrand.Float64() >= *accessLogSample) {
+		return
+	}
+	switch *accessLogFormat {
+	case logFormatJSON:
+		data, _ := json.Marshal(e)
+		accessLogger.Println(string(data))
+	case logFormatCLF:
+		accessLogger.Println(e.clf())
+	}
+}
+
+// clf formats the entry in the Common Log Format followed by the
+// balancer specific fields.
+func (e *accessEntry) clf() string {
+	backend := e.Backend
+	if backend == "" {
+		backend = "-"
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d backend=%s duration=%.3fms retries=%d request_id=%s`,
+		e.Client, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Uri, e.Proto,
+		e.Status, e.Bytes, backend, e.Duration, e.Retries, e.RequestId)
+}
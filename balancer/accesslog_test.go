@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestAccessLog(c *C) {
+	var requestId string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requestId = r.Header.Get(*requestIdHeader)
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	restore := withBackends(c, strategyRoundRobin, addr)
+	defer restore()
+	var out bytes.Buffer
+	prevLogger := accessLogger
+	accessLogger = log.New(&out, "", 0)
+	defer func() { accessLogger = prevLogger }()
+
+	r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	rw := httptest.NewRecorder()
+	serve(rw, r)
+
+	var entry accessEntry
+	c.Assert(json.Unmarshal(out.Bytes(), &entry), IsNil)
+	c.Assert(entry.RequestId, Not(Equals), "")
+	c.Assert(entry.RequestId, Equals, requestId)
+	c.Assert(rw.Header().Get(*requestIdHeader), Equals, requestId)
+	c.Assert(entry.Backend, Equals, addr)
+	c.Assert(entry.Status, Equals, http.StatusOK)
+	c.Assert(entry.Bytes, Equals, int64(2))
+	c.Assert(entry.Uri, Equals, "/api/v1/some-data")
+}
+
+func (s *BalancerSuite) TestAccessLogCLF(c *C) {
+	entry := accessEntry{
+		Time:      time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		RequestId: "abc",
+		Client:    "10.0.0.1",
+		Method:    "GET",
+		Uri:       "/report",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     42,
+		Duration:  1.5,
+		Retries:   1,
+	}
+	c.Assert(entry.clf(), Equals,
+		`10.0.0.1 - - [01/May/2024:10:00:00 +0000] "GET /report HTTP/1.1" 200 42 backend=- duration=1.500ms retries=1 request_id=abc`)
+}
+
+func (s *BalancerSuite) TestTracePropagation(c *C) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(server.URL, "http://"))
+	defer restore()
+	prevLogger := accessLogger
+	accessLogger = log.New(io.Discard, "", 0)
+	defer func() { accessLogger = prevLogger }()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(httptest.NewRecorder(), r)
+
+	c.Assert(traceparent, Matches, "00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01")
+	c.Assert(strings.Contains(traceparent, "00f067aa0ba902b7"), Equals, false)
+}
@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	allowCIDRs        = flags.String("allow", "", "comma separated list of client CIDRs (or IPs) allowed to use the balancer, empty allows any")
+	denyCIDRs         = flags.String("deny", "", "comma separated list of client CIDRs (or IPs) rejected by the balancer")
+	aclTrustForwarded = flags.Bool("acl-trust-forwarded", false, "whether access lists check the X-Forwarded-For/Forwarded client instead of the connection peer")
+)
+
+// AccessConfig filters clients by address. Denied addresses are rejected
+// even if they are allowed, and a non-empty allow list rejects any other
+// address.
+type AccessConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+
+	allow, deny []netip.Prefix
+}
+
+func (ac *AccessConfig) empty() bool {
+	return len(ac.Allow) == 0 && len(ac.Deny) == 0
+}
+
+// parse validates the lists and prepares them for matching.
+func (ac *AccessConfig) parse() error {
+	var err error
+	if ac.allow, err = parsePrefixes(ac.Allow); err != nil {
+		return err
+	}
+	ac.deny, err = parsePrefixes(ac.Deny)
+	return err
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (ac *AccessConfig) permits(addr netip.Addr) bool {
+	for _, prefix := range ac.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(ac.allow) == 0 {
+		return true
+	}
+	for _, prefix := range ac.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAllowed checks the client against the global access lists and
+// the ones of the route matching the request path.
+func clientAllowed(r *http.Request) bool {
+	c := currentConfig()
+	route := c.route(r.URL.Path)
+	if c.Access.empty() && (route == nil || route.Access.empty()) {
+		return true
+	}
+	client := r.RemoteAddr
+	if *aclTrustForwarded {
+		client = getRemoteIp(r)
+	}
+	addr, err := parseClientAddr(client)
+	if err != nil {
+		return false
+	}
+	return c.Access.permits(addr) && (route == nil || route.Access.permits(addr))
+}
+
+// withAccessControl rejects clients not permitted by the access lists.
+func withAccessControl(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !clientAllowed(r) {
+			log.Printf("Rejected %s %s from %s (request %s) by the access lists", r.Method, r.URL, r.RemoteAddr, requestId(r))
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(rw, r)
+	}
+}
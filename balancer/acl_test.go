@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestAccessConfig(c *C) {
+	ac := AccessConfig{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.10"},
+		Deny:  []string{"10.0.5.0/24"},
+	}
+	c.Assert(ac.parse(), IsNil)
+
+	allowed := []string{"10.1.2.3", "192.168.1.10", "2001:db8::1", "::ffff:10.0.0.1"}
+	for _, addr := range allowed {
+		c.Assert(ac.permits(netip.MustParseAddr(addr).Unmap()), Equals, true, Commentf(addr))
+	}
+	denied := []string{"10.0.5.7", "192.168.1.11", "2001:db9::1", "8.8.8.8"}
+	for _, addr := range denied {
+		c.Assert(ac.permits(netip.MustParseAddr(addr)), Equals, false, Commentf(addr))
+	}
+
+	c.Assert((&AccessConfig{Deny: []string{"10.0.0.0/33"}}).parse(), NotNil)
+	c.Assert((&AccessConfig{Allow: []string{"localhost"}}).parse(), NotNil)
+}
+
+func (s *BalancerSuite) TestRouteAccessControl(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080")
+	defer restore()
+	cfg := *config
+	cfg.Routes = []RouteConfig{{Prefix: "/admin/", Access: AccessConfig{Allow: []string{"10.0.0.0/8"}}}}
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(apply(&cfg), IsNil)
+
+	handler := withAccessControl(func(rw http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		path, remote, forwarded string
+		code                    int
+	}{
+		{"/api/v1/some-data", "8.8.8.8:1234", "", http.StatusOK},
+		{"/admin/backends", "10.0.0.2:1234", "", http.StatusOK},
+		{"/admin/backends", "8.8.8.8:1234", "", http.StatusForbidden},
+		{"/admin/backends", "8.8.8.8:1234", "10.0.0.2", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+		c.Assert(rw.Code, Equals, tc.code, Commentf("%s from %s", tc.path, tc.remote))
+	}
+}
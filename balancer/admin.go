@@ -0,0 +1,206 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+var (
+	adminPort  = flags.Int("admin-port", 0, "port of the admin API listener (0 disables it)")
+	adminToken = flags.String("admin-token", "", "bearer token required by the admin API, overrides $"+adminTokenEnv)
+)
+
+const adminTokenEnv = "LB_ADMIN_TOKEN"
+
+// BackendStatus is the runtime view of a backend returned by the admin
+// API. Drained is set once a draining backend can be stopped without
+// failing requests.
+type BackendStatus struct {
+	Address  string `json:"address"`
+	Weight   int    `json:"weight"`
+	Backup   bool   `json:"backup"`
+	Zone     string `json:"zone,omitempty"`
+	Healthy  bool   `json:"healthy"`
+	Ejected  bool   `json:"ejected"`
+	Drain    bool   `json:"drain"`
+	Drained  bool   `json:"drained"`
+	InFlight int    `json:"inFlight"`
+
+	LastCheck         *time.Time `json:"lastCheck,omitempty"`
+	LastCheckOk       bool       `json:"lastCheckOk"`
+	LastCheckDuration float64    `json:"lastCheckDurationMs"`
+
+	SLO *SLOStatus `json:"slo,omitempty"`
+}
+
+func backendStatuses() []BackendStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+	now := time.Now()
+	res := make([]BackendStatus, len(config.Backends))
+	for i, backend := range config.Backends {
+		status := BackendStatus{
+			Address:  backend.Address,
+			Weight:   backend.Weight,
+			Backup:   backend.Backup,
+			Zone:     backend.Zone,
+			Drain:    backend.Drain,
+			Drained:  drained(backend.Address, now),
+			InFlight: livePool.inFlight(backend.Address),
+		}
+		if state, ok := livePool.state(backend.Address); ok {
+			status.Weight = state.weight
+			status.Healthy = state.healthy
+			status.Ejected = state.ejected(now)
+			if !state.lastCheck.IsZero() {
+				lastCheck := state.lastCheck
+				status.LastCheck = &lastCheck
+				status.LastCheckOk = state.lastCheckOk
+				status.LastCheckDuration = float64(state.lastCheckDuration.Microseconds()) / 1000
+			}
+			if config.SLO.enabled() {
+				state.passive.Lock()
+				slo := state.slo.report(config.SLO, now)
+				state.passive.Unlock()
+				status.SLO = &slo
+			}
+		}
+		res[i] = status
+	}
+	return res
+}
+
+var updateMu sync.Mutex
+
+// updateConfig applies a modified copy of the current config. Changes
+// made this way last until the next reload of the config file.
+func updateConfig(modify func(c *Config) error) error {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	c := *currentConfig()
+	c.Backends = slices.Clone(c.Backends)
+	c.Routes = slices.Clone(c.Routes)
+	if err := modify(&c); err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if err := apply(&c); err != nil {
+		return err
+	}
+	requestHealthCheck()
+	return nil
+}
+
+func backendIndex(c *Config, addr string) int {
+	return slices.IndexFunc(c.Backends, func(b BackendConfig) bool {
+		return b.Address == addr
+	})
+}
+
+var errUnknownBackend = fmt.Errorf("unknown backend")
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+func writeUpdateError(rw http.ResponseWriter, err error) {
+	if err == errUnknownBackend {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(rw, err.Error(), http.StatusConflict)
+}
+
+func adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/backends", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, backendStatuses())
+	})
+
+	mux.HandleFunc("POST /admin/backends", func(rw http.ResponseWriter, r *http.Request) {
+		var backend BackendConfig
+		if err := json.NewDecoder(r.Body).Decode(&backend); err != nil {
+			http.Error(rw, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		// The exec probe runs its command on the balancer host, only the
+		// config file may set one.
+		if backend.Probe.Type == probeExec || len(backend.Probe.Command) > 0 {
+			http.Error(rw, "exec probes are only accepted from the config file", http.StatusBadRequest)
+			return
+		}
+		err := updateConfig(func(c *Config) error {
+			c.Backends = append(c.Backends, backend)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+	})
+
+	mux.HandleFunc("DELETE /admin/backends/{host}", func(rw http.ResponseWriter, r *http.Request) {
+		err := updateConfig(func(c *Config) error {
+			i := backendIndex(c, r.PathValue("host"))
+			if i < 0 {
+				return errUnknownBackend
+			}
+			c.Backends = slices.Delete(c.Backends, i, i+1)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /admin/backends/{host}/drain", func(rw http.ResponseWriter, r *http.Request) {
+		drain := r.URL.Query().Get("undo") != "true"
+		err := updateConfig(func(c *Config) error {
+			i := backendIndex(c, r.PathValue("host"))
+			if i < 0 {
+				return errUnknownBackend
+			}
+			c.Backends[i].Drain = drain
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /admin/affinity", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, assignments.list(time.Now()))
+	})
+
+	mux.HandleFunc("DELETE /admin/affinity", func(rw http.ResponseWriter, r *http.Request) {
+		n := assignments.flush(r.URL.Query().Get("backend"))
+		writeJSON(rw, http.StatusOK, map[string]int{"flushed": n})
+	})
+
+	return httptools.Chain(mux, httptools.Recover(), httptools.BearerAuth("lb-admin", []string{token}, nil))
+}
+
+func adminTokenConfig() string {
+	if *adminToken != "" {
+		return *adminToken
+	}
+	return strings.TrimSpace(os.Getenv(adminTokenEnv))
+}
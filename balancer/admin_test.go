@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestAdminBackends(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080")
+	defer restore()
+
+	admin := adminHandler("secret")
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, r)
+		return rw
+	}
+
+	rw := httptest.NewRecorder()
+	admin.ServeHTTP(rw, httptest.NewRequest("GET", "/admin/backends", nil))
+	c.Assert(rw.Code, Equals, http.StatusUnauthorized)
+
+	c.Assert(call("POST", "/admin/backends", `{"address": "server3:8080"}`).Code, Equals, http.StatusCreated)
+	c.Assert(call("POST", "/admin/backends", `{"address": "server3:8080"}`).Code, Equals, http.StatusConflict)
+	c.Assert(call("POST", "/admin/backends", `{"address": "server4:8080", "probe": {"type": "exec", "command": ["true"]}}`).Code, Equals, http.StatusBadRequest)
+	c.Assert(call("DELETE", "/admin/backends/server1:8080", "").Code, Equals, http.StatusNoContent)
+	c.Assert(call("DELETE", "/admin/backends/server1:8080", "").Code, Equals, http.StatusNotFound)
+	c.Assert(call("POST", "/admin/backends/server2:8080/drain", "").Code, Equals, http.StatusNoContent)
+
+	rw = call("GET", "/admin/backends", "")
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	var statuses []BackendStatus
+	c.Assert(json.NewDecoder(rw.Body).Decode(&statuses), IsNil)
+	c.Assert(statuses, HasLen, 2)
+	c.Assert(statuses[0].Address, Equals, "server2:8080")
+	c.Assert(statuses[0].Drain, Equals, true)
+	c.Assert(statuses[1].Address, Equals, "server3:8080")
+	c.Assert(statuses[1].Weight, Equals, 1)
+
+	livePool.healthy = []string{"server2:8080", "server3:8080"}
+	c.Assert(candidates("/"), DeepEquals, []string{"server3:8080"})
+}
@@ -0,0 +1,631 @@
+// Package balancer distributes the requests of clients among the healthy
+// backends with the configured strategy. It is run by cmd/lb and, in
+// process, by the integration tests.
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/chaos"
+	settings "github.com/roman-mazur/architecture-practice-4-template/config"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+)
+
+// flags are the settings of the balancer, parsed from the command line
+// by Main and from the arguments by Serve.
+var flags = flag.NewFlagSet("lb", flag.ContinueOnError)
+
+var options = settings.Options{
+	EnvPrefix: "LB_",
+	Secrets:   []string{"admin-token"},
+	Validate:  validateLogFlags,
+}
+
+var (
+	port        = flags.Int("port", 8090, "load balancer port")
+	timeoutSec  = flags.Int("timeout-sec", 3, "request timeout time in seconds")
+	https       = flags.Bool("https", false, "whether backends support HTTPs")
+	backends    = flags.String("backends", "", "comma-separated list of backend addresses (host:port), overrides $"+backendsEnv)
+	backups     = flags.String("backup-backends", "", "comma-separated list of backend addresses receiving traffic only when no other backend is healthy")
+	configFile  = flags.String("config", "", "path to a YAML/JSON config file, reloaded on SIGHUP")
+	hashKeyFlag = flags.String("hash-key", hashForwardedFor, "request part the ip-hash and sticky strategies bind clients by: "+hashKeyFormatHelp)
+	strategy    = flags.String("strategy", strategyIpHash, "balancing strategy, one of: "+strings.Join(strategies(), ", "))
+
+	traceEnabled = flags.Bool("trace", false, "whether to include tracing information into responses")
+	otlpEndpoint = flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector spans are exported to (empty disables the export)")
+	chaosConfig  = flags.String("chaos-config", os.Getenv("CHAOS_CONFIG"), "JSON file with the faults injected into the requests for resilience tests (empty disables them)")
+)
+
+var errNoHealthyBackends = fmt.Errorf("no healthy backends")
+
+const (
+	backendsEnv     = "LB_BACKENDS"
+	defaultBackends = "server1:8080,server2:8080,server3:8080"
+)
+
+var (
+	mu             sync.RWMutex
+	config         *Config
+	balancer       Balancer
+	routeBalancers = map[string]Balancer{}
+)
+
+func currentConfig() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config
+}
+
+func parseBackends(list string) ([]string, error) {
+	var res []string
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend address %q: %w", addr, err)
+		}
+		if host == "" {
+			return nil, fmt.Errorf("invalid backend address %q: missing host", addr)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid backend address %q: bad port %q", addr, port)
+		}
+		for _, existing := range res {
+			if existing == addr {
+				return nil, fmt.Errorf("duplicate backend address %q", addr)
+			}
+		}
+		res = append(res, addr)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no backends configured")
+	}
+	return res, nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+func backendsConfig() string {
+	if *backends != "" {
+		return *backends
+	}
+	if env := os.Getenv(backendsEnv); env != "" {
+		return env
+	}
+	return defaultBackends
+}
+
+func scheme() string {
+	if *https {
+		return "https"
+	}
+	return "http"
+}
+
+// parseClientAddr parses a client address. It accepts bare IPv4/IPv6
+// addresses as well as host:port forms, including bracketed IPv6 ones.
+// Zones are dropped and IPv4-mapped IPv6 addresses are unmapped.
+func parseClientAddr(ipStr string) (netip.Addr, error) {
+	host := strings.TrimSpace(ipStr)
+
+	if _, err := netip.ParseAddr(host); err != nil {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// ipToHashNumber hashes the client address. IPv4 (and IPv4-mapped IPv6)
+// addresses are hashed by their 4 bytes, other IPv6 addresses by the
+// full 16 bytes.
+func ipToHashNumber(ipStr string) (uint64, error) {
+	addr, err := parseClientAddr(ipStr)
+	if err != nil {
+		return 0, err
+	}
+
+	var hash [sha256.Size]byte
+	if addr.Is4() {
+		ipv4 := addr.As4()
+		hash = sha256.Sum256(ipv4[:])
+	} else {
+		ipv6 := addr.As16()
+		hash = sha256.Sum256(ipv6[:])
+	}
+	number := binary.BigEndian.Uint64(hash[:8])
+	return number, nil
+}
+
+// candidates returns healthy backends eligible for the path, except the
+// excluded ones, each one repeated according to its weight. Backends of
+// other zones are only returned when none of the local ones is
+// available, and backup backends when no primary one is.
+func candidates(path string, exclude ...string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	route := config.route(path)
+	now := time.Now()
+	var local, remote, backup []string
+	for _, backend := range config.Backends {
+		if !livePool.available(backend.Address, now) {
+			continue
+		}
+		if backend.Drain || slices.Contains(exclude, backend.Address) {
+			continue
+		}
+		if route != nil && len(route.Backends) > 0 && !slices.Contains(route.Backends, backend.Address) {
+			continue
+		}
+		for range backend.Weight {
+			switch {
+			case backend.Backup:
+				backup = append(backup, backend.Address)
+			case config.local(backend):
+				local = append(local, backend.Address)
+			default:
+				remote = append(remote, backend.Address)
+			}
+		}
+	}
+	switch {
+	case len(local) > 0:
+		return local
+	case len(remote) > 0:
+		return remote
+	}
+	return backup
+}
+
+// pick selects a backend for the request using the configured strategy,
+// skipping the excluded ones.
+func pick(r *http.Request, exclude ...string) (dst string, err error) {
+	_, span := tracing.Start(r.Context(), "pick backend", tracing.KindInternal)
+	defer func() {
+		span.SetAttribute("lb.backend", dst)
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}()
+	pool := candidates(r.URL.Path, exclude...)
+	span.SetAttribute("lb.candidates", len(pool))
+	span.SetAttribute("lb.excluded", len(exclude))
+	if len(pool) == 0 {
+		return "", errNoHealthyBackends
+	}
+	mu.RLock()
+	span.SetAttribute("lb.healthy_backends", len(livePool.healthy))
+	b := balancer
+	if route := config.route(r.URL.Path); route != nil && routeBalancers[route.Prefix] != nil {
+		b = routeBalancers[route.Prefix]
+	}
+	mu.RUnlock()
+	if hb, ok := b.(ipHashBalancer); ok {
+		key, err := hb.key.hash(r)
+		if err != nil {
+			return "", err
+		}
+		if dst, ok := stickyDrainingBackend(key, exclude); ok {
+			span.SetAttribute("lb.draining", true)
+			return dst, nil
+		}
+		defer func() {
+			if err == nil {
+				affinity.record(key, dst, time.Now())
+			}
+		}()
+	}
+	dst, err = b.Pick(pool, r)
+	if err != nil || admit(dst) {
+		return dst, err
+	}
+	span.SetAttribute("lb.slow_start_diverted", dst)
+	// The backend is still ramping up after joining the pool, divert the
+	// request unless there is nowhere else to send it.
+	rest := slices.DeleteFunc(pool, func(addr string) bool {
+		return addr == dst
+	})
+	if len(rest) == 0 {
+		return dst, nil
+	}
+	return b.Pick(rest, r)
+}
+
+func apply(c *Config) error {
+	b, err := newBalancer(c.Strategy, c.HashKey)
+	if err != nil {
+		return err
+	}
+	routes := make(map[string]Balancer, len(c.Routes))
+	for _, route := range c.Routes {
+		if route.Strategy == "" {
+			continue
+		}
+		if routes[route.Prefix], err = newBalancer(route.Strategy, route.hashKey(c)); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if config == nil || config.Strategy != c.Strategy || config.HashKey != c.HashKey {
+		balancer = b
+	}
+	// Keep the state (e.g. the round-robin position) of unchanged routes.
+	for prefix := range routes {
+		if config == nil {
+			break
+		}
+		prev := config.route(prefix)
+		next := c.route(prefix)
+		if prev != nil && prev.Prefix == prefix && prev.Strategy == next.Strategy &&
+			prev.hashKey(config) == next.hashKey(c) && routeBalancers[prefix] != nil {
+			routes[prefix] = routeBalancers[prefix]
+		}
+	}
+	routeBalancers = routes
+	config = c
+	for _, backend := range c.Backends {
+		if state, ok := livePool.state(backend.Address); ok {
+			state.weight = backend.Weight
+		}
+	}
+	updateDrainStarted(c, time.Now())
+	assignments.configure(c.Sticky)
+	return nil
+}
+
+// stickyDrainingBackend returns the draining backend the client was
+// bound to, as long as its grace period lasts and it is still healthy.
+func stickyDrainingBackend(key uint64, exclude []string) (string, bool) {
+	e, ok := affinity.lookup(key)
+	if !ok || slices.Contains(exclude, e.backend) {
+		return "", false
+	}
+	now := time.Now()
+	mu.RLock()
+	defer mu.RUnlock()
+	if !inGrace(e.backend, now) || !livePool.available(e.backend, now) {
+		return "", false
+	}
+	return e.backend, true
+}
+
+func reload() {
+	c, err := loadConfig(*configFile)
+	if err == nil {
+		err = apply(c)
+	}
+	if err != nil {
+		log.Printf("Config reload failed, keeping the previous one: %s", err)
+		return
+	}
+	healthCheck()
+	requestDiscovery()
+	log.Printf("Config reloaded, backends: %s", backendAddresses(c))
+}
+
+func backendAddresses(c *Config) string {
+	addresses := make([]string, len(c.Backends))
+	for i, backend := range c.Backends {
+		addresses[i] = backend.Address
+	}
+	return strings.Join(addresses, ", ")
+}
+
+func getRemoteIp(r *http.Request) string {
+	if !trustedPeer(r) {
+		return r.RemoteAddr
+	}
+
+	if client := forwardedClient(r.Header.Values("X-Forwarded-For")); client != "" {
+		return client
+	}
+
+	forwarder := r.Header.Get("Forwarded")
+
+	if forwarder != "" {
+		for _, part := range strings.Split(strings.Split(forwarder, ",")[0], ";") {
+			part = strings.TrimSpace(part)
+			if strings.HasPrefix(strings.ToLower(part), "for=") {
+				return strings.Trim(part[len("for="):], `"`)
+			}
+		}
+	}
+
+	return r.RemoteAddr
+}
+
+// serve handles the balancer's own endpoints and forwards everything else.
+func serve(rw http.ResponseWriter, r *http.Request) {
+	if *metricsPath != "" && r.URL.Path == *metricsPath && r.Method == http.MethodGet {
+		metrics.Default.ServeHTTP(rw, r)
+		return
+	}
+	if *statusPath != "" && r.URL.Path == *statusPath && r.Method == http.MethodGet {
+		serveStatus(rw, r)
+		return
+	}
+	if *versionPath != "" && r.URL.Path == *versionPath && r.Method == http.MethodGet {
+		version.Handler().ServeHTTP(rw, r)
+		return
+	}
+	if *selfHealthPath != "" && r.URL.Path == *selfHealthPath && r.Method == http.MethodGet {
+		serveSelfHealth(rw, r)
+		return
+	}
+	tracing.Handler("lb", withRequestId(withAccessLog(withAccessControl(handle)))).ServeHTTP(rw, r)
+}
+
+func handle(rw http.ResponseWriter, r *http.Request) {
+	ip := getRemoteIp(r)
+
+	release, err := admitRequest(r)
+	if err != nil {
+		log.Printf("Rejecting %s %s: %s", r.Method, r.URL, err)
+		writeError(rw, r, http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	if isUpgrade(r) {
+		dst, err := pick(r)
+		switch err {
+		case nil:
+			currentEntry(r).Backend = dst
+			release, err := acquireSlot(r.Context(), dst)
+			if err != nil {
+				writeError(rw, r, http.StatusServiceUnavailable)
+				return
+			}
+			defer release()
+			if tunnel(dst, rw, r, ip) != nil {
+				writeError(rw, r, http.StatusServiceUnavailable)
+			}
+		case errNoHealthyBackends:
+			writeError(rw, r, http.StatusBadGateway)
+		default:
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+
+	limit := *retryBodyLimit
+	if isGrpc(r.Header) {
+		// gRPC calls may stream in both directions, so they cannot wait
+		// for the whole request.
+		limit = -1
+	}
+	body, rest, retryable, err := retryBody(r, limit)
+	if err != nil {
+		fmt.Println("Error:", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.Body = rest
+	mirror(r, body, retryable)
+
+	c := currentConfig()
+	hedging := c.hedging(r.URL.Path)
+	hedged := hedging.Delay > 0 && retryable && idempotent(r.Method)
+
+	var tried []string
+	for {
+		dst, err := pick(r, tried...)
+
+		switch err {
+		case nil:
+		case errNoHealthyBackends:
+			if len(tried) > 0 {
+				writeError(rw, r, http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Println("Error: No health servers")
+			writeError(rw, r, http.StatusBadGateway)
+			return
+		default:
+			fmt.Println("Error:", err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		release, err := acquireSlot(r.Context(), dst)
+		if err != nil {
+			// Nothing has been sent yet, so any request can go elsewhere.
+			log.Printf("Backend %s is saturated: %s", dst, err)
+			tried = append(tried, dst)
+			continue
+		}
+
+		debugf("Forwarding %s to %s", ip, dst)
+		currentEntry(r).Backend = dst
+
+		// The slot is released even when the proxy aborts the handler on
+		// a response cut short by the backend.
+		err = func() error {
+			defer release()
+			if hedged {
+				return hedge(dst, rw, r, ip, body, hedging, &tried)
+			}
+			err := forward(dst, rw, r, ip)
+			tried = append(tried, dst)
+			return err
+		}()
+		if err == nil {
+			return
+		}
+		if !retryable || !(idempotent(r.Method) || notSent(err)) || len(tried) > c.retries(r.URL.Path) || r.Context().Err() != nil {
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, errBackendTimeout) {
+				status = http.StatusGatewayTimeout
+			}
+			writeError(rw, r, status)
+			return
+		}
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		retriesTotal.Inc()
+		currentEntry(r).Retries++
+		log.Printf("Retrying %s %s (request %s) on another backend", r.Method, r.URL, requestId(r))
+	}
+}
+
+// Main runs the balancer configured by the command line until SIGINT or
+// SIGTERM.
+func Main() {
+	settings.ParseSet(flags, options)
+	if err := run(signal.TerminationContext(), nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serve runs the balancer configured by args until ctx is done, serving
+// the frontend on l instead of -port, e.g. in-process in tests. The
+// arguments are parsed into the flags of the package, so Serve is called
+// once per process.
+func Serve(ctx context.Context, args []string, l net.Listener) error {
+	if _, err := settings.Load(flags, args, options); err != nil {
+		return err
+	}
+	return run(ctx, l)
+}
+
+func run(ctx context.Context, l net.Listener) error {
+	tracing.Configure("lb", *otlpEndpoint)
+	metrics.Configure("lb")
+	var err error
+	if trustedProxyList, err = parsePrefixes(splitList(*trustedProxies)); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	backendTLSConfig, err := backendTLS()
+	if err != nil {
+		return fmt.Errorf("invalid backend TLS configuration: %w", err)
+	}
+	transport := newTransport(backendTLSConfig)
+	backendClient.Transport = transport
+	healthClient = newHealthClient(backendTLSConfig)
+	if *grpcMode {
+		backendClient.Transport = newGrpcTransport(transport)
+	}
+
+	c, err := loadConfig(*configFile)
+	if err == nil {
+		err = apply(c)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	chaosRules, err := chaos.Load(*chaosConfig)
+	if err != nil {
+		return fmt.Errorf("invalid chaos configuration: %w", err)
+	}
+
+	healthCheck()
+
+	go healthCheckLoop(ctx)
+	go discoveryLoop(ctx)
+	go sloLoop(ctx)
+
+	signal.OnReload(reload)
+
+	tlsConfig, err := frontendTLS()
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	handler := withH2C(httptools.Chain(http.HandlerFunc(serve),
+		httptools.Recover(),
+		httptools.Metrics(metrics.Default, metricsRoute),
+		chaos.Middleware(chaosRules),
+	))
+	frontend := httptools.CreateServer(*port, handler)
+	if tlsConfig != nil {
+		frontend = httptools.CreateTLSServer(*port, handler, tlsConfig)
+	}
+	if l == nil {
+		if l, err = listen("frontend", *port); err != nil {
+			return fmt.Errorf("cannot listen on port %d: %w", *port, err)
+		}
+	}
+	frontend, err = withProxyProtocol(httptools.WithListener(frontend, l))
+	if err != nil {
+		return fmt.Errorf("invalid PROXY protocol configuration: %w", err)
+	}
+	servers := []httptools.Server{frontend}
+
+	if *adminPort != 0 {
+		token := adminTokenConfig()
+		if token == "" {
+			return fmt.Errorf("the admin API requires -admin-token or $%s", adminTokenEnv)
+		}
+		l, err := listen("admin", *adminPort)
+		if err != nil {
+			return fmt.Errorf("cannot listen on port %d: %w", *adminPort, err)
+		}
+		admin := httptools.WithListener(httptools.CreateServer(*adminPort, adminHandler(token)), l)
+		admin.Start()
+		servers = append(servers, admin)
+	}
+
+	log.Println("Starting load balancer...")
+	log.Printf("Tracing support enabled: %t", *traceEnabled)
+	log.Printf("Client TLS enabled: %t", tlsConfig != nil)
+	log.Printf("Backends: %s", backendAddresses(config))
+	log.Printf("Balancing strategy: %s", config.Strategy)
+	if *warmupTimeout > 0 && !waitForBackends(*warmupTimeout) {
+		log.Printf("No healthy backends after %s, accepting connections anyway", *warmupTimeout)
+	}
+	frontend.Start()
+	notifyReady()
+	signal.OnUpgrade(func() {
+		upgrade(servers...)
+	})
+	<-ctx.Done()
+
+	// Main exits right away on SIGTERM. In-process, the balancer
+	// stops listening without waiting for the active requests.
+	stopped, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, s := range servers {
+		_ = s.Shutdown(stopped)
+	}
+	return nil
+}
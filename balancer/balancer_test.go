@@ -0,0 +1,253 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BalancerSuite struct{}
+
+var _ = Suite(&BalancerSuite{})
+
+func (s *BalancerSuite) TestIpToHashNumber(c *C) {
+	ips := []string{
+		"87.154.128.68",
+		"55.234.146.40",
+		"93.167.203.49:8080",
+		"196.16.10.9",
+		"106.246.220.17:2121",
+	}
+
+	expectedIpBindings := [][]string{
+		{"55.234.146.40", "196.16.10.9", "106.246.220.17:2121"},
+		{"93.167.203.49:8080"},
+		{"87.154.128.68"},
+	}
+
+	serversCount := 3
+
+	for _, ip := range ips {
+		hashSum, err := ipToHashNumber(ip)
+		if err != nil {
+			c.Error("unexpected error", err)
+		}
+
+		serverIndex := hashSum % uint64(serversCount)
+
+		isValid := slices.Contains(expectedIpBindings[serverIndex], ip)
+
+		c.Assert(isValid, Equals, true, Commentf("expected %s to be in %v", ip, expectedIpBindings[serverIndex]))
+	}
+
+	invalidIp := "invalidIp"
+
+	_, err := ipToHashNumber(invalidIp)
+
+	c.Assert(err, NotNil, Commentf("expected error for invalid IP address"))
+
+	ipv6 := "2001:0db8:85a3:0000:0000:8a2e:0370:7334"
+
+	expected, err := ipToHashNumber(ipv6)
+
+	c.Assert(err, IsNil, Commentf("unexpected error for IPv6 address"))
+
+	for _, form := range []string{"[2001:db8:85a3::8a2e:370:7334]", "[2001:db8:85a3::8a2e:370:7334]:8080", "2001:db8:85a3::8a2e:370:7334%eth0"} {
+		hashSum, err := ipToHashNumber(form)
+		c.Assert(err, IsNil, Commentf("unexpected error for %s", form))
+		c.Assert(hashSum, Equals, expected, Commentf("expected %s to hash as %s", form, ipv6))
+	}
+
+	other, _ := ipToHashNumber("2001:db8:85a3::8a2e:370:7335")
+
+	c.Assert(other, Not(Equals), expected)
+
+	mapped, _ := ipToHashNumber("::ffff:87.154.128.68")
+	ipv4, _ := ipToHashNumber("87.154.128.68")
+
+	c.Assert(mapped, Equals, ipv4, Commentf("expected IPv4-mapped address to hash as IPv4"))
+}
+
+func (s *BalancerSuite) TestParseBackends(c *C) {
+	pool, err := parseBackends(" server1:8080, server2:8080,,10.0.0.1:80 ")
+	c.Assert(err, IsNil)
+	c.Assert(pool, DeepEquals, []string{"server1:8080", "server2:8080", "10.0.0.1:80"})
+
+	invalid := []string{
+		"",
+		"server1",
+		":8080",
+		"server1:http",
+		"server1:70000",
+		"server1:8080,server1:8080",
+	}
+	for _, list := range invalid {
+		_, err := parseBackends(list)
+		c.Assert(err, NotNil, Commentf("expected error for %q", list))
+	}
+}
+
+func (s *BalancerSuite) TestLoadConfig(c *C) {
+	filename := filepath.Join(c.MkDir(), "balancer.yaml")
+	data := `
+backends:
+  - address: server1:8080
+    weight: 2
+  - address: server2:8080
+healthCheck:
+  path: /ready
+  interval: 5s
+  timeout: 1s
+timeout: 2s
+routes:
+  - prefix: /api/
+    backends: [server2:8080]
+`
+	c.Assert(os.WriteFile(filename, []byte(data), 0o600), IsNil)
+
+	cfg, err := loadConfig(filename)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Backends, DeepEquals, []BackendConfig{
+		{Address: "server1:8080", Weight: 2},
+		{Address: "server2:8080", Weight: 1},
+	})
+	c.Assert(cfg.HealthCheck.Path, Equals, "/ready")
+	c.Assert(cfg.HealthCheck.Interval, Equals, 5*time.Second)
+	c.Assert(cfg.Timeout, Equals, 2*time.Second)
+
+	config = cfg
+	livePool.healthy = []string{"server1:8080", "server2:8080"}
+	c.Assert(candidates("/report"), DeepEquals, []string{"server1:8080", "server1:8080", "server2:8080"})
+	c.Assert(candidates("/api/v1/some-data"), DeepEquals, []string{"server2:8080"})
+
+	invalid := `
+backends:
+  - address: server1:8080
+routes:
+  - prefix: /api/
+    backends: [server3:8080]
+`
+	c.Assert(os.WriteFile(filename, []byte(invalid), 0o600), IsNil)
+	_, err = loadConfig(filename)
+	c.Assert(err, NotNil)
+}
+
+func (s *BalancerSuite) TestRequestTimeout(c *C) {
+	cfg := defaultConfig()
+	c.Assert(cfg.Timeout, Equals, time.Duration(*timeoutSec)*time.Second)
+
+	cfg.Backends = []BackendConfig{
+		{Address: "server1:8080", Timeout: 5 * time.Second},
+		{Address: "server2:8080"},
+	}
+	cfg.Routes = []RouteConfig{
+		{Prefix: "/report", Timeout: time.Second},
+		{Prefix: "/api/"},
+	}
+	c.Assert(cfg.requestTimeout("server1:8080", "/report"), Equals, time.Second)
+	c.Assert(cfg.requestTimeout("server1:8080", "/api/v1/some-data"), Equals, 5*time.Second)
+	c.Assert(cfg.requestTimeout("server2:8080", "/api/v1/some-data"), Equals, cfg.Timeout)
+}
+
+func (s *BalancerSuite) TestTimeoutBeyondWriteTimeout(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer backend.Close()
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(backend.URL, "http://"))
+	defer restore()
+	config.Routes = []RouteConfig{{Prefix: "/api/", Timeout: 2 * time.Second}}
+
+	lb := httptest.NewUnstartedServer(http.HandlerFunc(handle))
+	lb.Config.WriteTimeout = 100 * time.Millisecond
+	lb.Start()
+	defer lb.Close()
+
+	resp, err := http.Get(lb.URL + "/api/v1/some-data")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "OK")
+}
+
+func (s *BalancerSuite) TestRouteWithoutBackends(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+	restore := withBackends(c, strategyRoundRobin, addr)
+	defer restore()
+	config.Routes = []RouteConfig{{Prefix: "/report", Timeout: time.Second}}
+
+	dst, err := pick(httptest.NewRequest("GET", "/report", nil))
+	c.Assert(err, IsNil)
+	c.Assert(dst, Equals, addr)
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("GET", "/report", nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	c.Assert(rw.Body.String(), Equals, "OK")
+}
+
+func (s *BalancerSuite) TestRouteStrategies(c *C) {
+	restore := withBackends(c, strategyIpHash, "server1:8080", "server2:8080", "server3:8080")
+	defer restore()
+
+	cfg := *config
+	cfg.Routes = []RouteConfig{
+		{Prefix: "/api/*", Backends: []string{"server1:8080", "server2:8080"}, Strategy: strategyRoundRobin},
+		{Prefix: "/report", Backends: []string{"server3:8080"}},
+	}
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(cfg.Routes[0].Prefix, Equals, "/api/")
+	c.Assert(apply(&cfg), IsNil)
+
+	var picked []string
+	for range 4 {
+		r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		dst, err := pick(r)
+		c.Assert(err, IsNil)
+		picked = append(picked, dst)
+	}
+	c.Assert(picked[0], Not(Equals), picked[1], Commentf("the route balances round-robin instead of by client IP"))
+	c.Assert(picked[0], Equals, picked[2])
+
+	dst, err := pick(httptest.NewRequest("GET", "/report", nil))
+	c.Assert(err, IsNil)
+	c.Assert(dst, Equals, "server3:8080")
+
+	cfg.Routes[1].Strategy = "unknown"
+	c.Assert(cfg.validate(), NotNil)
+}
+
+func (s *BalancerSuite) TestBackupPool(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080", "backup:8080")
+	defer restore()
+	config.Backends[2].Backup = true
+
+	c.Assert(candidates("/"), DeepEquals, []string{"server1:8080", "server2:8080"})
+
+	livePool.healthy = []string{"server2:8080", "backup:8080"}
+	livePool.states["server2:8080"] = &backendHealth{ejectedUntil: time.Now().Add(time.Minute)}
+	c.Assert(candidates("/"), DeepEquals, []string{"backup:8080"}, Commentf("backups take over when no primary is available"))
+
+	livePool.healthy = []string{"server1:8080", "backup:8080"}
+	dst, err := pick(httptest.NewRequest("GET", "/", nil), "server1:8080")
+	c.Assert(err, IsNil)
+	c.Assert(dst, Equals, "backup:8080", Commentf("retries fail over to backups"))
+}
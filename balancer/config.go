@@ -0,0 +1,377 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the balancer setup. It can be loaded from a YAML
+// (or JSON, which is a subset of YAML) file passed with -config.
+type Config struct {
+	Backends    []BackendConfig   `yaml:"backends"`
+	Strategy    string            `yaml:"strategy"`
+	HashKey     string            `yaml:"hashKey"`
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	Retries     int               `yaml:"retries"`
+	Routes      []RouteConfig     `yaml:"routes"`
+	Discovery   DiscoveryConfig   `yaml:"discovery"`
+	SlowStart   time.Duration     `yaml:"slowStart"`
+	DrainGrace  time.Duration     `yaml:"drainGrace"`
+	Zone        string            `yaml:"zone"`
+
+	OutlierDetection OutlierConfig `yaml:"outlierDetection"`
+	Limits           LimitsConfig  `yaml:"limits"`
+	Headers          HeadersConfig `yaml:"headers"`
+	Access           AccessConfig  `yaml:"access"`
+	Mirror           MirrorConfig  `yaml:"mirror"`
+	Sticky           StickyConfig  `yaml:"sticky"`
+	Errors           ErrorsConfig  `yaml:"errors"`
+	SLO              SLOConfig     `yaml:"slo"`
+}
+
+type BackendConfig struct {
+	Address string        `yaml:"address" json:"address"`
+	Weight  int           `yaml:"weight" json:"weight"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// Drain stops sending new requests to the backend. Clients bound to
+	// it by ip-hash keep using it for the drain grace period.
+	Drain bool `yaml:"drain" json:"drain"`
+	// Backup backends only receive traffic when every primary one is
+	// unavailable.
+	Backup bool `yaml:"backup" json:"backup"`
+	// Zone is where the backend runs, see Config.Zone.
+	Zone string `yaml:"zone" json:"zone,omitempty"`
+	// MaxInFlight overrides the per backend concurrency limit.
+	MaxInFlight int `yaml:"maxInFlight" json:"maxInFlight"`
+	// Probe replaces the HTTP health check of backends that do not serve
+	// one.
+	Probe ProbeConfig `yaml:"probe" json:"probe,omitempty"`
+	// Source is set for backends found by service discovery.
+	Source string `yaml:"-" json:"source,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthyThreshold"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"`
+	ExpectedStatus     int           `yaml:"expectedStatus"`
+	ExpectedBody       string        `yaml:"expectedBody"`
+	PassiveFailures    int           `yaml:"passiveFailures"`
+	PassiveCooldown    time.Duration `yaml:"passiveCooldown"`
+	OverloadBackoff    time.Duration `yaml:"overloadBackoff"`
+	Jitter             time.Duration `yaml:"jitter"`
+}
+
+// RouteConfig restricts requests with the path prefix to a subset of
+// backends, optionally balanced with their own strategy. A route without
+// backends keeps all of them. A trailing * in the prefix is ignored, so
+// /api/* and /api/ are the same route.
+//
+// Routes may also override the timeout and retry budget, and hedge slow
+// requests.
+type RouteConfig struct {
+	Prefix   string        `yaml:"prefix"`
+	Backends []string      `yaml:"backends"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Retries  *int          `yaml:"retries"`
+	Hedging  HedgingConfig `yaml:"hedging"`
+	Strategy string        `yaml:"strategy"`
+	HashKey  string        `yaml:"hashKey"`
+	Access   AccessConfig  `yaml:"access"`
+}
+
+// hashKey returns the hash key of the route, falling back to the global one.
+func (r *RouteConfig) hashKey(c *Config) string {
+	if r.HashKey != "" {
+		return r.HashKey
+	}
+	return c.HashKey
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		HealthCheck: HealthCheckConfig{
+			Path:               *healthPath,
+			Interval:           *healthInterval,
+			Timeout:            *healthTimeout,
+			HealthyThreshold:   *healthyThreshold,
+			UnhealthyThreshold: *unhealthyThreshold,
+			ExpectedStatus:     *healthExpectedStatus,
+			ExpectedBody:       *healthExpectedBody,
+			PassiveFailures:    *passiveFailures,
+			PassiveCooldown:    *passiveCooldown,
+			OverloadBackoff:    *overloadBackoff,
+			Jitter:             *healthJitter,
+		},
+		Timeout:    time.Duration(*timeoutSec) * time.Second,
+		Retries:    *retries,
+		Strategy:   *strategy,
+		HashKey:    *hashKeyFlag,
+		SlowStart:  *slowStart,
+		DrainGrace: *drainGrace,
+		Zone:       *zone,
+		Mirror:     mirrorConfig(),
+		Sticky:     StickyConfig{TTL: *stickyTTL, Size: *stickySize},
+		Errors:     errorsConfig(),
+		SLO:        sloConfig(),
+		OutlierDetection: OutlierConfig{
+			Enabled:       *outlierDetection,
+			LatencyFactor: *outlierLatencyFactor,
+			ErrorRatio:    *outlierErrorRatio,
+			MinRequests:   *outlierMinRequests,
+			Ejection:      *outlierEjection,
+			MaxEjected:    *outlierMaxEjected,
+		},
+		Limits: LimitsConfig{
+			MaxInFlight:           *maxInFlight,
+			MaxInFlightPerBackend: *maxInFlightPerBackend,
+			QueueSize:             *queueSize,
+			QueueTimeout:          *queueTimeout,
+			Priority:              priorityConfig(),
+		},
+		Discovery: DiscoveryConfig{
+			DNS: splitList(*dnsBackends),
+			Docker: DockerDiscoveryConfig{
+				Socket:  *dockerSocket,
+				Label:   *dockerLabel,
+				Network: *dockerNetwork,
+				Port:    *dockerPort,
+			},
+			Consul: ConsulDiscoveryConfig{
+				Address: *consulAddress,
+				Service: *consulService,
+				Tag:     *consulTag,
+			},
+			Interval: *discoveryInterval,
+		},
+	}
+}
+
+func loadConfig(filename string) (*Config, error) {
+	config := defaultConfig()
+	headers, err := headersConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Headers = headers
+	config.Access = AccessConfig{Allow: splitList(*allowCIDRs), Deny: splitList(*denyCIDRs)}
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", filename, err)
+		}
+	}
+	if len(config.Backends) == 0 && !config.Discovery.enabled() {
+		pool, err := parseBackends(backendsConfig())
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range pool {
+			config.Backends = append(config.Backends, BackendConfig{Address: addr})
+		}
+		if *backups != "" {
+			pool, err := parseBackends(*backups)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range pool {
+				config.Backends = append(config.Backends, BackendConfig{Address: addr, Backup: true})
+			}
+		}
+	}
+	return config, config.validate()
+}
+
+func (c *Config) validate() error {
+	addresses := make([]string, len(c.Backends))
+	for i := range c.Backends {
+		backend := &c.Backends[i]
+		if backend.Weight == 0 {
+			backend.Weight = 1
+		}
+		if backend.Weight < 0 {
+			return fmt.Errorf("backend %s: negative weight %d", backend.Address, backend.Weight)
+		}
+		if backend.Timeout < 0 {
+			return fmt.Errorf("backend %s: negative timeout", backend.Address)
+		}
+		if err := backend.Probe.validate(); err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Address, err)
+		}
+		addresses[i] = backend.Address
+	}
+	if len(addresses) > 0 {
+		if _, err := parseBackends(strings.Join(addresses, ",")); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.Discovery.DNS {
+		if _, _, err := net.SplitHostPort(name); err != nil {
+			return fmt.Errorf("invalid DNS discovery name %q: %w", name, err)
+		}
+	}
+	if c.Discovery.enabled() && c.Discovery.Interval <= 0 {
+		return fmt.Errorf("discovery interval must be positive")
+	}
+	if c.Discovery.Docker.Label != "" && (c.Discovery.Docker.Port < 1 || c.Discovery.Docker.Port > 65535) {
+		return fmt.Errorf("invalid Docker discovery port %d", c.Discovery.Docker.Port)
+	}
+	if _, err := newBalancer(c.Strategy, c.HashKey); err != nil {
+		return err
+	}
+	if err := c.Mirror.validate(); err != nil {
+		return err
+	}
+	if err := c.Sticky.validate(); err != nil {
+		return err
+	}
+	if err := c.Access.parse(); err != nil {
+		return fmt.Errorf("access lists: %w", err)
+	}
+	if err := c.Errors.parse(); err != nil {
+		return err
+	}
+	if err := c.SLO.validate(); err != nil {
+		return err
+	}
+	if err := c.Headers.Request.validate(); err != nil {
+		return fmt.Errorf("request headers: %w", err)
+	}
+	if err := c.Headers.Response.validate(); err != nil {
+		return fmt.Errorf("response headers: %w", err)
+	}
+	if !strings.HasPrefix(c.HealthCheck.Path, "/") {
+		return fmt.Errorf("health check path must start with /: %q", c.HealthCheck.Path)
+	}
+	if c.HealthCheck.Interval <= 0 || c.HealthCheck.Timeout <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("intervals and timeouts must be positive")
+	}
+	if c.HealthCheck.Jitter < 0 || c.HealthCheck.Jitter >= c.HealthCheck.Interval {
+		return fmt.Errorf("health check jitter must be non-negative and shorter than the interval")
+	}
+	if oc := c.OutlierDetection; oc.Enabled && (oc.LatencyFactor <= 1 || oc.ErrorRatio <= 0 || oc.ErrorRatio > 1 ||
+		oc.MinRequests < 1 || oc.Ejection <= 0 || oc.MaxEjected <= 0 || oc.MaxEjected > 1) {
+		return fmt.Errorf("invalid outlier detection settings: %+v", oc)
+	}
+	if l := c.Limits; l.MaxInFlight < 0 || l.MaxInFlightPerBackend < 0 || l.QueueSize < 0 || l.QueueTimeout < 0 {
+		return fmt.Errorf("concurrency limits cannot be negative")
+	}
+	if err := c.Limits.Priority.validate(); err != nil {
+		return err
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+	if c.SlowStart < 0 {
+		return fmt.Errorf("slow start window cannot be negative")
+	}
+	if c.DrainGrace < 0 {
+		return fmt.Errorf("drain grace period cannot be negative")
+	}
+	if c.HealthCheck.HealthyThreshold < 1 || c.HealthCheck.UnhealthyThreshold < 1 {
+		return fmt.Errorf("health check thresholds must be at least 1")
+	}
+	if c.HealthCheck.PassiveFailures < 0 || (c.HealthCheck.PassiveFailures > 0 && c.HealthCheck.PassiveCooldown <= 0) {
+		return fmt.Errorf("passive health check needs a non-negative failure count and a positive cooldown")
+	}
+	if c.HealthCheck.OverloadBackoff < 0 {
+		return fmt.Errorf("overload backoff cannot be negative")
+	}
+	if c.HealthCheck.ExpectedStatus < 100 || c.HealthCheck.ExpectedStatus > 599 {
+		return fmt.Errorf("invalid expected health check status %d", c.HealthCheck.ExpectedStatus)
+	}
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		route.Prefix = strings.TrimSuffix(route.Prefix, "*")
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return fmt.Errorf("route prefix must start with /: %q", route.Prefix)
+		}
+		if route.Timeout < 0 {
+			return fmt.Errorf("route %s: negative timeout", route.Prefix)
+		}
+		if route.Retries != nil && *route.Retries < 0 {
+			return fmt.Errorf("route %s: negative retries", route.Prefix)
+		}
+		if err := route.Hedging.validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Prefix, err)
+		}
+		for _, addr := range route.Backends {
+			if !c.hasBackend(addr) {
+				return fmt.Errorf("route %s: unknown backend %s", route.Prefix, addr)
+			}
+		}
+		if err := route.Access.parse(); err != nil {
+			return fmt.Errorf("route %s access lists: %w", route.Prefix, err)
+		}
+		if route.Strategy != "" {
+			if _, err := newBalancer(route.Strategy, route.hashKey(c)); err != nil {
+				return fmt.Errorf("route %s: %w", route.Prefix, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) hasBackend(addr string) bool {
+	for _, backend := range c.Backends {
+		if backend.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the route with the longest prefix matching the path.
+func (c *Config) route(path string) *RouteConfig {
+	var match *RouteConfig
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if strings.HasPrefix(path, route.Prefix) && (match == nil || len(route.Prefix) > len(match.Prefix)) {
+			match = route
+		}
+	}
+	return match
+}
+
+// requestTimeout returns the timeout of a request to the backend. A route
+// timeout takes precedence over a backend one, which in turn overrides
+// the global timeout.
+func (c *Config) requestTimeout(addr, path string) time.Duration {
+	if route := c.route(path); route != nil && route.Timeout > 0 {
+		return route.Timeout
+	}
+	for _, backend := range c.Backends {
+		if backend.Address == addr && backend.Timeout > 0 {
+			return backend.Timeout
+		}
+	}
+	return c.Timeout
+}
+
+// retries returns how many times a failed request to the path may be
+// retried, a route budget taking precedence over the global one.
+func (c *Config) retries(path string) int {
+	if route := c.route(path); route != nil && route.Retries != nil {
+		return *route.Retries
+	}
+	return c.Retries
+}
+
+// hedging returns the hedging policy of the path.
+func (c *Config) hedging(path string) HedgingConfig {
+	if route := c.route(path); route != nil {
+		return route.Hedging
+	}
+	return HedgingConfig{}
+}
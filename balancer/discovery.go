@@ -0,0 +1,149 @@
+package balancer
+
+import (
+	"context"
+	"log"
+	"net"
+	"slices"
+	"time"
+)
+
+var (
+	dnsBackends       = flags.String("dns-backends", "", "comma-separated host:port names whose DNS records are used as backends")
+	discoveryInterval = flags.Duration("discovery-interval", 30*time.Second, "how often discovered backends are refreshed")
+)
+
+// DiscoveryConfig lists the sources backends are discovered from in
+// addition to the static ones.
+type DiscoveryConfig struct {
+	DNS      []string              `yaml:"dns"`
+	Docker   DockerDiscoveryConfig `yaml:"docker"`
+	Consul   ConsulDiscoveryConfig `yaml:"consul"`
+	Interval time.Duration         `yaml:"interval"`
+}
+
+func (c DiscoveryConfig) enabled() bool {
+	return len(c.DNS) > 0 || c.Docker.Label != "" || c.Consul.Service != ""
+}
+
+// discoverer finds backend addresses in an external source.
+type discoverer interface {
+	// source identifies the discoverer, discovered backends are tagged with it.
+	source() string
+	discover(ctx context.Context) ([]string, error)
+}
+
+func discoverers(c *Config) []discoverer {
+	var res []discoverer
+	for _, name := range c.Discovery.DNS {
+		res = append(res, dnsDiscoverer{name})
+	}
+	if c.Discovery.Docker.Label != "" {
+		res = append(res, newDockerDiscoverer(c.Discovery.Docker))
+	}
+	if c.Discovery.Consul.Service != "" {
+		res = append(res, consulDiscoverer{c.Discovery.Consul})
+	}
+	return res
+}
+
+// dnsDiscoverer resolves a host:port name into one backend per address.
+type dnsDiscoverer struct {
+	name string
+}
+
+func (d dnsDiscoverer) source() string {
+	return "dns:" + d.name
+}
+
+func (d dnsDiscoverer) discover(ctx context.Context) ([]string, error) {
+	host, port, err := net.SplitHostPort(d.name)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(ips))
+	for i, ip := range ips {
+		res[i] = net.JoinHostPort(ip, port)
+	}
+	return res, nil
+}
+
+// syncDiscovered replaces the backends previously found by the source
+// with the given addresses. Addresses configured statically are skipped.
+func syncDiscovered(source string, addrs []string) error {
+	c := currentConfig()
+	var current, others []string
+	for _, backend := range c.Backends {
+		if backend.Source == source {
+			current = append(current, backend.Address)
+		} else {
+			others = append(others, backend.Address)
+		}
+	}
+	addrs = slices.DeleteFunc(slices.Clone(addrs), func(addr string) bool {
+		return slices.Contains(others, addr)
+	})
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+	slices.Sort(current)
+	if slices.Equal(current, addrs) {
+		return nil
+	}
+
+	err := updateConfig(func(c *Config) error {
+		c.Backends = slices.DeleteFunc(c.Backends, func(b BackendConfig) bool {
+			return b.Source == source
+		})
+		for _, addr := range addrs {
+			if backendIndex(c, addr) < 0 {
+				c.Backends = append(c.Backends, BackendConfig{Address: addr, Source: source})
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Discovered backends from %s: %v", source, addrs)
+	}
+	return err
+}
+
+func discover() {
+	c := currentConfig()
+	for _, d := range discoverers(c) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Discovery.Interval)
+		addrs, err := d.discover(ctx)
+		cancel()
+		if err == nil {
+			err = syncDiscovered(d.source(), addrs)
+		}
+		if err != nil {
+			log.Printf("Discovery from %s failed, keeping the previous backends: %s", d.source(), err)
+		}
+	}
+}
+
+var rediscover = make(chan struct{}, 1)
+
+// requestDiscovery makes the discovery loop refresh backends right away.
+func requestDiscovery() {
+	select {
+	case rediscover <- struct{}{}:
+	default:
+	}
+}
+
+func discoveryLoop(ctx context.Context) {
+	for {
+		discover()
+		select {
+		case <-time.After(currentConfig().Discovery.Interval):
+		case <-rediscover:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
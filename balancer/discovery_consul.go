@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+)
+
+var (
+	consulAddress = flags.String("consul-address", "http://consul:8500", "Consul HTTP API address")
+	consulService = flags.String("consul-service", "", "discover passing instances of this Consul service, empty disables Consul discovery")
+	consulTag     = flags.String("consul-tag", "", "only use Consul service instances having this tag")
+)
+
+// discoveryClient queries the Consul API, retrying its transient failures
+// before the discovery round gives up.
+var discoveryClient = httpclient.New(httpclient.Options{
+	Timeout: 5 * time.Second,
+	Retry:   httpclient.RetryPolicy{Attempts: 2},
+})
+
+type ConsulDiscoveryConfig struct {
+	Address string `yaml:"address"`
+	Service string `yaml:"service"`
+	Tag     string `yaml:"tag"`
+}
+
+// consulDiscoverer lists the instances of a service passing their
+// Consul health checks.
+type consulDiscoverer struct {
+	ConsulDiscoveryConfig
+}
+
+func (d consulDiscoverer) source() string {
+	return "consul:" + d.Service
+}
+
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (d consulDiscoverer) discover(ctx context.Context) ([]string, error) {
+	query := url.Values{"passing": {"true"}}
+	if d.Tag != "" {
+		query.Set("tag", d.Tag)
+	}
+	u := fmt.Sprintf("%s/v1/health/service/%s?%s", d.Address, url.PathEscape(d.Service), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := discoveryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul responded with %s", resp.Status)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		res = append(res, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	return res, nil
+}
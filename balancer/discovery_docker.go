@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+)
+
+var (
+	dockerSocket  = flags.String("docker-socket", "/var/run/docker.sock", "Docker API socket used for discovery")
+	dockerLabel   = flags.String("docker-label", "", "discover running containers having this label (key or key=value), empty disables Docker discovery")
+	dockerNetwork = flags.String("docker-network", "", "network whose container addresses are used (defaults to the first one)")
+	dockerPort    = flags.Int("docker-port", 8080, "backend port used when a container has no lb.port label")
+)
+
+const dockerPortLabel = "lb.port"
+
+type DockerDiscoveryConfig struct {
+	Socket  string `yaml:"socket"`
+	Label   string `yaml:"label"`
+	Network string `yaml:"network"`
+	Port    int    `yaml:"port"`
+}
+
+// dockerDiscoverer lists running containers with the configured label
+// through the Docker Engine API.
+type dockerDiscoverer struct {
+	DockerDiscoveryConfig
+	client *http.Client
+}
+
+func newDockerDiscoverer(c DockerDiscoveryConfig) dockerDiscoverer {
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	transport := httpclient.NewTransport(httpclient.Options{})
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", c.Socket)
+	}
+	return dockerDiscoverer{c, &http.Client{Timeout: 10 * time.Second, Transport: transport}}
+}
+
+func (d dockerDiscoverer) source() string {
+	return "docker:" + d.Label
+}
+
+type dockerContainer struct {
+	Names           []string
+	Labels          map[string]string
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string
+		}
+	}
+}
+
+func (d dockerDiscoverer) discover(ctx context.Context) ([]string, error) {
+	filters, _ := json.Marshal(map[string][]string{
+		"label":  {d.Label},
+		"status": {"running"},
+	})
+	u := "http://docker/containers/json?filters=" + url.QueryEscape(string(filters))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("docker API responded with %s", resp.Status)
+	}
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, container := range containers {
+		if addr, ok := d.address(container); ok {
+			res = append(res, addr)
+		}
+	}
+	return res, nil
+}
+
+func (d dockerDiscoverer) address(container dockerContainer) (string, bool) {
+	port := d.Port
+	if value, ok := container.Labels[dockerPortLabel]; ok {
+		p, err := strconv.Atoi(value)
+		if err != nil {
+			return "", false
+		}
+		port = p
+	}
+	networks := make([]string, 0, len(container.NetworkSettings.Networks))
+	for name := range container.NetworkSettings.Networks {
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	for _, name := range networks {
+		ip := container.NetworkSettings.Networks[name].IPAddress
+		if ip != "" && (d.Network == "" || d.Network == name) {
+			return net.JoinHostPort(ip, strconv.Itoa(port)), true
+		}
+	}
+	return "", false
+}
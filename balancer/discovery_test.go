@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestSyncDiscovered(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080")
+	defer restore()
+
+	c.Assert(syncDiscovered("dns:server:8080", []string{"10.0.0.2:8080", "10.0.0.1:8080", "server1:8080"}), IsNil)
+	c.Assert(config.Backends, DeepEquals, []BackendConfig{
+		{Address: "server1:8080", Weight: 1},
+		{Address: "10.0.0.1:8080", Weight: 1, Source: "dns:server:8080"},
+		{Address: "10.0.0.2:8080", Weight: 1, Source: "dns:server:8080"},
+	})
+
+	c.Assert(syncDiscovered("dns:server:8080", []string{"10.0.0.3:8080"}), IsNil)
+	c.Assert(backendAddresses(config), Equals, "server1:8080, 10.0.0.3:8080")
+
+	// Nothing changed, the static backend aside.
+	prev := config
+	c.Assert(syncDiscovered("dns:server:8080", []string{"server1:8080", "10.0.0.3:8080"}), IsNil)
+	c.Assert(config == prev, Equals, true, Commentf("the config must not be updated"))
+}
+
+func (s *BalancerSuite) TestDnsDiscoverer(c *C) {
+	addrs, err := dnsDiscoverer{"localhost:8080"}.discover(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(slices.Contains(addrs, "127.0.0.1:8080"), Equals, true, Commentf("got %v", addrs))
+
+	_, err = dnsDiscoverer{"localhost"}.discover(context.Background())
+	c.Assert(err, NotNil)
+}
+
+func (s *BalancerSuite) TestConsulDiscoverer(c *C) {
+	consul := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v1/health/service/server")
+		c.Check(r.URL.Query().Get("passing"), Equals, "true")
+		c.Check(r.URL.Query().Get("tag"), Equals, "lb")
+		_, _ = rw.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "172.17.0.5", "Port": 8081}}
+		]`))
+	}))
+	defer consul.Close()
+
+	d := consulDiscoverer{ConsulDiscoveryConfig{Address: consul.URL, Service: "server", Tag: "lb"}}
+	addrs, err := d.discover(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"10.0.0.1:8080", "172.17.0.5:8081"})
+}
+
+func (s *BalancerSuite) TestDockerDiscoverer(c *C) {
+	socket := filepath.Join(c.MkDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+	docker := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/containers/json")
+		c.Check(r.URL.Query().Get("filters"), Matches, `.*"lb.enable=true".*`)
+		_, _ = rw.Write([]byte(`[
+			{"Labels": {}, "NetworkSettings": {"Networks": {"servers": {"IPAddress": "172.18.0.2"}}}},
+			{"Labels": {"lb.port": "9000"}, "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.3"}, "servers": {"IPAddress": "172.18.0.3"}}}},
+			{"Labels": {}, "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.4"}}}}
+		]`))
+	}))
+	docker.Listener = listener
+	docker.Start()
+	defer docker.Close()
+
+	d := newDockerDiscoverer(DockerDiscoveryConfig{Socket: socket, Label: "lb.enable=true", Network: "servers", Port: 8080})
+	addrs, err := d.discover(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(addrs, DeepEquals, []string{"172.18.0.2:8080", "172.18.0.3:9000"})
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+var drainGrace = flags.Duration("drain-grace", 30*time.Second, "how long clients bound to a draining backend by ip-hash keep being sent to it")
+
+// affinityTTL bounds how long an idle client is remembered.
+const affinityTTL = 10 * time.Minute
+
+type affinityEntry struct {
+	backend string
+	seen    time.Time
+}
+
+// affinityTable remembers the backend ip-hash clients were last sent to,
+// so they can stay there while it drains.
+type affinityTable struct {
+	mu      sync.Mutex
+	entries map[uint64]affinityEntry
+	pruned  time.Time
+}
+
+var affinity = &affinityTable{entries: make(map[uint64]affinityEntry)}
+
+func (t *affinityTable) record(key uint64, backend string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[key] = affinityEntry{backend, now}
+	if now.Sub(t.pruned) < time.Minute {
+		return
+	}
+	t.pruned = now
+	for k, e := range t.entries {
+		if now.Sub(e.seen) > affinityTTL {
+			delete(t.entries, k)
+		}
+	}
+}
+
+func (t *affinityTable) lookup(key uint64) (affinityEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	return e, ok
+}
+
+// drainStarted holds when each draining backend started draining. It is
+// maintained by apply and guarded by mu.
+var drainStarted = map[string]time.Time{}
+
+func updateDrainStarted(c *Config, now time.Time) {
+	started := make(map[string]time.Time)
+	for _, backend := range c.Backends {
+		if !backend.Drain {
+			continue
+		}
+		if t, ok := drainStarted[backend.Address]; ok {
+			started[backend.Address] = t
+		} else {
+			started[backend.Address] = now
+		}
+	}
+	drainStarted = started
+}
+
+// inGrace reports whether clients bound to the draining backend may
+// still be sent to it. It must be called with mu held.
+func inGrace(dst string, now time.Time) bool {
+	started, ok := drainStarted[dst]
+	return ok && now.Before(started.Add(config.DrainGrace))
+}
+
+// drained reports whether a draining backend can be safely stopped: the
+// grace period is over and no requests are in flight. It must be called
+// with mu held.
+func drained(dst string, now time.Time) bool {
+	_, draining := drainStarted[dst]
+	return draining && !inGrace(dst, now) && livePool.inFlight(dst) == 0
+}
@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestDrainKeepsBoundClients(c *C) {
+	restore := withBackends(c, strategyIpHash, "server1:8080", "server2:8080")
+	defer restore()
+	config.HashKey = hashRemoteAddr
+	c.Assert(apply(config), IsNil)
+	prevAffinity := affinity
+	affinity = &affinityTable{entries: make(map[uint64]affinityEntry)}
+	defer func() { affinity = prevAffinity }()
+
+	clients := map[string]string{}
+	for _, client := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1", "10.0.0.4:1", "10.0.0.5:1"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = client
+		dst, err := pick(r)
+		c.Assert(err, IsNil)
+		clients[client] = dst
+	}
+
+	cfg := *config
+	cfg.Backends = append([]BackendConfig(nil), cfg.Backends...)
+	cfg.Backends[0].Drain = true
+	cfg.DrainGrace = time.Hour
+	c.Assert(apply(&cfg), IsNil)
+
+	newClient := httptest.NewRequest("GET", "/", nil)
+	newClient.RemoteAddr = "10.0.0.100:1"
+	for range 3 {
+		dst, err := pick(newClient)
+		c.Assert(err, IsNil)
+		c.Assert(dst, Equals, "server2:8080", Commentf("new clients avoid the draining backend"))
+	}
+	for client, before := range clients {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = client
+		dst, err := pick(r)
+		c.Assert(err, IsNil)
+		c.Assert(dst, Equals, before, Commentf("bound clients stay during the grace period"))
+	}
+	mu.RLock()
+	c.Assert(drained("server1:8080", time.Now()), Equals, false)
+	c.Assert(drained("server1:8080", time.Now().Add(2*time.Hour)), Equals, true)
+	mu.RUnlock()
+
+	cfg.DrainGrace = 0
+	c.Assert(apply(&cfg), IsNil)
+	for client := range clients {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = client
+		dst, err := pick(r)
+		c.Assert(err, IsNil)
+		c.Assert(dst, Equals, "server2:8080", Commentf("the grace period is over"))
+	}
+}
@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	errorFormat   = flags.String("error-format", errorFormatText, "body of the responses the balancer generates itself (text, json or html)")
+	errorTemplate = flags.String("error-template", "", "HTML template file of the error responses, implies -error-format=html")
+	retryAfter    = flags.Duration("retry-after", 0, "Retry-After value of 502, 503 and 504 responses (0 omits the header)")
+)
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+	errorFormatHTML = "html"
+)
+
+// defaultErrorTemplate is used by the html format without a template file.
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Message}}</title></head>
+<body>
+<h1>{{.Status}} {{.Message}}</h1>
+{{if .RetryAfter}}<p>Please retry in {{.RetryAfter}} seconds.</p>{{end}}
+{{if .RequestId}}<p>Request ID: {{.RequestId}}</p>{{end}}
+</body>
+</html>
+`
+
+// ErrorsConfig describes the responses sent when no backend can serve a
+// request. Templates get the fields of errorDetails.
+type ErrorsConfig struct {
+	Format     string        `yaml:"format"`
+	Template   string        `yaml:"template"`
+	RetryAfter time.Duration `yaml:"retryAfter"`
+
+	tmpl *template.Template
+}
+
+func errorsConfig() ErrorsConfig {
+	ec := ErrorsConfig{Format: *errorFormat, Template: *errorTemplate, RetryAfter: *retryAfter}
+	if ec.Template != "" {
+		ec.Format = errorFormatHTML
+	}
+	return ec
+}
+
+// parse validates the settings and loads the template.
+func (ec *ErrorsConfig) parse() error {
+	if ec.RetryAfter < 0 {
+		return fmt.Errorf("retry after cannot be negative")
+	}
+	switch ec.Format {
+	case errorFormatText, errorFormatJSON:
+		return nil
+	case errorFormatHTML:
+	default:
+		return fmt.Errorf("unknown error format %q", ec.Format)
+	}
+	if ec.tmpl != nil {
+		return nil
+	}
+	text := defaultErrorTemplate
+	if ec.Template != "" {
+		data, err := os.ReadFile(ec.Template)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid error template: %w", err)
+	}
+	ec.tmpl = tmpl
+	return nil
+}
+
+// errorDetails is the JSON error envelope and the template data.
+type errorDetails struct {
+	Status     int    `json:"status"`
+	Message    string `json:"message"`
+	RequestId  string `json:"requestId,omitempty"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+}
+
+// writeError responds with a balancer generated error in the configured
+// format.
+func writeError(rw http.ResponseWriter, r *http.Request, status int) {
+	ec := currentConfig().Errors
+	details := errorDetails{Status: status, Message: http.StatusText(status), RequestId: requestId(r)}
+	if ec.RetryAfter > 0 && status >= http.StatusBadGateway && status <= http.StatusGatewayTimeout {
+		details.RetryAfter = int((ec.RetryAfter + time.Second - 1) / time.Second)
+		rw.Header().Set("Retry-After", strconv.Itoa(details.RetryAfter))
+	}
+	switch ec.Format {
+	case errorFormatJSON:
+		writeJSON(rw, status, map[string]errorDetails{"error": details})
+	case errorFormatHTML:
+		if ec.tmpl == nil {
+			http.Error(rw, details.Message, status)
+			return
+		}
+		var body bytes.Buffer
+		if err := ec.tmpl.Execute(&body, details); err != nil {
+			log.Printf("Failed to render the error page: %s", err)
+			http.Error(rw, details.Message, status)
+			return
+		}
+		rw.Header().Set("content-type", "text/html; charset=utf-8")
+		rw.WriteHeader(status)
+		_, _ = rw.Write(body.Bytes())
+	default:
+		http.Error(rw, details.Message, status)
+	}
+}
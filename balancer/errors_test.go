@@ -0,0 +1,74 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestErrorResponses(c *C) {
+	restore := withBackends(c, strategyRoundRobin)
+	defer restore()
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	c.Assert(rw.Code, Equals, http.StatusBadGateway)
+	c.Assert(rw.Body.String(), Equals, "Bad Gateway\n")
+	c.Assert(rw.Header().Get("Retry-After"), Equals, "")
+
+	config.Errors = ErrorsConfig{Format: errorFormatJSON, RetryAfter: 1500 * time.Millisecond}
+	c.Assert(config.Errors.parse(), IsNil)
+	rw = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	withRequestId(handle)(rw, r)
+	c.Assert(rw.Code, Equals, http.StatusBadGateway)
+	c.Assert(rw.Header().Get("Retry-After"), Equals, "2")
+	var envelope map[string]errorDetails
+	c.Assert(json.Unmarshal(rw.Body.Bytes(), &envelope), IsNil)
+	c.Assert(envelope["error"].Status, Equals, http.StatusBadGateway)
+	c.Assert(envelope["error"].Message, Equals, "Bad Gateway")
+	c.Assert(envelope["error"].RetryAfter, Equals, 2)
+
+	file := filepath.Join(c.MkDir(), "error.html")
+	c.Assert(os.WriteFile(file, []byte("<p>{{.Status}} {{.Message}}</p>"), 0o644), IsNil)
+	config.Errors = ErrorsConfig{Format: errorFormatHTML, Template: file}
+	c.Assert(config.Errors.parse(), IsNil)
+	rw = httptest.NewRecorder()
+	writeError(rw, httptest.NewRequest("GET", "/", nil), http.StatusServiceUnavailable)
+	c.Assert(rw.Header().Get("content-type"), Equals, "text/html; charset=utf-8")
+	c.Assert(rw.Body.String(), Equals, "<p>503 Service Unavailable</p>")
+}
+
+func (s *BalancerSuite) TestGatewayTimeout(c *C) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(slow.URL, "http://"))
+	defer restore()
+	config.Timeout = 50 * time.Millisecond
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("POST", "/api/v1/some-data", strings.NewReader("{}")))
+	c.Assert(rw.Code, Equals, http.StatusGatewayTimeout)
+}
+
+func (s *BalancerSuite) TestErrorsConfig(c *C) {
+	c.Assert((&ErrorsConfig{Format: "xml"}).parse(), NotNil)
+	c.Assert((&ErrorsConfig{Format: errorFormatText, RetryAfter: -time.Second}).parse(), NotNil)
+	c.Assert((&ErrorsConfig{Format: errorFormatHTML, Template: "/nonexistent.html"}).parse(), NotNil)
+	ec := ErrorsConfig{Format: errorFormatHTML}
+	c.Assert(ec.parse(), IsNil)
+	c.Assert(ec.tmpl, NotNil)
+}
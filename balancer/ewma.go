@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ewmaDecay = flags.Duration("ewma-decay", 10*time.Second, "time constant of the peak-ewma latency average")
+
+// latencyTracker keeps a peak-sensitive moving average of backend
+// latencies: a slower response replaces the average right away, faster
+// ones pull it down gradually.
+type latencyTracker struct {
+	mu    sync.Mutex
+	decay time.Duration
+	m     map[string]*latencyEWMA
+}
+
+type latencyEWMA struct {
+	value float64 // nanoseconds
+	last  time.Time
+}
+
+var latencies = &latencyTracker{m: make(map[string]*latencyEWMA)}
+
+func (t *latencyTracker) Observe(addr string, rtt time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.m[addr]
+	if !ok {
+		t.m[addr] = &latencyEWMA{float64(rtt), now}
+		return
+	}
+	v := float64(rtt)
+	if v > e.value {
+		e.value = v
+	} else {
+		w := math.Exp(-float64(now.Sub(e.last)) / float64(t.decayTime()))
+		e.value = e.value*w + v*(1-w)
+	}
+	e.last = now
+}
+
+// Get returns the average, decayed towards zero while the backend gets
+// no traffic so that it is probed again eventually.
+func (t *latencyTracker) Get(addr string, now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.m[addr]
+	if !ok {
+		return 0
+	}
+	return e.value * math.Exp(-float64(now.Sub(e.last))/float64(t.decayTime()))
+}
+
+func (t *latencyTracker) decayTime() time.Duration {
+	if t.decay > 0 {
+		return t.decay
+	}
+	return *ewmaDecay
+}
+
+type peakEWMABalancer struct {
+	latencies *latencyTracker
+	conns     *connCounter
+}
+
+// Pick chooses the backend with the lowest expected cost: its latency
+// average times the requests it would be serving, relative to its
+// weight. Backends without observations are tried first.
+func (b peakEWMABalancer) Pick(pool []string, _ *http.Request) (string, error) {
+	weights := make(map[string]int)
+	var order []string
+	for _, addr := range pool {
+		if weights[addr] == 0 {
+			order = append(order, addr)
+		}
+		weights[addr]++
+	}
+	now := time.Now()
+	cost := func(addr string) float64 {
+		return b.latencies.Get(addr, now) * float64(b.conns.Get(addr)+1) / float64(weights[addr])
+	}
+	best, bestCost := order[0], cost(order[0])
+	for _, addr := range order[1:] {
+		if c := cost(addr); c < bestCost {
+			best, bestCost = addr, c
+		}
+	}
+	return best, nil
+}
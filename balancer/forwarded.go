@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	forwardClientIp = flags.Bool("forward-client-ip", true, "whether to pass the client address, scheme and host to backends in X-Forwarded-* and X-Real-Ip headers")
+	trustedProxies  = flags.String("trusted-proxies", "", "comma separated list of proxy CIDRs whose X-Forwarded-For/Forwarded headers are believed, empty trusts no one")
+)
+
+// forwardingHeaders describe the path of a request through proxies.
+var forwardingHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-Ip", "Forwarded"}
+
+// trustedProxyList is parsed from -trusted-proxies on start.
+var trustedProxyList []netip.Prefix
+
+// containsAddr reports whether the address (with or without a port) is
+// in any of the prefixes.
+func containsAddr(prefixes []netip.Prefix, s string) bool {
+	addr, err := parseClientAddr(s)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedPeer reports whether the forwarding headers sent by the peer
+// connected to the balancer can be believed. Without trusted proxies
+// they never are, any client could set them.
+func trustedPeer(r *http.Request) bool {
+	return containsAddr(trustedProxyList, r.RemoteAddr)
+}
+
+// forwardedClient returns the client address from X-Forwarded-For: the
+// rightmost address not belonging to a trusted proxy, or the first one
+// if all of them do.
+func forwardedClient(values []string) string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		return ""
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !containsAddr(trustedProxyList, hops[i]) {
+			return hops[i]
+		}
+	}
+	return hops[0]
+}
+
+// setForwardedHeaders describes the client to the backend. Forwarding
+// headers of untrusted peers are replaced rather than extended, so that
+// clients cannot spoof their address.
+func setForwardedHeaders(h http.Header, r *http.Request) {
+	if !trustedPeer(r) {
+		for _, name := range forwardingHeaders {
+			h.Del(name)
+		}
+	}
+	addr, err := parseClientAddr(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	client := addr.String()
+	prior := h.Values("X-Forwarded-For")
+	h.Set("X-Forwarded-For", strings.Join(append(prior, client), ", "))
+	if h.Get("X-Real-Ip") == "" {
+		h.Set("X-Real-Ip", client)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+}
@@ -0,0 +1,43 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"net/netip"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestForwardedHeaders(c *C) {
+	defer func() { trustedProxyList = nil }()
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "192.0.2.1:56324"
+	h := r.Header.Clone()
+	setForwardedHeaders(h, r)
+	c.Assert(h.Get("X-Forwarded-For"), Equals, "192.0.2.1")
+	c.Assert(h.Get("X-Real-Ip"), Equals, "192.0.2.1")
+	c.Assert(h.Get("X-Forwarded-Proto"), Equals, "http")
+	c.Assert(h.Get("X-Forwarded-Host"), Equals, "example.com")
+
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	h = r.Header.Clone()
+	setForwardedHeaders(h, r)
+	c.Assert(h.Get("X-Forwarded-For"), Equals, "192.0.2.1", Commentf("no client is trusted by default"))
+	c.Assert(h.Get("X-Forwarded-Proto"), Equals, "http")
+	c.Assert(getRemoteIp(r), Equals, "192.0.2.1:56324")
+
+	trustedProxyList = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h = r.Header.Clone()
+	setForwardedHeaders(h, r)
+	c.Assert(h.Get("X-Forwarded-For"), Equals, "192.0.2.1", Commentf("spoofed values are dropped"))
+	c.Assert(h.Get("X-Forwarded-Proto"), Equals, "http")
+	c.Assert(getRemoteIp(r), Equals, "192.0.2.1:56324")
+
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.7, 10.0.0.5")
+	c.Assert(getRemoteIp(r), Equals, "203.0.113.7", Commentf("the rightmost untrusted hop is the client"))
+	h = r.Header.Clone()
+	setForwardedHeaders(h, r)
+	c.Assert(h.Values("X-Forwarded-For"), DeepEquals, []string{"198.51.100.9, 203.0.113.7, 10.0.0.5, 10.0.0.2"})
+}
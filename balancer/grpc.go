@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var grpcMode = flags.Bool("grpc", false, "whether to accept cleartext HTTP/2 (h2c) and balance gRPC calls individually over HTTP/2 backend connections")
+
+// gRPC status codes that mean the backend, rather than the call, failed.
+var grpcBackendFailures = map[string]bool{
+	"4":  true, // DEADLINE_EXCEEDED
+	"13": true, // INTERNAL
+	"14": true, // UNAVAILABLE
+}
+
+func isGrpc(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus returns the status of a finished gRPC call. It is sent in
+// the trailers, or in the headers of trailers-only responses.
+func grpcStatus(resp *http.Response) string {
+	if status := resp.Trailer.Get("Grpc-Status"); status != "" {
+		return status
+	}
+	return resp.Header.Get("Grpc-Status")
+}
+
+// grpcCallError reports a gRPC call the backend failed to serve.
+func grpcCallError(resp *http.Response) error {
+	if status := grpcStatus(resp); grpcBackendFailures[status] {
+		return fmt.Errorf("grpc-status %s: %s", status, resp.Trailer.Get("Grpc-Message"))
+	}
+	return nil
+}
+
+// grpcTransport sends gRPC calls to cleartext backends over h2c, so
+// calls are multiplexed on shared connections and each one is balanced
+// on its own. Other requests, and any request to TLS backends (which
+// negotiate HTTP/2 themselves), go through the regular transport.
+type grpcTransport struct {
+	regular http.RoundTripper
+	h2c     http.RoundTripper
+}
+
+func newGrpcTransport(regular *http.Transport) http.RoundTripper {
+	dialer := &net.Dialer{Timeout: *dialTimeout, KeepAlive: *keepAlive}
+	return grpcTransport{
+		regular: regular,
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func (t grpcTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "http" && isGrpc(r.Header) {
+		return t.h2c.RoundTrip(r)
+	}
+	return t.regular.RoundTrip(r)
+}
+
+// withH2C lets clients speak cleartext HTTP/2 when the gRPC mode is on.
+func withH2C(h http.Handler) http.Handler {
+	if !*grpcMode {
+		return h
+	}
+	return h2c.NewHandler(h, &http2.Server{})
+}
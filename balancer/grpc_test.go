@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestGrpcMode(c *C) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		_, _ = rw.Write(append([]byte(r.Proto+" "), body...))
+		if string(body) == "fail" {
+			rw.Header().Set("Grpc-Status", "14")
+			rw.Header().Set("Grpc-Message", "overloaded")
+			return
+		}
+		rw.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	restore := withBackends(c, strategyRoundRobin, addr)
+	defer restore()
+	config.HealthCheck.PassiveFailures = 1
+	livePool.states[addr] = &backendHealth{checked: true, healthy: true}
+
+	prevMode, prevTransport := *grpcMode, backendClient.Transport
+	*grpcMode = true
+	backendClient.Transport = newGrpcTransport(newTransport(nil))
+	defer func() { *grpcMode, backendClient.Transport = prevMode, prevTransport }()
+
+	frontend := httptest.NewServer(withH2C(http.HandlerFunc(serve)))
+	defer frontend.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	call := func(message string) (*http.Response, string) {
+		req, _ := http.NewRequest("POST", frontend.URL+"/helloworld.Greeter/SayHello", strings.NewReader(message))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+		resp, err := client.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := call("hello")
+	c.Assert(resp.ProtoMajor, Equals, 2)
+	c.Assert(body, Equals, "HTTP/2.0 hello", Commentf("calls reach the backend over HTTP/2"))
+	c.Assert(resp.Trailer.Get("Grpc-Status"), Equals, "0")
+	c.Assert(livePool.states[addr].ejected(time.Now()), Equals, false)
+
+	resp, _ = call("fail")
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.Trailer.Get("Grpc-Status"), Equals, "14")
+	c.Assert(resp.Trailer.Get("Grpc-Message"), Equals, "overloaded")
+	mu.RLock()
+	defer mu.RUnlock()
+	c.Assert(livePool.states[addr].ejected(time.Now()), Equals, true, Commentf("UNAVAILABLE counts as a backend failure"))
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hashKey describes which part of the request the ip-hash strategy uses
+// to bind clients to backends.
+type hashKey struct {
+	source string
+	name   string
+}
+
+const (
+	hashRemoteAddr    = "remote-addr"
+	hashForwardedFor  = "x-forwarded-for"
+	hashHeaderPrefix  = "header:"
+	hashCookiePrefix  = "cookie:"
+	hashPath          = "path"
+	hashKeyFormatHelp = "remote-addr, x-forwarded-for, header:<name>, cookie:<name> or path"
+)
+
+func parseHashKey(s string) (hashKey, error) {
+	switch {
+	case s == hashRemoteAddr || s == hashForwardedFor || s == hashPath:
+		return hashKey{source: s}, nil
+	case strings.HasPrefix(s, hashHeaderPrefix) && len(s) > len(hashHeaderPrefix):
+		return hashKey{source: hashHeaderPrefix, name: http.CanonicalHeaderKey(s[len(hashHeaderPrefix):])}, nil
+	case strings.HasPrefix(s, hashCookiePrefix) && len(s) > len(hashCookiePrefix):
+		return hashKey{source: hashCookiePrefix, name: s[len(hashCookiePrefix):]}, nil
+	default:
+		return hashKey{}, fmt.Errorf("invalid hash key %q, expected %s", s, hashKeyFormatHelp)
+	}
+}
+
+// hash returns the hash of the request key. Requests lacking the
+// configured header or cookie fall back to the client IP.
+func (k hashKey) hash(r *http.Request) (uint64, error) {
+	switch k.source {
+	case hashRemoteAddr:
+		return ipToHashNumber(r.RemoteAddr)
+	case hashPath:
+		return stringToHashNumber(r.URL.Path), nil
+	case hashHeaderPrefix:
+		if value := r.Header.Get(k.name); value != "" {
+			return stringToHashNumber(value), nil
+		}
+	case hashCookiePrefix:
+		if cookie, err := r.Cookie(k.name); err == nil && cookie.Value != "" {
+			return stringToHashNumber(cookie.Value), nil
+		}
+	}
+	return ipToHashNumber(getRemoteIp(r))
+}
+
+func stringToHashNumber(s string) uint64 {
+	hash := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(hash[:8])
+}
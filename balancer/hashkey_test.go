@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestHashKey(c *C) {
+	for _, invalid := range []string{"", "header:", "cookie:", "query"} {
+		_, err := parseHashKey(invalid)
+		c.Assert(err, NotNil, Commentf("expected error for %q", invalid))
+	}
+
+	trustedProxyList = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	defer func() { trustedProxyList = nil }()
+	r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.Header.Set("X-Forwarded-For", "87.154.128.68, 10.0.0.2")
+	r.Header.Set("X-User", "bob")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	expected := map[string]uint64{}
+	expected[hashRemoteAddr], _ = ipToHashNumber("10.0.0.1")
+	expected[hashForwardedFor], _ = ipToHashNumber("87.154.128.68")
+	expected[hashPath] = stringToHashNumber("/api/v1/some-data")
+	expected["header:x-user"] = stringToHashNumber("bob")
+	expected["cookie:session"] = stringToHashNumber("abc")
+	expected["header:X-Missing"] = expected[hashForwardedFor]
+
+	for key, hash := range expected {
+		k, err := parseHashKey(key)
+		c.Assert(err, IsNil)
+		actual, err := k.hash(r)
+		c.Assert(err, IsNil)
+		c.Assert(actual, Equals, hash, Commentf("hash key %s", key))
+	}
+}
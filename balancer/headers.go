@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+var (
+	requestHeadersSet     = flags.String("request-headers-set", "", "comma separated list of Name: value headers set on forwarded requests")
+	requestHeadersRemove  = flags.String("request-headers-remove", "", "comma separated list of headers removed from forwarded requests")
+	responseHeadersSet    = flags.String("response-headers-set", "", "comma separated list of Name: value headers set on responses")
+	responseHeadersRemove = flags.String("response-headers-remove", "", "comma separated list of headers removed from responses")
+
+	identityAuthor  = flags.String("identity-author", "", "value of the lb-author header on forwarded requests (defaults to the client IP)")
+	identityTeam    = flags.String("identity-team", "", "value of the lb-team header on forwarded requests (empty omits it)")
+	identityVersion = flags.String("identity-version", "", "value of the lb-version header on forwarded requests (empty omits it)")
+)
+
+// hopHeaders apply to a single connection and must not be forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type HeadersConfig struct {
+	Request  HeaderRules    `yaml:"request"`
+	Response HeaderRules    `yaml:"response"`
+	Identity IdentityConfig `yaml:"identity"`
+}
+
+// IdentityConfig describes who handled the request. The backends
+// aggregate their reports by these headers.
+type IdentityConfig struct {
+	Author  string `yaml:"author"`
+	Team    string `yaml:"team"`
+	Version string `yaml:"version"`
+}
+
+// set adds the identity headers to a forwarded request. Without a
+// configured author the client IP is used.
+func (ic IdentityConfig) set(h http.Header, ip string) {
+	if ic.Author != "" {
+		h.Set("lb-author", ic.Author)
+	} else {
+		h.Set("lb-author", ip)
+	}
+	if ic.Team != "" {
+		h.Set("lb-team", ic.Team)
+	}
+	if ic.Version != "" {
+		h.Set("lb-version", ic.Version)
+	}
+}
+
+// HeaderRules rewrite a header set. Headers are removed first, then
+// set (replacing any value) and finally added.
+type HeaderRules struct {
+	Remove []string          `yaml:"remove"`
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+}
+
+func (hr HeaderRules) apply(h http.Header) {
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	for name, value := range hr.Set {
+		h.Set(name, value)
+	}
+	for name, value := range hr.Add {
+		h.Add(name, value)
+	}
+}
+
+func (hr HeaderRules) validate() error {
+	names := append([]string{}, hr.Remove...)
+	for name := range hr.Set {
+		names = append(names, name)
+	}
+	for name := range hr.Add {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeaderList parses a comma separated list of Name: value pairs.
+func parseHeaderList(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, item := range splitList(s) {
+		name, value, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", item)
+		}
+		headers[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+func headersConfig() (HeadersConfig, error) {
+	var c HeadersConfig
+	var err error
+	if c.Request.Set, err = parseHeaderList(*requestHeadersSet); err != nil {
+		return c, err
+	}
+	if c.Response.Set, err = parseHeaderList(*responseHeadersSet); err != nil {
+		return c, err
+	}
+	c.Request.Remove = splitList(*requestHeadersRemove)
+	c.Response.Remove = splitList(*responseHeadersRemove)
+	c.Identity = IdentityConfig{Author: *identityAuthor, Team: *identityTeam, Version: *identityVersion}
+	return c, nil
+}
+
+// hasToken reports whether any of the comma separated header values
+// contains the token.
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including the ones listed
+// in the Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
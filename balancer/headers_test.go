@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestParseHeaderList(c *C) {
+	headers, err := parseHeaderList("x-auth: secret, X-Env:prod")
+	c.Assert(err, IsNil)
+	c.Assert(headers, DeepEquals, map[string]string{"X-Auth": "secret", "X-Env": "prod"})
+
+	_, err = parseHeaderList("X-Auth")
+	c.Assert(err, NotNil)
+	c.Assert(HeaderRules{Remove: []string{"Bad Header"}}.validate(), NotNil)
+}
+
+func (s *BalancerSuite) TestHeaderRules(c *C) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		rw.Header().Set("X-Internal", "1")
+		rw.Header().Set("Keep-Alive", "timeout=5")
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(server.URL, "http://"))
+	defer restore()
+	config.Headers = HeadersConfig{
+		Request: HeaderRules{
+			Remove: []string{"Cookie"},
+			Set:    map[string]string{"X-Auth": "secret"},
+			Add:    map[string]string{"X-Tag": "edge"},
+		},
+		Response: HeaderRules{Remove: []string{"X-Internal"}},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Set("X-Auth", "forged")
+	r.Header.Set("X-Tag", "client")
+	r.Header.Set("Connection", "X-Hop")
+	r.Header.Set("X-Hop", "1")
+	r.Header.Set("Te", "trailers, deflate")
+	rw := httptest.NewRecorder()
+	handle(rw, r)
+
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	c.Assert(received.Get("Cookie"), Equals, "")
+	c.Assert(received.Get("X-Auth"), Equals, "secret")
+	c.Assert(received.Values("X-Tag"), DeepEquals, []string{"client", "edge"})
+	c.Assert(received.Get("X-Hop"), Equals, "", Commentf("headers listed in Connection are hop-by-hop"))
+	c.Assert(received.Get("Te"), Equals, "trailers")
+	c.Assert(rw.Header().Get("X-Internal"), Equals, "")
+	c.Assert(rw.Header().Get("Keep-Alive"), Equals, "")
+}
+
+func (s *BalancerSuite) TestIdentityHeaders(c *C) {
+	h := http.Header{}
+	IdentityConfig{}.set(h, "10.0.0.1")
+	c.Assert(h.Get("lb-author"), Equals, "10.0.0.1")
+	c.Assert(h.Get("lb-team"), Equals, "")
+
+	IdentityConfig{Author: "lb-1", Team: "breaking_code", Version: "1.2.0"}.set(h, "10.0.0.1")
+	c.Assert(h.Get("lb-author"), Equals, "lb-1")
+	c.Assert(h.Get("lb-team"), Equals, "breaking_code")
+	c.Assert(h.Get("lb-version"), Equals, "1.2.0")
+}
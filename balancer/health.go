@@ -0,0 +1,310 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var (
+	healthPath           = flags.String("health-path", "/health", "backend health check path")
+	healthInterval       = flags.Duration("health-interval", 10*time.Second, "interval between backend health checks")
+	healthTimeout        = flags.Duration("health-timeout", 3*time.Second, "backend health check timeout")
+	healthyThreshold     = flags.Int("healthy-threshold", 1, "consecutive successful checks to mark a backend healthy")
+	unhealthyThreshold   = flags.Int("unhealthy-threshold", 1, "consecutive failed checks to mark a backend unhealthy")
+	healthExpectedStatus = flags.Int("health-status", http.StatusOK, "expected health check response status")
+	healthExpectedBody   = flags.String("health-body", "", "substring the health check response body must contain")
+	passiveFailures      = flags.Int("passive-failures", 3, "consecutive forwarding errors or 5xx responses that eject a backend (0 disables passive checks)")
+	passiveCooldown      = flags.Duration("passive-cooldown", 30*time.Second, "time an ejected backend stays out of the pool")
+	overloadBackoff      = flags.Duration("overload-backoff", 10*time.Second, "longest time a backend shedding requests with 503 and Retry-After stays out of the pool (0 counts such responses as failures)")
+	healthJitter         = flags.Duration("health-jitter", time.Second, "maximum random delay of each probe, spreading probes of many balancers over time")
+)
+
+const (
+	healthBodyLimit = 64 * 1024
+	// maxConcurrentProbes bounds the probes running at once when there
+	// are many (e.g. discovered) backends.
+	maxConcurrentProbes = 32
+)
+
+// backendHealth tracks consecutive probe results of a backend. The first
+// probe decides the initial state, later transitions require the
+// configured number of consecutive results. A backend added after the
+// first round of probes ramps up from its first healthy result.
+//
+// Independently of probes, forwarding results are observed passively:
+// a backend failing too many requests in a row is ejected until the
+// cooldown passes. A backend shedding requests because it is overloaded
+// is ejected right away, until the Retry-After it asked for.
+//
+// The probe results and weight are guarded by mu, the passive ones, which
+// change with every forwarded request, by their own mutex.
+type backendHealth struct {
+	checked      bool
+	healthy      bool
+	healthySince time.Time
+	added        bool
+	successes    int
+	failures     int
+	weight       int
+
+	lastCheck         time.Time
+	lastCheckDuration time.Duration
+	lastCheckOk       bool
+
+	passive         sync.Mutex
+	forwardFailures int
+	ejectedUntil    time.Time
+
+	stats backendStats
+	slo   sloSamples
+}
+
+func (h *backendHealth) ejected(now time.Time) bool {
+	h.passive.Lock()
+	defer h.passive.Unlock()
+	return now.Before(h.ejectedUntil)
+}
+
+// observeResponse records the outcome of a forwarded request in the
+// stats, SLO samples and passive checks of the backend, and reports
+// whether it has just been ejected.
+func (h *backendHealth) observeResponse(took time.Duration, ok bool, c *Config, now time.Time) bool {
+	h.passive.Lock()
+	defer h.passive.Unlock()
+	h.stats.observe(took, ok)
+	if c.SLO.enabled() {
+		h.slo.observe(now, took, ok, c.SLO.Window)
+	}
+	return h.observeForward(ok, c.HealthCheck, now)
+}
+
+// observeForward records a forwarding result and reports whether the
+// backend has just been ejected. It must be called with h.passive held.
+func (h *backendHealth) observeForward(ok bool, hc HealthCheckConfig, now time.Time) bool {
+	if ok {
+		h.forwardFailures = 0
+		return false
+	}
+	h.forwardFailures++
+	if hc.PassiveFailures == 0 || h.forwardFailures < hc.PassiveFailures || now.Before(h.ejectedUntil) {
+		return false
+	}
+	h.forwardFailures = 0
+	h.ejectedUntil = now.Add(hc.PassiveCooldown)
+	return true
+}
+
+func (h *backendHealth) observe(ok bool, hc HealthCheckConfig) {
+	if ok {
+		h.successes++
+		h.failures = 0
+	} else {
+		h.failures++
+		h.successes = 0
+	}
+	switch {
+	case !h.checked:
+		h.checked = true
+		h.healthy = ok
+		if ok && h.added {
+			h.healthySince = time.Now()
+		}
+	case h.healthy && h.failures >= hc.UnhealthyThreshold:
+		h.healthy = false
+	case !h.healthy && h.successes >= hc.HealthyThreshold:
+		h.healthy = true
+		h.healthySince = time.Now()
+	}
+}
+
+var recheck = make(chan struct{}, 1)
+
+// requestHealthCheck makes the health check loop probe backends right away.
+func requestHealthCheck() {
+	select {
+	case recheck <- struct{}{}:
+	default:
+	}
+}
+
+func healthCheckLoop(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(currentConfig().HealthCheck.Interval):
+		case <-recheck:
+		case <-ctx.Done():
+			return
+		}
+		healthCheck()
+	}
+}
+
+// health probes the backend as configured, by default with an HTTP GET
+// of the health check path.
+func health(backend BackendConfig, hc HealthCheckConfig) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+	switch backend.Probe.Type {
+	case probeTCP:
+		return tcpProbe(ctx, backend.Probe.target(backend.Address))
+	case probeExec:
+		return execProbe(ctx, backend.Probe.Command, backend.Address)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf("%s://%s%s", scheme(), backend.Probe.target(backend.Address), hc.Path), nil)
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != hc.ExpectedStatus {
+		return false
+	}
+	if hc.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, healthBodyLimit))
+		if err != nil || !strings.Contains(string(body), hc.ExpectedBody) {
+			return false
+		}
+	}
+	return true
+}
+
+var overloadsTotal = metrics.Default.NewCounter("lb_backend_overloads_total",
+	"Responses of backends shedding requests with 503 and Retry-After.", "backend")
+
+// shedRetryAfter returns the Retry-After of a response shedding the
+// request because of overload: a 503 with the header, in seconds or as a
+// date. Such responses are failures like any other 5xx with the overload
+// backoff disabled.
+func shedRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusServiceUnavailable || currentConfig().HealthCheck.OverloadBackoff == 0 {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// reportOverload backs off a backend that shed a request, for as long as
+// it asked but at most the overload backoff. The backend responds, so
+// this counts toward none of the passive failures and outliers: the
+// backend just leaves the pool and ramps up again with slow start. The
+// request still failed for its client, the stats and SLOs tell so.
+func reportOverload(dst string, retryAfter time.Duration, started time.Time) {
+	now := time.Now()
+	mu.RLock()
+	c := config
+	state, found := livePool.state(dst)
+	mu.RUnlock()
+	overloadsTotal.Inc(dst)
+	latencies.Observe(dst, max(now.Sub(started), c.Timeout), now)
+	if !found {
+		return
+	}
+	state.passive.Lock()
+	defer state.passive.Unlock()
+	state.stats.observe(now.Sub(started), false)
+	if c.SLO.enabled() {
+		state.slo.observe(now, now.Sub(started), false, c.SLO.Window)
+	}
+	until := now.Add(min(retryAfter, c.HealthCheck.OverloadBackoff))
+	if until.After(state.ejectedUntil) {
+		if !now.Before(state.ejectedUntil) {
+			log.Printf("Backend %s is overloaded, backing off for %s", dst, until.Sub(now).Round(time.Millisecond))
+		}
+		state.ejectedUntil = until
+	}
+}
+
+func healthCheck() {
+	c := currentConfig()
+	type result struct {
+		ok      bool
+		started time.Time
+		took    time.Duration
+	}
+	// Probes run concurrently, each with its own timeout, so a hanging
+	// backend does not delay the verdict on the others.
+	results := make([]result, len(c.Backends))
+	slots := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+	for i, backend := range c.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.HealthCheck.Jitter > 0 {
+				time.Sleep(rand.N(c.HealthCheck.Jitter))
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			started := time.Now()
+			ok := health(backend, c.HealthCheck)
+			results[i] = result{ok, started, time.Since(started)}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if config != c {
+		return
+	}
+	states := make(map[string]*backendHealth, len(c.Backends))
+	healthy := []string{}
+	for i, backend := range c.Backends {
+		state, ok := livePool.state(backend.Address)
+		if !ok {
+			state = &backendHealth{added: livePool.probed}
+		}
+		state.weight = backend.Weight
+		res := results[i]
+		state.observe(res.ok, c.HealthCheck)
+		state.lastCheck, state.lastCheckDuration, state.lastCheckOk = res.started, res.took, res.ok
+		states[backend.Address] = state
+		if state.healthy {
+			healthy = append(healthy, backend.Address)
+		}
+	}
+	livePool = livePool.withHealthy(healthy, states)
+}
+
+// reportForward feeds the outcome of a forwarded request into the
+// passive health state of the backend. The requests share mu for reading,
+// only the state of the backend is locked to update it.
+func reportForward(dst string, err error, status int, started time.Time) {
+	ok := err == nil && status < http.StatusInternalServerError
+	now := time.Now()
+	mu.RLock()
+	defer mu.RUnlock()
+	// Failures are penalized so that a backend failing fast does not
+	// look like the fastest one.
+	rtt := now.Sub(started)
+	if !ok {
+		rtt = max(rtt, config.Timeout)
+	}
+	latencies.Observe(dst, rtt, now)
+	state, found := livePool.state(dst)
+	if !found {
+		return
+	}
+	if state.observeResponse(now.Sub(started), ok, config, now) {
+		log.Printf("Backend %s ejected for %s after %d consecutive failures",
+			dst, config.HealthCheck.PassiveCooldown, config.HealthCheck.PassiveFailures)
+		return
+	}
+	detectOutlier(dst, now)
+}
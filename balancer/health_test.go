@@ -0,0 +1,178 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestBackendHealthThresholds(c *C) {
+	hc := HealthCheckConfig{HealthyThreshold: 2, UnhealthyThreshold: 3}
+	var h backendHealth
+
+	h.observe(true, hc)
+	c.Assert(h.healthy, Equals, true, Commentf("first probe decides the initial state"))
+
+	h.observe(false, hc)
+	h.observe(false, hc)
+	c.Assert(h.healthy, Equals, true)
+	h.observe(false, hc)
+	c.Assert(h.healthy, Equals, false)
+
+	h.observe(true, hc)
+	c.Assert(h.healthy, Equals, false)
+	h.observe(true, hc)
+	c.Assert(h.healthy, Equals, true)
+}
+
+func (s *BalancerSuite) TestHealthProbe(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+		_, _ = rw.Write([]byte("status: OK"))
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	hc := HealthCheckConfig{
+		Path:           "/ready",
+		Timeout:        time.Second,
+		ExpectedStatus: http.StatusAccepted,
+		ExpectedBody:   "OK",
+	}
+	c.Assert(health(BackendConfig{Address: addr}, hc), Equals, true)
+
+	hc.ExpectedBody = "FAILURE"
+	c.Assert(health(BackendConfig{Address: addr}, hc), Equals, false)
+
+	hc.ExpectedBody = ""
+	hc.ExpectedStatus = http.StatusOK
+	c.Assert(health(BackendConfig{Address: addr}, hc), Equals, false)
+}
+
+func (s *BalancerSuite) TestPassiveEjection(c *C) {
+	hc := HealthCheckConfig{PassiveFailures: 2, PassiveCooldown: time.Minute}
+	h := backendHealth{checked: true, healthy: true}
+	now := time.Now()
+
+	c.Assert(h.observeForward(false, hc, now), Equals, false)
+	c.Assert(h.observeForward(true, hc, now), Equals, false)
+	c.Assert(h.observeForward(false, hc, now), Equals, false)
+	c.Assert(h.observeForward(false, hc, now), Equals, true)
+	c.Assert(h.ejected(now), Equals, true)
+	c.Assert(h.ejected(now.Add(time.Minute)), Equals, false, Commentf("expected re-admission after cooldown"))
+
+	hc.PassiveFailures = 0
+	h = backendHealth{checked: true, healthy: true}
+	for range 10 {
+		c.Assert(h.observeForward(false, hc, now), Equals, false)
+	}
+}
+
+func (s *BalancerSuite) TestReportForwardSharesLock(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080")
+	defer restore()
+	state := &backendHealth{checked: true, healthy: true}
+	livePool.states["server1:8080"] = state
+
+	// Requests being balanced hold mu for reading, reporting the
+	// response must not wait for them.
+	mu.RLock()
+	done := make(chan struct{})
+	go func() {
+		reportForward("server1:8080", nil, http.StatusOK, time.Now())
+		close(done)
+	}()
+	reported := false
+	select {
+	case <-done:
+		reported = true
+	case <-time.After(time.Second):
+	}
+	mu.RUnlock()
+	c.Assert(reported, Equals, true)
+	stats, ejected := state.snapshot(time.Now())
+	c.Assert(stats.requests, Equals, 1)
+	c.Assert(ejected, Equals, false)
+}
+
+func (s *BalancerSuite) TestConcurrentHealthCheck(c *C) {
+	hanging := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer hanging.Close()
+	var addrs []string
+	for range 3 {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		addrs = append(addrs, strings.TrimPrefix(server.URL, "http://"))
+	}
+	hangingAddr := strings.TrimPrefix(hanging.URL, "http://")
+
+	restore := withBackends(c, strategyRoundRobin, append(addrs, hangingAddr)...)
+	defer restore()
+	config.HealthCheck.Timeout = 100 * time.Millisecond
+	config.HealthCheck.Jitter = 10 * time.Millisecond
+	livePool.healthy = nil
+
+	started := time.Now()
+	healthCheck()
+	c.Assert(time.Since(started) < 300*time.Millisecond, Equals, true, Commentf("probes run concurrently"))
+	c.Assert(livePool.healthy, DeepEquals, addrs)
+	c.Assert(livePool.states[hangingAddr].lastCheckOk, Equals, false)
+}
+
+func (s *BalancerSuite) TestOverloadBackoff(c *C) {
+	var shed, served int
+	overloaded := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		shed++
+		rw.Header().Set("Retry-After", "2")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer overloaded.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	defer healthy.Close()
+	overloadedAddr := strings.TrimPrefix(overloaded.URL, "http://")
+	healthyAddr := strings.TrimPrefix(healthy.URL, "http://")
+
+	restore := withBackends(c, strategyRoundRobin, overloadedAddr, healthyAddr)
+	defer restore()
+	config.HealthCheck.OverloadBackoff = time.Second
+	livePool.states[overloadedAddr] = &backendHealth{checked: true, healthy: true}
+	livePool.states[healthyAddr] = &backendHealth{checked: true, healthy: true}
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	c.Assert(rw.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(rw.Header().Get("Retry-After"), Equals, "2", Commentf("the backpressure reaches the client"))
+
+	state := livePool.states[overloadedAddr]
+	now := time.Now()
+	c.Assert(state.ejected(now), Equals, true)
+	c.Assert(state.ejected(now.Add(time.Second)), Equals, false, Commentf("the backoff is capped"))
+	c.Assert(state.forwardFailures, Equals, 0, Commentf("shedding is not a passive failure"))
+
+	for range 4 {
+		handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	}
+	c.Assert(shed, Equals, 1)
+	c.Assert(served, Equals, 4)
+
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	_, ok := shedRetryAfter(resp)
+	c.Assert(ok, Equals, false, Commentf("a 503 without Retry-After is a failure"))
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	retryAfter, ok := shedRetryAfter(resp)
+	c.Assert(ok && retryAfter > 58*time.Second, Equals, true, Commentf("got %s", retryAfter))
+}
@@ -0,0 +1,148 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+// HedgingConfig makes the balancer send another copy of a slow request to
+// a different backend instead of waiting for the first one. Only
+// idempotent requests with buffered bodies are hedged.
+type HedgingConfig struct {
+	// Delay is how long an attempt may go without a response before the
+	// next one starts, zero disables hedging.
+	Delay time.Duration `yaml:"delay"`
+	// MaxAttempts bounds the copies in flight, 2 when not set.
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+const defaultHedgeAttempts = 2
+
+func (hc HedgingConfig) validate() error {
+	if hc.Delay < 0 || hc.MaxAttempts < 0 || hc.MaxAttempts == 1 {
+		return fmt.Errorf("hedging needs a non-negative delay and at least 2 attempts")
+	}
+	return nil
+}
+
+func (hc HedgingConfig) attempts() int {
+	if hc.MaxAttempts == 0 {
+		return defaultHedgeAttempts
+	}
+	return hc.MaxAttempts
+}
+
+var (
+	errHedgeLost = fmt.Errorf("another backend answered first")
+
+	hedgesTotal = metrics.Default.NewCounter("lb_hedged_requests_total",
+		"Extra copies of slow requests sent to other backends.", "backend")
+)
+
+// hedge forwards the request to dst and, while no response arrives, to
+// another backend every hedging delay. The first response is copied back
+// and the other attempts are cancelled. Backends the request was sent to
+// are added to tried. Like forward, it writes nothing if every attempt
+// fails and returns the last error.
+func hedge(dst string, rw http.ResponseWriter, r *http.Request, ip string, body []byte, hc HedgingConfig, tried *[]string) error {
+	return proxy(dst, rw, r, ip, hedgingTransport{r: r, body: body, policy: hc, tried: tried})
+}
+
+// hedgingTransport races copies of a request addressed to one backend
+// against other backends.
+type hedgingTransport struct {
+	r      *http.Request
+	body   []byte
+	policy HedgingConfig
+	tried  *[]string
+}
+
+type attemptResult struct {
+	resp *http.Response
+	err  error
+}
+
+func (t hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	results := make(chan attemptResult, t.policy.attempts())
+	cancels := map[string]context.CancelCauseFunc{}
+	launch := func(dst string, release func()) {
+		ctx, cancel := context.WithCancelCause(req.Context())
+		cancels[dst] = cancel
+		attempt := req.Clone(ctx)
+		attempt.URL.Host, attempt.Host = dst, dst
+		if t.body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(t.body))
+		}
+		*t.tried = append(*t.tried, dst)
+		go func() {
+			resp, err := backendTransport{}.RoundTrip(attempt)
+			if release != nil {
+				if err != nil {
+					release()
+				} else {
+					onClose(resp, release)
+				}
+			}
+			results <- attemptResult{resp, err}
+		}()
+	}
+	launch(req.URL.Host, nil)
+	pending := 1
+
+	delay := time.NewTimer(t.policy.Delay)
+	defer delay.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				err = res.err
+				continue
+			}
+			// The winner runs until its response is copied, the request
+			// context ends it then.
+			winner := res.resp.Request.URL.Host
+			for attempt, cancel := range cancels {
+				if attempt != winner {
+					cancel(errHedgeLost)
+				}
+			}
+			go drain(results, pending)
+			return res.resp, nil
+		case <-delay.C:
+			if len(*t.tried) >= t.policy.attempts() {
+				continue
+			}
+			next, pickErr := pick(t.r, *t.tried...)
+			if pickErr != nil {
+				continue
+			}
+			release, slotErr := acquireSlot(t.r.Context(), next)
+			if slotErr != nil {
+				continue
+			}
+			hedgesTotal.Inc(next)
+			debugf("Hedging %s %s (request %s) to %s", t.r.Method, t.r.URL, requestId(t.r), next)
+			launch(next, release)
+			pending++
+			delay.Reset(t.policy.Delay)
+		}
+	}
+	return nil, err
+}
+
+// drain closes the responses of the attempts that lost the race.
+func drain(results <-chan attemptResult, pending int) {
+	for ; pending > 0; pending-- {
+		if res := <-results; res.err == nil {
+			res.resp.Body.Close()
+		}
+	}
+}
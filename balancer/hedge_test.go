@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestHedging(c *C) {
+	slowDone := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		defer close(slowDone)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		_, _ = rw.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	slowAddr, fastAddr := strings.TrimPrefix(slow.URL, "http://"), strings.TrimPrefix(fast.URL, "http://")
+	restore := withBackends(c, strategyRoundRobin, slowAddr, fastAddr)
+	defer restore()
+	config.Routes = []RouteConfig{{Prefix: "/api/", Backends: []string{slowAddr, fastAddr}, Hedging: HedgingConfig{Delay: 20 * time.Millisecond}}}
+
+	rw := httptest.NewRecorder()
+	started := time.Now()
+	handle(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	c.Assert(rw.Body.String(), Equals, "fast")
+	c.Assert(time.Since(started) < time.Second, Equals, true)
+	select {
+	case <-slowDone:
+	case <-time.After(time.Second):
+		c.Fatal("the slow attempt is not cancelled")
+	}
+	state, _ := livePool.state(slowAddr)
+	c.Assert(state == nil || state.forwardFailures == 0, Equals, true, Commentf("cancelled attempts are not failures"))
+}
+
+func (s *BalancerSuite) TestRouteRetries(c *C) {
+	cfg := defaultConfig()
+	cfg.Retries = 2
+	none := 0
+	cfg.Routes = []RouteConfig{
+		{Prefix: "/report", Retries: &none},
+		{Prefix: "/api/", Hedging: HedgingConfig{Delay: time.Second, MaxAttempts: 3}},
+	}
+	c.Assert(cfg.retries("/report"), Equals, 0)
+	c.Assert(cfg.retries("/api/v1/some-data"), Equals, 2)
+	c.Assert(cfg.hedging("/api/v1/some-data").attempts(), Equals, 3)
+	c.Assert(cfg.hedging("/report").Delay, Equals, time.Duration(0))
+
+	cfg.Routes[1].Hedging.MaxAttempts = 1
+	c.Assert(cfg.validate(), NotNil)
+	cfg.Routes[1].Hedging.MaxAttempts = 0
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(cfg.hedging("/api/v1/some-data").attempts(), Equals, defaultHedgeAttempts)
+}
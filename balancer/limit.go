@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	maxInFlight           = flags.Int("max-in-flight", 0, "maximum number of requests forwarded at once (0 means unlimited)")
+	maxInFlightPerBackend = flags.Int("max-in-flight-per-backend", 0, "maximum number of requests forwarded to a single backend at once (0 means unlimited)")
+	queueSize             = flags.Int("queue-size", 100, "how many requests may wait for a free slot when a limit is reached")
+	queueTimeout          = flags.Duration("queue-timeout", time.Second, "how long a request waits for a free slot before it is rejected")
+)
+
+var (
+	errQueueFull    = fmt.Errorf("request queue is full")
+	errQueueTimeout = fmt.Errorf("timed out waiting in the request queue")
+)
+
+type LimitsConfig struct {
+	MaxInFlight           int            `yaml:"maxInFlight"`
+	MaxInFlightPerBackend int            `yaml:"maxInFlightPerBackend"`
+	QueueSize             int            `yaml:"queueSize"`
+	QueueTimeout          time.Duration  `yaml:"queueTimeout"`
+	Priority              PriorityConfig `yaml:"priority"`
+}
+
+// limiter bounds the number of concurrent requests. Requests over the
+// limit wait in a bounded queue until a slot frees up or the deadline.
+type limiter struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+	queue   int64
+}
+
+func newLimiter(limit, queue int) *limiter {
+	return &limiter{
+		slots: make(chan struct{}, limit),
+		queue: int64(queue),
+	}
+}
+
+func (l *limiter) acquire(ctx context.Context, timeout time.Duration) error {
+	return l.acquireWithin(ctx, timeout, l.queue)
+}
+
+// acquireWithin is acquire with the queue bounded by queue instead of the
+// limiter's own size.
+func (l *limiter) acquireWithin(ctx context.Context, timeout time.Duration, queue int64) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.waiting.Add(1) > queue {
+		l.waiting.Add(-1)
+		return errQueueFull
+	}
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// limiters holds the limiter of every backend and the global one,
+// recreating them when the configured limit changes.
+var limiters = struct {
+	sync.Mutex
+	m map[string]*limiter
+}{m: make(map[string]*limiter)}
+
+const globalLimiter = ""
+
+func getLimiter(key string, limit, queue int) *limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+	l, ok := limiters.m[key]
+	if !ok || cap(l.slots) != limit || l.queue != int64(queue) {
+		l = newLimiter(limit, queue)
+		limiters.m[key] = l
+	}
+	return l
+}
+
+// acquireSlot reserves a slot for a request to dst, or a global one when
+// dst is empty. The returned function releases it.
+func acquireSlot(ctx context.Context, dst string) (func(), error) {
+	return acquireSlotWithin(ctx, dst, currentConfig().Limits.QueueSize)
+}
+
+func acquireSlotWithin(ctx context.Context, dst string, queue int) (func(), error) {
+	c := currentConfig()
+	limit := c.Limits.MaxInFlight
+	if dst != globalLimiter {
+		limit = c.Limits.MaxInFlightPerBackend
+		for _, backend := range c.Backends {
+			if backend.Address == dst && backend.MaxInFlight > 0 {
+				limit = backend.MaxInFlight
+			}
+		}
+	}
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	l := getLimiter(dst, limit, c.Limits.QueueSize)
+	if err := l.acquireWithin(ctx, c.Limits.QueueTimeout, int64(queue)); err != nil {
+		return nil, err
+	}
+	return l.release, nil
+}
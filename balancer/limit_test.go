@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestLimiterQueue(c *C) {
+	l := newLimiter(1, 1)
+	ctx := context.Background()
+	c.Assert(l.acquire(ctx, time.Second), IsNil)
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(ctx, time.Second) }()
+	for l.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(l.acquire(ctx, time.Second), Equals, errQueueFull)
+
+	l.release()
+	c.Assert(<-acquired, IsNil)
+	c.Assert(l.acquire(ctx, 10*time.Millisecond), Equals, errQueueTimeout)
+}
+
+func (s *BalancerSuite) TestSaturatedBackendIsSkipped(c *C) {
+	busy := httptest.NewServer(http.NotFoundHandler())
+	defer busy.Close()
+	free := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer free.Close()
+
+	busyAddr := strings.TrimPrefix(busy.URL, "http://")
+	restore := withBackends(c, strategyRoundRobin, busyAddr, strings.TrimPrefix(free.URL, "http://"))
+	defer restore()
+	config.Limits = LimitsConfig{MaxInFlightPerBackend: 1, QueueTimeout: time.Millisecond}
+
+	release, err := acquireSlot(context.Background(), busyAddr)
+	c.Assert(err, IsNil)
+	defer release()
+
+	for range 2 {
+		rw := httptest.NewRecorder()
+		handle(rw, httptest.NewRequest("POST", "/", strings.NewReader("data")))
+		c.Assert(rw.Code, Equals, http.StatusOK)
+		c.Assert(rw.Body.String(), Equals, "OK")
+	}
+}
+
+func (s *BalancerSuite) TestSlotReleasedOnTruncatedResponse(c *C) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			_, _ = rw.Write([]byte("OK"))
+			return
+		}
+		rw.Header().Set("Content-Length", "100")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("partial"))
+		rw.(http.Flusher).Flush()
+		conn, _, _ := http.NewResponseController(rw).Hijack()
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(backend.URL, "http://"))
+	defer restore()
+	config.Limits = LimitsConfig{MaxInFlightPerBackend: 1, QueueTimeout: time.Millisecond}
+
+	// The proxy aborts the handler with the response cut short, which only
+	// happens under a real server.
+	lb := httptest.NewServer(http.HandlerFunc(handle))
+	defer lb.Close()
+	lb.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	if resp, err := http.Get(lb.URL); err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, NotNil)
+	}
+	resp, err := http.Get(lb.URL)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+}
@@ -0,0 +1,73 @@
+package balancer
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var metricsPath = flags.String("metrics-path", "/metrics", "path the balancer serves its Prometheus metrics on (empty disables them)")
+
+var (
+	requestsTotal = metrics.Default.NewCounter("lb_requests_total",
+		"Requests forwarded to backends by response status code.", "backend", "code")
+	requestErrors = metrics.Default.NewCounter("lb_request_errors_total",
+		"Requests that failed to get a response from a backend.", "backend")
+	requestDuration = metrics.Default.NewHistogram("lb_request_duration_seconds",
+		"Time to get a response from a backend.", metrics.DefaultBuckets, "backend")
+	retriesTotal = metrics.Default.NewCounter("lb_retries_total",
+		"Requests retried on another backend.")
+)
+
+func init() {
+	metrics.Default.NewGaugeFunc("lb_in_flight_requests", "Requests currently being forwarded to a backend.",
+		[]string{"backend"}, func(emit func(float64, ...string)) {
+			mu.RLock()
+			defer mu.RUnlock()
+			for _, backend := range config.Backends {
+				emit(float64(livePool.inFlight(backend.Address)), backend.Address)
+			}
+		})
+	metrics.Default.NewGaugeFunc("lb_backend_healthy", "Whether a backend receives traffic (1) or not (0).",
+		[]string{"backend"}, func(emit func(float64, ...string)) {
+			mu.RLock()
+			defer mu.RUnlock()
+			now := time.Now()
+			for _, backend := range config.Backends {
+				healthy := 0.0
+				if state, ok := livePool.state(backend.Address); ok && state.healthy && !state.ejected(now) {
+					healthy = 1
+				}
+				emit(healthy, backend.Address)
+			}
+		})
+}
+
+// observeForward records the outcome of a single forwarding attempt.
+func observeForward(dst string, status int, err error, started time.Time) {
+	requestDuration.Observe(time.Since(started).Seconds(), dst)
+	if err != nil {
+		requestErrors.Inc(dst)
+		return
+	}
+	requestsTotal.Inc(dst, strconv.Itoa(status))
+}
+
+// metricsRoute names the route of a request in the HTTP metrics: the
+// endpoints of the balancer by their paths and the forwarded requests by
+// the prefix of their configured route, or "proxy" without one.
+func metricsRoute(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		for _, path := range []string{*metricsPath, *statusPath, *versionPath, *selfHealthPath} {
+			if path != "" && r.URL.Path == path {
+				return path
+			}
+		}
+	}
+	if route := currentConfig().route(r.URL.Path); route != nil {
+		return route.Prefix
+	}
+	return "proxy"
+}
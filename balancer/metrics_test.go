@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestMetricsEndpoint(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	restore := withBackends(c, strategyRoundRobin, addr)
+	defer restore()
+	livePool.states[addr] = &backendHealth{checked: true, healthy: true}
+
+	serve(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/some-data", nil))
+
+	rw := httptest.NewRecorder()
+	serve(rw, httptest.NewRequest("GET", *metricsPath, nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+
+	for _, line := range []string{
+		fmt.Sprintf(`lb_requests_total{backend=%q,code="418"} 1`, addr),
+		fmt.Sprintf(`lb_request_duration_seconds_count{backend=%q} 1`, addr),
+		fmt.Sprintf(`lb_in_flight_requests{backend=%q} 0`, addr),
+		fmt.Sprintf(`lb_backend_healthy{backend=%q} 1`, addr),
+	} {
+		c.Assert(strings.Contains(rw.Body.String(), line), Equals, true, Commentf("missing %s in\n%s", line, rw.Body.String()))
+	}
+}
+
+func (s *BalancerSuite) TestMetricsRoute(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080")
+	defer restore()
+	cfg := *config
+	cfg.Routes = []RouteConfig{{Prefix: "/report"}}
+	c.Assert(apply(&cfg), IsNil)
+
+	for target, route := range map[string]string{
+		"GET " + *metricsPath:          *metricsPath,
+		"GET " + *statusPath:           *statusPath,
+		"POST " + *statusPath:          "proxy",
+		"GET /report?author=x":         "/report",
+		"GET /api/v1/some-data?key=k1": "proxy",
+	} {
+		method, path, _ := strings.Cut(target, " ")
+		c.Assert(metricsRoute(httptest.NewRequest(method, path, nil)), Equals, route, Commentf("%s", target))
+	}
+}
@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var (
+	mirrorBackend = flags.String("mirror-backend", "", "shadow backend (host:port) receiving a copy of the traffic, responses are discarded")
+	mirrorPercent = flags.Float64("mirror-percent", 100, "percentage of requests mirrored to the shadow backend")
+	mirrorTimeout = flags.Duration("mirror-timeout", 5*time.Second, "timeout of mirrored requests")
+)
+
+// maxMirrorsInFlight bounds the goroutines spent on mirroring, requests
+// over it are not mirrored.
+const maxMirrorsInFlight = 100
+
+type MirrorConfig struct {
+	Backend string        `yaml:"backend"`
+	Percent float64       `yaml:"percent"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (mc MirrorConfig) validate() error {
+	if mc.Backend == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(mc.Backend); err != nil {
+		return fmt.Errorf("invalid mirror backend %q: %w", mc.Backend, err)
+	}
+	if mc.Percent < 0 || mc.Percent > 100 {
+		return fmt.Errorf("mirror percent must be between 0 and 100")
+	}
+	if mc.Timeout <= 0 {
+		return fmt.Errorf("mirror timeout must be positive")
+	}
+	return nil
+}
+
+var (
+	mirrorSlots   = make(chan struct{}, maxMirrorsInFlight)
+	mirroredTotal = metrics.Default.NewCounter("lb_mirrored_requests_total",
+		"Requests copied to the shadow backend by result.", "result")
+)
+
+// mirror sends a copy of the request to the shadow backend in the
+// background. Only requests whose body has been buffered are mirrored.
+func mirror(r *http.Request, body []byte, buffered bool) {
+	mc := currentConfig().Mirror
+	if mc.Backend == "" || !buffered || rand.Float64()*100 >= mc.Percent {
+		return
+	}
+	select {
+	case mirrorSlots <- struct{}{}:
+	default:
+		mirroredTotal.Inc("dropped")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mc.Timeout)
+	shadow := r.Clone(ctx)
+	shadow.RequestURI = ""
+	shadow.URL.Scheme = scheme()
+	shadow.URL.Host = mc.Backend
+	shadow.Host = mc.Backend
+	removeHopHeaders(shadow.Header)
+	shadow.Header.Set("lb-mirror", "true")
+	shadow.Body = http.NoBody
+	if len(body) > 0 {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	go func() {
+		defer func() { <-mirrorSlots }()
+		defer cancel()
+		resp, err := backendClient.Do(shadow)
+		if err != nil {
+			mirroredTotal.Inc("error")
+			debugf("Mirrored request to %s failed: %s", mc.Backend, err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		mirroredTotal.Inc("sent")
+	}()
+}
+
+func mirrorConfig() MirrorConfig {
+	return MirrorConfig{Backend: *mirrorBackend, Percent: *mirrorPercent, Timeout: *mirrorTimeout}
+}
@@ -0,0 +1,52 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestMirror(c *C) {
+	mirrored := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.RequestURI() + " " + string(body) + " " + r.Header.Get("lb-mirror")
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer primary.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(primary.URL, "http://"))
+	defer restore()
+	config.Mirror = MirrorConfig{Backend: strings.TrimPrefix(shadow.URL, "http://"), Percent: 100, Timeout: time.Second}
+	c.Assert(config.Mirror.validate(), IsNil)
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("POST", "/db/key?x=1", strings.NewReader(`{"value":"1"}`)))
+	c.Assert(rw.Code, Equals, http.StatusOK, Commentf("shadow responses do not affect clients"))
+	c.Assert(rw.Body.String(), Equals, "OK")
+	select {
+	case req := <-mirrored:
+		c.Assert(req, Equals, `POST /db/key?x=1 {"value":"1"} true`)
+	case <-time.After(time.Second):
+		c.Fatal("request is not mirrored")
+	}
+
+	config.Mirror.Percent = 0
+	handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	select {
+	case req := <-mirrored:
+		c.Fatalf("unexpected mirrored request %s", req)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(MirrorConfig{Backend: "shadow", Percent: 10, Timeout: time.Second}.validate(), NotNil)
+	c.Assert(MirrorConfig{Backend: "shadow:8080", Percent: 120, Timeout: time.Second}.validate(), NotNil)
+}
@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+var (
+	outlierDetection     = flags.Bool("outlier-detection", false, "eject backends whose latency or error rate is far worse than their peers'")
+	outlierLatencyFactor = flags.Float64("outlier-latency-factor", 3, "latency EWMA relative to the median of other backends that makes a backend an outlier")
+	outlierErrorRatio    = flags.Float64("outlier-error-ratio", 0.5, "error ratio EWMA that makes a backend an outlier")
+	outlierMinRequests   = flags.Int("outlier-min-requests", 20, "requests a backend must serve before it can be considered an outlier")
+	outlierEjection      = flags.Duration("outlier-ejection", 30*time.Second, "time an outlier stays out of the pool")
+	outlierMaxEjected    = flags.Float64("outlier-max-ejected", 0.5, "maximum fraction of backends ejected at once")
+)
+
+// ewmaWeight is the weight of the latest observation in the moving averages.
+const ewmaWeight = 0.1
+
+type OutlierConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	LatencyFactor float64       `yaml:"latencyFactor"`
+	ErrorRatio    float64       `yaml:"errorRatio"`
+	MinRequests   int           `yaml:"minRequests"`
+	Ejection      time.Duration `yaml:"ejection"`
+	MaxEjected    float64       `yaml:"maxEjected"`
+}
+
+// backendStats keeps exponentially weighted moving averages of the
+// latency (in seconds) and error ratio of forwarded requests.
+type backendStats struct {
+	requests int
+	latency  float64
+	errors   float64
+}
+
+func (s *backendStats) observe(latency time.Duration, ok bool) {
+	failure := 0.0
+	if !ok {
+		failure = 1
+	}
+	if s.requests == 0 {
+		s.latency, s.errors = latency.Seconds(), failure
+	} else {
+		s.latency += ewmaWeight * (latency.Seconds() - s.latency)
+		s.errors += ewmaWeight * (failure - s.errors)
+	}
+	s.requests++
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	values = slices.Clone(values)
+	slices.Sort(values)
+	if n := len(values); n%2 == 0 {
+		return (values[n/2-1] + values[n/2]) / 2
+	}
+	return values[len(values)/2]
+}
+
+// snapshot returns the stats of the backend and whether it is ejected.
+func (h *backendHealth) snapshot(now time.Time) (backendStats, bool) {
+	h.passive.Lock()
+	defer h.passive.Unlock()
+	return h.stats, now.Before(h.ejectedUntil)
+}
+
+// detectOutlier ejects dst if its stats are much worse than the ones of
+// the other backends. Must be called with mu held, at least for reading.
+func detectOutlier(dst string, now time.Time) {
+	oc := config.OutlierDetection
+	state := livePool.states[dst]
+	if !oc.Enabled || state == nil {
+		return
+	}
+	stats, ejected := state.snapshot(now)
+	if stats.requests < oc.MinRequests || ejected {
+		return
+	}
+
+	var peers []float64
+	ejectedPeers := 0
+	for addr, other := range livePool.states {
+		otherStats, otherEjected := other.snapshot(now)
+		if otherEjected {
+			ejectedPeers++
+		} else if addr != dst && otherStats.requests >= oc.MinRequests {
+			peers = append(peers, otherStats.latency)
+		}
+	}
+	if float64(ejectedPeers+1) > oc.MaxEjected*float64(len(livePool.states)) {
+		return
+	}
+
+	reason := ""
+	switch {
+	case stats.errors >= oc.ErrorRatio:
+		reason = "error ratio"
+	case len(peers) > 0 && stats.latency > oc.LatencyFactor*median(peers):
+		reason = "latency"
+	default:
+		return
+	}
+	state.passive.Lock()
+	state.ejectedUntil = now.Add(oc.Ejection)
+	state.stats = backendStats{}
+	state.passive.Unlock()
+	log.Printf("Backend %s ejected for %s as a %s outlier", dst, oc.Ejection, reason)
+}
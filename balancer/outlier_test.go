@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestOutlierDetection(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080", "server3:8080")
+	defer restore()
+	config.OutlierDetection = OutlierConfig{
+		Enabled:       true,
+		LatencyFactor: 3,
+		ErrorRatio:    0.5,
+		MinRequests:   5,
+		Ejection:      time.Minute,
+		MaxEjected:    0.5,
+	}
+	config.HealthCheck.PassiveFailures = 0
+	for _, addr := range []string{"server1:8080", "server2:8080", "server3:8080"} {
+		livePool.states[addr] = &backendHealth{checked: true, healthy: true}
+	}
+
+	now := time.Now()
+	for range 5 {
+		reportForward("server1:8080", nil, 200, now.Add(-10*time.Millisecond))
+		reportForward("server2:8080", nil, 200, now.Add(-12*time.Millisecond))
+	}
+	for range 4 {
+		reportForward("server3:8080", nil, 200, now.Add(-100*time.Millisecond))
+	}
+	c.Assert(livePool.states["server3:8080"].ejected(time.Now()), Equals, false, Commentf("not enough requests yet"))
+
+	reportForward("server3:8080", nil, 200, now.Add(-100*time.Millisecond))
+	c.Assert(livePool.states["server3:8080"].ejected(time.Now()), Equals, true, Commentf("expected latency outlier ejection"))
+
+	for range 5 {
+		reportForward("server2:8080", nil, 500, now.Add(-10*time.Millisecond))
+	}
+	c.Assert(livePool.states["server2:8080"].ejected(time.Now()), Equals, false, Commentf("at most half of the backends may be ejected"))
+}
+
+func (s *BalancerSuite) TestBackendStats(c *C) {
+	var stats backendStats
+	stats.observe(100*time.Millisecond, true)
+	c.Assert(stats.latency, Equals, 0.1)
+	c.Assert(stats.errors, Equals, 0.0)
+	stats.observe(200*time.Millisecond, false)
+	c.Assert(stats.latency > 0.1 && stats.latency < 0.2, Equals, true)
+	c.Assert(stats.errors, Equals, ewmaWeight)
+	c.Assert(median([]float64{3, 1, 2}), Equals, 2.0)
+	c.Assert(median([]float64{4, 1, 2, 3}), Equals, 2.5)
+}
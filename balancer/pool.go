@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"slices"
+	"time"
+)
+
+// backendPool is the runtime state of the backends: their health, weight,
+// stats and in-flight requests, the list of the healthy ones and a
+// version that grows whenever the list changes. Health checks build a new
+// pool aside and swap it in with mu held, so readers always see a
+// complete one; the passive checks update the entries in place, each one
+// under its own lock.
+type backendPool struct {
+	version uint64
+	healthy []string
+	states  map[string]*backendHealth
+	// conns counts the requests in flight to every backend, across the
+	// successive pools.
+	conns *connCounter
+	// probed is set once the backends went through a round of probes.
+	probed bool
+}
+
+// livePool is guarded by mu.
+var livePool = newBackendPool()
+
+func newBackendPool() *backendPool {
+	return &backendPool{healthy: []string{}, states: map[string]*backendHealth{}, conns: connections}
+}
+
+// withHealthy returns a copy of the pool with the given healthy backends,
+// the result of a round of probes.
+func (p *backendPool) withHealthy(healthy []string, states map[string]*backendHealth) *backendPool {
+	next := &backendPool{version: p.version, healthy: healthy, states: states, conns: p.conns, probed: true}
+	if !slices.Equal(p.healthy, healthy) {
+		next.version++
+	}
+	return next
+}
+
+func (p *backendPool) isHealthy(addr string) bool {
+	return slices.Contains(p.healthy, addr)
+}
+
+// available reports whether the backend is healthy and not ejected.
+func (p *backendPool) available(addr string, now time.Time) bool {
+	if !p.isHealthy(addr) {
+		return false
+	}
+	state, ok := p.states[addr]
+	return !ok || !state.ejected(now)
+}
+
+func (p *backendPool) inFlight(addr string) int {
+	return p.conns.Get(addr)
+}
+
+func (p *backendPool) state(addr string) (*backendHealth, bool) {
+	state, ok := p.states[addr]
+	return state, ok
+}
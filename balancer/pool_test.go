@@ -0,0 +1,24 @@
+package balancer
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestPoolVersion(c *C) {
+	p := newBackendPool()
+	states := map[string]*backendHealth{"server1:8080": {checked: true, healthy: true}}
+
+	next := p.withHealthy([]string{"server1:8080"}, states)
+	c.Assert(next.version, Equals, p.version+1)
+	c.Assert(p.healthy, HasLen, 0, Commentf("the previous pool must stay intact"))
+	c.Assert(next.conns, Equals, p.conns, Commentf("in-flight requests are counted across pools"))
+
+	same := next.withHealthy([]string{"server1:8080"}, states)
+	c.Assert(same.version, Equals, next.version)
+
+	states["server1:8080"].ejectedUntil = time.Now().Add(time.Minute)
+	c.Assert(same.available("server1:8080", time.Now()), Equals, false)
+	c.Assert(same.available("server2:8080", time.Now()), Equals, false)
+}
@@ -0,0 +1,154 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var (
+	highPriorityPaths = flags.String("high-priority-paths", "", "comma-separated path prefixes of high priority requests, which may always wait for a free slot")
+	lowPriorityPaths  = flags.String("low-priority-paths", "", "comma-separated path prefixes of low priority requests, which are shed first under overload")
+	lowPriorityShare  = flags.Float64("low-priority-share", 0.5, "fraction of -max-in-flight low priority requests may use, they never wait in the queue")
+	priorityHeader    = flags.String("priority-header", "", "request header naming the priority class (high, normal or low) of the request")
+)
+
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+var errShed = fmt.Errorf("shed to preserve capacity for higher priority requests")
+
+// PriorityRule assigns the class to requests with the path prefix and,
+// if Header is set, the header value (any value when Value is empty).
+type PriorityRule struct {
+	Path   string `yaml:"path"`
+	Header string `yaml:"header"`
+	Value  string `yaml:"value"`
+	Class  string `yaml:"class"`
+}
+
+func (pr PriorityRule) matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, pr.Path) {
+		return false
+	}
+	if pr.Header == "" {
+		return true
+	}
+	values := r.Header.Values(pr.Header)
+	return len(values) > 0 && (pr.Value == "" || values[0] == pr.Value)
+}
+
+// PriorityConfig classifies requests when the balancer is overloaded,
+// i.e. Limits.MaxInFlight is reached. Low priority requests only get
+// their share of the slots and are shed instead of queued, high priority
+// ones may wait in the queue even when it is full. Shares map the classes
+// to the fraction of the slots they may use.
+type PriorityConfig struct {
+	Header string             `yaml:"header"`
+	Rules  []PriorityRule     `yaml:"rules"`
+	Shares map[string]float64 `yaml:"shares"`
+}
+
+func priorityConfig() PriorityConfig {
+	pc := PriorityConfig{
+		Header: *priorityHeader,
+		Shares: map[string]float64{priorityLow: *lowPriorityShare},
+	}
+	for _, path := range splitList(*highPriorityPaths) {
+		pc.Rules = append(pc.Rules, PriorityRule{Path: path, Class: priorityHigh})
+	}
+	for _, path := range splitList(*lowPriorityPaths) {
+		pc.Rules = append(pc.Rules, PriorityRule{Path: path, Class: priorityLow})
+	}
+	return pc
+}
+
+func validPriority(class string) bool {
+	return class == priorityHigh || class == priorityNormal || class == priorityLow
+}
+
+func (pc PriorityConfig) validate() error {
+	for _, rule := range pc.Rules {
+		if !validPriority(rule.Class) {
+			return fmt.Errorf("unknown priority class %q", rule.Class)
+		}
+		if !strings.HasPrefix(rule.Path, "/") && (rule.Path != "" || rule.Header == "") {
+			return fmt.Errorf("priority rule path must start with /: %q", rule.Path)
+		}
+	}
+	for class, share := range pc.Shares {
+		if !validPriority(class) {
+			return fmt.Errorf("unknown priority class %q", class)
+		}
+		if share <= 0 || share > 1 {
+			return fmt.Errorf("share of the %s priority class must be in (0, 1]", class)
+		}
+	}
+	return nil
+}
+
+// classify returns the priority class of r. The header takes precedence
+// over the rules, of which the first matching one wins.
+func (pc PriorityConfig) classify(r *http.Request) string {
+	if pc.Header != "" {
+		if class := strings.ToLower(r.Header.Get(pc.Header)); validPriority(class) {
+			return class
+		}
+	}
+	for _, rule := range pc.Rules {
+		if rule.matches(r) {
+			return rule.Class
+		}
+	}
+	return priorityNormal
+}
+
+func (pc PriorityConfig) share(class string) float64 {
+	if share, ok := pc.Shares[class]; ok {
+		return share
+	}
+	return 1
+}
+
+var shedTotal = metrics.Default.NewCounter("lb_shed_requests_total",
+	"Requests rejected because the balancer is overloaded, by priority class and reason.", "priority", "reason")
+
+// admitRequest reserves a global slot for r according to its priority
+// class. The returned function releases it.
+func admitRequest(r *http.Request) (func(), error) {
+	c := currentConfig()
+	pc := c.Limits.Priority
+	class := pc.classify(r)
+	if limit := c.Limits.MaxInFlight; limit > 0 {
+		share := pc.share(class)
+		l := getLimiter(globalLimiter, limit, c.Limits.QueueSize)
+		if share < 1 && len(l.slots) >= max(int(share*float64(limit)), 1) {
+			shedTotal.Inc(class, "share")
+			return nil, errShed
+		}
+	}
+	queue := c.Limits.QueueSize
+	switch class {
+	case priorityHigh:
+		queue = math.MaxInt
+	case priorityLow:
+		if pc.share(class) < 1 {
+			queue = 0
+		}
+	}
+	release, err := acquireSlotWithin(r.Context(), globalLimiter, queue)
+	switch {
+	case errors.Is(err, errQueueFull):
+		shedTotal.Inc(class, "queue full")
+	case errors.Is(err, errQueueTimeout):
+		shedTotal.Inc(class, "queue timeout")
+	}
+	return release, err
+}
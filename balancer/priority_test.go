@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestPriorityClassify(c *C) {
+	pc := PriorityConfig{
+		Header: "lb-priority",
+		Rules: []PriorityRule{
+			{Path: "/report", Class: priorityLow},
+			{Header: "x-critical", Class: priorityHigh},
+		},
+	}
+	c.Assert(pc.validate(), IsNil)
+	c.Assert(pc.classify(httptest.NewRequest("GET", "/report", nil)), Equals, priorityLow)
+	c.Assert(pc.classify(httptest.NewRequest("GET", "/api/v1/some-data", nil)), Equals, priorityNormal)
+
+	r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	r.Header.Set("x-critical", "1")
+	c.Assert(pc.classify(r), Equals, priorityHigh)
+
+	r = httptest.NewRequest("GET", "/report", nil)
+	r.Header.Set("lb-priority", "High")
+	c.Assert(pc.classify(r), Equals, priorityHigh, Commentf("the header takes precedence"))
+
+	c.Assert(PriorityConfig{Rules: []PriorityRule{{Path: "/", Class: "urgent"}}}.validate(), ErrorMatches, `unknown priority class "urgent"`)
+	c.Assert(PriorityConfig{Shares: map[string]float64{priorityLow: 0}}.validate(), NotNil)
+}
+
+func (s *BalancerSuite) TestLowPriorityIsShedFirst(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080")
+	defer restore()
+	config.Limits = LimitsConfig{
+		MaxInFlight:  4,
+		QueueSize:    1,
+		QueueTimeout: time.Second,
+		Priority: PriorityConfig{
+			Rules:  []PriorityRule{{Path: "/report", Class: priorityLow}},
+			Shares: map[string]float64{priorityLow: 0.5},
+		},
+	}
+	report := httptest.NewRequest("GET", "/report", nil)
+	data := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	var releases []func()
+	for range 2 {
+		release, err := admitRequest(report)
+		c.Assert(err, IsNil)
+		releases = append(releases, release)
+	}
+	_, err := admitRequest(report)
+	c.Assert(err, Equals, errShed)
+
+	for range 2 {
+		release, err := admitRequest(data)
+		c.Assert(err, IsNil, Commentf("normal requests keep the rest of the slots"))
+		releases = append(releases, release)
+	}
+
+	// With every slot taken, normal requests wait in the queue.
+	acquired := make(chan error)
+	go func() {
+		release, err := acquireSlot(context.Background(), globalLimiter)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for getLimiter(globalLimiter, 4, 1).waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	high := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	config.Limits.Priority.Header = "lb-priority"
+	high.Header.Set("lb-priority", priorityHigh)
+	highAcquired := make(chan error)
+	go func() {
+		release, err := admitRequest(high)
+		if err == nil {
+			release()
+		}
+		highAcquired <- err
+	}()
+	_, err = admitRequest(data)
+	c.Assert(err, Equals, errQueueFull)
+
+	for _, release := range releases {
+		release()
+	}
+	c.Assert(<-acquired, IsNil)
+	c.Assert(<-highAcquired, IsNil, Commentf("high priority requests wait even when the queue is full"))
+}
@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+const (
+	probeHTTP = "http"
+	probeTCP  = "tcp"
+	probeExec = "exec"
+)
+
+// ProbeConfig selects how the health of a backend is checked. The http
+// probe (the default) requests the health check path, tcp only connects
+// and exec runs a command that must exit with status 0; the command gets
+// the backend address in the LB_BACKEND environment variable. Exec probes
+// are only accepted from the config file, not the admin API.
+type ProbeConfig struct {
+	Type string `yaml:"type" json:"type,omitempty"`
+	// Address is probed instead of the backend address, e.g. the RESP
+	// listener of the db next to its HTTP API.
+	Address string   `yaml:"address" json:"address,omitempty"`
+	Command []string `yaml:"command" json:"command,omitempty"`
+}
+
+func (pc *ProbeConfig) validate() error {
+	switch pc.Type {
+	case "", probeHTTP, probeTCP:
+		if len(pc.Command) > 0 {
+			return fmt.Errorf("probe command requires the exec probe")
+		}
+	case probeExec:
+		if len(pc.Command) == 0 {
+			return fmt.Errorf("exec probe without a command")
+		}
+		if pc.Address != "" {
+			return fmt.Errorf("exec probe cannot have an address")
+		}
+	default:
+		return fmt.Errorf("unknown probe type %q", pc.Type)
+	}
+	if pc.Address != "" {
+		if _, _, err := net.SplitHostPort(pc.Address); err != nil {
+			return fmt.Errorf("invalid probe address: %w", err)
+		}
+	}
+	return nil
+}
+
+// target is the address to probe for the backend at addr.
+func (pc ProbeConfig) target(addr string) string {
+	if pc.Address != "" {
+		return pc.Address
+	}
+	return addr
+}
+
+func tcpProbe(ctx context.Context, addr string) bool {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func execProbe(ctx context.Context, command []string, addr string) bool {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "LB_BACKEND="+addr)
+	return cmd.Run() == nil
+}
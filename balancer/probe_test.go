@@ -0,0 +1,46 @@
+package balancer
+
+import (
+	"net"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestTcpProbe(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	addr := l.Addr().String()
+	hc := HealthCheckConfig{Timeout: time.Second}
+
+	backend := BackendConfig{Address: "127.0.0.1:1", Probe: ProbeConfig{Type: probeTCP, Address: addr}}
+	c.Assert(health(backend, hc), Equals, true)
+
+	_ = l.Close()
+	c.Assert(health(backend, hc), Equals, false)
+}
+
+func (s *BalancerSuite) TestExecProbe(c *C) {
+	hc := HealthCheckConfig{Timeout: time.Second}
+	backend := BackendConfig{Address: "db:6379", Probe: ProbeConfig{
+		Type:    probeExec,
+		Command: []string{"sh", "-c", `test "$LB_BACKEND" = db:6379`},
+	}}
+	c.Assert(health(backend, hc), Equals, true)
+
+	backend.Address = "db:6380"
+	c.Assert(health(backend, hc), Equals, false)
+
+	backend.Probe.Command = []string{"sleep", "5"}
+	hc.Timeout = 50 * time.Millisecond
+	c.Assert(health(backend, hc), Equals, false)
+}
+
+func (s *BalancerSuite) TestProbeValidation(c *C) {
+	c.Assert((&ProbeConfig{}).validate(), IsNil)
+	c.Assert((&ProbeConfig{Type: probeTCP, Address: "db:6379"}).validate(), IsNil)
+	c.Assert((&ProbeConfig{Type: "icmp"}).validate(), ErrorMatches, `unknown probe type "icmp"`)
+	c.Assert((&ProbeConfig{Type: probeExec}).validate(), ErrorMatches, "exec probe without a command")
+	c.Assert((&ProbeConfig{Type: probeTCP, Command: []string{"true"}}).validate(), NotNil)
+	c.Assert((&ProbeConfig{Type: probeTCP, Address: "db"}).validate(), ErrorMatches, "invalid probe address.*")
+}
@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+var errBackendTimeout = fmt.Errorf("backend timeout")
+
+// writeSlack is left after the backend timeout for writing the response,
+// or the error replacing it, to the client.
+const writeSlack = time.Second
+
+// forward sends the request to dst and copies the response back. If no
+// response could be obtained nothing is written and the error is returned,
+// so the caller may retry or report the failure.
+func forward(dst string, rw http.ResponseWriter, r *http.Request, ip string) error {
+	return proxy(dst, rw, r, ip, backendTransport{})
+}
+
+// proxy forwards the request with a reverse proxy sending it through the
+// transport, which gets it addressed to dst. Hop-by-hop headers,
+// trailers, flushing of streamed responses and aborting responses that
+// fail halfway are left to httputil.ReverseProxy; the balancer adds its
+// headers, the timeout and reports failures instead of writing them.
+func proxy(dst string, rw http.ResponseWriter, r *http.Request, ip string, transport http.RoundTripper) error {
+	// The timeout covers the whole exchange except for server-sent
+	// events, which may legitimately stay open for a long time.
+	// The write deadline of the server follows it, so timeouts longer
+	// than the one of the server are not cut short.
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	timeout := currentConfig().requestTimeout(dst, r.URL.Path)
+	_ = http.NewResponseController(rw).SetWriteDeadline(time.Now().Add(timeout + writeSlack))
+	timer := time.AfterFunc(timeout, func() {
+		cancel(errBackendTimeout)
+	})
+	defer timer.Stop()
+
+	var failure error
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			rewrite(pr, dst, ip)
+		},
+		Transport:     transport,
+		FlushInterval: *flushInterval,
+		ModifyResponse: func(resp *http.Response) error {
+			currentConfig().Headers.Response.apply(resp.Header)
+			if *traceEnabled {
+				resp.Header.Set("lb-from", resp.Request.URL.Host)
+			}
+			if isEventStream(resp) && timer.Stop() {
+				_ = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+			}
+			return nil
+		},
+		ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, err error) {
+			failure = err
+		},
+	}
+	rp.ServeHTTP(rw, r.WithContext(ctx))
+	return failure
+}
+
+// rewrite addresses the outgoing request to dst and applies the header
+// rules.
+func rewrite(pr *httputil.ProxyRequest, dst, ip string) {
+	out := pr.Out
+	out.URL.Scheme = scheme()
+	out.URL.Host = dst
+	out.Host = dst
+	// ReverseProxy drops the forwarding headers, they are restored and
+	// extended as configured.
+	for _, name := range forwardingHeaders {
+		if values, ok := pr.In.Header[name]; ok {
+			out.Header[name] = slices.Clone(values)
+		}
+	}
+	if *forwardClientIp {
+		setForwardedHeaders(out.Header, pr.In)
+	}
+	headers := currentConfig().Headers
+	headers.Request.apply(out.Header)
+	headers.Identity.set(out.Header, ip)
+}
+
+// backendTransport sends requests to the backend they are addressed to.
+// It traces them and feeds their outcome into metrics and passive health
+// checks, which for gRPC calls happens once the trailers are read.
+type backendTransport struct{}
+
+func (backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dst := req.URL.Host
+	connections.Inc(dst)
+	ctx, span := tracing.Start(req.Context(), "forward", tracing.KindClient)
+	span.SetAttribute("lb.backend", dst)
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+	done := func() {
+		span.End()
+		connections.Dec(dst)
+	}
+
+	transport := backendClient.Transport
+	started := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		if cause := context.Cause(ctx); cause == errBackendTimeout || cause == errHedgeLost {
+			err = fmt.Errorf("%w: %w", cause, err)
+		}
+		span.SetError(err)
+		done()
+		if errors.Is(err, errHedgeLost) {
+			// Another backend answered first, this one is not to blame.
+			return nil, err
+		}
+		observeForward(dst, 0, err, started)
+		reportForward(dst, err, 0, started)
+		log.Printf("Failed to get response from %s for request %s: %s", dst, requestId(req), err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	observeForward(dst, resp.StatusCode, nil, started)
+	if retryAfter, shed := shedRetryAfter(resp); shed {
+		reportOverload(dst, retryAfter, started)
+		onClose(resp, done)
+		return resp, nil
+	}
+	if !isGrpc(resp.Header) {
+		reportForward(dst, nil, resp.StatusCode, started)
+		onClose(resp, done)
+		return resp, nil
+	}
+	onClose(resp, func() {
+		reportForward(dst, grpcCallError(resp), resp.StatusCode, started)
+		done()
+	})
+	return resp, nil
+}
+
+// onClose makes closing the response body call done, once.
+func onClose(resp *http.Response, done func()) {
+	resp.Body = &hookedBody{ReadCloser: resp.Body, done: done}
+}
+
+type hookedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *hookedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+const (
+	proxyProtocolOff      = "off"
+	proxyProtocolOptional = "optional"
+	proxyProtocolRequired = "required"
+)
+
+var (
+	proxyProtocol        = flags.String("proxy-protocol", proxyProtocolOff, "whether clients connect through a PROXY protocol (v1/v2) sending load balancer, one of: off, optional, required")
+	proxyProtocolTrusted = flags.String("proxy-protocol-trusted", "", "comma separated list of CIDRs allowed to send PROXY protocol headers, empty trusts any")
+)
+
+// withProxyProtocol configures the frontend according to the flags.
+func withProxyProtocol(s httptools.Server) (httptools.Server, error) {
+	switch *proxyProtocol {
+	case proxyProtocolOff:
+		return s, nil
+	case proxyProtocolOptional, proxyProtocolRequired:
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol mode %q", *proxyProtocol)
+	}
+	p := httptools.ProxyProtocol{Required: *proxyProtocol == proxyProtocolRequired}
+	if list := splitList(*proxyProtocolTrusted); len(list) > 0 {
+		trusted, err := parsePrefixes(list)
+		if err != nil {
+			return nil, err
+		}
+		p.Trusted = func(peer net.Addr) bool {
+			return containsAddr(trusted, peer.String())
+		}
+	}
+	return httptools.WithProxyProtocol(s, p), nil
+}
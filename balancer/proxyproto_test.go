@@ -0,0 +1,14 @@
+package balancer
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestProxyProtocolFlags(c *C) {
+	prev := *proxyProtocol
+	defer func() { *proxyProtocol = prev }()
+
+	*proxyProtocol = "sometimes"
+	_, err := withProxyProtocol(nil)
+	c.Assert(err, NotNil)
+}
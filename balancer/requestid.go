@@ -0,0 +1,52 @@
+package balancer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+var (
+	requestIdHeader = flags.String("request-id-header", "X-Request-Id", "header carrying the request ID to backends and back to clients")
+	trustRequestId  = flags.Bool("trust-request-id", true, "whether to keep request IDs sent by clients instead of generating new ones")
+)
+
+const maxRequestIdLength = 128
+
+func newRequestId() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// validRequestId accepts IDs of printable ASCII characters without
+// spaces, so they can be safely echoed into headers and logs.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func requestId(r *http.Request) string {
+	return r.Header.Get(*requestIdHeader)
+}
+
+// withRequestId makes sure the request carries an ID, which is then
+// forwarded to the backend and echoed in the response.
+func withRequestId(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := requestId(r)
+		if !*trustRequestId || !validRequestId(id) {
+			id = newRequestId()
+			r.Header.Set(*requestIdHeader, id)
+		}
+		rw.Header().Set(*requestIdHeader, id)
+		next(rw, r)
+	}
+}
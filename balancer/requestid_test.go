@@ -0,0 +1,35 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestRequestId(c *C) {
+	var forwarded string
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		forwarded = requestId(r)
+	}
+
+	rw := httptest.NewRecorder()
+	withRequestId(next)(rw, httptest.NewRequest("GET", "/", nil))
+	c.Assert(forwarded, Matches, "[0-9a-f]{32}")
+	c.Assert(rw.Header().Get("X-Request-Id"), Equals, forwarded)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "client-id-1")
+	rw = httptest.NewRecorder()
+	withRequestId(next)(rw, r)
+	c.Assert(forwarded, Equals, "client-id-1")
+	c.Assert(rw.Header().Get("X-Request-Id"), Equals, "client-id-1")
+
+	for _, id := range []string{"with space", strings.Repeat("a", maxRequestIdLength+1), "line\nbreak"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header["X-Request-Id"] = []string{id}
+		withRequestId(next)(httptest.NewRecorder(), r)
+		c.Assert(forwarded, Matches, "[0-9a-f]{32}", Commentf("%q must be replaced", id))
+	}
+}
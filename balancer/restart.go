@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+var (
+	upgradeTimeout  = flags.Duration("upgrade-timeout", time.Minute, "how long to wait for the new process to get ready on SIGUSR2")
+	shutdownTimeout = flags.Duration("shutdown-timeout", 30*time.Second, "how long the old process lets active requests finish after handing over its listeners")
+)
+
+// Listeners are passed to the new process as inherited files, listed in
+// listenersEnv as name:descriptor pairs. The new process reports that it
+// is ready by writing to the pipe named by readyFdEnv.
+const (
+	listenersEnv = "LB_LISTENERS"
+	readyFdEnv   = "LB_READY_FD"
+)
+
+// listeners holds the listeners of this process by name, so they can be
+// handed over.
+var listeners = map[string]net.Listener{}
+
+// listen returns the listener inherited from the previous process under
+// the name, or a new one on the port.
+func listen(name string, port int) (net.Listener, error) {
+	fd, ok, err := inheritedFd(name)
+	if err != nil {
+		return nil, err
+	}
+	var l net.Listener
+	if ok {
+		f := os.NewFile(uintptr(fd), name)
+		defer f.Close()
+		l, err = net.FileListener(f)
+		log.Printf("Using the %s listener of the previous process", name)
+	} else {
+		l, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+	}
+	if err == nil {
+		listeners[name] = l
+	}
+	return l, err
+}
+
+func inheritedFd(name string) (int, bool, error) {
+	for _, pair := range splitList(os.Getenv(listenersEnv)) {
+		n, value, _ := strings.Cut(pair, ":")
+		if n != name {
+			continue
+		}
+		fd, err := strconv.Atoi(value)
+		if err != nil || fd < 3 {
+			return 0, false, fmt.Errorf("invalid descriptor of the %s listener: %q", name, value)
+		}
+		return fd, true, nil
+	}
+	return 0, false, nil
+}
+
+// notifyReady tells the previous process, if any, that this one serves
+// the requests now.
+func notifyReady() {
+	value, ok := os.LookupEnv(readyFdEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(readyFdEnv)
+	os.Unsetenv(listenersEnv)
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		log.Printf("Cannot notify the previous process: invalid $%s %q", readyFdEnv, value)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "readiness pipe")
+	defer f.Close()
+	_, _ = f.Write([]byte{1})
+}
+
+// upgrade starts a new process of the (possibly replaced) executable
+// with the same arguments and hands the listeners over to it. Once the
+// new process is ready this one stops accepting connections, lets the
+// active requests finish and exits. If the new process fails to get
+// ready it is killed and this one keeps serving.
+func upgrade(servers ...httptools.Server) {
+	if err := handOver(); err != nil {
+		log.Printf("Upgrade failed, keeping the current process: %s", err)
+		return
+	}
+	log.Println("The new process is ready, draining connections")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Connections did not drain: %s", err)
+		}
+	}
+	os.Exit(0)
+}
+
+func handOver() error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var inherited []string
+	for name, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("the %s listener cannot be shared", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		// ExtraFiles start at descriptor 3.
+		inherited = append(inherited, fmt.Sprintf("%s:%d", name, 2+len(files)))
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenersEnv+"="+strings.Join(inherited, ","),
+		fmt.Sprintf("%s=%d", readyFdEnv, 2+len(files)))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Only the new process may hold the writing end, so that its exit
+	// ends the read below.
+	readyWriter.Close()
+	files = files[:len(files)-1]
+	go func() { _ = cmd.Wait() }()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		if err == io.EOF {
+			err = fmt.Errorf("the new process exited")
+		}
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(*upgradeTimeout):
+		err = fmt.Errorf("the new process is not ready after %s", *upgradeTimeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Signal(syscall.SIGKILL)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestInheritedListener(c *C) {
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer original.Close()
+	// The descriptors are taken over like in a new process.
+	f, err := original.(*net.TCPListener).File()
+	c.Assert(err, IsNil)
+	listenFd, err := syscall.Dup(int(f.Fd()))
+	c.Assert(err, IsNil)
+	f.Close()
+	ready, readyWriter, err := os.Pipe()
+	c.Assert(err, IsNil)
+	defer ready.Close()
+	readyFd, err := syscall.Dup(int(readyWriter.Fd()))
+	c.Assert(err, IsNil)
+	readyWriter.Close()
+
+	os.Setenv(listenersEnv, fmt.Sprintf("admin:%d,frontend:%d", 1000, listenFd))
+	os.Setenv(readyFdEnv, fmt.Sprint(readyFd))
+	defer os.Unsetenv(listenersEnv)
+	defer os.Unsetenv(readyFdEnv)
+	defer delete(listeners, "frontend")
+
+	l, err := listen("frontend", 0)
+	c.Assert(err, IsNil)
+	defer l.Close()
+	c.Assert(l.Addr().String(), Equals, original.Addr().String())
+	c.Assert(listeners["frontend"], Equals, l)
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", original.Addr().String())
+	c.Assert(err, IsNil)
+	conn.Close()
+
+	notifyReady()
+	buf := make([]byte, 1)
+	n, err := ready.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	_, ok := os.LookupEnv(listenersEnv)
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,61 @@
+package balancer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+var (
+	retries        = flags.Int("retries", 1, "how many times a failed request is retried on another backend")
+	retryBodyLimit = flags.Int64("retry-body-limit", 64*1024, "maximum request body size buffered to allow retries, larger bodies are streamed without retries")
+)
+
+// retryBody buffers the request body so it can be replayed on another
+// backend. Bodies over the limit are streamed and the request cannot be
+// retried, a negative limit streams any body. The returned reader must
+// replace r.Body.
+func retryBody(r *http.Request, limit int64) (body []byte, rest io.ReadCloser, ok bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, r.Body, true, nil
+	}
+	if limit < 0 {
+		return nil, r.Body, false, nil
+	}
+	if r.ContentLength > limit {
+		return nil, r.Body, false, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, r.Body, false, err
+	}
+	if int64(len(buf)) > limit {
+		return nil, readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}, false, nil
+	}
+	return buf, io.NopCloser(bytes.NewReader(buf)), true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// idempotent reports whether repeating the request has the same effect
+// as sending it once (RFC 9110, section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// notSent reports whether the forwarding error happened before the
+// request reached the backend, which makes any request safe to retry.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
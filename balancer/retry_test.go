@@ -0,0 +1,106 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+// withBackends installs a configuration where all the given backends
+// are healthy, and returns a function restoring the previous state.
+func withBackends(c *C, strategy string, addrs ...string) func() {
+	prevConfig, prevBalancer, prevRoutes, prevPool := config, balancer, routeBalancers, livePool
+	cfg := defaultConfig()
+	cfg.Strategy = strategy
+	for _, addr := range addrs {
+		cfg.Backends = append(cfg.Backends, BackendConfig{Address: addr, Weight: 1})
+	}
+	config = nil
+	c.Assert(apply(cfg), IsNil)
+	livePool = newBackendPool()
+	livePool.healthy = addrs
+	return func() {
+		config, balancer, routeBalancers, livePool = prevConfig, prevBalancer, prevRoutes, prevPool
+	}
+}
+
+func (s *BalancerSuite) TestRetryOnAnotherBackend(c *C) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	alive := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	}))
+	defer alive.Close()
+
+	restore := withBackends(c, strategyRoundRobin,
+		strings.TrimPrefix(dead.URL, "http://"),
+		strings.TrimPrefix(alive.URL, "http://"))
+	defer restore()
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	c.Assert(rw.Body.String(), Equals, "OK")
+
+	rw = httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("POST", "/api/v1/some-data", strings.NewReader("{}")))
+	c.Assert(rw.Code, Equals, http.StatusOK, Commentf("requests that never reached a backend are retried"))
+}
+
+func (s *BalancerSuite) TestRetryAfterRequestWasSent(c *C) {
+	var (
+		receivedMu sync.Mutex
+		received   []string
+	)
+	record := func(entry string) {
+		receivedMu.Lock()
+		defer receivedMu.Unlock()
+		received = append(received, entry)
+	}
+	take := func() []string {
+		receivedMu.Lock()
+		defer receivedMu.Unlock()
+		entries := received
+		received = nil
+		return entries
+	}
+	broken := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		record("broken:" + string(body))
+		conn, _, _ := http.NewResponseController(rw).Hijack()
+		conn.Close()
+	}))
+	defer broken.Close()
+	alive := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		record("alive:" + string(body))
+	}))
+	defer alive.Close()
+
+	addrs := []string{strings.TrimPrefix(broken.URL, "http://"), strings.TrimPrefix(alive.URL, "http://")}
+	restore := withBackends(c, strategyRoundRobin, addrs...)
+	defer restore()
+
+	rw := httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("PUT", "/db/key", strings.NewReader("value")))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	c.Assert(take(), DeepEquals, []string{"broken:value", "alive:value"}, Commentf("idempotent bodies are replayed"))
+
+	rw = httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("POST", "/db/key", strings.NewReader("value")))
+	c.Assert(rw.Code, Equals, http.StatusServiceUnavailable, Commentf("non-idempotent requests that reached a backend are not retried"))
+	c.Assert(take(), DeepEquals, []string{"broken:value"})
+
+	prevLimit := *retryBodyLimit
+	*retryBodyLimit = 2
+	defer func() { *retryBodyLimit = prevLimit }()
+	defer withBackends(c, strategyRoundRobin, addrs...)()
+	rw = httptest.NewRecorder()
+	handle(rw, httptest.NewRequest("PUT", "/db/key", strings.NewReader("value")))
+	c.Assert(rw.Code, Equals, http.StatusServiceUnavailable, Commentf("large bodies are streamed without retries"))
+	c.Assert(take(), DeepEquals, []string{"broken:value"})
+}
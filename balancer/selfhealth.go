@@ -0,0 +1,52 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+var selfHealthPath = flags.String("self-health-path", "/health", "path the balancer reports its own health on for orchestrators (empty disables it)")
+
+const (
+	healthReady    = "ready"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// SelfHealth is the health of the balancer itself. It is ready when
+// every backend is available, degraded when only some of them (or only
+// backups) are, and down when requests cannot be served at all.
+type SelfHealth struct {
+	Status    string `json:"status"`
+	Available int    `json:"available"`
+	Total     int    `json:"total"`
+}
+
+func selfHealth() SelfHealth {
+	h := SelfHealth{}
+	primary := false
+	for _, backend := range backendStatuses() {
+		h.Total++
+		if backend.Healthy && !backend.Ejected && !backend.Drain {
+			h.Available++
+			primary = primary || !backend.Backup
+		}
+	}
+	switch {
+	case h.Available == 0:
+		h.Status = healthDown
+	case h.Available == h.Total && primary:
+		h.Status = healthReady
+	default:
+		h.Status = healthDegraded
+	}
+	return h
+}
+
+func serveSelfHealth(rw http.ResponseWriter, _ *http.Request) {
+	h := selfHealth()
+	status := http.StatusOK
+	if h.Status == healthDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, h)
+}
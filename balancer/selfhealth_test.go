@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestSelfHealth(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080", "backup:8080")
+	defer restore()
+	config.Backends[2].Backup = true
+
+	check := func(healthy []string, code int, expected string) {
+		livePool.states = map[string]*backendHealth{}
+		for _, addr := range healthy {
+			livePool.states[addr] = &backendHealth{checked: true, healthy: true}
+		}
+		rw := httptest.NewRecorder()
+		serve(rw, httptest.NewRequest("GET", *selfHealthPath, nil))
+		c.Assert(rw.Code, Equals, code)
+		var h SelfHealth
+		c.Assert(json.Unmarshal(rw.Body.Bytes(), &h), IsNil)
+		c.Assert(h.Status, Equals, expected, Commentf("healthy: %v", healthy))
+		c.Assert(h.Available, Equals, len(healthy))
+		c.Assert(h.Total, Equals, 3)
+	}
+	check([]string{"server1:8080", "server2:8080", "backup:8080"}, http.StatusOK, healthReady)
+	check([]string{"server1:8080"}, http.StatusOK, healthDegraded)
+	check([]string{"backup:8080"}, http.StatusOK, healthDegraded)
+	check(nil, http.StatusServiceUnavailable, healthDown)
+}
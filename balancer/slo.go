@@ -0,0 +1,232 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var (
+	sloSuccess     = flags.Float64("slo-success", 0, "target ratio of successful forwarded requests per backend, e.g. 0.999 (0 disables it)")
+	sloLatency     = flags.Duration("slo-latency", 0, "target latency of the -slo-percentile of forwarded requests per backend (0 disables it)")
+	sloPercentile  = flags.Float64("slo-percentile", 99, "latency percentile the -slo-latency target applies to")
+	sloWindow      = flags.Duration("slo-window", 5*time.Minute, "rolling window the SLOs are evaluated over")
+	sloMinRequests = flags.Int("slo-min-requests", 20, "requests a backend must serve within the window before it can breach its SLOs")
+	sloWebhook     = flags.String("slo-webhook", "", "URL receiving a JSON POST whenever a backend starts or stops breaching its SLOs")
+)
+
+const (
+	// maxSloSamples bounds the memory spent per backend, older samples
+	// are dropped first.
+	maxSloSamples         = 10000
+	sloEvaluationInterval = 5 * time.Second
+	sloWebhookTimeout     = 5 * time.Second
+)
+
+// SLOConfig sets the service level objectives of every backend.
+type SLOConfig struct {
+	Success     float64       `yaml:"success"`
+	Latency     time.Duration `yaml:"latency"`
+	Percentile  float64       `yaml:"percentile"`
+	Window      time.Duration `yaml:"window"`
+	MinRequests int           `yaml:"minRequests"`
+	Webhook     string        `yaml:"webhook"`
+}
+
+func sloConfig() SLOConfig {
+	return SLOConfig{
+		Success:     *sloSuccess,
+		Latency:     *sloLatency,
+		Percentile:  *sloPercentile,
+		Window:      *sloWindow,
+		MinRequests: *sloMinRequests,
+		Webhook:     *sloWebhook,
+	}
+}
+
+func (sc SLOConfig) enabled() bool {
+	return sc.Success > 0 || sc.Latency > 0
+}
+
+func (sc SLOConfig) validate() error {
+	if sc.Success < 0 || sc.Success > 1 {
+		return fmt.Errorf("SLO success ratio must be between 0 and 1")
+	}
+	if sc.Latency < 0 {
+		return fmt.Errorf("SLO latency cannot be negative")
+	}
+	if sc.Percentile <= 0 || sc.Percentile > 100 {
+		return fmt.Errorf("SLO percentile must be in (0, 100]")
+	}
+	if sc.Window <= 0 {
+		return fmt.Errorf("SLO window must be positive")
+	}
+	if sc.MinRequests < 1 {
+		return fmt.Errorf("SLO minimum requests must be positive")
+	}
+	if sc.Webhook != "" {
+		if u, err := url.Parse(sc.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid SLO webhook %q", sc.Webhook)
+		}
+	}
+	return nil
+}
+
+// SLOStatus is the compliance of a backend within the window.
+type SLOStatus struct {
+	Requests    int      `json:"requests"`
+	SuccessRate float64  `json:"successRate"`
+	Latency     float64  `json:"latencyMs"`
+	Breached    bool     `json:"breached"`
+	Reasons     []string `json:"reasons,omitempty"`
+}
+
+type sloSample struct {
+	at      time.Time
+	latency time.Duration
+	ok      bool
+}
+
+// sloSamples keeps the forwarding results of a backend in the order
+// they happened. breached is the state last reported to the webhook.
+type sloSamples struct {
+	samples  []sloSample
+	breached bool
+}
+
+func (s *sloSamples) observe(now time.Time, latency time.Duration, ok bool, window time.Duration) {
+	s.samples = append(s.samples, sloSample{now, latency, ok})
+	start := s.start(now, window)
+	if over := len(s.samples) - maxSloSamples; over > start {
+		start = over
+	}
+	if start > 0 {
+		s.samples = append(s.samples[:0], s.samples[start:]...)
+	}
+}
+
+// start is the index of the first sample within the window.
+func (s *sloSamples) start(now time.Time, window time.Duration) int {
+	from := now.Add(-window)
+	i, _ := slices.BinarySearchFunc(s.samples, from, func(sample sloSample, t time.Time) int {
+		return sample.at.Compare(t)
+	})
+	return i
+}
+
+func (s *sloSamples) report(sc SLOConfig, now time.Time) SLOStatus {
+	samples := s.samples[s.start(now, sc.Window):]
+	status := SLOStatus{Requests: len(samples)}
+	if len(samples) == 0 {
+		return status
+	}
+	successes := 0
+	latencies := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		if sample.ok {
+			successes++
+		}
+		latencies[i] = sample.latency
+	}
+	slices.Sort(latencies)
+	rank := int(math.Ceil(sc.Percentile/100*float64(len(latencies)))) - 1
+	latency := latencies[max(rank, 0)]
+	status.SuccessRate = float64(successes) / float64(len(samples))
+	status.Latency = float64(latency.Microseconds()) / 1000
+	if len(samples) < sc.MinRequests {
+		return status
+	}
+	if sc.Success > 0 && status.SuccessRate < sc.Success {
+		status.Reasons = append(status.Reasons, "success rate")
+	}
+	if sc.Latency > 0 && latency > sc.Latency {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("p%g latency", sc.Percentile))
+	}
+	status.Breached = len(status.Reasons) > 0
+	return status
+}
+
+// SLOEvent is posted to the webhook when a backend starts or stops
+// breaching its SLOs.
+type SLOEvent struct {
+	Backend string    `json:"backend"`
+	Time    time.Time `json:"time"`
+	SLOStatus
+}
+
+var (
+	sloClient        = httpclient.New(httpclient.Options{Timeout: sloWebhookTimeout})
+	sloBreachesTotal = metrics.Default.NewCounter("lb_slo_breaches_total",
+		"Times a backend started breaching its SLOs.", "backend")
+)
+
+func sloLoop(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(sloEvaluationInterval):
+		case <-ctx.Done():
+			return
+		}
+		evaluateSLOs(time.Now())
+	}
+}
+
+// evaluateSLOs reports the backends whose compliance changed since the
+// previous evaluation.
+func evaluateSLOs(now time.Time) {
+	c := currentConfig()
+	if !c.SLO.enabled() {
+		return
+	}
+	var events []SLOEvent
+	mu.RLock()
+	for _, backend := range c.Backends {
+		state, ok := livePool.state(backend.Address)
+		if !ok {
+			continue
+		}
+		state.passive.Lock()
+		status := state.slo.report(c.SLO, now)
+		if status.Breached != state.slo.breached {
+			state.slo.breached = status.Breached
+			events = append(events, SLOEvent{Backend: backend.Address, Time: now, SLOStatus: status})
+		}
+		state.passive.Unlock()
+	}
+	mu.RUnlock()
+	for _, event := range events {
+		if event.Breached {
+			sloBreachesTotal.Inc(event.Backend)
+			log.Printf("Backend %s breaches its SLOs: %v (success rate %.4f, latency %.1fms)",
+				event.Backend, event.Reasons, event.SuccessRate, event.Latency)
+		} else {
+			log.Printf("Backend %s meets its SLOs again", event.Backend)
+		}
+		if c.SLO.Webhook != "" {
+			notifySLO(c.SLO.Webhook, event)
+		}
+	}
+}
+
+func notifySLO(webhook string, event SLOEvent) {
+	body, _ := json.Marshal(event)
+	resp, err := sloClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to notify the SLO webhook: %s", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		log.Printf("SLO webhook responded with %s", resp.Status)
+	}
+}
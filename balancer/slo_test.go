@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestSLOReport(c *C) {
+	sc := SLOConfig{Success: 0.9, Latency: 100 * time.Millisecond, Percentile: 90, Window: time.Minute, MinRequests: 10}
+	var samples sloSamples
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		samples.observe(start, 10*time.Millisecond, true, sc.Window)
+	}
+	report := samples.report(sc, start)
+	c.Assert(report.Requests, Equals, 10)
+	c.Assert(report.SuccessRate, Equals, 1.0)
+	c.Assert(report.Latency, Equals, 10.0)
+	c.Assert(report.Breached, Equals, false)
+
+	samples.observe(start, time.Second, false, sc.Window)
+	samples.observe(start, time.Second, false, sc.Window)
+	report = samples.report(sc, start)
+	c.Assert(report.Breached, Equals, true)
+	c.Assert(report.Reasons, DeepEquals, []string{"success rate", "p90 latency"})
+
+	later := start.Add(2 * time.Minute)
+	c.Assert(samples.report(sc, later).Requests, Equals, 0)
+	samples.observe(later, time.Millisecond, true, sc.Window)
+	c.Assert(samples.samples, HasLen, 1, Commentf("samples out of the window are dropped"))
+}
+
+func (s *BalancerSuite) TestSLOBreachNotifies(c *C) {
+	events := make(chan SLOEvent, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var event SLOEvent
+		c.Check(json.NewDecoder(r.Body).Decode(&event), IsNil)
+		events <- event
+	}))
+	defer webhook.Close()
+
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080")
+	defer restore()
+	config.SLO = SLOConfig{Success: 0.5, Percentile: 99, Window: time.Minute, MinRequests: 2, Webhook: webhook.URL}
+	livePool.states["server1:8080"] = &backendHealth{checked: true, healthy: true}
+	livePool.states["server2:8080"] = &backendHealth{checked: true, healthy: true}
+
+	started := time.Now()
+	for i := 0; i < 2; i++ {
+		reportForward("server1:8080", nil, http.StatusBadGateway, started)
+		reportForward("server2:8080", nil, http.StatusOK, started)
+	}
+	evaluateSLOs(time.Now())
+	select {
+	case event := <-events:
+		c.Assert(event.Backend, Equals, "server1:8080")
+		c.Assert(event.Breached, Equals, true)
+		c.Assert(event.SuccessRate, Equals, 0.0)
+	default:
+		c.Fatal("the webhook was not notified")
+	}
+	c.Assert(currentStatus().SLOBreaches, DeepEquals, []string{"server1:8080"})
+
+	evaluateSLOs(time.Now())
+	c.Assert(events, HasLen, 0, Commentf("only changes are notified"))
+
+	for i := 0; i < 4; i++ {
+		reportForward("server1:8080", nil, http.StatusOK, started)
+	}
+	evaluateSLOs(time.Now())
+	c.Assert((<-events).Breached, Equals, false)
+	c.Assert(currentStatus().SLOBreaches, HasLen, 0)
+}
+
+func (s *BalancerSuite) TestSLOValidation(c *C) {
+	sc := sloConfig()
+	c.Assert(sc.validate(), IsNil)
+	sc.Success = 1.5
+	c.Assert(sc.validate(), ErrorMatches, "SLO success ratio .*")
+	sc.Success = 0.99
+	sc.Webhook = "ftp://alerts"
+	c.Assert(sc.validate(), ErrorMatches, "invalid SLO webhook .*")
+}
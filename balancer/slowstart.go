@@ -0,0 +1,51 @@
+package balancer
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+var slowStart = flags.Duration("slow-start", 0, "window over which a backend that became healthy ramps up to its full traffic share (0 disables slow start)")
+
+// rampStart returns when the backend last (re)joined the pool: it became
+// healthy or its passive ejection ended.
+func (h *backendHealth) rampStart() time.Time {
+	h.passive.Lock()
+	defer h.passive.Unlock()
+	if h.ejectedUntil.After(h.healthySince) {
+		return h.ejectedUntil
+	}
+	return h.healthySince
+}
+
+// share returns the fraction of its regular traffic the backend should
+// receive at the moment.
+func (h *backendHealth) share(now time.Time, window time.Duration) float64 {
+	if window <= 0 {
+		return 1
+	}
+	start := h.rampStart()
+	if start.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(start)
+	if elapsed >= window {
+		return 1
+	}
+	if elapsed < 0 {
+		return 0
+	}
+	return float64(elapsed) / float64(window)
+}
+
+// admit decides whether a request picked for the backend may go there or
+// should be diverted while the backend is ramping up.
+func admit(dst string) bool {
+	share := 1.0
+	mu.RLock()
+	if state, ok := livePool.state(dst); ok {
+		share = state.share(time.Now(), config.SlowStart)
+	}
+	mu.RUnlock()
+	return share >= 1 || rand.Float64() < share
+}
@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestSlowStartShare(c *C) {
+	now := time.Now()
+	window := 10 * time.Second
+
+	var h backendHealth
+	h.observe(true, HealthCheckConfig{HealthyThreshold: 1, UnhealthyThreshold: 1})
+	c.Assert(h.share(now, window), Equals, 1.0, Commentf("backends healthy from the start are not ramped"))
+
+	h.healthySince = now.Add(-2 * time.Second)
+	c.Assert(h.share(now, window), Equals, 0.2)
+	c.Assert(h.share(now, 0), Equals, 1.0)
+	c.Assert(h.share(now.Add(window), window), Equals, 1.0)
+
+	h.ejectedUntil = now.Add(-time.Second)
+	c.Assert(h.share(now, window), Equals, 0.1, Commentf("ramp restarts after a passive ejection"))
+}
+
+func (s *BalancerSuite) TestSlowStartDivertsTraffic(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080")
+	defer restore()
+	config.SlowStart = time.Hour
+	livePool.states["server2:8080"] = &backendHealth{checked: true, healthy: true, healthySince: time.Now()}
+
+	for range 10 {
+		dst, err := pick(httptest.NewRequest("GET", "/", nil))
+		c.Assert(err, IsNil)
+		c.Assert(dst, Equals, "server1:8080")
+	}
+}
+
+func (s *BalancerSuite) TestSlowStartAddedBackend(c *C) {
+	healthy := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	server1, server2 := httptest.NewServer(healthy), httptest.NewServer(healthy)
+	defer server1.Close()
+	defer server2.Close()
+	initial := strings.TrimPrefix(server1.URL, "http://")
+	added := strings.TrimPrefix(server2.URL, "http://")
+	restore := withBackends(c, strategyRoundRobin, initial)
+	defer restore()
+	config.SlowStart = time.Hour
+	config.HealthCheck.Jitter = 0
+
+	healthCheck()
+	state, _ := livePool.state(initial)
+	c.Assert(state.share(time.Now(), config.SlowStart), Equals, 1.0, Commentf("backends healthy from the start are not ramped"))
+
+	c.Assert(updateConfig(func(c *Config) error {
+		c.Backends = append(c.Backends, BackendConfig{Address: added, Weight: 1})
+		return nil
+	}), IsNil)
+	healthCheck()
+	state, _ = livePool.state(added)
+	c.Assert(livePool.isHealthy(added), Equals, true)
+	c.Assert(state.share(time.Now(), config.SlowStart) < 0.01, Equals, true, Commentf("backends added later ramp up"))
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+var (
+	statusPath  = flags.String("status-path", "/lb/status", "path the balancer serves its own status on (empty disables it)")
+	versionPath = flags.String("version-path", "/lb/version", "path the balancer serves its build information on, /version is forwarded to the backends (empty disables it)")
+)
+
+// Status describes the current state of the balancer.
+type Status struct {
+	Strategy  string          `json:"strategy"`
+	HashKey   string          `json:"hashKey,omitempty"`
+	Healthy   []string        `json:"healthy"`
+	Unhealthy []string        `json:"unhealthy"`
+	InFlight  int             `json:"inFlight"`
+	Backends  []BackendStatus `json:"backends"`
+	// PoolVersion grows whenever the set of healthy backends changes.
+	PoolVersion uint64 `json:"poolVersion"`
+	// SLOBreaches lists the backends currently breaching their SLOs.
+	SLOBreaches []string `json:"sloBreaches,omitempty"`
+}
+
+func currentStatus() Status {
+	c := currentConfig()
+	status := Status{
+		Strategy:  c.Strategy,
+		Healthy:   []string{},
+		Unhealthy: []string{},
+		Backends:  backendStatuses(),
+	}
+	mu.RLock()
+	status.PoolVersion = livePool.version
+	mu.RUnlock()
+	if c.Strategy == strategyIpHash || c.Strategy == strategySticky {
+		status.HashKey = c.HashKey
+	}
+	for _, backend := range status.Backends {
+		if backend.Healthy && !backend.Ejected {
+			status.Healthy = append(status.Healthy, backend.Address)
+		} else {
+			status.Unhealthy = append(status.Unhealthy, backend.Address)
+		}
+		status.InFlight += backend.InFlight
+		if backend.SLO != nil && backend.SLO.Breached {
+			status.SLOBreaches = append(status.SLOBreaches, backend.Address)
+		}
+	}
+	return status
+}
+
+func serveStatus(rw http.ResponseWriter, _ *http.Request) {
+	writeJSON(rw, http.StatusOK, currentStatus())
+}
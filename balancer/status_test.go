@@ -0,0 +1,46 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestStatus(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080")
+	defer restore()
+	livePool.healthy = []string{"server1:8080"}
+	checked := time.Now()
+	livePool.states["server1:8080"] = &backendHealth{checked: true, healthy: true, lastCheck: checked, lastCheckOk: true, lastCheckDuration: 2 * time.Millisecond}
+	livePool.states["server2:8080"] = &backendHealth{checked: true, lastCheck: checked}
+	connections.Inc("server1:8080")
+	defer connections.Dec("server1:8080")
+
+	rw := httptest.NewRecorder()
+	serve(rw, httptest.NewRequest("GET", *statusPath, nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+
+	var status Status
+	c.Assert(json.Unmarshal(rw.Body.Bytes(), &status), IsNil)
+	c.Assert(status.Strategy, Equals, strategyRoundRobin)
+	c.Assert(status.Healthy, DeepEquals, []string{"server1:8080"})
+	c.Assert(status.Unhealthy, DeepEquals, []string{"server2:8080"})
+	c.Assert(status.InFlight, Equals, 1)
+	c.Assert(status.Backends, HasLen, 2)
+	c.Assert(status.Backends[0].LastCheckOk, Equals, true)
+	c.Assert(status.Backends[0].LastCheckDuration, Equals, 2.0)
+	c.Assert(status.Backends[1].LastCheck.Equal(checked), Equals, true)
+}
+
+func (s *BalancerSuite) TestVersion(c *C) {
+	rw := httptest.NewRecorder()
+	serve(rw, httptest.NewRequest("GET", *versionPath, nil))
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	var info version.Info
+	c.Assert(json.Unmarshal(rw.Body.Bytes(), &info), IsNil)
+	c.Assert(info.Version, Equals, version.Version)
+}
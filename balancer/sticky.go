@@ -0,0 +1,174 @@
+package balancer
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	stickyTTL  = flags.Duration("sticky-ttl", 30*time.Minute, "how long the sticky strategy remembers the backend of an idle client")
+	stickySize = flags.Int("sticky-size", 100000, "maximum number of clients the sticky strategy remembers, the least recently seen are forgotten first")
+)
+
+const strategySticky = "sticky"
+
+type StickyConfig struct {
+	TTL  time.Duration `yaml:"ttl"`
+	Size int           `yaml:"size"`
+}
+
+func (sc StickyConfig) validate() error {
+	if sc.TTL <= 0 || sc.Size <= 0 {
+		return fmt.Errorf("sticky table TTL and size must be positive")
+	}
+	return nil
+}
+
+// Assignment is a client bound to a backend by the sticky strategy.
+type Assignment struct {
+	Key      string    `json:"key"`
+	Backend  string    `json:"backend"`
+	LastSeen time.Time `json:"lastSeen"`
+	Expires  time.Time `json:"expires"`
+}
+
+type assignment struct {
+	key     uint64
+	backend string
+	seen    time.Time
+}
+
+// assignmentTable remembers which backend each client of the sticky
+// strategy was sent to. Entries expire after the TTL and the least
+// recently used ones are evicted when the table is full. Unlike modulo
+// hashing, a client keeps its backend when others join or leave.
+type assignmentTable struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List // of *assignment, most recently used first
+	entries map[uint64]*list.Element
+}
+
+var assignments = newAssignmentTable()
+
+func newAssignmentTable() *assignmentTable {
+	return &assignmentTable{ttl: *stickyTTL, size: *stickySize, order: list.New(), entries: make(map[uint64]*list.Element)}
+}
+
+// configure changes the limits, evicting entries over the new size.
+func (t *assignmentTable) configure(sc StickyConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl, t.size = sc.TTL, sc.Size
+	t.evict()
+}
+
+func (t *assignmentTable) evict() {
+	for t.order.Len() > t.size {
+		t.remove(t.order.Back())
+	}
+}
+
+func (t *assignmentTable) remove(e *list.Element) {
+	delete(t.entries, e.Value.(*assignment).key)
+	t.order.Remove(e)
+}
+
+// get returns the live backend of the client and marks it as used.
+func (t *assignmentTable) get(key uint64, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return "", false
+	}
+	a := e.Value.(*assignment)
+	if now.Sub(a.seen) > t.ttl {
+		t.remove(e)
+		return "", false
+	}
+	a.seen = now
+	t.order.MoveToFront(e)
+	return a.backend, true
+}
+
+func (t *assignmentTable) set(key uint64, backend string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.entries[key]; ok {
+		a := e.Value.(*assignment)
+		a.backend, a.seen = backend, now
+		t.order.MoveToFront(e)
+		return
+	}
+	t.entries[key] = t.order.PushFront(&assignment{key, backend, now})
+	t.evict()
+}
+
+// list returns the live assignments, most recently used first.
+func (t *assignmentTable) list(now time.Time) []Assignment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := []Assignment{}
+	for e := t.order.Front(); e != nil; e = e.Next() {
+		a := e.Value.(*assignment)
+		if now.Sub(a.seen) > t.ttl {
+			continue
+		}
+		res = append(res, Assignment{
+			Key:      fmt.Sprintf("%016x", a.key),
+			Backend:  a.backend,
+			LastSeen: a.seen,
+			Expires:  a.seen.Add(t.ttl),
+		})
+	}
+	return res
+}
+
+// flush forgets the clients of the backend, or all of them if it is
+// empty, and returns how many were forgotten.
+func (t *assignmentTable) flush(backend string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for e := t.order.Front(); e != nil; {
+		next := e.Next()
+		if backend == "" || e.Value.(*assignment).backend == backend {
+			t.remove(e)
+			n++
+		}
+		e = next
+	}
+	return n
+}
+
+// stickyBalancer sends clients to the backend recorded in the table,
+// assigning new clients, and those whose backend left the pool, to the
+// least loaded backend.
+type stickyBalancer struct {
+	key      hashKey
+	table    *assignmentTable
+	fallback Balancer
+}
+
+func (b stickyBalancer) Pick(pool []string, r *http.Request) (string, error) {
+	key, err := b.key.hash(r)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if dst, ok := b.table.get(key, now); ok && slices.Contains(pool, dst) {
+		return dst, nil
+	}
+	dst, err := b.fallback.Pick(pool, r)
+	if err != nil {
+		return "", err
+	}
+	b.table.set(key, dst, now)
+	return dst, nil
+}
@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestAssignmentTable(c *C) {
+	t := newAssignmentTable()
+	t.configure(StickyConfig{TTL: time.Minute, Size: 2})
+	now := time.Now()
+
+	t.set(1, "server1:8080", now)
+	t.set(2, "server2:8080", now)
+	_, _ = t.get(1, now)
+	t.set(3, "server3:8080", now)
+	_, ok := t.get(2, now)
+	c.Assert(ok, Equals, false, Commentf("the least recently used entry is evicted"))
+	dst, ok := t.get(1, now)
+	c.Assert(ok, Equals, true)
+	c.Assert(dst, Equals, "server1:8080")
+
+	_, ok = t.get(3, now.Add(2*time.Minute))
+	c.Assert(ok, Equals, false, Commentf("idle entries expire"))
+	c.Assert(t.list(now), HasLen, 1)
+
+	t.set(4, "server1:8080", now)
+	c.Assert(t.flush("server1:8080"), Equals, 2)
+	c.Assert(t.list(now), HasLen, 0)
+}
+
+func (s *BalancerSuite) TestStickyStrategy(c *C) {
+	restore := withBackends(c, strategySticky, "server1:8080", "server2:8080", "server3:8080")
+	defer restore()
+	assignments.flush("")
+	defer assignments.flush("")
+
+	pickFor := func(client string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = client
+		dst, err := pick(r)
+		c.Assert(err, IsNil)
+		return dst
+	}
+	first := map[string]string{}
+	for _, client := range []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.3:1000"} {
+		first[client] = pickFor(client)
+	}
+	livePool.healthy = []string{"server1:8080", "server2:8080", "server3:8080", "server4:8080"}
+	config.Backends = append(config.Backends, BackendConfig{Address: "server4:8080", Weight: 1})
+	for client, dst := range first {
+		c.Assert(pickFor(client), Equals, dst, Commentf("clients keep their backend when the pool grows"))
+	}
+
+	livePool.healthy = []string{"server4:8080"}
+	c.Assert(pickFor("10.0.0.1:1000"), Equals, "server4:8080")
+	livePool.healthy = []string{"server1:8080", "server2:8080", "server3:8080", "server4:8080"}
+	c.Assert(pickFor("10.0.0.1:1000"), Equals, "server4:8080", Commentf("reassigned clients stay on the new backend"))
+
+	admin := adminHandler("secret")
+	call := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, r)
+		return rw
+	}
+	rw := call("GET", "/admin/affinity")
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	var list []Assignment
+	c.Assert(json.NewDecoder(rw.Body).Decode(&list), IsNil)
+	c.Assert(list, HasLen, 3)
+	c.Assert(list[0].Backend, Equals, "server4:8080")
+
+	c.Assert(call("DELETE", "/admin/affinity?backend=server4:8080").Body.String(), Equals, "{\"flushed\":1}\n")
+	c.Assert(call("DELETE", "/admin/affinity").Body.String(), Equals, "{\"flushed\":2}\n")
+}
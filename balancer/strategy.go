@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer chooses a backend for the request out of the pool of healthy
+// candidates. The pool is never empty and contains each backend as many
+// times as its weight.
+type Balancer interface {
+	Pick(pool []string, r *http.Request) (string, error)
+}
+
+const (
+	strategyIpHash     = "ip-hash"
+	strategyRoundRobin = "round-robin"
+	strategyRandom     = "random"
+	strategyLeastConn  = "least-connections"
+	strategyPeakEWMA   = "peak-ewma"
+)
+
+func strategies() []string {
+	return []string{strategyIpHash, strategyRoundRobin, strategyRandom, strategyLeastConn, strategyPeakEWMA, strategySticky}
+}
+
+func newBalancer(strategy, key string) (Balancer, error) {
+	switch strategy {
+	case strategyIpHash:
+		k, err := parseHashKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return ipHashBalancer{k}, nil
+	case strategyRoundRobin:
+		return new(roundRobinBalancer), nil
+	case strategyRandom:
+		return randomBalancer{}, nil
+	case strategyLeastConn:
+		return leastConnBalancer{connections}, nil
+	case strategyPeakEWMA:
+		return peakEWMABalancer{latencies, connections}, nil
+	case strategySticky:
+		k, err := parseHashKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return stickyBalancer{k, assignments, leastConnBalancer{connections}}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, expected one of %v", strategy, strategies())
+	}
+}
+
+type ipHashBalancer struct {
+	key hashKey
+}
+
+func (b ipHashBalancer) Pick(pool []string, r *http.Request) (string, error) {
+	hashSum, err := b.key.hash(r)
+	if err != nil {
+		return "", err
+	}
+	return pool[hashSum%uint64(len(pool))], nil
+}
+
+type roundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Pick(pool []string, _ *http.Request) (string, error) {
+	n := b.next.Add(1) - 1
+	return pool[n%uint64(len(pool))], nil
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(pool []string, _ *http.Request) (string, error) {
+	return pool[rand.IntN(len(pool))], nil
+}
+
+type leastConnBalancer struct {
+	conns *connCounter
+}
+
+// Pick chooses the backend with the lowest in-flight to weight ratio,
+// ties are resolved by the pool order.
+func (b leastConnBalancer) Pick(pool []string, _ *http.Request) (string, error) {
+	weights := make(map[string]int)
+	var order []string
+	for _, addr := range pool {
+		if weights[addr] == 0 {
+			order = append(order, addr)
+		}
+		weights[addr]++
+	}
+	best := order[0]
+	bestConns := b.conns.Get(best)
+	for _, addr := range order[1:] {
+		conns := b.conns.Get(addr)
+		if conns*weights[best] < bestConns*weights[addr] {
+			best, bestConns = addr, conns
+		}
+	}
+	return best, nil
+}
+
+// connCounter tracks the number of in-flight requests per backend.
+type connCounter struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+var connections = &connCounter{m: make(map[string]int)}
+
+func (c *connCounter) Inc(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[addr]++
+}
+
+func (c *connCounter) Dec(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[addr]--
+}
+
+func (c *connCounter) Get(addr string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.m[addr]
+}
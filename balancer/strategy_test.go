@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestStrategies(c *C) {
+	pool := []string{"server1:8080", "server1:8080", "server2:8080"}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "87.154.128.68:1234"
+
+	ipHash, err := newBalancer(strategyIpHash, hashForwardedFor)
+	c.Assert(err, IsNil)
+	first, err := ipHash.Pick(pool, r)
+	c.Assert(err, IsNil)
+	for range 5 {
+		dst, _ := ipHash.Pick(pool, r)
+		c.Assert(dst, Equals, first)
+	}
+
+	roundRobin, err := newBalancer(strategyRoundRobin, "")
+	c.Assert(err, IsNil)
+	var picked []string
+	for range 4 {
+		dst, _ := roundRobin.Pick(pool, r)
+		picked = append(picked, dst)
+	}
+	c.Assert(picked, DeepEquals, []string{"server1:8080", "server1:8080", "server2:8080", "server1:8080"})
+
+	random, err := newBalancer(strategyRandom, "")
+	c.Assert(err, IsNil)
+	dst, _ := random.Pick(pool, r)
+	c.Assert(dst == "server1:8080" || dst == "server2:8080", Equals, true)
+
+	conns := &connCounter{m: make(map[string]int)}
+	leastConn := leastConnBalancer{conns}
+	dst, _ = leastConn.Pick(pool, r)
+	c.Assert(dst, Equals, "server1:8080")
+	conns.Inc("server1:8080")
+	dst, _ = leastConn.Pick(pool, r)
+	c.Assert(dst, Equals, "server2:8080")
+	conns.Inc("server2:8080")
+	conns.Inc("server1:8080")
+	dst, _ = leastConn.Pick(pool, r)
+	c.Assert(dst, Equals, "server1:8080", Commentf("weight 2 with 2 connections ties with weight 1 with 1"))
+
+	_, err = newBalancer("unknown", "")
+	c.Assert(err, NotNil)
+}
+
+func (s *BalancerSuite) TestPeakEWMA(c *C) {
+	now := time.Now()
+	tracker := &latencyTracker{decay: 10 * time.Second, m: make(map[string]*latencyEWMA)}
+	tracker.Observe("server1:8080", 10*time.Millisecond, now)
+	tracker.Observe("server1:8080", 100*time.Millisecond, now)
+	c.Assert(tracker.Get("server1:8080", now), Equals, float64(100*time.Millisecond), Commentf("peaks are taken at once"))
+	tracker.Observe("server1:8080", 10*time.Millisecond, now.Add(10*time.Second))
+	c.Assert(tracker.Get("server1:8080", now.Add(10*time.Second)) < float64(50*time.Millisecond), Equals, true)
+	c.Assert(tracker.Get("server1:8080", now.Add(time.Hour)) < 1, Equals, true, Commentf("idle backends decay to be probed again"))
+
+	tracker = &latencyTracker{decay: time.Hour, m: make(map[string]*latencyEWMA)}
+	conns := &connCounter{m: make(map[string]int)}
+	balancer := peakEWMABalancer{tracker, conns}
+	pool := []string{"server1:8080", "server2:8080", "server3:8080"}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	tracker.Observe("server1:8080", 5*time.Millisecond, now)
+	tracker.Observe("server2:8080", 2*time.Second, now)
+	dst, _ := balancer.Pick(pool, r)
+	c.Assert(dst, Equals, "server3:8080", Commentf("unobserved backends are tried first"))
+
+	tracker.Observe("server3:8080", 20*time.Millisecond, now)
+	dst, _ = balancer.Pick(pool, r)
+	c.Assert(dst, Equals, "server1:8080")
+	for range 4 {
+		conns.Inc("server1:8080")
+	}
+	dst, _ = balancer.Pick(pool, r)
+	c.Assert(dst, Equals, "server3:8080", Commentf("in-flight requests raise the cost"))
+}
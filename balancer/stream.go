@@ -0,0 +1,13 @@
+package balancer
+
+import (
+	"mime"
+	"net/http"
+)
+
+var flushInterval = flags.Duration("flush-interval", 0, "how often buffered response data is flushed to clients (0 flushes only at the end, negative after every write)")
+
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
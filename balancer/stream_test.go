@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestEventStreamFlushing(c *C) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("data: first\n\n"))
+		rw.(http.Flusher).Flush()
+		<-release
+		_, _ = rw.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(backend.URL, "http://"))
+	defer restore()
+
+	frontend := httptest.NewServer(http.HandlerFunc(handle))
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/events")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		c.Assert(line, Equals, "data: first\n")
+	case <-time.After(2 * time.Second):
+		c.Fatal("first event was not flushed to the client")
+	}
+}
@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	tlsCert     = flags.String("tls-cert", "", "certificate file for serving clients over HTTPS")
+	tlsKey      = flags.String("tls-key", "", "private key file for serving clients over HTTPS")
+	acmeDomains = flags.String("acme-domains", "", "comma-separated domains to obtain certificates for via ACME (TLS-ALPN challenge)")
+	acmeCache   = flags.String("acme-cache", ".acme", "directory to cache ACME certificates in")
+	acmeEmail   = flags.String("acme-email", "", "contact email for the ACME account")
+
+	backendCert = flags.String("backend-cert", "", "client certificate file presented to HTTPS backends")
+	backendKey  = flags.String("backend-key", "", "client private key file presented to HTTPS backends")
+	backendCA   = flags.String("backend-ca", "", "CA bundle used to verify HTTPS backends instead of the system roots")
+	backendSNI  = flags.String("backend-server-name", "", "server name sent to HTTPS backends and verified in their certificates instead of the backend host")
+
+	backendInsecureSkipVerify = flags.Bool("backend-insecure-skip-verify", false, "do not verify certificates of HTTPS backends, for test environments only")
+)
+
+// frontendTLS returns the TLS config for the client-facing listener,
+// or nil if the balancer should serve plain HTTP.
+func frontendTLS() (*tls.Config, error) {
+	domains := splitList(*acmeDomains)
+	switch {
+	case len(domains) > 0 && (*tlsCert != "" || *tlsKey != ""):
+		return nil, fmt.Errorf("-acme-domains cannot be combined with -tls-cert/-tls-key")
+	case len(domains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(*acmeCache),
+			Email:      *acmeEmail,
+		}
+		return manager.TLSConfig(), nil
+	case *tlsCert != "" && *tlsKey != "":
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	case *tlsCert != "" || *tlsKey != "":
+		return nil, fmt.Errorf("both -tls-cert and -tls-key must be set")
+	default:
+		return nil, nil
+	}
+}
+
+// backendTLS returns the TLS config used when talking to HTTPS backends,
+// or nil if the defaults should be used.
+func backendTLS() (*tls.Config, error) {
+	if *backendCert == "" && *backendKey == "" && *backendCA == "" && *backendSNI == "" && !*backendInsecureSkipVerify {
+		return nil, nil
+	}
+	if !*https {
+		return nil, fmt.Errorf("backend TLS options require -https")
+	}
+	if *backendInsecureSkipVerify && (*backendCA != "" || *backendSNI != "") {
+		return nil, fmt.Errorf("-backend-insecure-skip-verify cannot be combined with -backend-ca/-backend-server-name")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: *backendSNI}
+	if *backendInsecureSkipVerify {
+		log.Printf("WARNING: certificates of HTTPS backends are not verified")
+		config.InsecureSkipVerify = true
+	}
+	switch {
+	case *backendCert != "" && *backendKey != "":
+		cert, err := tls.LoadX509KeyPair(*backendCert, *backendKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case *backendCert != "" || *backendKey != "":
+		return nil, fmt.Errorf("both -backend-cert and -backend-key must be set")
+	}
+	if *backendCA != "" {
+		pem, err := os.ReadFile(*backendCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *backendCA)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestBackendTLS(c *C) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	backend.StartTLS()
+	defer backend.Close()
+
+	// The test server certificate is reused both as the CA bundle and
+	// as the client certificate.
+	dir := c.MkDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	cert := backend.TLS.Certificates[0]
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	c.Assert(os.WriteFile(certFile, certPem, 0o600), IsNil)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	c.Assert(err, IsNil)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	c.Assert(os.WriteFile(keyFile, keyPem, 0o600), IsNil)
+
+	defer func(h bool, cert, key, ca string) {
+		*https, *backendCert, *backendKey, *backendCA = h, cert, key, ca
+	}(*https, *backendCert, *backendKey, *backendCA)
+
+	*https, *backendCert, *backendKey, *backendCA = true, certFile, "", certFile
+	_, err = backendTLS()
+	c.Assert(err, NotNil, Commentf("expected error for a certificate without a key"))
+
+	*backendKey = keyFile
+	config, err := backendTLS()
+	c.Assert(err, IsNil)
+
+	client := &http.Client{Transport: newTransport(config)}
+	resp, err := client.Get(backend.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	*https = false
+	_, err = backendTLS()
+	c.Assert(err, NotNil, Commentf("expected error without -https"))
+}
+
+func (s *BalancerSuite) TestBackendTLSVerification(c *C) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	caFile := filepath.Join(c.MkDir(), "ca.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.TLS.Certificates[0].Certificate[0]})
+	c.Assert(os.WriteFile(caFile, certPem, 0o600), IsNil)
+
+	defer func(h, skip bool, ca, sni string) {
+		*https, *backendInsecureSkipVerify, *backendCA, *backendSNI = h, skip, ca, sni
+	}(*https, *backendInsecureSkipVerify, *backendCA, *backendSNI)
+	get := func() error {
+		config, err := backendTLS()
+		c.Assert(err, IsNil)
+		client := &http.Client{Transport: newTransport(config)}
+		resp, err := client.Get(backend.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// The test certificate is issued for example.com and 127.0.0.1.
+	*https, *backendCA, *backendSNI = true, caFile, "example.com"
+	c.Assert(get(), IsNil)
+	*backendSNI = "server1.test"
+	c.Assert(get(), NotNil, Commentf("the certificate does not match the server name"))
+
+	*backendCA, *backendSNI = "", ""
+	c.Assert(get(), NotNil, Commentf("the certificate is not trusted by the system roots"))
+	*backendInsecureSkipVerify = true
+	c.Assert(get(), IsNil)
+
+	*backendCA = caFile
+	_, err := backendTLS()
+	c.Assert(err, ErrorMatches, "-backend-insecure-skip-verify cannot be combined .*")
+}
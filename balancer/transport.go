@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+)
+
+var (
+	maxIdleConns        = flags.Int("max-idle-conns", 1000, "maximum number of idle backend connections in total")
+	maxIdleConnsPerHost = flags.Int("max-idle-conns-per-host", 256, "maximum number of idle connections kept per backend")
+	maxConnsPerHost     = flags.Int("max-conns-per-host", 0, "maximum number of connections per backend (0 means unlimited)")
+	idleConnTimeout     = flags.Duration("idle-conn-timeout", 90*time.Second, "how long an idle backend connection is kept open")
+	dialTimeout         = flags.Duration("dial-timeout", 2*time.Second, "backend connection timeout")
+	keepAlive           = flags.Duration("keep-alive", 30*time.Second, "TCP keep-alive period for backend connections (negative disables keep-alives)")
+	tlsHandshakeTimeout = flags.Duration("tls-handshake-timeout", 5*time.Second, "backend TLS handshake timeout")
+
+	responseHeaderTimeout = flags.Duration("response-header-timeout", 0, "time to wait for backend response headers after the request is sent (0 means only the request timeout applies)")
+)
+
+// backendClient is used for all requests to backends. Redirects are
+// passed through to clients instead of being followed. Its transport is
+// set up again once the flags are parsed, the requests are bounded by the
+// request timeouts of the config.
+var backendClient = &http.Client{
+	Transport: newTransport(nil),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// healthClient probes the backends. It has a pool of its own, so the
+// probes are not queued behind the forwarded requests when the backends
+// are at -max-conns-per-host.
+var healthClient = httpclient.New(httpclient.Options{Timeout: -1, NoRedirects: true})
+
+func transportOptions(tlsConfig *tls.Config) httpclient.Options {
+	opts := httpclient.Options{
+		DialTimeout:           *dialTimeout,
+		KeepAlive:             *keepAlive,
+		TLSHandshakeTimeout:   *tlsHandshakeTimeout,
+		ResponseHeaderTimeout: *responseHeaderTimeout,
+		IdleConnTimeout:       *idleConnTimeout,
+		MaxIdleConns:          *maxIdleConns,
+		MaxIdleConnsPerHost:   *maxIdleConnsPerHost,
+		MaxConnsPerHost:       *maxConnsPerHost,
+		TLSConfig:             tlsConfig,
+	}
+	if opts.ResponseHeaderTimeout == 0 {
+		opts.ResponseHeaderTimeout = -1
+	}
+	return opts
+}
+
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return httpclient.NewTransport(transportOptions(tlsConfig))
+}
+
+// newHealthClient creates the client of the health probes, bounded by the
+// timeout of the health checks.
+func newHealthClient(tlsConfig *tls.Config) *http.Client {
+	opts := transportOptions(tlsConfig)
+	opts.Timeout, opts.NoRedirects = -1, true
+	opts.MaxIdleConnsPerHost = 2
+	opts.MaxConnsPerHost = 0
+	return httpclient.New(opts)
+}
@@ -0,0 +1,159 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+// isUpgrade reports whether the request switches the connection to
+// another protocol (e.g. WebSocket) or opens a tunnel with CONNECT.
+func isUpgrade(r *http.Request) bool {
+	if r.Method == http.MethodConnect {
+		return true
+	}
+	return r.Header.Get("Upgrade") != "" && hasToken(r.Header.Values("Connection"), "upgrade")
+}
+
+func dialBackend(ctx context.Context, dst string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: *dialTimeout, KeepAlive: *keepAlive}
+	if scheme() != "https" {
+		return dialer.DialContext(ctx, "tcp", dst)
+	}
+	var config *tls.Config
+	if transport, ok := backendClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(dst)
+	}
+	// Upgraded connections are HTTP/1.1 only.
+	config.NextProtos = []string{"http/1.1"}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+	return tlsDialer.DialContext(ctx, "tcp", dst)
+}
+
+// tunnel forwards an upgrade or CONNECT request to dst. If the backend
+// accepts it, the client connection is hijacked and bridged with the
+// backend one until either side closes. A rejected handshake is passed
+// back to the client as a regular response.
+func tunnel(dst string, rw http.ResponseWriter, r *http.Request, ip string) error {
+	connections.Inc(dst)
+	defer connections.Dec(dst)
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		return fmt.Errorf("connection does not support hijacking")
+	}
+
+	handshakeTimeout := currentConfig().requestTimeout(dst, r.URL.Path)
+	ctx, cancel := context.WithTimeout(r.Context(), handshakeTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "tunnel", tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("lb.backend", dst)
+
+	started := time.Now()
+	backendConn, err := dialBackend(ctx, dst)
+	if err != nil {
+		observeForward(dst, 0, err, started)
+		reportForward(dst, err, 0, started)
+		span.SetError(err)
+		log.Printf("Failed to connect to %s: %s", dst, err)
+		return err
+	}
+	defer backendConn.Close()
+	backendConn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	fwdRequest := r.Clone(ctx)
+	fwdRequest.RequestURI = ""
+	if r.Method != http.MethodConnect {
+		fwdRequest.URL.Host = dst
+		fwdRequest.URL.Scheme = scheme()
+		fwdRequest.Host = dst
+	}
+	headers := currentConfig().Headers
+	if *forwardClientIp {
+		setForwardedHeaders(fwdRequest.Header, r)
+	}
+	headers.Request.apply(fwdRequest.Header)
+	headers.Identity.set(fwdRequest.Header, ip)
+	tracing.Inject(ctx, fwdRequest.Header)
+
+	if err := fwdRequest.Write(backendConn); err != nil {
+		observeForward(dst, 0, err, started)
+		reportForward(dst, err, 0, started)
+		span.SetError(err)
+		log.Printf("Failed to send upgrade request to %s: %s", dst, err)
+		return err
+	}
+	backendReader := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(backendReader, fwdRequest)
+	if err != nil {
+		observeForward(dst, 0, err, started)
+		reportForward(dst, err, 0, started)
+		span.SetError(err)
+		log.Printf("Failed to get upgrade response from %s: %s", dst, err)
+		return err
+	}
+	observeForward(dst, resp.StatusCode, nil, started)
+	reportForward(dst, nil, resp.StatusCode, started)
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	defer resp.Body.Close()
+	headers.Response.apply(resp.Header)
+
+	accepted := resp.StatusCode == http.StatusSwitchingProtocols ||
+		(r.Method == http.MethodConnect && resp.StatusCode/100 == 2)
+	if !accepted {
+		for k, values := range resp.Header {
+			for _, value := range values {
+				rw.Header().Add(k, value)
+			}
+		}
+		rw.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(rw, resp.Body)
+		return nil
+	}
+
+	if *traceEnabled {
+		resp.Header.Set("lb-from", dst)
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Failed to hijack client connection: %s", err)
+		return nil
+	}
+	defer clientConn.Close()
+	clientConn.SetDeadline(time.Time{})
+	backendConn.SetDeadline(time.Time{})
+
+	log.Println("tunnel", resp.StatusCode, dst, r.URL)
+	fmt.Fprintf(clientBuf, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return nil
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(backendConn, clientBuf)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(clientConn, backendReader)
+		done <- struct{}{}
+	}()
+	<-done
+	return nil
+}
@@ -0,0 +1,61 @@
+package balancer
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestUpgradeTunnel(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		_, _ = io.Copy(conn, buf)
+	}))
+	defer backend.Close()
+
+	restore := withBackends(c, strategyRoundRobin, strings.TrimPrefix(backend.URL, "http://"))
+	defer restore()
+
+	frontend := httptest.NewServer(http.HandlerFunc(handle))
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(frontend.URL, "http://"))
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+	c.Assert(err, IsNil)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusSwitchingProtocols)
+
+	_, err = io.WriteString(conn, "ping\n")
+	c.Assert(err, IsNil)
+	line, err := reader.ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Assert(line, Equals, "ping\n")
+
+	req, _ := http.NewRequest("GET", frontend.URL+"/ws", nil)
+	req.Header.Set("Upgrade", "unknown")
+	req.Header.Set("Connection", "Upgrade")
+	rejected, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	rejected.Body.Close()
+	c.Assert(rejected.StatusCode, Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,31 @@
+package balancer
+
+import (
+	"time"
+)
+
+var warmupTimeout = flags.Duration("warmup-timeout", 30*time.Second, "how long to wait for a healthy backend before accepting connections (0 accepts them right away)")
+
+// warmupPollInterval is how often backends are probed during warm-up.
+const warmupPollInterval = 500 * time.Millisecond
+
+// waitForBackends blocks until at least one backend is healthy, probing
+// backends more often than usual, or until the timeout passes. It
+// reports whether a healthy backend was found.
+func waitForBackends(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		mu.RLock()
+		ready := len(livePool.healthy) > 0
+		mu.RUnlock()
+		if ready {
+			return true
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+		time.Sleep(min(wait, warmupPollInterval))
+		requestHealthCheck()
+	}
+}
@@ -0,0 +1,22 @@
+package balancer
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestWaitForBackends(c *C) {
+	restore := withBackends(c, strategyRoundRobin)
+	defer restore()
+
+	c.Assert(waitForBackends(10*time.Millisecond), Equals, false)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		livePool = livePool.withHealthy([]string{"server1:8080"}, livePool.states)
+	}()
+	c.Assert(waitForBackends(5*time.Second), Equals, true)
+}
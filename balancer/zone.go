@@ -0,0 +1,10 @@
+package balancer
+
+var zone = flags.String("zone", "", "zone of this balancer instance, backends of the same zone are preferred (empty disables the preference)")
+
+// local reports whether the backend is in the zone of the balancer.
+// Without a balancer zone every backend is local, while backends
+// without a zone are always treated as remote.
+func (c *Config) local(backend BackendConfig) bool {
+	return c.Zone == "" || backend.Zone == c.Zone
+}
@@ -0,0 +1,28 @@
+package balancer
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *BalancerSuite) TestZonePreference(c *C) {
+	restore := withBackends(c, strategyRoundRobin, "server1:8080", "server2:8080", "server3:8080", "backup:8080")
+	defer restore()
+	config.Zone = "a"
+	config.Backends[0].Zone = "a"
+	config.Backends[1].Zone = "b"
+	config.Backends[3].Backup = true
+
+	c.Assert(candidates("/"), DeepEquals, []string{"server1:8080"})
+
+	livePool.healthy = []string{"server2:8080", "server3:8080", "backup:8080"}
+	c.Assert(candidates("/"), DeepEquals, []string{"server2:8080", "server3:8080"},
+		Commentf("other zones and backends without a zone take over when the local ones are down"))
+
+	livePool.healthy = []string{"backup:8080"}
+	c.Assert(candidates("/"), DeepEquals, []string{"backup:8080"})
+
+	config.Zone = ""
+	livePool.healthy = []string{"server1:8080", "server2:8080", "server3:8080", "backup:8080"}
+	c.Assert(candidates("/"), DeepEquals, []string{"server1:8080", "server2:8080", "server3:8080"},
+		Commentf("without a balancer zone all backends are local"))
+}
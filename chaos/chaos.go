@@ -0,0 +1,251 @@
+// Package chaos injects faults into the requests of a service: latency,
+// error responses, connection resets and responses cut short. It lets
+// the tests exercise the retries, circuit breakers and passive health
+// checks built around the services.
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+// Duration is a time.Duration written as a string in JSON, e.g. "150ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"150ms\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule injects faults into the requests with the path prefix and, if set,
+// the method. The rates are the probabilities of the faults. A request is
+// delayed independently of the other faults, of which it suffers one at
+// most, so their rates must add up to 1 at most.
+type Rule struct {
+	Path   string `json:"path"`
+	Method string `json:"method,omitempty"`
+
+	Latency     Duration `json:"latency,omitempty"`
+	LatencyRate float64  `json:"latencyRate,omitempty"`
+	// ErrorRate requests are responded with ErrorStatus, 503 Service
+	// Unavailable if zero, without reaching the service.
+	ErrorRate   float64 `json:"errorRate,omitempty"`
+	ErrorStatus int     `json:"errorStatus,omitempty"`
+	// ResetRate requests have their connection reset before a response.
+	ResetRate float64 `json:"resetRate,omitempty"`
+	// PartialRate requests get half of their response before the
+	// connection is closed.
+	PartialRate float64 `json:"partialRate,omitempty"`
+}
+
+func (r Rule) matches(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, r.Path) && (r.Method == "" || r.Method == req.Method)
+}
+
+func validRate(rate float64) bool {
+	return rate >= 0 && rate <= 1
+}
+
+func (r Rule) validate() error {
+	switch {
+	case !strings.HasPrefix(r.Path, "/") && r.Path != "":
+		return errors.New("path must start with /")
+	case r.Latency < 0:
+		return errors.New("latency must not be negative")
+	case !validRate(r.LatencyRate) || !validRate(r.ErrorRate) || !validRate(r.ResetRate) || !validRate(r.PartialRate):
+		return errors.New("rates must be between 0 and 1")
+	case r.ErrorRate+r.ResetRate+r.PartialRate > 1:
+		return errors.New("error, reset and partial rates must add up to 1 at most")
+	case r.ErrorStatus != 0 && (r.ErrorStatus < 400 || r.ErrorStatus > 599):
+		return fmt.Errorf("error status %d is not an error", r.ErrorStatus)
+	}
+	return nil
+}
+
+// Config lists the rules, of which the first one matching a request
+// applies. A non-zero Seed makes the faults repeat from run to run.
+type Config struct {
+	Rules []Rule `json:"rules"`
+	Seed  uint64 `json:"seed,omitempty"`
+}
+
+func (c Config) Validate() error {
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("chaos rule %d for %q: %w", i+1, rule.Path, err)
+		}
+	}
+	return nil
+}
+
+// Load reads the config from the JSON file, an empty config if filename
+// is empty.
+func Load(filename string) (Config, error) {
+	var c Config
+	if filename == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return c, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("invalid chaos config: %w", err)
+	}
+	return c, c.Validate()
+}
+
+const (
+	faultLatency = "latency"
+	faultError   = "error"
+	faultReset   = "reset"
+	faultPartial = "partial"
+)
+
+var faultsTotal = metrics.Default.NewCounter("chaos_faults_total",
+	"Faults injected into the requests, by fault.", "fault")
+
+// Handler injects the faults of the config into the requests of next. It
+// returns next itself when there are no rules.
+func Handler(c Config, next http.Handler) http.Handler {
+	if len(c.Rules) == 0 {
+		return next
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewPCG(seed, seed))
+	roll := func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return rnd.Float64()
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rule, ok := match(c.Rules, r)
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if rule.Latency > 0 && roll() < rule.LatencyRate {
+			faultsTotal.Inc(faultLatency)
+			select {
+			case <-time.After(time.Duration(rule.Latency)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch p := roll(); {
+		case p < rule.ResetRate:
+			faultsTotal.Inc(faultReset)
+			reset(rw)
+		case p < rule.ResetRate+rule.ErrorRate:
+			faultsTotal.Inc(faultError)
+			status := rule.ErrorStatus
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(rw, "chaos: injected failure", status)
+		case p < rule.ResetRate+rule.ErrorRate+rule.PartialRate:
+			faultsTotal.Inc(faultPartial)
+			partial(rw, r, next)
+		default:
+			next.ServeHTTP(rw, r)
+		}
+	})
+}
+
+// Middleware is Handler for the middleware chains of httptools.
+func Middleware(c Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler { return Handler(c, next) }
+}
+
+func match(rules []Rule, r *http.Request) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.matches(r) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// reset closes the connection abruptly, so the client gets a TCP reset.
+// Connections that cannot be taken over, e.g. HTTP/2 ones, have the
+// stream aborted instead.
+func reset(rw http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// partial sends the header of the response of next, announcing its full
+// length, and half of its body before aborting the response.
+func partial(rw http.ResponseWriter, r *http.Request, next http.Handler) {
+	rec := &recorder{header: http.Header{}}
+	next.ServeHTTP(rec, r)
+	for name, values := range rec.header {
+		rw.Header()[name] = values
+	}
+	rw.Header().Set("Content-Length", strconv.Itoa(rec.body.Len()))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rw.WriteHeader(rec.status)
+	_, _ = rw.Write(rec.body.Bytes()[:rec.body.Len()/2])
+	_ = http.NewResponseController(rw).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+// recorder keeps the response of a handler.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
@@ -0,0 +1,142 @@
+package chaos
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+	_, _ = io.WriteString(rw, "all the data of the response")
+})
+
+func serve(t *testing.T, c Config) *httptest.Server {
+	srv := httptest.NewServer(Handler(c, okHandler))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(url string) (string, int, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), res.StatusCode, err
+}
+
+func TestHandler_Faults(t *testing.T) {
+	srv := serve(t, Config{Rules: []Rule{
+		{Path: "/slow", Latency: Duration(50 * time.Millisecond), LatencyRate: 1},
+		{Path: "/error", Method: "GET", ErrorRate: 1, ErrorStatus: http.StatusBadGateway},
+		{Path: "/reset", ResetRate: 1},
+		{Path: "/partial", PartialRate: 1},
+	}})
+
+	start := time.Now()
+	if body, status, err := get(srv.URL + "/slow"); err != nil || status != http.StatusOK || body != "all the data of the response" {
+		t.Errorf("slow request got %d %q, %v", status, body, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected a delay of 50ms, the request took %s", d)
+	}
+
+	if _, status, err := get(srv.URL + "/error"); err != nil || status != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d, %v", status, err)
+	}
+	res, err := http.Post(srv.URL+"/error", "text/plain", nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("expected the rule to skip POST, got %v, %v", res, err)
+	} else {
+		res.Body.Close()
+	}
+
+	if _, _, err := get(srv.URL + "/reset"); err == nil {
+		t.Error("expected the connection to be reset")
+	}
+
+	body, status, err := get(srv.URL + "/partial")
+	if !errors.Is(err, io.ErrUnexpectedEOF) || status != http.StatusOK || body != "all the data o" {
+		t.Errorf("expected half of the response, got %d %q, %v", status, body, err)
+	}
+
+	if body, status, err := get(srv.URL + "/other"); err != nil || status != http.StatusOK || body != "all the data of the response" {
+		t.Errorf("unmatched request got %d %q, %v", status, body, err)
+	}
+}
+
+func TestHandler_Rates(t *testing.T) {
+	c := Config{Seed: 42, Rules: []Rule{{Path: "/", ErrorRate: 0.3}}}
+	failures := func() []bool {
+		h := Handler(c, okHandler)
+		var res []bool
+		for range 200 {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			res = append(res, rec.Code == http.StatusServiceUnavailable)
+		}
+		return res
+	}
+	first, second := failures(), failures()
+	n := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatal("expected the same faults with the same seed")
+		}
+		if first[i] {
+			n++
+		}
+	}
+	if n < 40 || n > 80 {
+		t.Errorf("expected about 60 failures of 200 requests, got %d", n)
+	}
+}
+
+func TestHandler_NoRules(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(Config{}, okHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if c, err := Load(""); err != nil || len(c.Rules) != 0 {
+		t.Errorf("Load(\"\") = %+v, %v", c, err)
+	}
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		config string
+		err    string
+	}{
+		"valid":        {`{"seed": 1, "rules": [{"path": "/api", "latency": "150ms", "latencyRate": 0.5, "errorRate": 0.1}]}`, ""},
+		"rate":         {`{"rules": [{"path": "/", "resetRate": 1.5}]}`, "between 0 and 1"},
+		"sum":          {`{"rules": [{"path": "/", "errorRate": 0.6, "partialRate": 0.6}]}`, "add up"},
+		"status":       {`{"rules": [{"path": "/", "errorRate": 1, "errorStatus": 200}]}`, "not an error"},
+		"path":         {`{"rules": [{"path": "api"}]}`, "must start with /"},
+		"duration":     {`{"rules": [{"path": "/", "latency": 150}]}`, "duration"},
+		"unknownField": {`{"rules": [{"path": "/", "errors": 1}]}`, "unknown field"},
+	} {
+		filename := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(filename, []byte(tc.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		c, err := Load(filename)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %s", name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: expected an error with %q, got %v", name, tc.err, err)
+		}
+		if name == "valid" && (len(c.Rules) != 1 || time.Duration(c.Rules[0].Latency) != 150*time.Millisecond) {
+			t.Errorf("unexpected config %+v", c)
+		}
+	}
+}
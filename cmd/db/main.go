@@ -0,0 +1,8 @@
+// Command db serves the datastore over HTTP, see the dbserver package.
+package main
+
+import "github.com/roman-mazur/architecture-practice-4-template/dbserver"
+
+func main() {
+	dbserver.Main()
+}
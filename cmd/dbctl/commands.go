@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// listPage is the number of keys requested at once, the db's maximum.
+const listPage = 1000
+
+func (c *ctl) get(ctx context.Context, args []string) error {
+	entry, err := c.db.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.json(entry)
+	}
+	return c.table([]string{"KEY", "VALUE"}, [][]string{{entry.Key, entry.Value}})
+}
+
+type putResult struct {
+	Key     string `json:"key"`
+	Created bool   `json:"created"`
+}
+
+func (c *ctl) put(ctx context.Context, args []string) error {
+	value := args[1]
+	if value == "-" {
+		b, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(b), "\n")
+	}
+	created, err := c.db.Put(ctx, args[0], value)
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.json(putResult{Key: args[0], Created: created})
+	}
+	if created {
+		return c.printf("created %s\n", args[0])
+	}
+	return c.printf("updated %s\n", args[0])
+}
+
+func (c *ctl) delete(ctx context.Context, args []string) error {
+	if err := c.db.Delete(ctx, args[0]); err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.json(struct {
+			Key string `json:"key"`
+		}{args[0]})
+	}
+	return c.printf("deleted %s\n", args[0])
+}
+
+// eachKey calls fn for every key starting with prefix in ascending order,
+// a page of keys at a time.
+func (c *ctl) eachKey(ctx context.Context, prefix string, fn func(key string) error) error {
+	after := ""
+	for {
+		list, err := c.db.KeysAfter(ctx, prefix, after, listPage)
+		if err != nil {
+			return err
+		}
+		for _, key := range list.Keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if !list.Truncated || len(list.Keys) == 0 {
+			return nil
+		}
+		after = list.Keys[len(list.Keys)-1]
+	}
+}
+
+func (c *ctl) list(ctx context.Context, args []string) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	keys := []string{}
+	if err := c.eachKey(ctx, prefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.json(struct {
+			Keys []string `json:"keys"`
+		}{keys})
+	}
+	rows := make([][]string, len(keys))
+	for i, key := range keys {
+		rows[i] = []string{key}
+	}
+	return c.table([]string{"KEY"}, rows)
+}
+
+type transferResult struct {
+	Entries int `json:"entries"`
+}
+
+// backup writes an entry per line. The keys deleted while it runs are
+// left out.
+func (c *ctl) backup(ctx context.Context, args []string) error {
+	out, path := c.out, "-"
+	var file *os.File
+	if len(args) > 0 && args[0] != "-" {
+		path = args[0]
+		var err error
+		if file, err = os.Create(path); err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	n := 0
+	err := c.eachKey(ctx, "", func(key string) error {
+		entry, err := c.db.Get(ctx, key)
+		if errors.Is(err, dbclient.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("backup of %s: %w", key, err)
+		}
+		n++
+		return enc.Encode(entry)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		// The summary would end up in the backup.
+		return nil
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return c.transferred(transferResult{Entries: n}, "backed up %d entries to %s\n", n, path)
+}
+
+func (c *ctl) restore(ctx context.Context, args []string) error {
+	in := c.in
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	dec := json.NewDecoder(bufio.NewReader(in))
+	n := 0
+	for {
+		var entry dbclient.Entry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid backup after %d entries: %w", n, err)
+		}
+		if entry.Key == "" {
+			return fmt.Errorf("invalid backup: entry %d has no key", n+1)
+		}
+		if _, err := c.db.Put(ctx, entry.Key, entry.Value); err != nil {
+			return fmt.Errorf("restore of %s: %w", entry.Key, err)
+		}
+		n++
+	}
+	return c.transferred(transferResult{Entries: n}, "restored %d entries\n", n)
+}
+
+func (c *ctl) transferred(res transferResult, format string, args ...any) error {
+	if c.format == formatJSON {
+		return c.json(res)
+	}
+	return c.printf(format, args...)
+}
+
+// merge compacts the db and prints the outcome of the compaction.
+func (c *ctl) merge(ctx context.Context, _ []string) error {
+	if err := c.db.Compact(ctx); err != nil {
+		return err
+	}
+	res, err := c.db.Compactions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(res.History) == 0 {
+		return errors.New("the db reports no compaction")
+	}
+	last := res.History[len(res.History)-1]
+	if c.format == formatJSON {
+		return c.json(last)
+	}
+	return c.table([]string{"TRIGGER", "STARTED", "DURATION", "BEFORE", "AFTER", "RECLAIMED"}, [][]string{{
+		last.Trigger,
+		last.StartedAt.Format(time.RFC3339),
+		last.Duration.String(),
+		strconv.FormatInt(last.BytesBefore, 10),
+		strconv.FormatInt(last.BytesAfter, 10),
+		strconv.FormatInt(last.BytesReclaimed, 10),
+	}})
+}
+
+func (c *ctl) stats(ctx context.Context, _ []string) error {
+	stats, err := c.db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.json(stats)
+	}
+	return c.table([]string{"STAT", "VALUE"}, [][]string{
+		{"workers", strconv.Itoa(stats.Workers)},
+		{"active", strconv.Itoa(stats.Active)},
+		{"pending", strconv.Itoa(stats.Pending)},
+		{"reads", strconv.FormatUint(stats.Reads, 10)},
+		{"rejected", strconv.FormatUint(stats.Rejected, 10)},
+		{"panics", strconv.FormatUint(stats.Panics, 10)},
+	})
+}
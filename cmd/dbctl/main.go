@@ -0,0 +1,151 @@
+// Command dbctl manages the db of cmd/db through its HTTP API, e.g.
+//
+//	dbctl put team awesome
+//	dbctl -o json list user:
+//	dbctl backup db.jsonl
+//	dbctl -addr http://db:5432 restore db.jsonl
+//
+// Run dbctl -h for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+)
+
+const (
+	addrEnv  = "DB_ADDR"
+	tokenEnv = "DB_TOKEN"
+)
+
+var (
+	addr    = flag.String("addr", envOr(addrEnv, "http://localhost:5432"), "address of the db, overrides $"+addrEnv)
+	token   = flag.String("token", os.Getenv(tokenEnv), "bearer token sent with every request, overrides $"+tokenEnv)
+	output  = flag.String("o", formatTable, "output format: "+formatTable+" or "+formatJSON)
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout of every request")
+	retries = flag.Int("retries", 2, "extra attempts of the requests failing with a transient error")
+)
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+const usage = `Usage: dbctl [flags] command [arguments]
+
+Commands:
+  get KEY               print the value of KEY
+  put KEY VALUE         store VALUE under KEY, - reads VALUE from stdin
+  delete KEY            remove KEY
+  list [PREFIX]         list the keys starting with PREFIX
+  backup [FILE]         write all the entries to FILE as JSON lines, stdout by default
+  restore [FILE]        store the entries of a backup in FILE, stdin by default
+  merge                 compact the segments of the db, -token is its -admin-token
+  stats                 print the read queue statistics
+
+Flags:
+`
+
+// errUsage is returned for a malformed command line.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *output != formatTable && *output != formatJSON {
+		fmt.Fprintf(os.Stderr, "dbctl: unknown output format %q\n", *output)
+		os.Exit(2)
+	}
+
+	var transport http.RoundTripper = httpclient.NewTransport(httpclient.Options{})
+	if *token != "" {
+		transport = bearer{token: *token, next: transport}
+	}
+	c := &ctl{
+		db: dbclient.New(strings.TrimSuffix(*addr, "/")+"/db", dbclient.Options{
+			Timeout:   *timeout,
+			Retries:   *retries,
+			Backoff:   200 * time.Millisecond,
+			Transport: transport,
+		}),
+		format: *output,
+		in:     os.Stdin,
+		out:    os.Stdout,
+	}
+	err := c.run(context.Background(), flag.Args())
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "dbctl: %s\n\n", err)
+		flag.Usage()
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "dbctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// bearer authorizes the requests with the token.
+type bearer struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (b bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return b.next.RoundTrip(r)
+}
+
+// ctl runs the commands against the db, writing their results to out in
+// the format.
+type ctl struct {
+	db     *dbclient.Client
+	format string
+	in     io.Reader
+	out    io.Writer
+}
+
+type command struct {
+	min, max int
+	run      func(c *ctl, ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"get":     {1, 1, (*ctl).get},
+	"put":     {2, 2, (*ctl).put},
+	"delete":  {1, 1, (*ctl).delete},
+	"list":    {0, 1, (*ctl).list},
+	"backup":  {0, 1, (*ctl).backup},
+	"restore": {0, 1, (*ctl).restore},
+	"merge":   {0, 0, (*ctl).merge},
+	"stats":   {0, 0, (*ctl).stats},
+}
+
+func (c *ctl) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: no command", errUsage)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+	if n := len(args) - 1; n < cmd.min || n > cmd.max {
+		return fmt.Errorf("%w: wrong number of arguments of %s", errUsage, args[0])
+	}
+	return cmd.run(c, ctx, args[1:])
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// fakeDb serves the API of cmd/db from a map, listing two keys a page.
+type fakeDb struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeDb) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/admin/stats":
+		_ = json.NewEncoder(rw).Encode(datastore.QueueStats{Workers: 4, Reads: 7})
+		return
+	case r.URL.Path == "/db":
+		keys := []string{}
+		for key := range f.values {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("after") {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		list := dbclient.KeyList{Keys: keys}
+		if len(keys) > 2 {
+			list.Keys, list.Truncated = keys[:2], true
+		}
+		_ = json.NewEncoder(rw).Encode(list)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/db/")
+	switch r.Method {
+	case "GET":
+		value, ok := f.values[key]
+		if !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(dbclient.Entry{Key: key, Value: value})
+	case "PUT":
+		var entry dbclient.Entry
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+		_, existed := f.values[key]
+		f.values[key] = entry.Value
+		if !existed {
+			rw.WriteHeader(http.StatusCreated)
+		}
+	case "DELETE":
+		if _, ok := f.values[key]; !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		delete(f.values, key)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newCtl(t *testing.T, db *fakeDb, format string) (*ctl, *bytes.Buffer) {
+	srv := httptest.NewServer(db)
+	t.Cleanup(srv.Close)
+	transport := bearer{token: "secret", next: http.DefaultTransport}
+	out := new(bytes.Buffer)
+	return &ctl{
+		db:     dbclient.New(srv.URL+"/db", dbclient.Options{Transport: transport}),
+		format: format,
+		in:     strings.NewReader(""),
+		out:    out,
+	}, out
+}
+
+func TestCommands(t *testing.T) {
+	db := &fakeDb{values: map[string]string{}}
+	c, out := newCtl(t, db, formatTable)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"put", "team", "awesome"}, "created team\n"},
+		{[]string{"put", "team", "better"}, "updated team\n"},
+		{[]string{"get", "team"}, "KEY   VALUE\nteam  better\n"},
+		{[]string{"put", "user:1", "ann"}, "created user:1\n"},
+		{[]string{"put", "user:2", "bob"}, "created user:2\n"},
+		{[]string{"put", "user:3", "cid"}, "created user:3\n"},
+		{[]string{"list", "user:"}, "KEY\nuser:1\nuser:2\nuser:3\n"},
+		{[]string{"delete", "user:2"}, "deleted user:2\n"},
+		{[]string{"stats"}, "STAT      VALUE\nworkers   4\nactive    0\npending   0\nreads     7\nrejected  0\npanics    0\n"},
+	} {
+		out.Reset()
+		if err := c.run(ctx, tc.args); err != nil {
+			t.Fatalf("%v: %s", tc.args, err)
+		}
+		if out.String() != tc.out {
+			t.Errorf("%v printed %q, expected %q", tc.args, out.String(), tc.out)
+		}
+	}
+
+	c.format = formatJSON
+	out.Reset()
+	if err := c.run(ctx, []string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	var list struct{ Keys []string }
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil || !slices.Equal(list.Keys, []string{"team", "user:1", "user:3"}) {
+		t.Errorf("unexpected list %s, %v", out, err)
+	}
+
+	var se *dbclient.StatusError
+	if err := c.run(ctx, []string{"get", "user:2"}); !errors.Is(err, dbclient.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	unauthorized := httptest.NewServer(db)
+	defer unauthorized.Close()
+	c.db = dbclient.New(unauthorized.URL+"/db", dbclient.Options{})
+	if err := c.run(ctx, []string{"stats"}); !errors.As(err, &se) || se.Status != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %v", err)
+	}
+	for _, args := range [][]string{nil, {"drop"}, {"get"}, {"put", "k"}, {"merge", "now"}} {
+		if err := c.run(ctx, args); !errors.Is(err, errUsage) {
+			t.Errorf("%v: expected errUsage, got %v", args, err)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	src := &fakeDb{values: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}}
+	c, out := newCtl(t, src, formatTable)
+	file := filepath.Join(t.TempDir(), "backup.jsonl")
+	if err := c.run(context.Background(), []string{"backup", file}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "backed up 5 entries to "+file+"\n" {
+		t.Errorf("unexpected summary %q", out)
+	}
+
+	dst := &fakeDb{values: map[string]string{"a": "old"}}
+	c, out = newCtl(t, dst, formatJSON)
+	if err := c.run(context.Background(), []string{"restore", file}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "{\n  \"entries\": 5\n}\n" {
+		t.Errorf("unexpected summary %q", out)
+	}
+	if len(dst.values) != len(src.values) {
+		t.Errorf("restored %v, expected %v", dst.values, src.values)
+	}
+	for key, value := range src.values {
+		if dst.values[key] != value {
+			t.Errorf("restored %s = %q, expected %q", key, dst.values[key], value)
+		}
+	}
+
+	c.in = strings.NewReader(`{"key": "f", "value": "6"}` + "\n" + `{"value": "7"}`)
+	if err := c.run(context.Background(), []string{"restore"}); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("expected an invalid backup, got %v", err)
+	}
+}
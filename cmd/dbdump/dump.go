@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+)
+
+// The states of the records. Only the live ones survive a compaction.
+const (
+	stateLive        = "live"
+	stateOverwritten = "overwritten"
+	stateDeleted     = "deleted"
+	stateTombstone   = "tombstone"
+)
+
+const (
+	statusOK        = "ok"
+	statusCorrupted = "corrupted"
+)
+
+// RecordInfo is a record of a segment with its state in the directory.
+type RecordInfo struct {
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Key       string `json:"key"`
+	ValueSize int    `json:"valueSize"`
+	State     string `json:"state"`
+	// Value is set when the values are dumped or exported.
+	Value *string `json:"value,omitempty"`
+}
+
+// SegmentInfo sums up a segment. The bytes of a corrupted segment after
+// the offset of its Error are unreadable and counted in none of its
+// records.
+type SegmentInfo struct {
+	Index      int          `json:"index"`
+	Path       string       `json:"path"`
+	Size       int64        `json:"size"`
+	Records    int          `json:"records"`
+	Live       int          `json:"live"`
+	Dead       int          `json:"dead"`
+	Tombstones int          `json:"tombstones"`
+	LiveBytes  int64        `json:"liveBytes"`
+	DeadBytes  int64        `json:"deadBytes"`
+	Status     string       `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	Entries    []RecordInfo `json:"entries,omitempty"`
+}
+
+// Report is the content of a db directory.
+type Report struct {
+	Dir       string        `json:"dir"`
+	Segments  []SegmentInfo `json:"segments"`
+	Size      int64         `json:"size"`
+	Records   int           `json:"records"`
+	Live      int           `json:"live"`
+	Dead      int           `json:"dead"`
+	LiveBytes int64         `json:"liveBytes"`
+	Corrupted int           `json:"corruptedSegments"`
+}
+
+// inspect reads the segments of dir in the order the db recovers them, so
+// the last record of a key decides whether it is live. The corrupted
+// segments are reported rather than failing the inspection, their records
+// before the corruption still count. The values are kept if asked for.
+func inspect(dir string, values bool) (*Report, error) {
+	segments, err := datastore.Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	type location struct{ segment, record int }
+	latest := map[string]location{}
+	report := &Report{Dir: dir, Segments: make([]SegmentInfo, len(segments))}
+	for i, segment := range segments {
+		info := &report.Segments[i]
+		*info = SegmentInfo{Index: segment.Index, Path: segment.Path, Size: segment.Size, Status: statusOK}
+		err := datastore.ScanSegment(segment.Path, func(r datastore.Record) error {
+			record := RecordInfo{Offset: r.Offset, Size: r.Size, Key: r.Key, ValueSize: len(r.Value)}
+			if values && !r.Tombstone {
+				record.Value = &r.Value
+			}
+			if r.Tombstone {
+				record.State = stateTombstone
+			}
+			latest[r.Key] = location{i, len(info.Entries)}
+			info.Entries = append(info.Entries, record)
+			return nil
+		})
+		if errors.Is(err, datastore.ErrCorrupted) {
+			info.Status, info.Error = statusCorrupted, err.Error()
+			report.Corrupted++
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	for key, loc := range latest {
+		record := &report.Segments[loc.segment].Entries[loc.record]
+		if record.State != stateTombstone {
+			record.State = stateLive
+		} else {
+			// The tombstone is the last of its key.
+			latest[key] = location{-1, -1}
+		}
+	}
+	for i := range report.Segments {
+		info := &report.Segments[i]
+		for j := range info.Entries {
+			record := &info.Entries[j]
+			switch {
+			case record.State == "" && latest[record.Key].segment < 0:
+				record.State = stateDeleted
+			case record.State == "":
+				record.State = stateOverwritten
+			}
+			info.Records++
+			if record.State == stateLive {
+				info.Live++
+				info.LiveBytes += record.Size
+				continue
+			}
+			info.Dead++
+			info.DeadBytes += record.Size
+			if record.State == stateTombstone {
+				info.Tombstones++
+			}
+		}
+		report.Size += info.Size
+		report.Records += info.Records
+		report.Live += info.Live
+		report.Dead += info.Dead
+		report.LiveBytes += info.LiveBytes
+	}
+	return report, nil
+}
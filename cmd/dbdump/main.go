@@ -0,0 +1,148 @@
+// Command dbdump inspects a data directory of cmd/db offline, e.g. one
+// the db fails to recover or one growing unexpectedly. It prints the
+// records of every segment with their offsets and whether they are live,
+// overwritten or deleted, and checks that every entry fits its segment,
+// the format having no checksums:
+//
+//	dbdump -dir .db
+//	dbdump -dir .db -records -values
+//	dbdump -dir .db -o json > report.json
+//	dbdump -dir .db -export live.jsonl
+//
+// The export holds the live entries in the backup format of dbctl, which
+// restores them into a new db. dbdump exits with 1 when a segment is
+// corrupted.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+var (
+	dir     = flag.String("dir", ".db", "directory of the db segments")
+	output  = flag.String("o", formatTable, "output format: "+formatTable+" or "+formatJSON)
+	records = flag.Bool("records", false, "list every record of the segments")
+	values  = flag.Bool("values", false, "list the values of the records, implies -records")
+	export  = flag.String("export", "", "file the live entries are written to as JSON lines for dbctl restore")
+)
+
+func main() {
+	flag.Parse()
+	if *output != formatTable && *output != formatJSON {
+		fmt.Fprintf(os.Stderr, "dbdump: unknown output format %q\n", *output)
+		os.Exit(2)
+	}
+	*records = *records || *values
+
+	report, err := inspect(*dir, *values || *export != "")
+	if err == nil && *export != "" {
+		err = exportLive(report, *export)
+	}
+	if err == nil {
+		err = write(os.Stdout, report, *output, *records, *values)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbdump: %s\n", err)
+		os.Exit(1)
+	}
+	if report.Corrupted > 0 {
+		os.Exit(1)
+	}
+}
+
+// write prints the report, with the records of the segments if asked for.
+func write(w io.Writer, report *Report, format string, withRecords, withValues bool) error {
+	if !withRecords || !withValues {
+		for i := range report.Segments {
+			if !withRecords {
+				report.Segments[i].Entries = nil
+			}
+			for j := range report.Segments[i].Entries {
+				report.Segments[i].Entries[j].Value = nil
+			}
+		}
+	}
+	if format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEGMENT\tSIZE\tRECORDS\tLIVE\tDEAD\tTOMBSTONES\tLIVE BYTES\tSTATUS")
+	for _, s := range report.Segments {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Index, s.Size, s.Records, s.Live, s.Dead, s.Tombstones, s.LiveBytes, s.Status)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t\t%d\t\n", report.Size, report.Records, report.Live, report.Dead, report.LiveBytes)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, s := range report.Segments {
+		if s.Error != "" {
+			fmt.Fprintf(w, "\n%s\n", s.Error)
+		}
+	}
+	if !withRecords {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"SEGMENT", "OFFSET", "SIZE", "KEY", "VALUE SIZE", "STATE"}
+	if withValues {
+		header = append(header, "VALUE")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, s := range report.Segments {
+		for _, r := range s.Entries {
+			row := []string{strconv.Itoa(s.Index), strconv.FormatInt(r.Offset, 10), strconv.FormatInt(r.Size, 10),
+				strconv.Quote(r.Key), strconv.Itoa(r.ValueSize), r.State}
+			if withValues && r.Value != nil {
+				row = append(row, strconv.Quote(*r.Value))
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+// exportLive writes the live entries to filename in the backup format of
+// dbctl.
+func exportLive(report *Report, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, s := range report.Segments {
+		for _, r := range s.Entries {
+			if r.State != stateLive {
+				continue
+			}
+			if err := enc.Encode(dbclient.Entry{Key: r.Key, Value: *r.Value}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
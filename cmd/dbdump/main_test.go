@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// fill writes a db with records in every state, a segment per put.
+func fill(t *testing.T) string {
+	dir := t.TempDir()
+	db, err := datastore.NewDb(dir, datastore.DbOptions{MaxSegmentSize: 1, WorkerPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range [][2]string{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"c", "4"}, {"c", ""}} {
+		if op[1] == "" {
+			err = db.Delete(op[0])
+		} else {
+			err = db.Put(op[0], op[1])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestInspect(t *testing.T) {
+	dir := fill(t)
+	report, err := inspect(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, s := range report.Segments {
+		for _, r := range s.Entries {
+			states = append(states, r.Key+":"+r.State)
+		}
+	}
+	if got := strings.Join(states, " "); got != "a:overwritten b:live a:live c:deleted c:tombstone" {
+		t.Errorf("unexpected states %s", got)
+	}
+	if report.Records != 5 || report.Live != 2 || report.Dead != 3 || report.Corrupted != 0 {
+		t.Errorf("unexpected totals %+v", report)
+	}
+
+	export := filepath.Join(t.TempDir(), "live.jsonl")
+	if err := exportLive(report, export); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	exported := map[string]string{}
+	for lines := bufio.NewScanner(f); lines.Scan(); {
+		var e dbclient.Entry
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		exported[e.Key] = e.Value
+	}
+	if len(exported) != 2 || exported["a"] != "3" || exported["b"] != "2" {
+		t.Errorf("unexpected export %v", exported)
+	}
+
+	var out strings.Builder
+	if err := write(&out, report, formatTable, true, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"total", `"c"`, "tombstone", "ok"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestInspect_Corrupted(t *testing.T) {
+	dir := fill(t)
+	segments, err := datastore.Segments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(segments[1].Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0xff, 0xff, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	report, err := inspect(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := report.Segments[1]
+	if report.Corrupted != 1 || s.Status != statusCorrupted || s.Records != 1 || !strings.Contains(s.Error, "offset") {
+		t.Errorf("expected the record before the corruption and the error, got %+v", s)
+	}
+	if report.Records != 5 {
+		t.Errorf("expected the other segments to be read, got %d records", report.Records)
+	}
+
+	var out strings.Builder
+	if err := write(&out, report, formatJSON, false, false); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil || decoded.Segments[1].Entries != nil {
+		t.Errorf("unexpected JSON report %s, %v", out.String(), err)
+	}
+}
@@ -1,186 +0,0 @@
-package main
-
-import (
-	"context"
-	"crypto/sha256"
-	"encoding/binary"
-	"flag"
-	"fmt"
-	"io"
-	"log"
-	"net"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/roman-mazur/architecture-practice-4-template/httptools"
-	"github.com/roman-mazur/architecture-practice-4-template/signal"
-)
-
-var (
-	port       = flag.Int("port", 8090, "load balancer port")
-	timeoutSec = flag.Int("timeout-sec", 3, "request timeout time in seconds")
-	https      = flag.Bool("https", false, "whether backends support HTTPs")
-
-	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
-)
-
-var (
-	timeout     = time.Duration(*timeoutSec) * time.Second
-	serversPool = []string{
-		"server1:8080",
-		"server2:8080",
-		"server3:8080",
-	}
-	healthServersPool = []string{}
-)
-
-func scheme() string {
-	if *https {
-		return "https"
-	}
-	return "http"
-}
-
-func health(dst string) bool {
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	req, _ := http.NewRequestWithContext(ctx, "GET",
-		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false
-	}
-	if resp.StatusCode != http.StatusOK {
-		return false
-	}
-	return true
-}
-
-func forward(dst string, rw http.ResponseWriter, r *http.Request, ip string) error {
-	ctx, _ := context.WithTimeout(r.Context(), timeout)
-	fwdRequest := r.Clone(ctx)
-	fwdRequest.RequestURI = ""
-	fwdRequest.URL.Host = dst
-	fwdRequest.URL.Scheme = scheme()
-	fwdRequest.URL.Path = r.URL.Path
-	fwdRequest.URL.RawQuery = r.URL.RawQuery
-	fwdRequest.Host = dst
-
-	fwdRequest.Header.Set("lb-author", ip)
-
-	resp, err := http.DefaultClient.Do(fwdRequest)
-	if err == nil {
-		for k, values := range resp.Header {
-			for _, value := range values {
-				rw.Header().Add(k, value)
-			}
-		}
-		if *traceEnabled {
-			rw.Header().Set("lb-from", dst)
-		}
-		log.Println("fwd", resp.StatusCode, resp.Request.URL)
-		rw.WriteHeader(resp.StatusCode)
-		defer resp.Body.Close()
-		_, err := io.Copy(rw, resp.Body)
-		if err != nil {
-			log.Printf("Failed to write response: %s", err)
-		}
-		return nil
-	} else {
-		log.Printf("Failed to get response from %s: %s", dst, err)
-		rw.WriteHeader(http.StatusServiceUnavailable)
-		return err
-	}
-}
-
-func ipToHashNumber(ipStr string) (uint64, error) {
-	host := ipStr
-
-	if strings.Contains(ipStr, ":") {
-		host, _, _ = net.SplitHostPort(ipStr)
-	}
-
-	ip := net.ParseIP(host)
-
-	if ip == nil {
-		return 0, fmt.Errorf("invalid IP address: %s", ipStr)
-	}
-
-	ipv4 := ip.To4()
-	if ipv4 == nil {
-		return 0, fmt.Errorf("not an IPv4 address: %s", ipStr)
-	}
-	hash := sha256.Sum256(ipv4)
-	number := binary.BigEndian.Uint64(hash[:8])
-	return number, nil
-}
-
-func healthCheck() {
-	healthServersPool = []string{}
-	for _, server := range serversPool {
-		healthState := health(server)
-		if healthState {
-			healthServersPool = append(healthServersPool, server)
-		}
-	}
-}
-
-func getRemoteIp(r *http.Request) string {
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-
-	if xForwardedFor != "" {
-		return strings.Split(xForwardedFor, ",")[0]
-	}
-
-	forwarder := r.Header.Get("Forwarded")
-
-	if forwarder != "" {
-		for _, part := range strings.Split(forwarder, ";") {
-			if strings.HasPrefix(part, "for=") {
-				return strings.TrimPrefix(part, "for=")
-			}
-		}
-	}
-
-	return r.RemoteAddr
-}
-
-func main() {
-	flag.Parse()
-
-	healthCheck()
-
-	go func() {
-		for range time.Tick(10 * time.Second) {
-			healthCheck()
-		}
-	}()
-
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		ip := getRemoteIp(r)
-
-		hashSum, err := ipToHashNumber(ip)
-
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
-		}
-
-		if len(healthServersPool) == 0 {
-			fmt.Println("Error: No health servers")
-			rw.WriteHeader(http.StatusBadGateway)
-			return
-		}
-
-		serverIndex := hashSum % uint64(len(healthServersPool))
-
-		fmt.Printf("forwarding %s to %s\n", ip, healthServersPool[serverIndex])
-
-		forward(healthServersPool[serverIndex], rw, r, ip)
-	}))
-
-	log.Println("Starting load balancer...")
-	log.Printf("Tracing support enabled: %t", *traceEnabled)
-	frontend.Start()
-	signal.WaitForTerminationSignal()
-}
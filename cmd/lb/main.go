@@ -0,0 +1,9 @@
+// Command lb balances the requests among the backends, see the balancer
+// package.
+package main
+
+import "github.com/roman-mazur/architecture-practice-4-template/balancer"
+
+func main() {
+	balancer.Main()
+}
@@ -0,0 +1,126 @@
+// Command loadgen drives the balancer with reads and writes of some-data
+// and prints the latency percentiles and error rates, e.g.
+//
+//	loadgen -target http://localhost:8090 -rps 500 -concurrency 50 -duration 30s -write-ratio 0.1
+//
+// The results can be exported for comparing the runs, e.g. of two
+// commits, with -json, -csv and -push-db.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/loadgen"
+)
+
+var (
+	target       = flag.String("target", "http://localhost:8090", "address of the balancer")
+	rps          = flag.Float64("rps", 100, "requests started per second (0 sends them as fast as -concurrency allows)")
+	concurrency  = flag.Int("concurrency", 10, "requests in flight at most")
+	duration     = flag.Duration("duration", 10*time.Second, "how long the load lasts")
+	keys         = flag.Int("keys", 100, "number of distinct keys requested")
+	keyPrefix    = flag.String("key-prefix", "loadgen-", "prefix of the requested keys, followed by their number")
+	distribution = flag.String("distribution", loadgen.DistributionUniform, "distribution of the requested keys: "+loadgen.DistributionUniform+" or "+loadgen.DistributionZipf)
+	writeRatio   = flag.Float64("write-ratio", 0.1, "fraction of the requests writing a key")
+	clientIPs    = flag.String("client-ips", "", "comma-separated client addresses sent in X-Forwarded-For in turn")
+	token        = flag.String("token", os.Getenv("API_TOKEN"), "bearer token of the API, defaults to $API_TOKEN")
+	timeout      = flag.Duration("timeout", 10*time.Second, "timeout of every request")
+	maxErrorRate = flag.Float64("max-error-rate", 1, "exit with 1 when a larger fraction of the requests fails")
+
+	jsonOutput = flag.String("json", "", "file the summary and every request are written to as JSON")
+	csvOutput  = flag.String("csv", "", "file every request is written to as CSV")
+	run        = flag.String("run", "", "name of the run in the exports and the db, e.g. the commit tested (the time if empty)")
+	pushDb     = flag.String("push-db", "", "address of the db the summary is stored in under the run, e.g. http://localhost:5432")
+)
+
+func main() {
+	flag.Parse()
+	c := loadgen.Config{
+		Target:       *target,
+		RPS:          *rps,
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Distribution: *distribution,
+		WriteRatio:   *writeRatio,
+		Token:        *token,
+		Samples:      *jsonOutput != "" || *csvOutput != "",
+	}
+	for i := range *keys {
+		c.Keys = append(c.Keys, *keyPrefix+strconv.Itoa(i))
+	}
+	for _, ip := range strings.Split(*clientIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			c.ClientIPs = append(c.ClientIPs, ip)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+	c.Client = &http.Client{Timeout: *timeout, Transport: transport}
+
+	// Interrupting the run still prints the report of the requests so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := loadgen.Run(ctx, c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %s\n", err)
+		os.Exit(2)
+	}
+	if err := res.WriteReport(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %s\n", err)
+		os.Exit(1)
+	}
+	if err := export(res); err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %s\n", err)
+		os.Exit(1)
+	}
+	if rate := res.Total.ErrorRate(); rate > *maxErrorRate {
+		fmt.Fprintf(os.Stderr, "loadgen: error rate %.2f%% exceeds %.2f%%\n", 100*rate, 100**maxErrorRate)
+		os.Exit(1)
+	}
+}
+
+// export writes the results to the files and the db of the flags.
+func export(res *loadgen.Result) error {
+	name := *run
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405Z")
+	}
+	write := func(filename string, export func(io.Writer) error) error {
+		if filename == "" {
+			return nil
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		if err := export(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := write(*jsonOutput, func(w io.Writer) error { return res.WriteJSON(w, name) }); err != nil {
+		return err
+	}
+	if err := write(*csvOutput, res.WriteCSV); err != nil {
+		return err
+	}
+	if *pushDb == "" {
+		return nil
+	}
+	db := dbclient.New(strings.TrimSuffix(*pushDb, "/")+"/db", dbclient.Options{})
+	if err := res.Push(context.Background(), db, name); err != nil {
+		return fmt.Errorf("cannot push the results: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+// Command server serves the some-data API, see the server package.
+package main
+
+import "github.com/roman-mazur/architecture-practice-4-template/server"
+
+func main() {
+	server.Main()
+}
@@ -0,0 +1,91 @@
+// Command status serves the state of the whole system at a single URL:
+// the health, readiness and key stats of the balancer, every app server
+// and the db, probed on every request of /status, e.g.
+//
+//	status -balancer http://balancer:8090 -servers server1:8080,server2:8080 -db http://db:5432
+//
+// /status responds with 503 Service Unavailable when the system is down.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/config"
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+)
+
+var (
+	port     = flag.Int("port", 8070, "status port")
+	balancer = flag.String("balancer", "http://balancer:8090", "address of the balancer")
+	servers  = flag.String("servers", "server1:8080,server2:8080,server3:8080", "comma-separated host:port addresses of the app servers")
+	dbAddr   = flag.String("db", "http://db:5432", "address of the db")
+	timeout  = flag.Duration("timeout", 2*time.Second, "timeout of every probe")
+)
+
+func validateFlags() error {
+	switch {
+	case *port < 1 || *port > 65535:
+		return errors.New("invalid port")
+	case *timeout <= 0:
+		return errors.New("timeout must be positive")
+	}
+	return nil
+}
+
+func main() {
+	config.Parse(config.Options{EnvPrefix: "STATUS_", Validate: validateFlags})
+
+	a := &Aggregator{
+		Client:   httpclient.New(httpclient.Options{Timeout: *timeout}),
+		Balancer: *balancer,
+		Db:       *dbAddr,
+	}
+	for _, server := range strings.Split(*servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			a.Servers = append(a.Servers, "http://"+server)
+		}
+	}
+
+	h := new(http.ServeMux)
+	h.HandleFunc("GET /status", func(rw http.ResponseWriter, r *http.Request) {
+		s := a.Check(r.Context())
+		status := http.StatusOK
+		if s.State == stateDown {
+			status = http.StatusServiceUnavailable
+		}
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(status)
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(s)
+	})
+	h.HandleFunc("GET /health", func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("OK"))
+	})
+	h.Handle("GET /version", version.Handler())
+
+	server := httptools.CreateServerWith(*port, h, httptools.Options{Middleware: []httptools.Middleware{
+		httptools.Recover(),
+	}})
+	server.Start()
+	log.Printf("Serving the system status on port %d", *port)
+	signal.WaitForTerminationSignal()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout+time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("In-flight requests did not finish: %s", err)
+		os.Exit(1)
+	}
+}
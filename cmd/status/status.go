@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The states of the components and of the whole system.
+const (
+	stateUp       = "up"
+	stateDegraded = "degraded"
+	stateDown     = "down"
+)
+
+// maxBodySize limits the part of the responses of the probes kept in the
+// tree, the stats are small.
+const maxBodySize = 64 << 10
+
+// Probe is the response of a component to a request for its health or
+// stats.
+type Probe struct {
+	URL string `json:"url"`
+	OK  bool   `json:"ok"`
+	// Code is the HTTP status, zero when no response came.
+	Code      int     `json:"code,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	// Body is the JSON of the response, or its text as a JSON string.
+	Body  json.RawMessage `json:"body,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Component is the state of a service derived from its probes.
+type Component struct {
+	State  string           `json:"state"`
+	Probes map[string]Probe `json:"probes"`
+}
+
+// System is the tree of the states of all the services.
+type System struct {
+	State     string               `json:"state"`
+	CheckedAt time.Time            `json:"checkedAt"`
+	Balancer  Component            `json:"balancer"`
+	Servers   map[string]Component `json:"servers"`
+	Db        Component            `json:"db"`
+}
+
+// check is a request of a probe. The component is down when a critical
+// check fails and degraded when another one does.
+type check struct {
+	name     string
+	path     string
+	critical bool
+}
+
+var (
+	balancerChecks = []check{{"health", "/health", true}, {"stats", "/lb/status", false}}
+	serverChecks   = []check{{"live", "/live", true}, {"ready", "/ready", true}, {"stats", "/report?limit=10", false}}
+	dbChecks       = []check{{"health", "/health", true}, {"stats", "/admin/stats", false}}
+)
+
+// Aggregator probes the balancer, the servers and the db.
+type Aggregator struct {
+	Client   *http.Client
+	Balancer string
+	// Servers are the base URLs of the servers, e.g. http://server1:8080.
+	Servers []string
+	Db      string
+}
+
+// Check probes every component at once and derives the state of the
+// system: down when the balancer, the db or every server is down, and
+// degraded when any component is not up.
+func (a *Aggregator) Check(ctx context.Context) System {
+	s := System{CheckedAt: time.Now(), Servers: make(map[string]Component, len(a.Servers))}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	probe := func(base string, checks []check, set func(Component)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := a.component(ctx, base, checks)
+			mu.Lock()
+			defer mu.Unlock()
+			set(c)
+		}()
+	}
+	probe(a.Balancer, balancerChecks, func(c Component) { s.Balancer = c })
+	probe(a.Db, dbChecks, func(c Component) { s.Db = c })
+	for _, server := range a.Servers {
+		probe(server, serverChecks, func(c Component) { s.Servers[hostOf(server)] = c })
+	}
+	wg.Wait()
+
+	serversDown := len(a.Servers) > 0
+	s.State = stateUp
+	for _, c := range s.Servers {
+		serversDown = serversDown && c.State == stateDown
+		if c.State != stateUp {
+			s.State = stateDegraded
+		}
+	}
+	switch {
+	case s.Balancer.State == stateDown || s.Db.State == stateDown || serversDown:
+		s.State = stateDown
+	case s.Balancer.State != stateUp || s.Db.State != stateUp:
+		s.State = stateDegraded
+	}
+	return s
+}
+
+func (a *Aggregator) component(ctx context.Context, base string, checks []check) Component {
+	c := Component{State: stateUp, Probes: make(map[string]Probe, len(checks))}
+	for _, ch := range checks {
+		p := a.probe(ctx, strings.TrimSuffix(base, "/")+ch.path)
+		c.Probes[ch.name] = p
+		switch {
+		case !p.OK && ch.critical:
+			c.State = stateDown
+		case (!p.OK || reportsDegraded(p.Body)) && c.State == stateUp:
+			c.State = stateDegraded
+		}
+	}
+	return c
+}
+
+func (a *Aggregator) probe(ctx context.Context, url string) Probe {
+	p := Probe{URL: url}
+	start := time.Now()
+	defer func() { p.LatencyMs = float64(time.Since(start).Microseconds()) / 1000 }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	res, err := a.Client.Do(req)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	defer res.Body.Close()
+	p.Code = res.StatusCode
+	p.OK = res.StatusCode == http.StatusOK
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBodySize))
+	if err != nil {
+		p.Error = err.Error()
+		p.OK = false
+		return p
+	}
+	p.Body = jsonBody(body)
+	return p
+}
+
+// jsonBody keeps a JSON body as it is and turns any other into a JSON
+// string, e.g. the OK of the probes of the servers.
+func jsonBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+// reportsDegraded tells whether the body is the health of the balancer
+// with only a part of the backends available.
+func reportsDegraded(body json.RawMessage) bool {
+	var health struct {
+		Status string `json:"status"`
+	}
+	return json.Unmarshal(body, &health) == nil && health.Status == stateDegraded
+}
+
+// hostOf is the host:port of a base URL, which names the server in the
+// tree as the balancer names its backends.
+func hostOf(base string) string {
+	_, host, ok := strings.Cut(base, "://")
+	if !ok {
+		host = base
+	}
+	return strings.TrimSuffix(host, "/")
+}
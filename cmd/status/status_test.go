@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fake serves the given responses by path, 404 for the others.
+func fake(t *testing.T, responses map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		switch {
+		case !ok:
+			http.NotFound(rw, r)
+		case body == "fail":
+			http.Error(rw, "FAILURE: db is unreachable", http.StatusServiceUnavailable)
+		default:
+			_, _ = io.WriteString(rw, body)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func healthyServer(t *testing.T) *httptest.Server {
+	return fake(t, map[string]string{"/live": "OK", "/ready": "OK", "/report": `{"requests": [], "total": 0}`})
+}
+
+func TestAggregator_Check(t *testing.T) {
+	balancer := fake(t, map[string]string{"/health": `{"status": "ready"}`, "/lb/status": `{"strategy": "round-robin"}`})
+	db := fake(t, map[string]string{"/health": "OK", "/admin/stats": `{"workers": 4}`})
+	server1, server2 := healthyServer(t), healthyServer(t)
+	a := &Aggregator{
+		Client:   &http.Client{Timeout: time.Second},
+		Balancer: balancer.URL,
+		Servers:  []string{server1.URL, server2.URL},
+		Db:       db.URL,
+	}
+
+	s := a.Check(context.Background())
+	if s.State != stateUp || s.Balancer.State != stateUp || s.Db.State != stateUp {
+		t.Fatalf("expected everything up, got %+v", s)
+	}
+	host := hostOf(server1.URL)
+	if c, ok := s.Servers[host]; !ok || c.State != stateUp || string(c.Probes["ready"].Body) != `"OK"` {
+		t.Errorf("unexpected state of %s: %+v", host, s.Servers)
+	}
+	if stats := s.Db.Probes["stats"]; !stats.OK || string(stats.Body) != `{"workers": 4}` {
+		t.Errorf("unexpected db stats %+v", stats)
+	}
+
+	// A server not ready is down, which degrades the system while the
+	// other one serves.
+	a.Servers[1] = fake(t, map[string]string{"/live": "OK", "/ready": "fail"}).URL
+	s = a.Check(context.Background())
+	if c := s.Servers[hostOf(a.Servers[1])]; s.State != stateDegraded || c.State != stateDown || c.Probes["ready"].Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a down server to degrade the system, got %s with %+v", s.State, c)
+	}
+
+	// The stats of the servers are not critical.
+	a.Servers[1] = fake(t, map[string]string{"/live": "OK", "/ready": "OK"}).URL
+	if c := a.Check(context.Background()).Servers[hostOf(a.Servers[1])]; c.State != stateDegraded {
+		t.Errorf("expected a server without stats to be degraded, got %+v", c)
+	}
+
+	a.Servers[1] = server2.URL
+	a.Balancer = fake(t, map[string]string{"/health": `{"status": "degraded"}`, "/lb/status": `{}`}).URL
+	if s := a.Check(context.Background()); s.State != stateDegraded || s.Balancer.State != stateDegraded {
+		t.Errorf("expected the degraded balancer to degrade the system, got %s", s.State)
+	}
+
+	a.Balancer = balancer.URL
+	db.Close()
+	s = a.Check(context.Background())
+	if s.State != stateDown || s.Db.State != stateDown || s.Db.Probes["health"].Error == "" {
+		t.Errorf("expected the system down without the db, got %s with %+v", s.State, s.Db)
+	}
+	if _, err := json.Marshal(s); err != nil {
+		t.Errorf("cannot encode the tree: %s", err)
+	}
+}
+
+func TestAggregator_AllServersDown(t *testing.T) {
+	a := &Aggregator{
+		Client:   &http.Client{Timeout: time.Second},
+		Balancer: fake(t, map[string]string{"/health": `{"status": "down"}`, "/lb/status": `{}`}).URL,
+		Servers:  []string{fake(t, map[string]string{"/live": "OK", "/ready": "fail"}).URL},
+		Db:       fake(t, map[string]string{"/health": "OK", "/admin/stats": `{}`}).URL,
+	}
+	if s := a.Check(context.Background()); s.State != stateDown {
+		t.Errorf("expected the system down without servers, got %s", s.State)
+	}
+}
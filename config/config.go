@@ -0,0 +1,213 @@
+// Package config sets the flags of a command from several layers, in
+// increasing precedence: the flag defaults, a settings file, environment
+// variables and the command line. The flags stay the single definition
+// of the settings, their names being the keys of the settings file and,
+// prefixed and in upper case, the names of the environment variables,
+// e.g. -health-interval is set by LB_HEALTH_INTERVAL.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	settingsFlag   = "settings"
+	dumpConfigFlag = "dump-config"
+)
+
+// Sources of the values of the flags.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+type Options struct {
+	// EnvPrefix starts the names of the environment variables, e.g. LB_.
+	EnvPrefix string
+	// Secrets are the flags whose values are hidden by the dump.
+	Secrets []string
+	// Validate checks the settings once all of them are set.
+	Validate func() error
+}
+
+// EnvName is the environment variable setting the flag.
+func (o Options) EnvName(flagName string) string {
+	return o.EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Config records where the values of the flags of fs come from.
+type Config struct {
+	fs      *flag.FlagSet
+	opts    Options
+	sources map[string]string
+	dump    *bool
+}
+
+// Load parses args with fs and sets the flags missing from args from the
+// settings file named by -settings and from the environment. It adds the
+// -settings and -dump-config flags to fs.
+func Load(fs *flag.FlagSet, args []string, opts Options) (*Config, error) {
+	c := &Config{fs: fs, opts: opts, sources: map[string]string{}}
+	settings := fs.String(settingsFlag, os.Getenv(opts.EnvName(settingsFlag)),
+		"JSON or YAML file setting the flags by name, which the environment and the command line override, defaults to $"+opts.EnvName(settingsFlag))
+	c.dump = fs.Bool(dumpConfigFlag, false, "print the settings with their sources as JSON and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) { c.sources[f.Name] = SourceFlag })
+
+	if *settings != "" {
+		values, err := readSettings(*settings)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if fs.Lookup(name) == nil || name == settingsFlag || name == dumpConfigFlag {
+				return nil, fmt.Errorf("%s: unknown setting %q", *settings, name)
+			}
+			if err := c.set(name, values[name], SourceFile); err != nil {
+				return nil, fmt.Errorf("%s: %w", *settings, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == settingsFlag || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(opts.EnvName(f.Name)); ok {
+			err = c.set(f.Name, value, SourceEnv)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Validate != nil && !*c.dump {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// set sets the flag unless a layer above the source already did.
+func (c *Config) set(name, value, source string) error {
+	layers := []string{SourceDefault, SourceFile, SourceEnv, SourceFlag}
+	if slices.Index(layers, c.Source(name)) > slices.Index(layers, source) {
+		return nil
+	}
+	if err := c.fs.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q of %s from the %s: %w", value, name, source, err)
+	}
+	c.sources[name] = source
+	return nil
+}
+
+// Source tells which layer set the flag.
+func (c *Config) Source(name string) string {
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// readSettings reads a flat object of the flag values. Lists are joined
+// with commas, as the flags listing things expect.
+func readSettings(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// YAML is a superset of JSON, one parser reads both.
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid settings file %s: %w", filename, err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("invalid settings file %s: %s must not be an object", filename, name)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// Setting is a flag in the dump.
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Dump writes the settings as a JSON object keyed by the flag names.
+func (c *Config) Dump(w io.Writer) error {
+	settings := map[string]Setting{}
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == settingsFlag || f.Name == dumpConfigFlag {
+			return
+		}
+		value := f.Value.String()
+		if slices.Contains(c.opts.Secrets, f.Name) && value != "" {
+			value = "<hidden>"
+		}
+		settings[f.Name] = Setting{Value: value, Source: c.Source(f.Name)}
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(settings)
+}
+
+// Parse is Load of the command line flags for main. It exits on invalid
+// settings and after the dump when -dump-config is set.
+func Parse(opts Options) *Config {
+	return ParseSet(flag.CommandLine, opts)
+}
+
+// ParseSet is Parse of the command line arguments into fs, for the
+// services defining their flags in a set of their own.
+func ParseSet(fs *flag.FlagSet, opts Options) *Config {
+	c, err := Load(fs, os.Args[1:], opts)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s\n", err)
+		os.Exit(2)
+	}
+	if *c.dump {
+		if err := c.Dump(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	return c
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFlags struct {
+	fs       *flag.FlagSet
+	port     *int
+	interval *time.Duration
+	backends *string
+	token    *string
+}
+
+func newTestFlags() testFlags {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return testFlags{
+		fs:       fs,
+		port:     fs.Int("port", 8080, ""),
+		interval: fs.Duration("health-interval", 10*time.Second, ""),
+		backends: fs.String("backends", "", ""),
+		token:    fs.String("admin-token", "", ""),
+	}
+}
+
+func writeSettings(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "settings.yaml")
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoad_Layers(t *testing.T) {
+	settings := writeSettings(t, "port: 9000\nhealth-interval: 2s\nbackends: [a:1, b:2]\n")
+	t.Setenv("TEST_HEALTH_INTERVAL", "3s")
+	t.Setenv("TEST_PORT", "9001")
+
+	f := newTestFlags()
+	c, err := Load(f.fs, []string{"-settings", settings, "-port", "9002"}, Options{EnvPrefix: "TEST_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *f.port != 9002 || *f.interval != 3*time.Second || *f.backends != "a:1,b:2" || *f.token != "" {
+		t.Errorf("unexpected flags %d, %s, %q, %q", *f.port, *f.interval, *f.backends, *f.token)
+	}
+	for name, source := range map[string]string{
+		"port":            SourceFlag,
+		"health-interval": SourceEnv,
+		"backends":        SourceFile,
+		"admin-token":     SourceDefault,
+	} {
+		if s := c.Source(name); s != source {
+			t.Errorf("%s is set by the %s, expected the %s", name, s, source)
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	validate := errors.New("invalid port")
+	for name, tc := range map[string]struct {
+		settings string
+		env      string
+		opts     Options
+		err      string
+	}{
+		"unknown setting": {settings: "ports: 1\n", err: `unknown setting "ports"`},
+		"object":          {settings: "port: {value: 1}\n", err: "must not be an object"},
+		"file value":      {settings: "health-interval: soon\n", err: "invalid value \"soon\" of health-interval from the file"},
+		"env value":       {env: "soon", err: "invalid value \"soon\" of health-interval from the env"},
+		"validate":        {opts: Options{Validate: func() error { return validate }}, err: "invalid port"},
+	} {
+		f := newTestFlags()
+		var args []string
+		if tc.settings != "" {
+			args = []string{"-settings", writeSettings(t, tc.settings)}
+		}
+		if tc.env != "" {
+			t.Setenv("TEST_HEALTH_INTERVAL", tc.env)
+		}
+		tc.opts.EnvPrefix = "TEST_"
+		_, err := Load(f.fs, args, tc.opts)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error with %q, got %v", name, tc.err, err)
+		}
+		os.Unsetenv("TEST_HEALTH_INTERVAL")
+	}
+}
+
+func TestDump(t *testing.T) {
+	f := newTestFlags()
+	c, err := Load(f.fs, []string{"-admin-token", "secret", "-dump-config"}, Options{Secrets: []string{"admin-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := c.Dump(&out); err != nil {
+		t.Fatal(err)
+	}
+	var settings map[string]Setting
+	if err := json.Unmarshal([]byte(out.String()), &settings); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Setting{
+		"port":            {"8080", SourceDefault},
+		"health-interval": {"10s", SourceDefault},
+		"backends":        {"", SourceDefault},
+		"admin-token":     {"<hidden>", SourceFlag},
+	}
+	if len(settings) != len(expected) {
+		t.Errorf("unexpected dump %s", out.String())
+	}
+	for name, s := range expected {
+		if settings[name] != s {
+			t.Errorf("%s is dumped as %+v, expected %+v", name, settings[name], s)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := (Options{EnvPrefix: "LB_"}).EnvName("health-interval"); name != "LB_HEALTH_INTERVAL" {
+		t.Errorf("unexpected name %s", name)
+	}
+}
@@ -0,0 +1,160 @@
+package datastore
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// readCache keeps the values of the recently read keys, so reading them
+// again does not touch the segments. A value is cached with the location
+// of its record and only served while the index still points there: a
+// read racing a write may cache the value it read after the write, but
+// not serve it afterwards. A compaction reuses the locations, so it
+// empties the cache.
+type readCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      list.List
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key      string
+	location hashEntry
+	value    string
+}
+
+// CacheStats is the state of the read cache.
+type CacheStats struct {
+	Capacity int    `json:"capacity"`
+	Entries  int    `json:"entries"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+func newReadCache(capacity int) *readCache {
+	return &readCache{capacity: capacity, entries: make(map[string]*list.Element)}
+}
+
+func (c *readCache) get(key string, location hashEntry) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok || el.Value.(*cacheEntry).location != location {
+		c.misses.Add(1)
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (c *readCache) put(key string, location hashEntry, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key, location, value}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, location, value})
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops the value of a key written or deleted, it would not be
+// served anymore anyway.
+func (c *readCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *readCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+func (c *readCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Capacity: c.capacity,
+		Entries:  c.lru.Len(),
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+	}
+}
+
+// RecentKeys returns at most n keys, the most recently written first. The
+// order of the records in the segments is all the db knows of it, so the
+// keys copied by a compaction are ordered arbitrarily, after those
+// written since.
+func (db *Db) RecentKeys(n int) []string {
+	db.mu.RLock()
+	type located struct {
+		key      string
+		location hashEntry
+	}
+	all := make([]located, 0, len(db.index))
+	for key, location := range db.index {
+		all = append(all, located{key, location})
+	}
+	db.mu.RUnlock()
+	slices.SortFunc(all, func(a, b located) int {
+		if c := cmp.Compare(b.location[0], a.location[0]); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.location[1], a.location[1])
+	})
+	keys := make([]string, 0, min(n, len(all)))
+	for _, l := range all[:min(n, len(all))] {
+		keys = append(keys, l.key)
+	}
+	return keys
+}
+
+// Prime loads the values of the keys into the read cache, e.g. right
+// after the recovery so the first reads do not wait for the disk. The
+// keys that do not exist are skipped, as are those beyond the capacity of
+// the cache. It returns the number of values loaded, the first read
+// failing stops it.
+func (db *Db) Prime(keys []string) (int, error) {
+	if db.cache == nil {
+		return 0, fmt.Errorf("the read cache is disabled")
+	}
+	loaded := 0
+	for _, key := range keys {
+		if loaded == db.cache.capacity {
+			break
+		}
+		db.mu.RLock()
+		location, found := db.index[key]
+		db.mu.RUnlock()
+		if !found {
+			continue
+		}
+		value, err := db.readAt(location[0], location[1])
+		if err != nil {
+			return loaded, fmt.Errorf("failed to prime %s: %w", key, err)
+		}
+		db.cache.put(key, location, value)
+		loaded++
+	}
+	return loaded, nil
+}
@@ -0,0 +1,83 @@
+package datastore
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TriggerManual    = "manual"
+	TriggerScheduled = "scheduled"
+
+	compactionHistorySize = 20
+)
+
+// Compaction describes a single run of segment merging.
+type Compaction struct {
+	Trigger        string        `json:"trigger"`
+	StartedAt      time.Time     `json:"startedAt"`
+	Duration       time.Duration `json:"duration"`
+	BytesBefore    int64         `json:"bytesBefore"`
+	BytesAfter     int64         `json:"bytesAfter"`
+	BytesReclaimed int64         `json:"bytesReclaimed"`
+	Running        bool          `json:"running"`
+	Error          string        `json:"error,omitempty"`
+}
+
+type compactionLog struct {
+	mu      sync.Mutex
+	current *Compaction
+	history []Compaction
+}
+
+func (l *compactionLog) begin(trigger string) *Compaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current = &Compaction{
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+		Running:   true,
+	}
+	return l.current
+}
+
+func (l *compactionLog) update(f func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f()
+}
+
+func (l *compactionLog) end(run *Compaction, bytesAfter int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	run.Running = false
+	run.Duration = time.Since(run.StartedAt)
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		run.BytesAfter = bytesAfter
+		run.BytesReclaimed = run.BytesBefore - bytesAfter
+	}
+	if len(l.history) == compactionHistorySize {
+		l.history = l.history[1:]
+	}
+	l.history = append(l.history, *run)
+	l.current = nil
+}
+
+// Compactions returns the currently running compaction (if any) and
+// the most recent finished runs, oldest first.
+func (db *Db) Compactions() (*Compaction, []Compaction) {
+	l := &db.compactions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var current *Compaction
+	if l.current != nil {
+		c := *l.current
+		c.Duration = time.Since(c.StartedAt)
+		current = &c
+	}
+	history := make([]Compaction, len(l.history))
+	copy(history, l.history)
+	return current, history
+}
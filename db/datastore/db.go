@@ -2,38 +2,73 @@ package datastore
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"fmt"
-	"io"
+	"maps"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrNotFound = fmt.Errorf("record does not exist")
 	ErrDbClosed = fmt.Errorf("db is closed")
+	ErrExists   = fmt.Errorf("record already exists")
 )
 
 const (
-	DbSegmentExt      = ".seg"
+	DbSegmentExt = ".seg"
+	// mergeTempExt marks the copy a compaction makes, it is not a segment
+	// until the compaction renames it.
+	mergeTempExt      = ".tmp"
 	recoverbufferSize = 8192
 )
 
 type DbOptions struct {
 	MaxSegmentSize int64
 	WorkerPoolSize int
+	// MaxPendingReads bounds the reads waiting for one of the workers,
+	// 0 leaves them unbounded. The OverloadPolicy, by default
+	// OverloadBlock, applies to reads beyond it.
+	MaxPendingReads int
+	OverloadPolicy  OverloadPolicy
+	OverloadTimeout time.Duration
+	// ReadKeyAffinity serves all reads of a key by the same worker, in
+	// the order they were made.
+	ReadKeyAffinity bool
+	// Metrics receives the timings of the reads, if set.
+	Metrics MetricsSink
+	// CacheSize is the number of values of the recently read keys kept in
+	// memory, 0 disables the read cache.
+	CacheSize int
 }
 
 type hashEntry [2]int64
 type hashIndex map[string]hashEntry
 
+type writeMode int
+
+const (
+	writeUpsert writeMode = iota
+	writeCreate
+	writeDelete
+)
+
+type writeResult struct {
+	prev    string
+	existed bool
+	err     error
+}
+
 type writeMsg struct {
-	e     entry
-	errCh chan error
+	e        entry
+	mode     writeMode
+	withPrev bool
+	resCh    chan writeResult
 }
 
 type Db struct {
@@ -46,6 +81,11 @@ type Db struct {
 	mu             sync.RWMutex
 	isClosed       bool
 	wq             *workerQueue
+	compactMu      sync.Mutex
+	compactions    compactionLog
+	cache          *readCache
+	pendingWrites  atomic.Int64
+	writes         atomic.Uint64
 
 	index hashIndex
 }
@@ -57,9 +97,26 @@ func NewDb(dir string, options DbOptions) (*Db, error) {
 		maxSegmentSize: options.MaxSegmentSize,
 		dir:            dir,
 	}
-	db.wq = newWorkerQueue(db.get, options.WorkerPoolSize)
+	queue := queueOptions{
+		maxPending:  options.MaxPendingReads,
+		policy:      options.OverloadPolicy,
+		timeout:     options.OverloadTimeout,
+		keyAffinity: options.ReadKeyAffinity,
+		metrics:     options.Metrics,
+	}
+	if err := queue.validate(); err != nil {
+		return nil, err
+	}
+	if options.CacheSize < 0 {
+		return nil, fmt.Errorf("cache size cannot be negative")
+	}
+	if options.CacheSize > 0 {
+		db.cache = newReadCache(options.CacheSize)
+	}
+	db.wq = newWorkerQueue(db.get, options.WorkerPoolSize, queue)
 	err := db.recover()
 	if err != nil {
+		db.wq.Close()
 		return nil, err
 	}
 	go db.write()
@@ -84,88 +141,80 @@ func (db *Db) toSegmentPath(index int64) string {
 	return filepath.Join(db.dir, filename)
 }
 
+func (db *Db) mergePath() string {
+	return filepath.Join(db.dir, "merge"+mergeTempExt)
+}
+
 func (db *Db) loadSegment() error {
 	segmentPath := db.getSegmentPath()
 	segment, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
-	if err == nil {
-		db.segment = segment
-		db.segmentOffset = 0
+	if err != nil {
+		return err
 	}
-	return err
-}
-
-func (db *Db) recoverSegmentIndex() (int, error) {
-	files, err := os.ReadDir(db.dir)
+	// The last segment recovered is appended to, its records stay where
+	// they are.
+	info, err := segment.Stat()
 	if err != nil {
-		return -1, err
-	}
-	segmentIndex := -1
-	for _, file := range files {
-		filename := file.Name()
-		if filepath.Ext(filename) == DbSegmentExt {
-			basename := strings.TrimSuffix(filename, DbSegmentExt)
-			index, err := strconv.Atoi(basename)
-			if err != nil {
-				return -1, err
-			}
-			if index > segmentIndex {
-				segmentIndex = index
-			}
-		}
+		segment.Close()
+		return err
 	}
-	return segmentIndex, nil
+	db.segment = segment
+	db.segmentOffset = info.Size()
+	return nil
+}
+
+// nextSegment closes the current segment and starts appending to a new
+// one.
+func (db *Db) nextSegment() error {
+	db.segment.Close()
+	db.segmentIndex++
+	return db.loadSegment()
 }
 
+// recover indexes the segments in the order they were written. A
+// compaction leaves gaps in their numbers, the segments it merged are
+// gone. The copy of a compaction interrupted before the swap is stale
+// and removed.
 func (db *Db) recover() error {
-	segmentIndex, err := db.recoverSegmentIndex()
+	leftovers, err := filepath.Glob(filepath.Join(db.dir, "*"+mergeTempExt))
 	if err != nil {
 		return err
 	}
-	for i := 0; i <= segmentIndex; i++ {
-		db.segmentIndex = i
-		db.segmentOffset = 0
-		segmentPath := db.getSegmentPath()
-		input, err := os.OpenFile(segmentPath, os.O_RDONLY, 0o600)
-		if err != nil {
+	for _, path := range leftovers {
+		if err := os.Remove(path); err != nil {
 			return err
 		}
-		defer input.Close()
-		var buffer [recoverbufferSize]byte
-		in := bufio.NewReaderSize(input, recoverbufferSize)
-		for err == nil {
-			var (
-				header, data []byte
-				n            int
-			)
-			header, err = in.Peek(recoverbufferSize)
-			if err == io.EOF {
-				if len(header) == 0 {
-					continue
-				}
-			} else if err != nil {
-				return err
-			}
-			size := binary.LittleEndian.Uint32(header)
-			if size < recoverbufferSize {
-				data = buffer[:size]
-			} else {
-				data = make([]byte, size)
-			}
-			n, err = in.Read(data)
-			if err == nil {
-				if n != int(size) {
-					return fmt.Errorf("corrupted file")
-				}
-				var e entry
-				e.Decode(data)
-				db.setIndex(e.key)
-				db.segmentOffset += int64(n)
-			}
+	}
+	segments, err := Segments(db.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		db.segmentIndex = segment.Index
+		if err := db.recoverSegment(segment.Path); err != nil {
+			return err
 		}
 	}
 	return db.loadSegment()
 }
 
+// recoverSegment indexes the entries of the segment. An entry not fitting
+// the rest of the segment fails the recovery with ErrCorrupted, before
+// anything is allocated for it.
+func (db *Db) recoverSegment(segmentPath string) error {
+	db.segmentOffset = 0
+	return ScanSegment(segmentPath, func(r Record) error {
+		db.segmentOffset = r.Offset
+		if r.Tombstone {
+			delete(db.index, r.Key)
+		} else {
+			db.setIndex(r.Key)
+		}
+		db.segmentOffset += r.Size
+		return nil
+	})
+}
+
 func (db *Db) Close() error {
 	if db.isClosed {
 		return nil
@@ -181,23 +230,41 @@ func (db *Db) get(key string) (string, error) {
 		return "", ErrDbClosed
 	}
 	db.mu.RLock()
-	segmentIndex, segmentOffset, found := db.getIndex(key)
+	location, found := db.index[key]
 	db.mu.RUnlock()
 	if !found {
 		return "", ErrNotFound
 	}
+	if db.cache == nil {
+		return db.readAt(location[0], location[1])
+	}
+	if value, ok := db.cache.get(key, location); ok {
+		return value, nil
+	}
+	value, err := db.readAt(location[0], location[1])
+	if err == nil {
+		db.cache.put(key, location, value)
+	}
+	return value, err
+}
+
+func (db *Db) readAt(segmentIndex, segmentOffset int64) (string, error) {
 	segmentPath := db.toSegmentPath(segmentIndex)
 	file, err := os.Open(segmentPath)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
 	_, err = file.Seek(segmentOffset, 0)
 	if err != nil {
 		return "", err
 	}
 	reader := bufio.NewReader(file)
-	value, err := readValue(reader)
+	value, err := readValue(reader, info.Size()-segmentOffset)
 	if err != nil {
 		return "", err
 	}
@@ -208,40 +275,155 @@ func (db *Db) Get(key string) (string, error) {
 	return db.wq.Do(key)
 }
 
+// CacheStats reports the state of the read cache, zero if it is disabled.
+func (db *Db) CacheStats() CacheStats {
+	if db.cache == nil {
+		return CacheStats{}
+	}
+	return db.cache.stats()
+}
+
+// WriteStats describe the writes of the db: those waiting for the single
+// writer or being written and the total since the start.
+type WriteStats struct {
+	Pending int    `json:"pending"`
+	Writes  uint64 `json:"writes"`
+}
+
+// WriteQueueStats reports the state of the queue of writes.
+func (db *Db) WriteQueueStats() WriteStats {
+	return WriteStats{Pending: int(db.pendingWrites.Load()), Writes: db.writes.Load()}
+}
+
+// ReadQueueStats reports the state of the queue of reads.
+func (db *Db) ReadQueueStats() QueueStats {
+	return db.wq.stats()
+}
+
+// GetCtx is Get giving up once ctx is done, e.g. when the client of the
+// request it serves has gone.
+func (db *Db) GetCtx(ctx context.Context, key string) (string, error) {
+	return db.wq.DoCtx(ctx, key)
+}
+
 func (db *Db) write() {
 	for msg := range db.writeCh {
 		db.mu.Lock()
-		n, err := db.segment.Write(msg.e.Encode())
+		msg.resCh <- db.writeEntry(msg)
+		db.mu.Unlock()
+	}
+}
+
+func (db *Db) writeEntry(msg writeMsg) writeResult {
+	var res writeResult
+	segmentIndex, segmentOffset, existed := db.getIndex(msg.e.key)
+	res.existed = existed
+	if existed && msg.mode == writeCreate {
+		res.err = ErrExists
+		return res
+	}
+	if !existed && msg.mode == writeDelete {
+		res.err = ErrNotFound
+		return res
+	}
+	if existed && msg.withPrev {
+		prev, err := db.readAt(segmentIndex, segmentOffset)
 		if err != nil {
-			msg.errCh <- fmt.Errorf("failed to put %s: %s", msg.e.key, msg.e.value)
-		} else {
-			msg.errCh <- nil
-			db.setIndex(msg.e.key)
-			db.segmentOffset += int64(n)
-			if db.segmentOffset >= db.maxSegmentSize {
-				db.segment.Close()
-				db.segmentIndex++
-				db.loadSegment()
-			}
+			res.err = err
+			return res
 		}
-		db.mu.Unlock()
+		res.prev = prev
+	}
+	data := msg.e.Encode()
+	if msg.mode == writeDelete {
+		data = encodeTombstone(msg.e.key)
+	}
+	n, err := db.segment.Write(data)
+	if err != nil {
+		res.err = fmt.Errorf("failed to put %s: %s", msg.e.key, msg.e.value)
+		return res
+	}
+	if msg.mode == writeDelete {
+		delete(db.index, msg.e.key)
+	} else {
+		db.setIndex(msg.e.key)
+	}
+	if db.cache != nil {
+		db.cache.remove(msg.e.key)
 	}
+	db.segmentOffset += int64(n)
+	if db.segmentOffset >= db.maxSegmentSize {
+		db.nextSegment()
+	}
+	return res
+}
+
+func (db *Db) send(msg writeMsg) writeResult {
+	if db.isClosed {
+		return writeResult{err: ErrDbClosed}
+	}
+	msg.resCh = make(chan writeResult)
+	db.pendingWrites.Add(1)
+	defer db.pendingWrites.Add(-1)
+	db.writeCh <- msg
+	res := <-msg.resCh
+	db.writes.Add(1)
+	return res
 }
 
+// Put stores the value under the key, overwriting any existing record.
 func (db *Db) Put(key, value string) error {
+	return db.send(writeMsg{e: entry{key: key, value: value}}).err
+}
+
+// Create stores the value only if the key does not exist yet,
+// otherwise ErrExists is returned and nothing is written.
+func (db *Db) Create(key, value string) error {
+	return db.send(writeMsg{e: entry{key: key, value: value}, mode: writeCreate}).err
+}
+
+// Upsert stores the value under the key and reports whether the key
+// existed before together with its previous value.
+func (db *Db) Upsert(key, value string) (prev string, existed bool, err error) {
+	res := db.send(writeMsg{e: entry{key: key, value: value}, withPrev: true})
+	return res.prev, res.existed, res.err
+}
+
+// Store stores the value under the key and reports whether the key
+// existed before. Unlike Upsert it does not read the previous value.
+func (db *Db) Store(key, value string) (existed bool, err error) {
+	res := db.send(writeMsg{e: entry{key: key, value: value}})
+	return res.existed, res.err
+}
+
+// Delete removes the key, returning ErrNotFound if it does not exist. A
+// tombstone record is appended so that the key stays deleted after a
+// restart, compaction drops both.
+func (db *Db) Delete(key string) error {
+	return db.send(writeMsg{e: entry{key: key}, mode: writeDelete}).err
+}
+
+// Keys returns the existing keys starting with prefix in ascending order.
+func (db *Db) Keys(prefix string) ([]string, error) {
 	if db.isClosed {
-		return ErrDbClosed
+		return nil, ErrDbClosed
 	}
-	e := entry{
-		key:   key,
-		value: value,
+	db.mu.RLock()
+	keys := []string{}
+	for key := range db.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
 	}
-	errCh := make(chan error)
-	db.writeCh <- writeMsg{e, errCh}
-	return <-errCh
+	db.mu.RUnlock()
+	slices.Sort(keys)
+	return keys, nil
 }
 
-func (db *Db) Copy(filename string) (int64, hashIndex, error) {
+// copyRecords writes the records of the index into filename, returning
+// their size and locations there. The segments of the index must not be
+// written to meanwhile.
+func (db *Db) copyRecords(live hashIndex, filename string) (int64, hashIndex, error) {
 	var (
 		segmentOffset int64
 		index         = make(hashIndex)
@@ -251,8 +433,8 @@ func (db *Db) Copy(filename string) (int64, hashIndex, error) {
 		return 0, nil, err
 	}
 	defer swap.Close()
-	for key := range db.index {
-		value, err := db.get(key)
+	for key, location := range live {
+		value, err := db.readAt(location[0], location[1])
 		if err != nil {
 			os.Remove(filename)
 			return 0, nil, err
@@ -272,35 +454,92 @@ func (db *Db) Copy(filename string) (int64, hashIndex, error) {
 	return segmentOffset, index, nil
 }
 
+func (db *Db) size() (int64, error) {
+	segments, err := Segments(db.dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, segment := range segments {
+		size += segment.Size
+	}
+	return size, nil
+}
+
+// Merge compacts all segments into a single one.
 func (db *Db) Merge() error {
+	return db.Compact(TriggerManual)
+}
+
+// Compact merges all segments into a single one keeping only the latest
+// value of every key. The run is recorded in the compaction history
+// under the given trigger.
+func (db *Db) Compact(trigger string) error {
 	if db.isClosed {
 		return ErrDbClosed
 	}
-	swapFilename := db.toSegmentPath(time.Now().Unix())
-	segmentOffset, index, err := db.Copy(swapFilename)
-	if err != nil {
-		return err
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	run := db.compactions.begin(trigger)
+	bytesAfter, err := db.merge(run)
+	db.compactions.end(run, bytesAfter, err)
+	return err
+}
+
+// merge copies the live records into a new segment 0 replacing the
+// others. The db is locked only to seal the segments being merged and to
+// swap them for the copy, the reads and writes go on while it is made.
+func (db *Db) merge(run *Compaction) (int64, error) {
+	db.mu.Lock()
+	bytesBefore, err := db.size()
+	if err == nil {
+		err = db.nextSegment()
 	}
-	segmentIndex := db.segmentIndex
-	segmentPath := db.toSegmentPath(0)
-	err = os.Rename(swapFilename, segmentPath)
+	sealed := db.segmentIndex - 1
+	live := maps.Clone(db.index)
+	db.mu.Unlock()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	segment, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	db.compactions.update(func() { run.BytesBefore = bytesBefore })
+
+	swapFilename := db.mergePath()
+	segmentOffset, index, err := db.copyRecords(live, swapFilename)
 	if err != nil {
-		return err
+		return 0, err
 	}
+
 	db.mu.Lock()
-	db.segment.Close()
-	db.segmentIndex = 0
-	db.index = index
-	db.segmentOffset = segmentOffset
-	db.segment = segment
-	db.mu.Unlock()
-	for i := 1; i <= segmentIndex; i++ {
-		segmentPath := db.toSegmentPath(int64(i))
-		os.Remove(segmentPath)
+	defer db.mu.Unlock()
+	segmentPath := db.toSegmentPath(0)
+	if err := os.Rename(swapFilename, segmentPath); err != nil {
+		os.Remove(swapFilename)
+		return 0, err
 	}
-	return nil
+	// The keys written or deleted during the copy keep their newer
+	// records.
+	for key, location := range index {
+		if current, ok := db.index[key]; ok && current == live[key] {
+			db.index[key] = location
+		}
+	}
+	if db.cache != nil {
+		db.cache.clear()
+	}
+	for i := 1; i <= sealed; i++ {
+		os.Remove(db.toSegmentPath(int64(i)))
+	}
+	// Without writes during the copy the merged segment is appended to
+	// and the db is left with a single one.
+	if db.segmentOffset == 0 && db.segmentIndex == sealed+1 {
+		if segment, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY, 0o600); err == nil {
+			db.segment.Close()
+			os.Remove(db.getSegmentPath())
+			db.segment = segment
+			db.segmentIndex = 0
+			db.segmentOffset = segmentOffset
+		}
+	}
+	return segmentOffset, nil
 }
@@ -1,7 +1,11 @@
 package datastore
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -162,3 +166,445 @@ func TestDb_Put(t *testing.T) {
 		}
 	})
 }
+
+func TestDb_CreateUpsert(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{
+		MaxSegmentSize: segmentSize,
+		WorkerPoolSize: poolSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t.Run("create", func(t *testing.T) {
+		if err := db.Create("key1", "value1"); err != nil {
+			t.Fatalf("Cannot create %s: %s", "key1", err)
+		}
+		if err := db.Create("key1", "value2"); err != ErrExists {
+			t.Errorf("Expected %s, got %v", ErrExists, err)
+		}
+		value, _ := db.Get("key1")
+		if value != "value1" {
+			t.Errorf("Bad value returned expected %s, got %s", "value1", value)
+		}
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		prev, existed, err := db.Upsert("key1", "value3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !existed || prev != "value1" {
+			t.Errorf("Expected previous value %s, got %s (existed: %t)", "value1", prev, existed)
+		}
+		_, existed, err = db.Upsert("key2", "value2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existed {
+			t.Errorf("Expected %s to be created", "key2")
+		}
+		value, _ := db.Get("key1")
+		if value != "value3" {
+			t.Errorf("Bad value returned expected %s, got %s", "value3", value)
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		existed, err := db.Store("key1", "value4")
+		if err != nil || !existed {
+			t.Errorf("Expected %s to exist, got %t: %v", "key1", existed, err)
+		}
+		existed, err = db.Store("key3", "value3")
+		if err != nil || existed {
+			t.Errorf("Expected %s to be created, got %t: %v", "key3", existed, err)
+		}
+		value, _ := db.Get("key1")
+		if value != "value4" {
+			t.Errorf("Bad value returned expected %s, got %s", "value4", value)
+		}
+	})
+}
+
+func TestDb_Compactions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{
+		MaxSegmentSize: segmentSize,
+		WorkerPoolSize: poolSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(TriggerScheduled); err != nil {
+		t.Fatal(err)
+	}
+
+	current, history := db.Compactions()
+	if current != nil {
+		t.Errorf("Unexpected running compaction %+v", current)
+	}
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 compaction in history, got %d", len(history))
+	}
+	run := history[0]
+	if run.Trigger != TriggerScheduled {
+		t.Errorf("Bad trigger expected %s, got %s", TriggerScheduled, run.Trigger)
+	}
+	if run.BytesReclaimed <= 0 || run.BytesAfter+run.BytesReclaimed != run.BytesBefore {
+		t.Errorf("Unexpected compaction sizes %+v", run)
+	}
+	value, _ := db.Get("key")
+	if value != "value" {
+		t.Errorf("Bad value returned expected %s, got %s", "value", value)
+	}
+}
+
+func TestDb_CompactWhileWriting(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: poolSize}
+	db, err := NewDb(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 200 {
+		if err := db.Put(fmt.Sprintf("key-%d", i), "old"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compacted := make(chan error)
+	go func() { compacted <- db.Compact(TriggerManual) }()
+	for i := range 200 {
+		key := fmt.Sprintf("key-%d", i)
+		if i%10 == 0 {
+			err = db.Delete(key)
+		} else if i%2 == 0 {
+			err = db.Put(key, "new")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-compacted; err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		for i := range 200 {
+			value, err := db.Get(fmt.Sprintf("key-%d", i))
+			switch {
+			case i%10 == 0:
+				if err != ErrNotFound {
+					t.Errorf("Expected key-%d deleted, got %q: %v", i, value, err)
+				}
+			case i%2 == 0:
+				if value != "new" {
+					t.Errorf("Expected the write of key-%d during the compaction, got %q: %v", i, value, err)
+				}
+			default:
+				if value != "old" {
+					t.Errorf("Expected key-%d kept by the compaction, got %q: %v", i, value, err)
+				}
+			}
+		}
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check()
+}
+
+func TestDb_DeleteKeys(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{
+		MaxSegmentSize: segmentSize,
+		WorkerPoolSize: poolSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"user:2", "user:1", "team:1"} {
+		if err := db.Put(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		if err := db.Delete("user:2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get("user:2"); err != ErrNotFound {
+			t.Errorf("Expected %s, got %v", ErrNotFound, err)
+		}
+		if err := db.Delete("user:2"); err != ErrNotFound {
+			t.Errorf("Expected %s, got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		keys, err := db.Keys("user:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0] != "user:1" {
+			t.Errorf("Unexpected keys %v", keys)
+		}
+		if keys, _ := db.Keys(""); len(keys) != 2 || keys[0] != "team:1" {
+			t.Errorf("Unexpected keys %v", keys)
+		}
+	})
+
+	t.Run("recover", func(t *testing.T) {
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db, err = NewDb(dir, DbOptions{
+			MaxSegmentSize: segmentSize,
+			WorkerPoolSize: poolSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Get("user:2"); err != ErrNotFound {
+			t.Errorf("Deleted key is back after a restart: %v", err)
+		}
+		if err := db.Merge(); err != nil {
+			t.Fatal(err)
+		}
+		if keys, _ := db.Keys(""); len(keys) != 2 {
+			t.Errorf("Unexpected keys after compaction %v", keys)
+		}
+	})
+}
+
+func TestDb_WriteAfterReopen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{{"k1", "v1"}, {"k2", "v2"}} {
+		if err := db.Put(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// The writes go to the end of the recovered segment, after the
+	// records of the earlier process.
+	for _, kv := range [][2]string{{"k3", "v3"}, {"k2", "v2.1"}} {
+		if err := db.Put(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, expected := range map[string]string{"k1": "v1", "k2": "v2.1", "k3": "v3"} {
+		if value, err := db.Get(key); err != nil || value != expected {
+			t.Errorf("Expected %s to be %s, got %q: %v", key, expected, value, err)
+		}
+	}
+}
+
+func TestDb_RecoverInterruptedMerge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{{"k1", "v1"}, {"k2", "v2"}} {
+		if err := db.Put(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A compaction stopped mid-copy leaves a snapshot older than the
+	// writes made during the copy.
+	snapshot, err := os.ReadFile(db.getSegmentPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db.mergePath(), snapshot, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k1", "v1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("k2"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get("k1"); err != nil || value != "v1.1" {
+		t.Errorf("Expected k1 to be v1.1, got %q: %v", value, err)
+	}
+	if _, err := db.Get("k2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected k2 to stay deleted, got %v", err)
+	}
+	if _, err := os.Stat(db.mergePath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale merge copy to be removed, got %v", err)
+	}
+	if db.segmentIndex != 0 {
+		t.Errorf("Expected to append to segment 0, got %d", db.segmentIndex)
+	}
+}
+
+func TestDb_RecoverCorrupted(t *testing.T) {
+	for name, corrupt := range map[string]func(data []byte) []byte{
+		"huge entry":  func(data []byte) []byte { return append(data, 0xff, 0xff, 0xff, 0x7f, 3, 0, 0, 0) },
+		"torn header": func(data []byte) []byte { return append(data, 20, 0) },
+		"torn entry":  func(data []byte) []byte { return data[:len(data)-2] },
+		"huge key": func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[4:], 1<<30)
+			return data
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := NewDb(dir, DbOptions{
+				MaxSegmentSize: segmentSize,
+				WorkerPoolSize: poolSize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"key1", "key2"} {
+				if err := db.Put(key, "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			segmentPath := db.getSegmentPath()
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(segmentPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(segmentPath, corrupt(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewDb(dir, DbOptions{
+				MaxSegmentSize: segmentSize,
+				WorkerPoolSize: poolSize,
+			})
+			if !errors.Is(err, ErrCorrupted) || !strings.Contains(err.Error(), segmentPath) {
+				t.Errorf("expected ErrCorrupted in %s, got %v", segmentPath, err)
+			}
+		})
+	}
+}
+
+func TestDb_ReadCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1, CacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{{"k1", "v1"}, {"k2", "v2"}, {"k3", "v3"}, {"k1", "v1.1"}} {
+		if _, err := db.Get(kv[0]); err != nil && err != ErrNotFound {
+			t.Fatal(err)
+		}
+		if err := db.Put(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, _ := db.Get("k1"); value != "v1.1" {
+		t.Errorf("Expected the written value, got %s", value)
+	}
+	db.Close()
+
+	db, err = NewDb(dir, DbOptions{MaxSegmentSize: segmentSize, WorkerPoolSize: 1, CacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if keys := strings.Join(db.RecentKeys(2), ","); keys != "k1,k3" {
+		t.Errorf("Expected the most recently written keys, got %s", keys)
+	}
+	n, err := db.Prime([]string{"missing", "k2", "k1", "k3"})
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 values primed, got %d: %v", n, err)
+	}
+
+	// The segment is gone, the values come from the cache.
+	os.Rename(db.toSegmentPath(0), db.toSegmentPath(0)+".moved")
+	for key, expected := range map[string]string{"k1": "v1.1", "k2": "v2"} {
+		if value, err := db.Get(key); err != nil || value != expected {
+			t.Errorf("Expected %s cached as %s, got %q: %v", key, expected, value, err)
+		}
+	}
+	if stats := db.CacheStats(); stats.Entries != 2 || stats.Hits != 2 || stats.Misses != 0 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+	os.Rename(db.toSegmentPath(0)+".moved", db.toSegmentPath(0))
+
+	if err := db.Compact(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.CacheStats(); stats.Entries != 0 {
+		t.Errorf("Expected the compaction to empty the cache, got %+v", stats)
+	}
+	if value, _ := db.Get("k2"); value != "v2" {
+		t.Errorf("Expected the value after the compaction, got %s", value)
+	}
+}
@@ -3,13 +3,44 @@ package datastore
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 )
 
 type entry struct {
 	key, value string
 }
 
+// ErrCorrupted is returned for entries whose sizes do not fit the bytes
+// they are read from.
+var ErrCorrupted = errors.New("corrupted entry")
+
+// tombstoneSize is written as the value size of the records deleting a
+// key. Such records have no value.
+const tombstoneSize = math.MaxUint32
+
+// entryHeaderSize is the size of the entry, key and value size fields.
+const entryHeaderSize = 12
+
+func encodeTombstone(key string) []byte {
+	kl := len(key)
+	res := make([]byte, kl+12)
+	binary.LittleEndian.PutUint32(res, uint32(kl+12))
+	binary.LittleEndian.PutUint32(res[4:], uint32(kl))
+	copy(res[8:], key)
+	binary.LittleEndian.PutUint32(res[kl+8:], tombstoneSize)
+	return res
+}
+
+// isTombstone tells whether the entry, which must have been decoded
+// successfully, deletes its key.
+func isTombstone(input []byte) bool {
+	kl := binary.LittleEndian.Uint32(input[4:])
+	return binary.LittleEndian.Uint32(input[kl+8:]) == tombstoneSize
+}
+
 func (e *entry) Encode() []byte {
 	kl := len(e.key)
 	vl := len(e.value)
@@ -23,47 +54,72 @@ func (e *entry) Encode() []byte {
 	return res
 }
 
-func (e *entry) Decode(input []byte) {
-	kl := binary.LittleEndian.Uint32(input[4:])
-	keyBuf := make([]byte, kl)
-	copy(keyBuf, input[8:kl+8])
-	e.key = string(keyBuf)
-
+// Decode parses an encoded entry, checking its sizes against the input
+// rather than trusting them.
+func (e *entry) Decode(input []byte) error {
+	if len(input) < entryHeaderSize {
+		return fmt.Errorf("%w: %d bytes are too short for an entry", ErrCorrupted, len(input))
+	}
+	size := binary.LittleEndian.Uint32(input)
+	if int64(size) != int64(len(input)) {
+		return fmt.Errorf("%w: entry size %d does not match its %d bytes", ErrCorrupted, size, len(input))
+	}
+	kl := int64(binary.LittleEndian.Uint32(input[4:]))
+	if kl > int64(len(input))-entryHeaderSize {
+		return fmt.Errorf("%w: key size %d exceeds the entry", ErrCorrupted, kl)
+	}
 	vl := binary.LittleEndian.Uint32(input[kl+8:])
-	valBuf := make([]byte, vl)
-	copy(valBuf, input[kl+12:kl+12+vl])
-	e.value = string(valBuf)
+	if vl == tombstoneSize {
+		vl = 0
+	}
+	if kl+int64(vl)+entryHeaderSize != int64(len(input)) {
+		return fmt.Errorf("%w: key size %d and value size %d do not add up to the entry", ErrCorrupted, kl, vl)
+	}
+	e.key = string(input[8 : kl+8])
+	e.value = string(input[kl+12:])
+	return nil
 }
 
-func readValue(in *bufio.Reader) (string, error) {
+// readValue reads the value of the entry at the start of in, which has
+// at most limit bytes left.
+func readValue(in *bufio.Reader, limit int64) (string, error) {
 	header, err := in.Peek(8)
 	if err != nil {
-		return "", err
+		return "", truncated(err)
 	}
-	keySize := int(binary.LittleEndian.Uint32(header[4:]))
-	_, err = in.Discard(keySize + 8)
-	if err != nil {
-		return "", err
+	size := int64(binary.LittleEndian.Uint32(header))
+	keySize := int64(binary.LittleEndian.Uint32(header[4:]))
+	if size < entryHeaderSize || size > limit || keySize > size-entryHeaderSize {
+		return "", fmt.Errorf("%w: entry of %d bytes with a key of %d bytes", ErrCorrupted, size, keySize)
+	}
+	if _, err := in.Discard(int(keySize) + 8); err != nil {
+		return "", truncated(err)
 	}
 
 	header, err = in.Peek(4)
 	if err != nil {
-		return "", err
+		return "", truncated(err)
 	}
-	valSize := int(binary.LittleEndian.Uint32(header))
-	_, err = in.Discard(4)
-	if err != nil {
-		return "", err
+	valSize := int64(binary.LittleEndian.Uint32(header))
+	if keySize+valSize+entryHeaderSize != size {
+		return "", fmt.Errorf("%w: key size %d and value size %d do not add up to the entry", ErrCorrupted, keySize, valSize)
+	}
+	if _, err := in.Discard(4); err != nil {
+		return "", truncated(err)
 	}
 
 	data := make([]byte, valSize)
-	n, err := in.Read(data)
-	if err != nil {
-		return "", err
-	}
-	if n != valSize {
-		return "", fmt.Errorf("can't read value bytes (read %d, expected %d)", n, valSize)
+	if _, err := io.ReadFull(in, data); err != nil {
+		return "", truncated(err)
 	}
-
 	return string(data), nil
 }
+
+// truncated reports the end of the input in the middle of an entry as
+// corruption.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated entry", ErrCorrupted)
+	}
+	return err
+}
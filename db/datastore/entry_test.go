@@ -3,12 +3,16 @@ package datastore
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"testing"
 )
 
 func TestEntry_Encode(t *testing.T) {
 	e := entry{"key", "value"}
-	e.Decode(e.Encode())
+	if err := e.Decode(e.Encode()); err != nil {
+		t.Fatal(err)
+	}
 	if e.key != "key" {
 		t.Error("incorrect key")
 	}
@@ -20,7 +24,7 @@ func TestEntry_Encode(t *testing.T) {
 func TestReadValue(t *testing.T) {
 	e := entry{"key", "test-value"}
 	data := e.Encode()
-	v, err := readValue(bufio.NewReader(bytes.NewReader(data)))
+	v, err := readValue(bufio.NewReader(bytes.NewReader(data)), int64(len(data)))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,3 +32,87 @@ func TestReadValue(t *testing.T) {
 		t.Errorf("Got bat value [%s]", v)
 	}
 }
+
+func TestEntry_DecodeCorrupted(t *testing.T) {
+	valid := (&entry{"key", "value"}).Encode()
+	corrupt := func(offset int, v uint32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[offset:], v)
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"empty":           nil,
+		"short":           valid[:8],
+		"truncated":       valid[:len(valid)-1],
+		"huge size":       corrupt(0, 1<<31),
+		"huge key":        corrupt(4, 1<<31),
+		"huge value":      corrupt(11, 1<<31),
+		"short value":     corrupt(11, 2),
+		"trailing bytes":  append(bytes.Clone(valid), 0),
+		"tombstone value": append(encodeTombstone("key"), 'v'),
+	} {
+		var e entry
+		if err := e.Decode(data); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("%s: expected ErrCorrupted, got %v", name, err)
+		}
+		if _, err := readValue(bufio.NewReader(bytes.NewReader(data)), int64(len(data))); !errors.Is(err, ErrCorrupted) && name != "trailing bytes" {
+			t.Errorf("%s: expected readValue to fail with ErrCorrupted, got %v", name, err)
+		}
+	}
+}
+
+func FuzzEntry_RoundTrip(f *testing.F) {
+	f.Add("key", "value")
+	f.Add("", "")
+	f.Add("k\x00ey", "v\xffalue")
+	f.Fuzz(func(t *testing.T, key, value string) {
+		data := (&entry{key, value}).Encode()
+		var e entry
+		if err := e.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		if e.key != key || e.value != value {
+			t.Errorf("decoded %q: %q, expected %q: %q", e.key, e.value, key, value)
+		}
+		v, err := readValue(bufio.NewReader(bytes.NewReader(data)), int64(len(data)))
+		if err != nil || v != value {
+			t.Errorf("readValue = %q, %v, expected %q", v, err, value)
+		}
+	})
+}
+
+func FuzzEntry_Decode(f *testing.F) {
+	f.Add((&entry{"key", "value"}).Encode())
+	f.Add(encodeTombstone("key"))
+	f.Add([]byte{12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{255, 255, 255, 255, 255, 255, 255, 255})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// readValue reads an entry from a stream, it must agree with Decode
+		// of the bytes of the entry.
+		if v, err := readValue(bufio.NewReader(bytes.NewReader(data)), int64(len(data))); err == nil {
+			var e entry
+			if derr := e.Decode(data[:binary.LittleEndian.Uint32(data)]); derr != nil || e.value != v {
+				t.Errorf("readValue = %q, Decode = %q, %v", v, e.value, derr)
+			}
+		} else if !errors.Is(err, ErrCorrupted) {
+			t.Errorf("expected readValue to fail with ErrCorrupted, got %v", err)
+		}
+
+		var e entry
+		err := e.Decode(data)
+		if err != nil {
+			if !errors.Is(err, ErrCorrupted) {
+				t.Errorf("expected ErrCorrupted, got %v", err)
+			}
+			return
+		}
+		// The valid entries encode back to their input.
+		reencoded := (&entry{e.key, e.value}).Encode()
+		if isTombstone(data) {
+			reencoded = encodeTombstone(e.key)
+		}
+		if !bytes.Equal(reencoded, data) {
+			t.Errorf("%x decodes to %q: %q, which encodes to %x", data, e.key, e.value, reencoded)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Segment is a segment file of a db directory.
+type Segment struct {
+	Index int
+	Path  string
+	Size  int64
+}
+
+// Segments lists the segments of the directory in the order they were
+// written, without opening the db, e.g. for inspecting the directory.
+func Segments(dir string) ([]Segment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != DbSegmentExt {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(file.Name(), DbSegmentExt))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected segment name %s", file.Name())
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, Segment{Index: index, Path: filepath.Join(dir, file.Name()), Size: info.Size()})
+	}
+	slices.SortFunc(segments, func(a, b Segment) int { return a.Index - b.Index })
+	return segments, nil
+}
+
+// Record is an entry as it is stored in a segment.
+type Record struct {
+	// Offset is where the entry starts in the segment and Size is its
+	// length with the header.
+	Offset int64
+	Size   int64
+	Key    string
+	Value  string
+	// Tombstone records delete their key and have no value.
+	Tombstone bool
+}
+
+// ScanSegment calls fn with the records of the segment in order, stopping
+// at the first error of fn. An entry not fitting the rest of the segment
+// stops the scan with ErrCorrupted, after the records before it.
+func ScanSegment(segmentPath string, fn func(Record) error) error {
+	input, err := os.Open(segmentPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	corrupted := func(err error) error {
+		return fmt.Errorf("segment %s at offset %d: %w", segmentPath, offset, err)
+	}
+
+	var buffer [recoverbufferSize]byte
+	in := bufio.NewReaderSize(input, recoverbufferSize)
+	for offset < info.Size() {
+		header, err := in.Peek(4)
+		if err != nil {
+			return corrupted(truncated(err))
+		}
+		size := int64(binary.LittleEndian.Uint32(header))
+		if size < entryHeaderSize || size > info.Size()-offset {
+			return corrupted(fmt.Errorf("%w: entry size %d does not fit the segment", ErrCorrupted, size))
+		}
+		data := buffer[:]
+		if size > recoverbufferSize {
+			data = make([]byte, size)
+		}
+		data = data[:size]
+		if _, err := io.ReadFull(in, data); err != nil {
+			return corrupted(truncated(err))
+		}
+		var e entry
+		if err := e.Decode(data); err != nil {
+			return corrupted(err)
+		}
+		r := Record{Offset: offset, Size: size, Key: e.key, Value: e.value, Tombstone: isTombstone(data)}
+		if err := fn(r); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
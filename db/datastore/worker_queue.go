@@ -1,12 +1,68 @@
 package datastore
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var ErrWorkerQueueIsClosed = fmt.Errorf("worker queue is closed")
+var (
+	ErrWorkerQueueIsClosed = fmt.Errorf("worker queue is closed")
+	ErrOverloaded          = fmt.Errorf("too many pending reads")
+	ErrWorkerPanic         = fmt.Errorf("worker panicked")
+)
+
+// OverloadPolicy decides what happens to a read when the maximum number
+// of reads already wait for a worker.
+type OverloadPolicy string
+
+const (
+	// OverloadBlock waits for room in the queue as long as the context of
+	// the read allows.
+	OverloadBlock OverloadPolicy = "block"
+	// OverloadReject fails the read with ErrOverloaded right away.
+	OverloadReject OverloadPolicy = "reject"
+	// OverloadTimeout waits for room up to the timeout, then fails the
+	// read with ErrOverloaded.
+	OverloadTimeout OverloadPolicy = "timeout"
+)
+
+// queueOptions bound the reads waiting for a worker, 0 maxPending leaves
+// them unbounded. keyAffinity makes every key served by the same worker.
+type queueOptions struct {
+	maxPending  int
+	policy      OverloadPolicy
+	timeout     time.Duration
+	keyAffinity bool
+	metrics     MetricsSink
+}
+
+// MetricsSink receives the timings of the reads: how long each waited for
+// a worker and how long the worker took. It is called by the workers, so
+// it must be safe for concurrent use and quick.
+type MetricsSink interface {
+	ObserveRead(wait, exec time.Duration)
+}
+
+func (o queueOptions) validate() error {
+	switch o.policy {
+	case "", OverloadBlock, OverloadReject:
+	case OverloadTimeout:
+		if o.timeout <= 0 {
+			return fmt.Errorf("the timeout overload policy needs a positive timeout")
+		}
+	default:
+		return fmt.Errorf("unknown overload policy %q", o.policy)
+	}
+	if o.maxPending < 0 {
+		return fmt.Errorf("maximum pending reads cannot be negative")
+	}
+	return nil
+}
 
 type getResult struct {
 	value string
@@ -14,87 +70,264 @@ type getResult struct {
 }
 
 type getMsg struct {
-	key   string
-	resCh chan getResult
+	ctx    context.Context
+	key    string
+	resCh  chan getResult
+	queued time.Time
 }
 
 type worker func(string) (string, error)
 
+// workerQueue runs a worker function on a fixed number of goroutines. The
+// queue channel is unbuffered, so a request is handed directly to an idle
+// worker and the sender blocks while all of them are busy: the workers
+// themselves are the semaphore and there is nothing to dispatch.
+//
+// With keyAffinity, every worker has a queue of its own instead and a
+// key always goes to the worker its hash picks. Reads of a key are then
+// served one at a time in the order they came, at the cost of waiting for
+// that worker while others may be idle. Writes need no such care: the
+// single writer has updated the index by the time Put returns.
+//
+// With maxPending set, pendingSlots holds a token for every read waiting
+// for a worker and the policy applies when it is full.
 type workerQueue struct {
-	workerPool []chan getMsg
-	msgQueue   chan getMsg
+	msgQueue     chan getMsg
+	workerQueues []chan getMsg
+	closed       chan struct{}
+	opts         queueOptions
+	pendingSlots chan struct{}
 
-	mu sync.Mutex
+	workers  int
+	pending  atomic.Int64
+	active   atomic.Int64
+	reads    atomic.Uint64
+	rejected atomic.Uint64
+	panics   atomic.Uint64
 
-	isClosed bool
+	closeOnce sync.Once
+	running   sync.WaitGroup
 }
 
-func newWorkerQueue(w worker, workerCount int) *workerQueue {
+// resChPool recycles the result channels of the requests that received
+// their result. Abandoned ones may still get a late result, so they are
+// left to the garbage collector.
+var resChPool = sync.Pool{New: func() any { return make(chan getResult, 1) }}
+
+func newWorkerQueue(w worker, workerCount int, opts queueOptions) *workerQueue {
 	q := &workerQueue{
-		workerPool: make([]chan getMsg, workerCount),
+		msgQueue: make(chan getMsg),
+		closed:   make(chan struct{}),
+		opts:     opts,
+		workers:  workerCount,
+	}
+	if opts.maxPending > 0 {
+		q.pendingSlots = make(chan struct{}, opts.maxPending)
+	}
+	if opts.keyAffinity {
+		q.workerQueues = make([]chan getMsg, workerCount)
+		for i := range q.workerQueues {
+			q.workerQueues[i] = make(chan getMsg)
+			q.running.Add(1)
+			go q.work(w, q.workerQueues[i])
+		}
+		return q
 	}
 	for i := 0; i < workerCount; i++ {
-		q.workerPool[i] = make(chan getMsg)
-		go q.spawnWorker(w, q.workerPool[i])
+		q.running.Add(1)
+		go q.work(w, q.msgQueue)
 	}
-	q.start(workerCount)
 	return q
 }
 
-func (q *workerQueue) start(workerCount int) {
-	q.msgQueue = make(chan getMsg, workerCount)
-	go func() {
-		for msg := range q.msgQueue {
-			q.mu.Lock()
+// queueFor returns the queue the read of key is sent to.
+func (q *workerQueue) queueFor(key string) chan getMsg {
+	if q.workerQueues == nil {
+		return q.msgQueue
+	}
+	// FNV-1a, inlined to keep dispatch allocation-free.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return q.workerQueues[h%uint32(len(q.workerQueues))]
+}
 
-			for len(q.workerPool) == 0 {
-				q.mu.Unlock()
-				time.Sleep(1 * time.Millisecond)
-				q.mu.Lock()
+func (q *workerQueue) work(w worker, queue chan getMsg) {
+	defer q.running.Done()
+	for {
+		select {
+		case msg := <-queue:
+			// A read may be received as the queue is being closed, select
+			// does not prefer the closed channel. It must not start then.
+			if q.isClosed() {
+				msg.resCh <- getResult{err: ErrWorkerQueueIsClosed}
+				return
 			}
-
-			if len(q.workerPool) == 0 {
-				panic("worker pool is empty")
+			// Requests abandoned while waiting are not worth a read.
+			if err := msg.ctx.Err(); err != nil {
+				msg.resCh <- getResult{err: err}
+				continue
 			}
+			started := time.Now()
+			q.active.Add(1)
+			res, panicked := call(w, msg.key)
+			q.active.Add(-1)
+			q.reads.Add(1)
+			if q.opts.metrics != nil {
+				q.opts.metrics.ObserveRead(started.Sub(msg.queued), time.Since(started))
+			}
+			msg.resCh <- res
+			if panicked {
+				// The worker is replaced rather than trusted with more reads
+				// after it failed half way through one.
+				q.panics.Add(1)
+				q.running.Add(1)
+				go q.work(w, queue)
+				return
+			}
+		case <-q.closed:
+			return
+		}
+	}
+}
 
-			workerCh := q.workerPool[0]
-			q.workerPool[0] = nil
-			q.workerPool = q.workerPool[1:]
-			workerCh <- msg
-			q.mu.Unlock()
+// call runs the worker, turning a panic into an ErrWorkerPanic.
+func call(w worker, key string) (res getResult, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Worker panicked reading %s: %v\n%s", key, r, debug.Stack())
+			res, panicked = getResult{err: fmt.Errorf("%w: %v", ErrWorkerPanic, r)}, true
 		}
 	}()
+	value, err := w(key)
+	return getResult{value, err}, false
 }
 
-func (q *workerQueue) spawnWorker(w worker, ch chan getMsg) {
-	for msg := range ch {
-		value, err := w(msg.key)
-		msg.resCh <- getResult{value, err}
-		q.mu.Lock()
-		q.workerPool = append(q.workerPool, ch)
-		q.mu.Unlock()
-	}
+func (q *workerQueue) Do(key string) (string, error) {
+	return q.DoCtx(context.Background(), key)
 }
 
-func (q *workerQueue) Do(key string) (string, error) {
-	if q.isClosed {
+// DoCtx runs the worker for the key, giving up on waiting for a free
+// worker or for the result once ctx is done.
+func (q *workerQueue) DoCtx(ctx context.Context, key string) (string, error) {
+	queued := time.Now()
+	if q.isClosed() {
 		return "", ErrWorkerQueueIsClosed
 	}
-	resCh := make(chan getResult)
-	q.msgQueue <- getMsg{key, resCh}
-	res := <-resCh
-	return res.value, res.err
+	if err := q.enqueue(ctx); err != nil {
+		return "", err
+	}
+	// The result channel is buffered, so a worker finishing an abandoned
+	// request does not block on it.
+	resCh := resChPool.Get().(chan getResult)
+	err := error(nil)
+	select {
+	case q.queueFor(key) <- getMsg{ctx, key, resCh, queued}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-q.closed:
+		err = ErrWorkerQueueIsClosed
+	}
+	q.dequeue()
+	if err != nil {
+		resChPool.Put(resCh)
+		return "", err
+	}
+	// A worker got the read, it responds even if the queue is closed.
+	select {
+	case res := <-resCh:
+		resChPool.Put(resCh)
+		return res.value, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
 
-func (q *workerQueue) Close() {
-	if q.isClosed {
-		return
+// enqueue counts the read as pending, applying the overload policy when
+// the queue is full.
+func (q *workerQueue) enqueue(ctx context.Context) error {
+	if q.pendingSlots != nil {
+		select {
+		case q.pendingSlots <- struct{}{}:
+		default:
+			if err := q.waitForRoom(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	q.pending.Add(1)
+	return nil
+}
+
+func (q *workerQueue) waitForRoom(ctx context.Context) error {
+	var timeout <-chan time.Time
+	switch q.opts.policy {
+	case OverloadReject:
+		q.rejected.Add(1)
+		return ErrOverloaded
+	case OverloadTimeout:
+		timer := time.NewTimer(q.opts.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case q.pendingSlots <- struct{}{}:
+		return nil
+	case <-timeout:
+		q.rejected.Add(1)
+		return ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-q.closed:
+		return ErrWorkerQueueIsClosed
 	}
-	q.isClosed = true
-	q.mu.Lock()
-	defer q.mu.Unlock()
-	close(q.msgQueue)
-	for _, ch := range q.workerPool {
-		close(ch)
+}
+
+func (q *workerQueue) dequeue() {
+	q.pending.Add(-1)
+	if q.pendingSlots != nil {
+		<-q.pendingSlots
+	}
+}
+
+// QueueStats describe the read queue of the db: the reads waiting for a
+// worker, the workers busy reading and the totals since the start.
+type QueueStats struct {
+	Workers  int    `json:"workers"`
+	Active   int    `json:"active"`
+	Pending  int    `json:"pending"`
+	Reads    uint64 `json:"reads"`
+	Rejected uint64 `json:"rejected"`
+	Panics   uint64 `json:"panics"`
+}
+
+func (q *workerQueue) stats() QueueStats {
+	return QueueStats{
+		Workers:  q.workers,
+		Active:   int(q.active.Load()),
+		Pending:  int(q.pending.Load()),
+		Reads:    q.reads.Load(),
+		Rejected: q.rejected.Load(),
+		Panics:   q.panics.Load(),
 	}
 }
+
+func (q *workerQueue) isClosed() bool {
+	select {
+	case <-q.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers, waiting for the reads they are serving to
+// finish. Reads waiting for a worker and those made later fail with
+// ErrWorkerQueueIsClosed. It is safe to call concurrently with DoCtx and
+// more than once.
+func (q *workerQueue) Close() {
+	q.closeOnce.Do(func() { close(q.closed) })
+	q.running.Wait()
+}
@@ -0,0 +1,379 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingWorker reads nothing until release is closed, counting calls.
+func blockingWorker(release chan struct{}, calls *atomic.Int32) worker {
+	return func(key string) (string, error) {
+		calls.Add(1)
+		<-release
+		return "value of " + key, nil
+	}
+}
+
+func TestWorkerQueue_DoCtx(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	q := newWorkerQueue(blockingWorker(release, &calls), 1, queueOptions{})
+	defer q.Close()
+
+	busy := make(chan error)
+	go func() {
+		_, err := q.Do("busy")
+		busy <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.DoCtx(ctx, "waiting"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to time out, got %v", err)
+	}
+
+	close(release)
+	if err := <-busy; err != nil {
+		t.Fatal(err)
+	}
+	value, err := q.DoCtx(context.Background(), "key")
+	if err != nil || value != "value of key" {
+		t.Fatalf("unexpected result %q, %v", value, err)
+	}
+	// The abandoned request was skipped by the worker.
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 reads, got %d", n)
+	}
+}
+
+func TestWorkerQueue_Close(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	q := newWorkerQueue(blockingWorker(release, &calls), 1, queueOptions{})
+
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := q.Do("a")
+		inFlight <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := q.Do("b")
+		waiting <- err
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, ErrWorkerQueueIsClosed) {
+			t.Errorf("expected ErrWorkerQueueIsClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("requests still wait after the queue was closed")
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the read in flight finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the reads finished")
+	}
+	if err := <-inFlight; err != nil {
+		t.Errorf("the read in flight failed: %v", err)
+	}
+	if _, err := q.Do("c"); !errors.Is(err, ErrWorkerQueueIsClosed) {
+		t.Errorf("expected ErrWorkerQueueIsClosed, got %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 read, got %d", n)
+	}
+}
+
+func TestWorkerQueue_ConcurrentClose(t *testing.T) {
+	var closed atomic.Bool
+	var lateReads atomic.Int32
+	q := newWorkerQueue(func(key string) (string, error) {
+		if closed.Load() {
+			lateReads.Add(1)
+		}
+		return key, nil
+	}, 4, queueOptions{})
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				key := fmt.Sprintf("%d-%d", i, j)
+				value, err := q.Do(key)
+				if errors.Is(err, ErrWorkerQueueIsClosed) {
+					return
+				}
+				if err != nil || value != key {
+					t.Errorf("Do(%s) = %q, %v", key, value, err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	closed.Store(true)
+	q.Close()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do calls still wait after the queue was closed")
+	}
+	if n := lateReads.Load(); n != 0 {
+		t.Errorf("%d reads started after Close returned", n)
+	}
+}
+
+func BenchmarkWorkerQueue_Do(b *testing.B) {
+	q := newWorkerQueue(func(key string) (string, error) { return key, nil }, 4, queueOptions{})
+	defer q.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := q.Do("key"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWorkerQueue_OverloadPolicies(t *testing.T) {
+	for _, policy := range []OverloadPolicy{OverloadReject, OverloadTimeout, OverloadBlock} {
+		t.Run(string(policy), func(t *testing.T) {
+			release := make(chan struct{})
+			var calls atomic.Int32
+			q := newWorkerQueue(blockingWorker(release, &calls), 1, queueOptions{
+				maxPending: 1,
+				policy:     policy,
+				timeout:    20 * time.Millisecond,
+			})
+			defer q.Close()
+
+			// One read is being served and another one waits for the worker.
+			results := make(chan error, 2)
+			do := func(key string) {
+				_, err := q.Do(key)
+				results <- err
+			}
+			go do("served")
+			for calls.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			go do("pending")
+			for q.stats().Pending == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := q.DoCtx(ctx, "overflow")
+			expected := ErrOverloaded
+			if policy == OverloadBlock {
+				expected = context.DeadlineExceeded
+			}
+			if !errors.Is(err, expected) {
+				t.Errorf("expected %v, got %v", expected, err)
+			}
+			if stats := q.stats(); stats.Pending != 1 || (policy != OverloadBlock) != (stats.Rejected == 1) {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+
+			close(release)
+			for i := 0; i < 2; i++ {
+				if err := <-results; err != nil {
+					t.Error(err)
+				}
+			}
+			if stats := q.stats(); stats.Pending != 0 {
+				t.Errorf("expected no pending reads, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQueueOptions_Validate(t *testing.T) {
+	for _, opts := range []queueOptions{
+		{policy: "drop"},
+		{policy: OverloadTimeout},
+		{maxPending: -1},
+	} {
+		if opts.validate() == nil {
+			t.Errorf("expected %+v to be invalid", opts)
+		}
+	}
+}
+
+func TestWorkerQueue_RecoversPanics(t *testing.T) {
+	q := newWorkerQueue(func(key string) (string, error) {
+		if key == "bad" {
+			panic("corrupted entry")
+		}
+		return "value of " + key, nil
+	}, 2, queueOptions{})
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := q.Do("bad"); !errors.Is(err, ErrWorkerPanic) {
+			t.Fatalf("expected ErrWorkerPanic, got %v", err)
+		}
+	}
+	// The panicked workers were replaced, so reads are still served.
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := q.Do("good")
+			results <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reads hang after the workers panicked")
+		}
+	}
+	if stats := q.stats(); stats.Panics != 5 || stats.Workers != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWorkerQueue_KeyAffinity(t *testing.T) {
+	gates := map[string]chan struct{}{}
+	started := make(chan string, 3)
+	q := newWorkerQueue(func(key string) (string, error) {
+		started <- key
+		<-gates[key]
+		return key, nil
+	}, 4, queueOptions{keyAffinity: true})
+	defer q.Close()
+
+	other := "b"
+	for q.queueFor(other) == q.queueFor("a") {
+		other += "b"
+	}
+	gates["a"], gates[other] = make(chan struct{}), make(chan struct{})
+	defer close(gates[other])
+
+	results := make(chan error, 3)
+	do := func(key string) {
+		_, err := q.Do(key)
+		results <- err
+	}
+	go do("a")
+	if key := <-started; key != "a" {
+		t.Fatalf("unexpected read of %s", key)
+	}
+	// The second read of the key waits for the worker of the first one,
+	// while a key of another worker is read right away.
+	go do("a")
+	go do(other)
+	if key := <-started; key != other {
+		t.Fatalf("expected the read of %s to start first, got %s", other, key)
+	}
+	select {
+	case <-started:
+		t.Fatal("two reads of a key run at once")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(gates["a"])
+	if key := <-started; key != "a" {
+		t.Fatalf("unexpected read of %s", key)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+type recordingSink struct {
+	mu         sync.Mutex
+	waits, run []time.Duration
+}
+
+func (s *recordingSink) ObserveRead(wait, exec time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waits = append(s.waits, wait)
+	s.run = append(s.run, exec)
+}
+
+func TestWorkerQueue_Metrics(t *testing.T) {
+	sink := new(recordingSink)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	q := newWorkerQueue(blockingWorker(release, &calls), 1, queueOptions{metrics: sink})
+	defer q.Close()
+
+	results := make(chan error, 2)
+	go func() {
+		_, err := q.Do("first")
+		results <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := q.Do("second")
+		results <- err
+	}()
+	for q.stats().Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := q.stats(); stats.Active != 1 || stats.Pending != 1 || stats.Reads != 0 {
+		t.Errorf("unexpected stats while reading %+v", stats)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := q.stats(); stats.Active != 0 || stats.Pending != 0 || stats.Reads != 2 {
+		t.Errorf("unexpected stats after reading %+v", stats)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.waits) != 2 || sink.run[0] < 10*time.Millisecond || sink.waits[1] < 10*time.Millisecond {
+		t.Errorf("unexpected timings: waits %v, runs %v", sink.waits, sink.run)
+	}
+}
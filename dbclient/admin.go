@@ -0,0 +1,88 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// QueueStats describe the read queue of the db.
+type QueueStats struct {
+	Workers  int    `json:"workers"`
+	Active   int    `json:"active"`
+	Pending  int    `json:"pending"`
+	Reads    uint64 `json:"reads"`
+	Rejected uint64 `json:"rejected"`
+	Panics   uint64 `json:"panics"`
+}
+
+// Compaction is a run of the segment merging of the db.
+type Compaction struct {
+	Trigger        string        `json:"trigger"`
+	StartedAt      time.Time     `json:"startedAt"`
+	Duration       time.Duration `json:"duration"`
+	BytesBefore    int64         `json:"bytesBefore"`
+	BytesAfter     int64         `json:"bytesAfter"`
+	BytesReclaimed int64         `json:"bytesReclaimed"`
+	Running        bool          `json:"running"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// Compactions are the running compaction, if any, and the recent ones,
+// oldest first.
+type Compactions struct {
+	Current *Compaction  `json:"current"`
+	History []Compaction `json:"history"`
+}
+
+// Stats fetches the statistics of the read queue of the db.
+func (c *Client) Stats(ctx context.Context) (*QueueStats, error) {
+	stats := new(QueueStats)
+	if err := c.admin().getJSON(ctx, "stats", "/admin/stats", stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Compactions fetches the compaction history of the db.
+func (c *Client) Compactions(ctx context.Context) (*Compactions, error) {
+	res := new(Compactions)
+	if err := c.admin().getJSON(ctx, "compactions", "/admin/compactions", res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Compact merges the segments of the db, returning once it is done. The
+// db requires its admin token for it, sent by Options.Transport.
+func (c *Client) Compact(ctx context.Context) error {
+	return c.admin().do(ctx, "compact", "POST", "/admin/compactions", nil, nil, func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+			return statusError(res)
+		}
+		return nil
+	})
+}
+
+// admin is the client of the admin API, served at the root of the db
+// next to the /db API.
+func (c *Client) admin() *Client {
+	admin := *c
+	admin.base = strings.TrimSuffix(c.base, "/db")
+	return &admin
+}
+
+func (c *Client) getJSON(ctx context.Context, op, path string, res any) error {
+	return c.do(ctx, op, "GET", path, nil, nil, func(r *http.Response) error {
+		if r.StatusCode != http.StatusOK {
+			return statusError(r)
+		}
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			return fmt.Errorf("%w: invalid response: %s", ErrUnavailable, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,52 @@
+package dbclient
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker stops sending requests to the db after too many consecutive
+// failures. Once the cooldown passes a single request is let through:
+// its success closes the breaker, a failure opens it again.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return nil
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) record(ok bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// retryAfter is the time left until the breaker lets a request through.
+func (b *breaker) retryAfter(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.openUntil.Sub(now), 0)
+}
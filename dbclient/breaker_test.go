@@ -0,0 +1,39 @@
+package dbclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 2, cooldown: time.Second}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := b.allow(now); err != nil {
+			t.Fatalf("closed breaker rejected a request: %s", err)
+		}
+		b.record(false, now)
+	}
+	if err := b.allow(now.Add(500 * time.Millisecond)); err != ErrCircuitOpen {
+		t.Fatalf("expected the breaker to be open, got %v", err)
+	}
+
+	later := now.Add(2 * time.Second)
+	if err := b.allow(later); err != nil {
+		t.Fatalf("expected a probe after the cooldown, got %s", err)
+	}
+	if err := b.allow(later); err != ErrCircuitOpen {
+		t.Fatal("only one probe may be in flight")
+	}
+	b.record(false, later)
+	if err := b.allow(later.Add(500 * time.Millisecond)); err != ErrCircuitOpen {
+		t.Fatal("a failed probe must open the breaker again")
+	}
+
+	later = later.Add(2 * time.Second)
+	_ = b.allow(later)
+	b.record(true, later)
+	if err := b.allow(later); err != nil {
+		t.Fatalf("a successful probe must close the breaker, got %s", err)
+	}
+}
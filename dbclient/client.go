@@ -0,0 +1,316 @@
+// Package dbclient is a client of the HTTP API of cmd/db. Requests are
+// traced, timed out, retried on transient failures and guarded by a
+// circuit breaker; failures are reported with the errors below.
+package dbclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+var (
+	ErrNotFound    = errors.New("key not found")
+	ErrExists      = errors.New("key already exists")
+	ErrNotModified = errors.New("value not modified")
+	ErrTimeout     = errors.New("db did not respond in time")
+	ErrUnavailable = errors.New("db is unavailable")
+	ErrCircuitOpen = errors.New("db circuit breaker is open")
+)
+
+// StatusError is returned when the db rejects a request, e.g. with 422
+// Unprocessable Entity when the value breaks its validation rules.
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("db responded with %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("db responded with %d: %s", e.Status, e.Message)
+}
+
+// maxErrorMessage bounds the error messages read from the db.
+const maxErrorMessage = 1024
+
+type Options struct {
+	// Timeout bounds every attempt, 5 seconds if zero.
+	Timeout time.Duration
+	// Retries is the number of extra attempts after ErrTimeout or
+	// ErrUnavailable, waiting Backoff and twice as long after every
+	// attempt.
+	Retries int
+	Backoff time.Duration
+	// BreakerFailures consecutive failures open the circuit breaker for
+	// BreakerCooldown, 0 disables the breaker.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// Transport replaces the pooled default transport.
+	Transport http.RoundTripper
+}
+
+// Entry is a value stored in the db. ETag and LastModified are the
+// validators the db responded with, if any.
+type Entry struct {
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
+}
+
+type Client struct {
+	base    string
+	client  *http.Client
+	opts    Options
+	breaker *breaker
+}
+
+// New creates a client of the db API at base, e.g. http://db:5432/db. The
+// admin API is expected at the root of base, e.g. http://db:5432/admin.
+func New(base string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	transport := opts.Transport
+	if transport == nil {
+		// The client traces and retries the requests itself.
+		transport = httpclient.NewTransport(httpclient.Options{})
+	}
+	return &Client{
+		base:    strings.TrimSuffix(base, "/"),
+		client:  &http.Client{Timeout: opts.Timeout, Transport: transport},
+		opts:    opts,
+		breaker: &breaker{threshold: opts.BreakerFailures, cooldown: opts.BreakerCooldown},
+	}
+}
+
+type requestIdKey struct{}
+
+const requestIdHeader = "X-Request-Id"
+
+// WithRequestId makes the requests sent with ctx carry the request ID.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RetryAfter is the time left until the open circuit breaker lets a
+// request through.
+func (c *Client) RetryAfter() time.Duration {
+	return c.breaker.retryAfter(time.Now())
+}
+
+// Get fetches the value of key.
+func (c *Client) Get(ctx context.Context, key string) (*Entry, error) {
+	return c.get(ctx, key, nil)
+}
+
+// Revalidate fetches the value of prev.Key unless it has not changed
+// since prev, as told by its validators, in which case it returns
+// ErrNotModified.
+func (c *Client) Revalidate(ctx context.Context, prev *Entry) (*Entry, error) {
+	return c.get(ctx, prev.Key, func(h http.Header) {
+		if prev.ETag != "" {
+			h.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			h.Set("If-Modified-Since", prev.LastModified)
+		}
+	})
+}
+
+func (c *Client) get(ctx context.Context, key string, header func(http.Header)) (*Entry, error) {
+	var entry *Entry
+	err := c.do(ctx, "get", "GET", keyPath(key), nil, header, func(res *http.Response) error {
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotModified:
+			return ErrNotModified
+		default:
+			return statusError(res)
+		}
+		entry = new(Entry)
+		if err := json.NewDecoder(res.Body).Decode(entry); err != nil {
+			return fmt.Errorf("%w: invalid response: %s", ErrUnavailable, err)
+		}
+		entry.Key = key
+		entry.ETag = res.Header.Get("ETag")
+		entry.LastModified = res.Header.Get("Last-Modified")
+		return nil
+	})
+	return entry, err
+}
+
+// Put stores the value under key and reports whether the key is new.
+func (c *Client) Put(ctx context.Context, key, value string) (created bool, err error) {
+	body, _ := json.Marshal(Entry{Value: value})
+	err = c.do(ctx, "put", "PUT", keyPath(key), body, nil, func(res *http.Response) error {
+		switch res.StatusCode {
+		case http.StatusCreated:
+			created = true
+		case http.StatusOK, http.StatusNoContent:
+		default:
+			return statusError(res)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// Create stores the value under key only if the key does not exist yet,
+// returning ErrExists otherwise.
+func (c *Client) Create(ctx context.Context, key, value string) error {
+	body, _ := json.Marshal(Entry{Value: value})
+	return c.do(ctx, "create", "POST", keyPath(key), body, nil, func(res *http.Response) error {
+		switch res.StatusCode {
+		case http.StatusCreated, http.StatusOK, http.StatusNoContent:
+			return nil
+		case http.StatusConflict:
+			return ErrExists
+		}
+		return statusError(res)
+	})
+}
+
+// Delete removes key.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, "delete", "DELETE", keyPath(key), nil, nil, func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+			return statusError(res)
+		}
+		return nil
+	})
+}
+
+// KeyList is a page of keys, Truncated is set when more keys match.
+type KeyList struct {
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated"`
+}
+
+// Keys lists at most limit keys starting with prefix in ascending order,
+// the db's maximum if limit is 0.
+func (c *Client) Keys(ctx context.Context, prefix string, limit int) (*KeyList, error) {
+	return c.KeysAfter(ctx, prefix, "", limit)
+}
+
+// KeysAfter is like Keys but lists only the keys following after, which
+// pages through the keys when after is the last key of the previous page.
+func (c *Client) KeysAfter(ctx context.Context, prefix, after string, limit int) (*KeyList, error) {
+	query := url.Values{"prefix": {prefix}}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var list *KeyList
+	err := c.do(ctx, "list", "GET", "?"+query.Encode(), nil, nil, func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK {
+			return statusError(res)
+		}
+		list = new(KeyList)
+		if err := json.NewDecoder(res.Body).Decode(list); err != nil {
+			return fmt.Errorf("%w: invalid response: %s", ErrUnavailable, err)
+		}
+		return nil
+	})
+	return list, err
+}
+
+func keyPath(key string) string {
+	return "/" + url.PathEscape(key)
+}
+
+func statusError(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	message, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorMessage))
+	err := &StatusError{Status: res.StatusCode, Message: strings.TrimSpace(string(message))}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// retryable errors are the transient failures of the db.
+func retryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable)
+}
+
+// do sends the request, retrying it on transient failures, and hands
+// the response to handle.
+func (c *Client) do(ctx context.Context, op, method, path string, body []byte, header func(http.Header), handle func(*http.Response) error) error {
+	backoff := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, op, method, path, body, header, handle)
+		if !retryable(err) || attempt >= c.opts.Retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, op, method, path string, body []byte, header func(http.Header), handle func(*http.Response) error) error {
+	if err := c.breaker.allow(time.Now()); err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "db "+op, tracing.KindClient)
+	defer span.End()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if header != nil {
+		header(req.Header)
+	}
+	tracing.Inject(ctx, req.Header)
+	if id, ok := ctx.Value(requestIdKey{}).(string); ok && id != "" {
+		req.Header.Set(requestIdHeader, id)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.breaker.record(false, time.Now())
+		span.SetError(err)
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+			return fmt.Errorf("%w: %s", ErrTimeout, err)
+		}
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+	span.SetAttribute("http.status_code", res.StatusCode)
+	err = handle(res)
+	c.breaker.record(!retryable(err), time.Now())
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotModified) && !errors.Is(err, ErrExists) {
+		span.SetError(err)
+	}
+	return err
+}
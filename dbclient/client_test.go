@@ -0,0 +1,211 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	values := map[string]string{}
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(requestIdHeader); id != "req-1" {
+			t.Errorf("unexpected request ID %q", id)
+		}
+		if r.URL.Path == "/db" {
+			list := KeyList{Keys: []string{}}
+			for key := range values {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("after") {
+					list.Keys = append(list.Keys, key)
+				}
+			}
+			list.Truncated = r.URL.Query().Get("limit") == "1" && len(list.Keys) > 1
+			_ = json.NewEncoder(rw).Encode(list)
+			return
+		}
+		key := r.URL.Path[len("/db/"):]
+		switch r.Method {
+		case "GET":
+			v, ok := values[key]
+			if !ok {
+				http.Error(rw, "not found", http.StatusNotFound)
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"`+v+`"` {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+			rw.Header().Set("ETag", `"`+v+`"`)
+			_ = json.NewEncoder(rw).Encode(Entry{Key: key, Value: v})
+		case "POST":
+			if _, ok := values[key]; ok {
+				http.Error(rw, "exists", http.StatusConflict)
+				return
+			}
+			var e Entry
+			_ = json.NewDecoder(r.Body).Decode(&e)
+			values[key] = e.Value
+			rw.WriteHeader(http.StatusCreated)
+		case "PUT":
+			var e Entry
+			_ = json.NewDecoder(r.Body).Decode(&e)
+			if e.Value == "" {
+				http.Error(rw, "empty value", http.StatusUnprocessableEntity)
+				return
+			}
+			_, existed := values[key]
+			values[key] = e.Value
+			if !existed {
+				rw.WriteHeader(http.StatusCreated)
+			}
+		case "DELETE":
+			if _, ok := values[key]; !ok {
+				http.Error(rw, "not found", http.StatusNotFound)
+				return
+			}
+			delete(values, key)
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer db.Close()
+	c := New(db.URL+"/db/", Options{})
+	ctx := WithRequestId(context.Background(), "req-1")
+
+	if created, err := c.Put(ctx, "k", "v1"); err != nil || !created {
+		t.Fatalf("Put = %t, %v", created, err)
+	}
+	if created, err := c.Put(ctx, "k", "v2"); err != nil || created {
+		t.Fatalf("Put = %t, %v", created, err)
+	}
+	if err := c.Create(ctx, "k", "v3"); err != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if err := c.Create(ctx, "new", "v"); err != nil || values["new"] != "v" {
+		t.Errorf("Create = %v", err)
+	}
+	delete(values, "new")
+	var se *StatusError
+	if _, err := c.Put(ctx, "k", ""); !errors.As(err, &se) || se.Status != http.StatusUnprocessableEntity || se.Message != "empty value" {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+
+	entry, err := c.Get(ctx, "k")
+	if err != nil || entry.Value != "v2" || entry.ETag != `"v2"` {
+		t.Fatalf("Get = %+v, %v", entry, err)
+	}
+	if _, err := c.Revalidate(ctx, entry); err != ErrNotModified {
+		t.Errorf("expected ErrNotModified, got %v", err)
+	}
+
+	if list, err := c.Keys(ctx, "k", 0); err != nil || len(list.Keys) != 1 || list.Keys[0] != "k" || list.Truncated {
+		t.Errorf("Keys = %+v, %v", list, err)
+	}
+	if list, err := c.Keys(ctx, "x", 1); err != nil || len(list.Keys) != 0 {
+		t.Errorf("Keys = %+v, %v", list, err)
+	}
+	if list, err := c.KeysAfter(ctx, "k", "k", 0); err != nil || len(list.Keys) != 0 {
+		t.Errorf("KeysAfter = %+v, %v", list, err)
+	}
+
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "k"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClientRetriesAndTimeouts(t *testing.T) {
+	var attempts atomic.Int32
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch n := attempts.Add(1); {
+		case r.URL.Path == "/db/slow":
+			time.Sleep(100 * time.Millisecond)
+		case n == 1:
+			rw.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = rw.Write([]byte(`{"key":"k","value":"v"}`))
+		}
+	}))
+	defer db.Close()
+
+	c := New(db.URL+"/db", Options{Timeout: 20 * time.Millisecond, Retries: 1})
+	if entry, err := c.Get(context.Background(), "k"); err != nil || entry.Value != "v" {
+		t.Fatalf("expected the retry to succeed, got %+v, %v", entry, err)
+	}
+
+	attempts.Store(0)
+	if _, err := c.Get(context.Background(), "slow"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+
+	c = New(db.URL+"/db", Options{Timeout: 20 * time.Millisecond, BreakerFailures: 1, BreakerCooldown: time.Minute})
+	_, _ = c.Get(context.Background(), "slow")
+	if _, err := c.Get(context.Background(), "k"); err != ErrCircuitOpen {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if c.RetryAfter() <= 0 {
+		t.Error("expected the breaker to tell when to retry")
+	}
+}
+
+func TestClientAdmin(t *testing.T) {
+	compacted := false
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /admin/stats":
+			_ = json.NewEncoder(rw).Encode(QueueStats{Workers: 4, Reads: 7})
+		case "POST /admin/compactions":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(rw, "invalid token", http.StatusForbidden)
+				return
+			}
+			compacted = true
+			rw.WriteHeader(http.StatusNoContent)
+		case "GET /admin/compactions":
+			res := Compactions{History: []Compaction{}}
+			if compacted {
+				res.History = append(res.History, Compaction{Trigger: "manual", BytesBefore: 10, BytesAfter: 4, BytesReclaimed: 6})
+			}
+			_ = json.NewEncoder(rw).Encode(res)
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer db.Close()
+	c := New(db.URL+"/db", Options{})
+	ctx := context.Background()
+
+	if stats, err := c.Stats(ctx); err != nil || stats.Workers != 4 || stats.Reads != 7 {
+		t.Errorf("Stats = %+v, %v", stats, err)
+	}
+	var se *StatusError
+	if err := c.Compact(ctx); !errors.As(err, &se) || se.Status != http.StatusForbidden {
+		t.Errorf("expected the compaction forbidden, got %v", err)
+	}
+	c = New(db.URL+"/db", Options{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer secret")
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+	if err := c.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Compactions(ctx)
+	if err != nil || len(res.History) != 1 || res.History[0].BytesReclaimed != 6 {
+		t.Errorf("Compactions = %+v, %v", res, err)
+	}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
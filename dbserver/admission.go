@@ -0,0 +1,84 @@
+package dbserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+var admissionRejectedTotal = metrics.Default.NewCounter("db_admission_rejected_total",
+	"Requests rejected because the db was overloaded, by the exhausted resource.", "reason")
+
+const (
+	reasonWriteQueue = "write_queue"
+	reasonReadPool   = "read_pool"
+)
+
+// admissionOptions are the thresholds of the admission control, the zero
+// ones disable it.
+type admissionOptions struct {
+	// MaxPendingWrites sheds the writes arriving while so many wait for
+	// the writer.
+	MaxPendingWrites int
+	// MaxReadUtilization sheds the reads arriving while this share of the
+	// read workers is busy, between 0 and 1.
+	MaxReadUtilization float64
+	// RetryAfter is what the rejected clients are told to wait.
+	RetryAfter time.Duration
+}
+
+// admissionStats are the stats of the db the admission is decided on.
+type admissionStats interface {
+	ReadQueueStats() datastore.QueueStats
+	WriteQueueStats() datastore.WriteStats
+}
+
+// admission rejects the requests of the db API with 503 Service
+// Unavailable and Retry-After while the resource they need is saturated,
+// rather than queueing them past the timeouts of their clients. The
+// balancer takes such responses for backpressure and sends the following
+// requests elsewhere until Retry-After passes.
+func admission(db admissionStats, opts admissionOptions) httptools.Middleware {
+	retryAfter := strconv.Itoa(max(int((opts.RetryAfter+time.Second-1)/time.Second), 1))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if reason := overloaded(db, opts, r); reason != "" {
+				admissionRejectedTotal.Inc(reason)
+				rw.Header().Set("Retry-After", retryAfter)
+				http.Error(rw, fmt.Sprintf("db overloaded: %s saturated", reason), http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// overloaded returns the saturated resource the request needs, if any.
+// Only the requests of the db API are shed, the admin and health ones
+// must get through an overload.
+func overloaded(db admissionStats, opts admissionOptions, r *http.Request) string {
+	if r.URL.Path != "/db" && !strings.HasPrefix(r.URL.Path, "/db/") {
+		return ""
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if opts.MaxReadUtilization <= 0 {
+			return ""
+		}
+		stats := db.ReadQueueStats()
+		if stats.Workers > 0 && float64(stats.Active)/float64(stats.Workers) >= opts.MaxReadUtilization {
+			return reasonReadPool
+		}
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		if opts.MaxPendingWrites > 0 && db.WriteQueueStats().Pending >= opts.MaxPendingWrites {
+			return reasonWriteQueue
+		}
+	}
+	return ""
+}
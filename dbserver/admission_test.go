@@ -0,0 +1,48 @@
+package dbserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+)
+
+type fakeStats struct {
+	reads  datastore.QueueStats
+	writes datastore.WriteStats
+}
+
+func (s *fakeStats) ReadQueueStats() datastore.QueueStats  { return s.reads }
+func (s *fakeStats) WriteQueueStats() datastore.WriteStats { return s.writes }
+
+func TestAdmission(t *testing.T) {
+	stats := &fakeStats{reads: datastore.QueueStats{Workers: 10, Active: 8}, writes: datastore.WriteStats{Pending: 5}}
+	h := admission(stats, admissionOptions{MaxPendingWrites: 5, MaxReadUtilization: 0.9, RetryAfter: 1500 * time.Millisecond})(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	rec := serve("PUT", "/db/key")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected a write over the threshold shed with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("GET", "/db/key"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a read under the utilization threshold admitted, got %d", rec.Code)
+	}
+	stats.reads.Active = 9
+	if rec := serve("GET", "/db"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a read over the utilization threshold shed, got %d", rec.Code)
+	}
+	if rec := serve("GET", "/admin/stats"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the admin API admitted, got %d", rec.Code)
+	}
+	stats.writes.Pending = 4
+	if rec := serve("DELETE", "/db/key"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a write under the threshold admitted, got %d", rec.Code)
+	}
+}
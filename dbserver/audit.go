@@ -0,0 +1,238 @@
+package dbserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+const (
+	maxAuditEntries     = 1000
+	defaultAuditEntries = 100
+
+	// maxAuditScan bounds the tail of the log a query reads, the older
+	// entries are not queryable.
+	maxAuditScan   = 16 << 20
+	auditChunkSize = 64 << 10
+)
+
+// AuditEntry records a mutating call of the API: who made it, when, what
+// it changed and how it went. ForwardedFor is whatever the proxies of the
+// request claimed, RemoteAddr the peer the db saw.
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Op           string    `json:"op"`
+	Key          string    `json:"key,omitempty"`
+	Status       int       `json:"status"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	ForwardedFor string    `json:"forwardedFor,omitempty"`
+	RequestId    string    `json:"requestId,omitempty"`
+}
+
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// auditLog appends the entries to a file of JSON lines, apart from the
+// segments: it is neither compacted nor restored with them. The entries
+// are written as the calls complete, a failure to write one is logged
+// and does not fail the call.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+	// scanLimit is the size of the tail of the file the queries read.
+	scanLimit int64
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f, path: path, scanLimit: maxAuditScan}, nil
+}
+
+func (a *auditLog) append(e AuditEntry) {
+	line, _ := json.Marshal(e)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Cannot write the audit entry of %s %s: %s", e.Op, e.Key, err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// auditFilter selects the entries of a query, the zero fields match any.
+type auditFilter struct {
+	Key   string
+	Op    string
+	Since time.Time
+	Limit int
+}
+
+func (f auditFilter) match(e AuditEntry) bool {
+	return (f.Key == "" || e.Key == f.Key) && (f.Op == "" || e.Op == f.Op) && !e.Time.Before(f.Since)
+}
+
+// query returns the latest entries matching the filter, oldest first.
+// The file is read backwards from its end in chunks, until the limit of
+// entries or the scan limit is reached.
+func (a *auditLog) query(f auditFilter) ([]AuditEntry, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pos := info.Size()
+	start := max(pos-a.scanLimit, 0)
+	res := []AuditEntry{}
+	buf := make([]byte, auditChunkSize)
+	var first []byte
+	for pos > start && len(res) < f.Limit {
+		n := min(int64(len(buf)), pos-start)
+		pos -= n
+		if _, err := file.ReadAt(buf[:n], pos); err != nil {
+			return nil, err
+		}
+		lines := bytes.Split(append(buf[:n:n], first...), []byte{'\n'})
+		// The first line may start in the chunk before.
+		first = slices.Clone(lines[0])
+		res = f.collect(res, lines[1:])
+	}
+	if pos == 0 {
+		res = f.collect(res, [][]byte{first})
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// collect appends the entries of the lines matching the filter to res,
+// newest first, up to the limit.
+func (f auditFilter) collect(res []AuditEntry, lines [][]byte) []AuditEntry {
+	for i := len(lines) - 1; i >= 0 && len(res) < f.Limit; i-- {
+		var e AuditEntry
+		if err := json.Unmarshal(lines[i], &e); err != nil {
+			// The line is empty, cut by the scan limit or being written
+			// as the query reads it.
+			continue
+		}
+		if f.match(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// auditOp names the operation of a mutating call, empty for the others.
+func auditOp(r *http.Request) (op, key string) {
+	key = strings.TrimPrefix(r.URL.Path, "/db/")
+	if key == r.URL.Path {
+		if r.Method == http.MethodPost && r.URL.Path == "/admin/compactions" {
+			return "compact", ""
+		}
+		return "", ""
+	}
+	switch r.Method {
+	case http.MethodPost:
+		return "create", key
+	case http.MethodPut:
+		return "put", key
+	case http.MethodDelete:
+		return "delete", key
+	}
+	return "", ""
+}
+
+// middleware records the mutating calls passing through it.
+func (a *auditLog) middleware() httptools.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			op, key := auditOp(r)
+			if op == "" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			a.append(AuditEntry{
+				Time:         time.Now().UTC(),
+				Op:           op,
+				Key:          key,
+				Status:       rec.status,
+				RemoteAddr:   host,
+				ForwardedFor: r.Header.Get("X-Forwarded-For"),
+				RequestId:    r.Header.Get("X-Request-Id"),
+			})
+		})
+	}
+}
+
+// handler serves the queries of the log: GET /admin/audit with the key,
+// op, since (RFC 3339) and limit parameters.
+func (a *auditLog) handler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := auditFilter{Key: q.Get("key"), Op: q.Get("op"), Limit: defaultAuditEntries}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditEntries {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditEntries), http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if s := q.Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		f.Since = since
+	}
+	entries, err := a.query(f)
+	if err != nil {
+		log.Printf("Cannot read the audit log: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditLog{Entries: entries})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
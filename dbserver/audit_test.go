@@ -0,0 +1,118 @@
+package dbserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	audit, err := openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	h := audit.middleware()(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	call := func(method, path string) {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = "10.0.0.2:4567"
+		r.Header.Set("X-Forwarded-For", "192.0.2.1")
+		r.Header.Set("X-Request-Id", method+path)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	call("PUT", "/db/a")
+	call("GET", "/db/a")
+	call("POST", "/db/b")
+	call("PUT", "/db/a")
+	call("DELETE", "/db/c")
+	call("POST", "/admin/compactions")
+
+	query := func(params string) []AuditEntry {
+		rec := httptest.NewRecorder()
+		audit.handler(rec, httptest.NewRequest("GET", "/admin/audit?"+params, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unexpected status %d of %s", rec.Code, params)
+		}
+		var res AuditLog
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Entries
+	}
+	all := query("")
+	if len(all) != 5 {
+		t.Fatalf("Expected the 5 mutating calls, got %+v", all)
+	}
+	if e := all[0]; e.Op != "put" || e.Key != "a" || e.Status != http.StatusOK || e.RemoteAddr != "10.0.0.2" ||
+		e.ForwardedFor != "192.0.2.1" || e.RequestId != "PUT/db/a" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if e := all[3]; e.Op != "delete" || e.Status != http.StatusNotFound {
+		t.Errorf("Expected the failed delete recorded, got %+v", e)
+	}
+	if e := all[4]; e.Op != "compact" {
+		t.Errorf("Expected the compaction recorded, got %+v", e)
+	}
+
+	if entries := query("key=a&limit=1"); len(entries) != 1 || entries[0].RequestId != "PUT/db/a" || entries[0] != all[2] {
+		t.Errorf("Expected the latest change of a, got %+v", entries)
+	}
+	if entries := query("op=create"); len(entries) != 1 || entries[0].Key != "b" {
+		t.Errorf("Expected the create of b, got %+v", entries)
+	}
+	if entries := query("since=2999-01-01T00:00:00Z"); len(entries) != 0 {
+		t.Errorf("Expected no entries from the future, got %+v", entries)
+	}
+	rec := httptest.NewRecorder()
+	audit.handler(rec, httptest.NewRequest("GET", "/admin/audit?limit=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid limit rejected, got %d", rec.Code)
+	}
+}
+
+func TestAuditLog_Tail(t *testing.T) {
+	audit, err := openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	// Enough entries for the file to span several chunks.
+	for i := range 1000 {
+		audit.append(AuditEntry{Op: "put", Key: fmt.Sprintf("key-%04d", i), Status: http.StatusOK})
+	}
+
+	entries, err := audit.query(auditFilter{Limit: maxAuditEntries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1000 || entries[0].Key != "key-0000" || entries[999].Key != "key-0999" {
+		t.Fatalf("Expected all the entries in order, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Key != fmt.Sprintf("key-%04d", i) {
+			t.Fatalf("Unexpected entry %d: %+v", i, e)
+		}
+	}
+	if entries, _ := audit.query(auditFilter{Key: "key-0500", Limit: 10}); len(entries) != 1 {
+		t.Errorf("Expected the entry of key-0500 found, got %+v", entries)
+	}
+
+	// Only the tail of the file is read, the entry it cuts is skipped.
+	info, _ := os.Stat(audit.path)
+	audit.scanLimit = info.Size() / 10
+	entries, err = audit.query(auditFilter{Limit: maxAuditEntries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 90 || len(entries) > 100 || entries[len(entries)-1].Key != "key-0999" {
+		t.Errorf("Expected about the last 100 entries, got %d", len(entries))
+	}
+}
@@ -0,0 +1,53 @@
+package dbserver
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type corsOptions struct {
+	Origins []string
+	Methods []string
+	Headers []string
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+func (o corsOptions) allowOrigin(origin string) bool {
+	return slices.Contains(o.Origins, "*") || slices.Contains(o.Origins, origin)
+}
+
+func cors(options corsOptions, next http.Handler) http.Handler {
+	if len(options.Origins) == 0 {
+		return next
+	}
+	methods := strings.Join(options.Methods, ", ")
+	headers := strings.Join(options.Headers, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !options.allowOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package dbserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCors(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	handler := cors(corsOptions{
+		Origins: []string{"http://app.example"},
+		Methods: splitList("GET, PUT"),
+		Headers: splitList("Content-Type"),
+	}, next)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		r := httptest.NewRequest("OPTIONS", "/db/key", nil)
+		r.Header.Set("Origin", "http://app.example")
+		r.Header.Set("Access-Control-Request-Method", "PUT")
+		rw := serve(r)
+		if rw.Code != http.StatusNoContent {
+			t.Errorf("Expected status %d, got %d", http.StatusNoContent, rw.Code)
+		}
+		for name, expected := range map[string]string{
+			"Access-Control-Allow-Origin":  "http://app.example",
+			"Access-Control-Allow-Methods": "GET, PUT",
+			"Access-Control-Allow-Headers": "Content-Type",
+			"Vary":                         "Origin",
+		} {
+			if value := rw.Header().Get(name); value != expected {
+				t.Errorf("Expected %s to be %q, got %q", name, expected, value)
+			}
+		}
+	})
+
+	t.Run("allowed origin", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/db/key", nil)
+		r.Header.Set("Origin", "http://app.example")
+		rw := serve(r)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, rw.Code)
+		}
+		if value := rw.Header().Get("Access-Control-Allow-Origin"); value != "http://app.example" {
+			t.Errorf("Expected the origin to be allowed, got %q", value)
+		}
+		if value := rw.Header().Get("Access-Control-Allow-Methods"); value != "" {
+			t.Errorf("Expected no allowed methods outside of a preflight, got %q", value)
+		}
+	})
+
+	t.Run("rejected origin", func(t *testing.T) {
+		r := httptest.NewRequest("OPTIONS", "/db/key", nil)
+		r.Header.Set("Origin", "http://evil.example")
+		r.Header.Set("Access-Control-Request-Method", "PUT")
+		rw := serve(r)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Expected the request to reach the handler, got %d", rw.Code)
+		}
+		for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods"} {
+			if value := rw.Header().Get(name); value != "" {
+				t.Errorf("Expected no %s, got %q", name, value)
+			}
+		}
+	})
+}
@@ -0,0 +1,437 @@
+// Package dbserver serves the datastore over HTTP, as a single node or a
+// router sharding the keys among nodes. It is run by cmd/db and, in
+// process, by the integration tests.
+package dbserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/chaos"
+	"github.com/roman-mazur/architecture-practice-4-template/config"
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+)
+
+const maxListedKeys = 1000
+
+// flags are the settings of the db, parsed from the command line by Main
+// and from the arguments by Serve.
+var flags = flag.NewFlagSet("db", flag.ContinueOnError)
+
+var options = config.Options{EnvPrefix: "DB_", Secrets: []string{"admin-token"}, Validate: validateFlags}
+
+var (
+	port = flags.Int("port", 5432, "db port")
+	dir  = flags.String("dir", ".db", "directory of the db segments")
+
+	shutdownTimeout = flags.Duration("shutdown-timeout", 10*time.Second, "how long in-flight requests may take to finish after SIGTERM")
+
+	segmentSize = flags.Int64("segment-size", 10*1024*1024, "size in bytes a segment is closed at and a new one started")
+	readWorkers = flags.Int("read-workers", 1000, "workers reading the values of the keys")
+
+	corsOrigins = flags.String("cors-origins", "", "comma-separated list of allowed CORS origins (\"*\" allows any, empty disables CORS)")
+	corsMethods = flags.String("cors-methods", "GET,POST,PUT,DELETE,OPTIONS", "comma-separated list of allowed CORS methods")
+	corsHeaders = flags.String("cors-headers", "Content-Type", "comma-separated list of allowed CORS request headers")
+
+	compactionInterval = flags.Duration("compaction-interval", 0, "interval between automatic segment compactions (0 disables them)")
+
+	adminToken = flags.String("admin-token", "", "bearer token required by POST /admin/compactions and GET /admin/audit (empty disables them)")
+
+	validationRules = flags.String("validation-rules", "", "path to a JSON file with per key prefix value validation rules")
+
+	maxPendingReads = flags.Int("max-pending-reads", 0, "reads that may wait for a free worker (0 leaves them unbounded)")
+	overloadPolicy  = flags.String("overload-policy", string(datastore.OverloadBlock), "what happens to reads beyond -max-pending-reads: block, reject or timeout")
+	overloadTimeout = flags.Duration("overload-timeout", time.Second, "how long reads beyond -max-pending-reads wait with the timeout policy")
+	readKeyAffinity = flags.Bool("read-key-affinity", false, "serve all reads of a key by the same worker, in the order they arrive")
+
+	maxPendingWrites   = flags.Int("admission-max-pending-writes", 0, "writes waiting for the writer at which new ones are rejected with 503 (0 disables it)")
+	maxReadUtilization = flags.Float64("admission-max-read-utilization", 0, "share of busy read workers, up to 1, at which new reads are rejected with 503 (0 disables it)")
+	admissionRetry     = flags.Duration("admission-retry-after", time.Second, "Retry-After of the requests rejected by the admission control")
+
+	auditLogPath = flags.String("audit-log", "", "file every mutating call is recorded in as JSON lines, its last 16 MiB queryable at /admin/audit with -admin-token (empty disables the audit)")
+
+	cacheSize   = flags.Int("cache-size", 0, "values of the recently read keys kept in memory (0 disables the read cache)")
+	primeKeys   = flags.String("prime-keys", "", "comma-separated keys, or @file with a key per line, loaded into the read cache at startup")
+	primeRecent = flags.Int("prime-recent", 0, "number of the most recently written keys loaded into the read cache at startup")
+
+	shards       = flags.String("shards", "", "comma-separated base URLs of db nodes, e.g. http://db-1:5432, this instance then routes the keys to them by consistent hash instead of storing them")
+	shardVnodes  = flags.Int("shard-vnodes", 128, "points of every shard on the hash ring, more spread the keys more evenly")
+	shardTimeout = flags.Duration("shard-timeout", 5*time.Second, "how long the router waits for a shard to respond")
+
+	otlpEndpoint = flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector spans are exported to (empty disables the export)")
+	chaosConfig  = flags.String("chaos-config", os.Getenv("CHAOS_CONFIG"), "JSON file with the faults injected into the requests for resilience tests (empty disables them)")
+)
+
+type Result struct {
+	Key      string  `json:"key"`
+	Value    string  `json:"value"`
+	Previous *string `json:"previous,omitempty"`
+}
+
+type KeyList struct {
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated"`
+}
+
+type Compactions struct {
+	Current *datastore.Compaction  `json:"current"`
+	History []datastore.Compaction `json:"history"`
+}
+
+func validateFlags() error {
+	switch {
+	case *port < 1 || *port > 65535:
+		return fmt.Errorf("invalid port %d", *port)
+	case *segmentSize <= 0:
+		return errors.New("segment size must be positive")
+	case *readWorkers <= 0:
+		return errors.New("read workers must be positive")
+	case *cacheSize < 0 || *primeRecent < 0:
+		return errors.New("cache size and prime recent cannot be negative")
+	case *cacheSize == 0 && (*primeKeys != "" || *primeRecent > 0):
+		return errors.New("priming needs the read cache, set -cache-size")
+	case *maxPendingWrites < 0 || *maxReadUtilization < 0 || *maxReadUtilization > 1:
+		return errors.New("admission thresholds must be non-negative and the read utilization at most 1")
+	case *shardVnodes <= 0 || *shardTimeout <= 0:
+		return errors.New("shard vnodes and timeout must be positive")
+	}
+	return nil
+}
+
+// Main runs the db configured by the command line until SIGINT or
+// SIGTERM.
+func Main() {
+	config.ParseSet(flags, options)
+	if err := run(signal.TerminationContext(), nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serve runs the db configured by args until ctx is done, serving on l
+// instead of -port, e.g. in-process in tests. The arguments are parsed
+// into the flags of the package, so Serve is called once per process.
+func Serve(ctx context.Context, args []string, l net.Listener) error {
+	if _, err := config.Load(flags, args, options); err != nil {
+		return err
+	}
+	return run(ctx, l)
+}
+
+func run(ctx context.Context, l net.Listener) error {
+	tracing.Configure("db", *otlpEndpoint)
+	metrics.Configure("db")
+	chaosRules, err := chaos.Load(*chaosConfig)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /version", version.Handler())
+	mux.Handle("GET /metrics", metrics.Default)
+
+	if nodes := splitList(*shards); len(nodes) > 0 {
+		rt, err := newRouter(nodes, *shardVnodes, *shardTimeout)
+		if err != nil {
+			return err
+		}
+		rt.register(mux)
+		log.Printf("Routing the keys to %d shards", len(nodes))
+		serve(ctx, l, mux, chaosRules, nil)
+		return nil
+	}
+
+	db, err := datastore.NewDb(*dir, datastore.DbOptions{
+		MaxSegmentSize:  *segmentSize,
+		WorkerPoolSize:  *readWorkers,
+		MaxPendingReads: *maxPendingReads,
+		OverloadPolicy:  datastore.OverloadPolicy(*overloadPolicy),
+		OverloadTimeout: *overloadTimeout,
+		ReadKeyAffinity: *readKeyAffinity,
+		Metrics:         queueMetrics{},
+		CacheSize:       *cacheSize,
+	})
+	if err != nil {
+		return err
+	}
+	registerQueueGauges(db.ReadQueueStats)
+	registerCacheGauges(db.CacheStats)
+	registerWriteGauges(db.WriteQueueStats)
+
+	keys, err := loadPrimeKeys(*primeKeys)
+	if err != nil {
+		return err
+	}
+	prime(db, keys, *primeRecent)
+
+	v, err := loadValidator(*validationRules)
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("GET /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		value, err := db.GetCtx(r.Context(), key)
+		switch {
+		case errors.Is(err, datastore.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, datastore.ErrOverloaded):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Result{
+			Key:   key,
+			Value: value,
+		})
+	})
+
+	// POST creates a new record and fails with 409 Conflict if the key already exists.
+	mux.HandleFunc("POST /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		var result Result
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err := v.Validate(key, result.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		switch err := db.Create(key, result.Value); err {
+		case nil:
+			w.WriteHeader(http.StatusCreated)
+		case datastore.ErrExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	})
+
+	// PUT is an idempotent upsert: 201 Created for a new key, 200 OK for an overwrite.
+	// With ?previous=true the response body contains the overwritten value.
+	mux.HandleFunc("PUT /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		var result Result
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err := v.Validate(key, result.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		// The previous value is read under the write lock, so only when
+		// it is asked for.
+		withPrev := r.URL.Query().Get("previous") == "true"
+		var (
+			prev    string
+			existed bool
+			err     error
+		)
+		if withPrev {
+			prev, existed, err = db.Upsert(key, result.Value)
+		} else {
+			existed, err = db.Store(key, result.Value)
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusCreated
+		if existed {
+			status = http.StatusOK
+		}
+		if !withPrev {
+			w.WriteHeader(status)
+			return
+		}
+		res := Result{Key: key, Value: result.Value}
+		if existed {
+			res.Previous = &prev
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// DELETE responds with 204 No Content, or 404 Not Found for a missing key.
+	mux.HandleFunc("DELETE /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		switch err := db.Delete(r.PathValue("key")); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case datastore.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	})
+
+	// GET /db lists the keys with the prefix in ascending order, at most
+	// limit of them. Truncated is set when more keys match. With after only
+	// the keys following it are listed, to page through all the keys.
+	mux.HandleFunc("GET /db", func(w http.ResponseWriter, r *http.Request) {
+		limit := maxListedKeys
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxListedKeys {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListedKeys), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		keys, err := db.Keys(r.URL.Query().Get("prefix"))
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if after := r.URL.Query().Get("after"); after != "" {
+			i, found := slices.BinarySearch(keys, after)
+			if found {
+				i++
+			}
+			keys = keys[i:]
+		}
+		res := KeyList{Keys: keys}
+		if len(keys) > limit {
+			res.Keys, res.Truncated = keys[:limit], true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// /health tells that the db is up, e.g. to cmd/status.
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("GET /admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.ReadQueueStats())
+	})
+
+	mux.HandleFunc("GET /admin/stats/writes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.WriteQueueStats())
+	})
+
+	mux.HandleFunc("GET /admin/cache", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.CacheStats())
+	})
+
+	mux.HandleFunc("GET /admin/compactions", func(w http.ResponseWriter, r *http.Request) {
+		current, history := db.Compactions()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Compactions{
+			Current: current,
+			History: history,
+		})
+	})
+
+	if *adminToken != "" {
+		mux.Handle("POST /admin/compactions", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+			if err := db.Compact(datastore.TriggerManual); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+
+	if *compactionInterval > 0 {
+		go func() {
+			for range time.Tick(*compactionInterval) {
+				if err := db.Compact(datastore.TriggerScheduled); err != nil {
+					log.Printf("Scheduled compaction failed: %s", err)
+				}
+			}
+		}()
+	}
+
+	closeAll := db.Close
+	var extra []httptools.Middleware
+	if *auditLogPath != "" {
+		audit, err := openAuditLog(*auditLogPath)
+		if err != nil {
+			return err
+		}
+		if *adminToken != "" {
+			mux.Handle("GET /admin/audit", adminOnly(audit.handler))
+		}
+		// The rejected calls are recorded too.
+		extra = append(extra, audit.middleware())
+		closeAll = func() error { return errors.Join(db.Close(), audit.Close()) }
+	}
+	extra = append(extra, admission(db, admissionOptions{
+		MaxPendingWrites:   *maxPendingWrites,
+		MaxReadUtilization: *maxReadUtilization,
+		RetryAfter:         *admissionRetry,
+	}))
+	serve(ctx, l, mux, chaosRules, closeAll, extra...)
+	return nil
+}
+
+// adminOnly lets through only the requests with the admin token.
+func adminOnly(h http.HandlerFunc) http.Handler {
+	return httptools.BearerAuth("db-admin", []string{*adminToken}, nil)(h)
+}
+
+// serve runs the API of the mux until ctx is done, then lets the
+// in-flight requests finish and closes what it served, if anything. The
+// extra middleware runs after the common one.
+func serve(ctx context.Context, l net.Listener, mux *http.ServeMux, chaosRules chaos.Config, closeDb func() error, extra ...httptools.Middleware) {
+	middleware := []httptools.Middleware{
+		func(next http.Handler) http.Handler {
+			return cors(corsOptions{
+				Origins: splitList(*corsOrigins),
+				Methods: splitList(*corsMethods),
+				Headers: splitList(*corsHeaders),
+			}, next)
+		},
+		tracing.Middleware("db"),
+		httptools.Recover(),
+		httptools.Metrics(metrics.Default, httptools.MuxRoute(mux)),
+		chaos.Middleware(chaosRules),
+	}
+	server := httptools.CreateServerWith(*port, mux, httptools.Options{Middleware: append(middleware, extra...)})
+	if l != nil {
+		server = httptools.WithListener(server, l)
+	}
+	server.Start()
+	<-ctx.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("In-flight requests did not finish: %s", err)
+	}
+	if closeDb == nil {
+		return
+	}
+	if err := closeDb(); err != nil {
+		log.Printf("Cannot close the db: %s", err)
+	}
+}
@@ -0,0 +1,72 @@
+package dbserver
+
+import (
+	"time"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+// readBuckets reach below the default ones, reads of a warm page cache
+// take microseconds.
+var readBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+var (
+	readWaitSeconds = metrics.Default.NewHistogram("db_read_wait_seconds",
+		"Time reads waited for a free worker.", readBuckets)
+	readDurationSeconds = metrics.Default.NewHistogram("db_read_duration_seconds",
+		"Time workers took to read a value.", readBuckets)
+)
+
+// queueMetrics records the timings of the reads of the db.
+type queueMetrics struct{}
+
+func (queueMetrics) ObserveRead(wait, exec time.Duration) {
+	readWaitSeconds.Observe(wait.Seconds())
+	readDurationSeconds.Observe(exec.Seconds())
+}
+
+// registerQueueGauges exposes the state of the read queue, sampled when
+// the metrics are scraped.
+func registerQueueGauges(stats func() datastore.QueueStats) {
+	gauge := func(name, help string, value func(datastore.QueueStats) float64) {
+		metrics.Default.NewGaugeFunc(name, help, nil, func(emit func(float64, ...string)) {
+			emit(value(stats()))
+		})
+	}
+	gauge("db_read_workers", "Workers serving reads.",
+		func(s datastore.QueueStats) float64 { return float64(s.Workers) })
+	gauge("db_read_workers_active", "Workers busy reading.",
+		func(s datastore.QueueStats) float64 { return float64(s.Active) })
+	gauge("db_read_queue_depth", "Reads waiting for a free worker.",
+		func(s datastore.QueueStats) float64 { return float64(s.Pending) })
+	gauge("db_reads", "Reads served since the start.",
+		func(s datastore.QueueStats) float64 { return float64(s.Reads) })
+	gauge("db_reads_rejected", "Reads rejected by the overload policy since the start.",
+		func(s datastore.QueueStats) float64 { return float64(s.Rejected) })
+	gauge("db_read_worker_panics", "Reads that made a worker panic since the start.",
+		func(s datastore.QueueStats) float64 { return float64(s.Panics) })
+}
+
+// registerCacheGauges exposes the state of the read cache.
+func registerCacheGauges(stats func() datastore.CacheStats) {
+	gauge := func(name, help string, value func(datastore.CacheStats) float64) {
+		metrics.Default.NewGaugeFunc(name, help, nil, func(emit func(float64, ...string)) {
+			emit(value(stats()))
+		})
+	}
+	gauge("db_cache_entries", "Values kept in the read cache.",
+		func(s datastore.CacheStats) float64 { return float64(s.Entries) })
+	gauge("db_cache_hits", "Reads served from the read cache since the start.",
+		func(s datastore.CacheStats) float64 { return float64(s.Hits) })
+	gauge("db_cache_misses", "Reads of the segments the read cache missed since the start.",
+		func(s datastore.CacheStats) float64 { return float64(s.Misses) })
+}
+
+// registerWriteGauges exposes the state of the write queue.
+func registerWriteGauges(stats func() datastore.WriteStats) {
+	metrics.Default.NewGaugeFunc("db_write_queue_depth", "Writes waiting for the writer or being written.", nil,
+		func(emit func(float64, ...string)) { emit(float64(stats().Pending)) })
+	metrics.Default.NewGaugeFunc("db_writes", "Writes served since the start.", nil,
+		func(emit func(float64, ...string)) { emit(float64(stats().Writes)) })
+}
@@ -0,0 +1,54 @@
+package dbserver
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	datastore "github.com/roman-mazur/architecture-practice-4-template/db/datastore"
+)
+
+// loadPrimeKeys parses -prime-keys: a comma-separated list of keys, or
+// @file naming a file with a key per line.
+func loadPrimeKeys(s string) ([]string, error) {
+	filename, ok := strings.CutPrefix(s, "@")
+	if !ok {
+		return splitList(s), nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		if key := strings.TrimSpace(lines.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, lines.Err()
+}
+
+// prime warms the read cache up before the db serves: the configured keys
+// first, then the most recently written ones. It only logs the failures,
+// a cold cache is slower but still correct.
+func prime(db *datastore.Db, keys []string, recent int) {
+	if len(keys) == 0 && recent == 0 {
+		return
+	}
+	started := time.Now()
+	for _, key := range db.RecentKeys(recent) {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	n, err := db.Prime(keys)
+	if err != nil {
+		log.Printf("Priming the read cache failed: %s", err)
+	}
+	log.Printf("Primed the read cache with %d values in %s", n, time.Since(started).Round(time.Millisecond))
+}
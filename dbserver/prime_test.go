@@ -0,0 +1,34 @@
+package dbserver
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadPrimeKeys(t *testing.T) {
+	keys, err := loadPrimeKeys("k1, k2")
+	if err != nil || !slices.Equal(keys, []string{"k1", "k2"}) {
+		t.Errorf("Expected [k1 k2], got %v: %v", keys, err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(filename, []byte("k1\n\n  k2  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = loadPrimeKeys("@" + filename)
+	if err != nil || !slices.Equal(keys, []string{"k1", "k2"}) {
+		t.Errorf("Expected [k1 k2], got %v: %v", keys, err)
+	}
+
+	// A line the scanner cannot read fails the whole list instead of
+	// priming only the keys before it.
+	if err := os.WriteFile(filename, []byte("k1\n"+strings.Repeat("x", 1<<17)+"\nk2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = loadPrimeKeys("@" + filename); err == nil {
+		t.Errorf("Expected an error for an over-long line, got %v", keys)
+	}
+}
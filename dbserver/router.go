@@ -0,0 +1,221 @@
+package dbserver
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/httpclient"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+// ring assigns the keys to the nodes by consistent hashing: every node
+// owns the arcs ending at its virtual points, so adding or removing a node
+// only moves the keys of its arcs, about 1/N of them.
+type ring struct {
+	points []uint64
+	owners []int
+	nodes  []string
+}
+
+func newRing(nodes []string, vnodes int) *ring {
+	r := &ring{nodes: nodes}
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	points := make([]point, 0, len(nodes)*vnodes)
+	for i, node := range nodes {
+		for v := range vnodes {
+			points = append(points, point{hashOf(node + "#" + strconv.Itoa(v)), i})
+		}
+	}
+	slices.SortFunc(points, func(a, b point) int { return cmp.Compare(a.hash, b.hash) })
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+func hashOf(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// node returns the index of the node owning key.
+func (r *ring) node(key string) int {
+	i, _ := slices.BinarySearch(r.points, hashOf(key))
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+var routedTotal = metrics.Default.NewCounter("db_router_requests_total",
+	"Requests the router forwarded to the shards.", "shard", metrics.LabelMethod)
+
+// router serves the db API from the shards, each key from the node its
+// hash picks. It keeps no state: the data is only where the ring put it,
+// so changing the shards moves keys the router no longer finds on their
+// old node until they are copied, e.g. with a dbctl backup of every old
+// node restored through the router.
+type router struct {
+	ring    *ring
+	proxies []*httputil.ReverseProxy
+	clients []*dbclient.Client
+	health  *http.Client
+}
+
+func newRouter(nodes []string, vnodes int, timeout time.Duration) (*router, error) {
+	rt := &router{ring: newRing(nodes, vnodes), health: httpclient.New(httpclient.Options{Timeout: 2 * time.Second})}
+	transport := httpclient.Wrap(httpclient.NewTransport(httpclient.Options{ResponseHeaderTimeout: timeout}),
+		httpclient.Options{SpanName: "shard"})
+	for _, node := range nodes {
+		target, err := url.Parse(node)
+		if err != nil || target.Host == "" {
+			return nil, fmt.Errorf("invalid shard %q, expected a base URL like http://db-1:5432", node)
+		}
+		rt.proxies = append(rt.proxies, &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+			},
+			Transport: transport,
+			ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
+				log.Printf("Shard %s failed to serve %s %s: %s", node, r.Method, r.URL.Path, err)
+				http.Error(rw, "Bad Gateway", http.StatusBadGateway)
+			},
+		})
+		rt.clients = append(rt.clients, dbclient.New(strings.TrimSuffix(node, "/")+"/db", dbclient.Options{
+			Timeout: timeout,
+			Retries: 1,
+		}))
+	}
+	return rt, nil
+}
+
+// register adds the routes of the db API to mux.
+func (rt *router) register(mux *http.ServeMux) {
+	mux.HandleFunc("/db/{key}", rt.forward)
+	mux.HandleFunc("GET /db", rt.list)
+	mux.HandleFunc("GET /admin/shards", rt.shards)
+	// The router is healthy as long as it runs, the shards are checked
+	// on their own.
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+}
+
+func (rt *router) forward(w http.ResponseWriter, r *http.Request) {
+	i := rt.ring.node(r.PathValue("key"))
+	routedTotal.Inc(rt.ring.nodes[i], r.Method)
+	rt.proxies[i].ServeHTTP(w, r)
+}
+
+// list merges the keys of every shard. Each shard lists up to limit keys
+// after the cursor, so the first limit keys of the merge are the first
+// limit keys of all of them.
+func (rt *router) list(w http.ResponseWriter, r *http.Request) {
+	limit := maxListedKeys
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListedKeys {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListedKeys), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	prefix, after := r.URL.Query().Get("prefix"), r.URL.Query().Get("after")
+
+	type page struct {
+		list *dbclient.KeyList
+		err  error
+	}
+	pages := make(chan page, len(rt.clients))
+	for _, c := range rt.clients {
+		go func() {
+			list, err := c.KeysAfter(r.Context(), prefix, after, limit)
+			pages <- page{list, err}
+		}()
+	}
+	res := KeyList{Keys: []string{}}
+	for range rt.clients {
+		p := <-pages
+		if p.err != nil {
+			log.Printf("Listing the keys of a shard failed: %s", p.err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		res.Keys = append(res.Keys, p.list.Keys...)
+		res.Truncated = res.Truncated || p.list.Truncated
+	}
+	slices.Sort(res.Keys)
+	if len(res.Keys) > limit {
+		res.Keys, res.Truncated = res.Keys[:limit], true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type ShardStatus struct {
+	Node    string `json:"node"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Shards struct {
+	Shards []ShardStatus `json:"shards"`
+	// Node owns the key of the request, if one was given.
+	Node string `json:"node,omitempty"`
+}
+
+// shards reports the shards and whether they respond, with ?key= also the
+// shard owning the key.
+func (rt *router) shards(w http.ResponseWriter, r *http.Request) {
+	res := Shards{Shards: make([]ShardStatus, len(rt.clients))}
+	done := make(chan struct{})
+	for i := range rt.ring.nodes {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			res.Shards[i] = rt.check(r.Context(), rt.ring.nodes[i])
+		}()
+	}
+	for range rt.ring.nodes {
+		<-done
+	}
+	if r.URL.Query().Has("key") {
+		res.Node = rt.ring.nodes[rt.ring.node(r.URL.Query().Get("key"))]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (rt *router) check(ctx context.Context, node string) ShardStatus {
+	status := ShardStatus{Node: node}
+	req, _ := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(node, "/")+"/health", nil)
+	resp, err := rt.health.Do(req)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		status.Error = resp.Status
+		return status
+	}
+	status.Healthy = true
+	return status
+}
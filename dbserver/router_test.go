@@ -0,0 +1,147 @@
+package dbserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeShard stores the values in memory, serving the part of the db API
+// the router uses.
+func fakeShard(t *testing.T) (*httptest.Server, map[string]string) {
+	var mu sync.Mutex
+	values := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		var res Result
+		_ = json.NewDecoder(r.Body).Decode(&res)
+		mu.Lock()
+		values[r.PathValue("key")] = res.Value
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /db/{key}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		value, ok := values[r.PathValue("key")]
+		mu.Unlock()
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Result{Key: r.PathValue("key"), Value: value})
+	})
+	mux.HandleFunc("GET /db", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys := []string{}
+		for key := range values {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("after") {
+				keys = append(keys, key)
+			}
+		}
+		mu.Unlock()
+		slices.Sort(keys)
+		var limit int
+		fmt.Sscan(r.URL.Query().Get("limit"), &limit)
+		res := KeyList{Keys: keys}
+		if len(keys) > limit {
+			res.Keys, res.Truncated = keys[:limit], true
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, values
+}
+
+func TestRouter(t *testing.T) {
+	var nodes []string
+	var stores []map[string]string
+	for range 3 {
+		srv, values := fakeShard(t)
+		nodes = append(nodes, srv.URL)
+		stores = append(stores, values)
+	}
+	rt, err := newRouter(nodes, 64, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	rt.register(mux)
+	router := httptest.NewServer(mux)
+	defer router.Close()
+
+	for i := range 30 {
+		key := fmt.Sprintf("key-%02d", i)
+		req, _ := http.NewRequest("PUT", router.URL+"/db/"+key, strings.NewReader(`{"value":"v"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Unexpected status %d putting %s", resp.StatusCode, key)
+		}
+		if _, ok := stores[rt.ring.node(key)][key]; !ok {
+			t.Errorf("Expected %s stored on its shard", key)
+		}
+	}
+	total := 0
+	for i, values := range stores {
+		if len(values) == 0 {
+			t.Errorf("Expected shard %d to get some of the keys", i)
+		}
+		total += len(values)
+	}
+	if total != 30 {
+		t.Errorf("Expected every key stored once, got %d", total)
+	}
+
+	resp, err := http.Get(router.URL + "/db/key-07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Result
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if res.Value != "v" {
+		t.Errorf("Unexpected value %+v", res)
+	}
+
+	resp, err = http.Get(router.URL + "/db?prefix=key-1&after=key-12&limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list KeyList
+	_ = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if got := strings.Join(list.Keys, ","); got != "key-13,key-14,key-15,key-16,key-17" || !list.Truncated {
+		t.Errorf("Unexpected merged page %+v", list)
+	}
+}
+
+func TestRing_Rebalance(t *testing.T) {
+	nodes := []string{"http://db-1", "http://db-2", "http://db-3"}
+	before := newRing(nodes, 128)
+	after := newRing(append(nodes, "http://db-4"), 128)
+	moved := 0
+	for i := range 10000 {
+		key := fmt.Sprintf("key-%d", i)
+		if b, a := before.node(key), after.node(key); b != a {
+			moved++
+			if a != 3 {
+				t.Fatalf("Expected %s to move only to the new node, it moved from %d to %d", key, b, a)
+			}
+		}
+	}
+	// A quarter of the keys is the share of the new node.
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("Expected about a quarter of the keys to move, %d did", moved)
+	}
+}
@@ -0,0 +1,172 @@
+package dbserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// validationRule restricts values written under keys starting with Prefix.
+// Either Pattern (a regular expression matched against the raw value) or
+// Schema (a JSON schema subset the value must satisfy) may be set, or both.
+type validationRule struct {
+	Prefix  string      `json:"prefix"`
+	Pattern string      `json:"regex,omitempty"`
+	Schema  *jsonSchema `json:"schema,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// jsonSchema supports the commonly used subset of JSON schema keywords.
+type jsonSchema struct {
+	Type       string                 `json:"type,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+	Enum       []any                  `json:"enum,omitempty"`
+	MinLength  *int                   `json:"minLength,omitempty"`
+	MaxLength  *int                   `json:"maxLength,omitempty"`
+	Minimum    *float64               `json:"minimum,omitempty"`
+	Maximum    *float64               `json:"maximum,omitempty"`
+}
+
+type validator struct {
+	rules []validationRule
+}
+
+func loadValidator(filename string) (*validator, error) {
+	v := new(validator)
+	if filename == "" {
+		return v, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &v.rules); err != nil {
+		return nil, fmt.Errorf("invalid validation rules: %w", err)
+	}
+	for i := range v.rules {
+		rule := &v.rules[i]
+		if rule.Pattern == "" && rule.Schema == nil {
+			return nil, fmt.Errorf("validation rule for prefix %q has neither regex nor schema", rule.Prefix)
+		}
+		if rule.Pattern != "" {
+			if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+				return nil, fmt.Errorf("validation rule for prefix %q: %w", rule.Prefix, err)
+			}
+		}
+	}
+	return v, nil
+}
+
+// rule returns the rule with the longest prefix matching the key.
+func (v *validator) rule(key string) *validationRule {
+	var match *validationRule
+	for i := range v.rules {
+		rule := &v.rules[i]
+		if strings.HasPrefix(key, rule.Prefix) && (match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = rule
+		}
+	}
+	return match
+}
+
+func (v *validator) Validate(key, value string) error {
+	rule := v.rule(key)
+	if rule == nil {
+		return nil
+	}
+	if rule.re != nil && !rule.re.MatchString(value) {
+		return fmt.Errorf("value does not match %q", rule.Pattern)
+	}
+	if rule.Schema != nil {
+		var doc any
+		if err := json.Unmarshal([]byte(value), &doc); err != nil {
+			return fmt.Errorf("value is not valid JSON: %w", err)
+		}
+		return rule.Schema.validate("$", doc)
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if n == float64(int64(n)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func (s *jsonSchema) validate(path string, v any) error {
+	if s.Type != "" {
+		t := jsonType(v)
+		if t != s.Type && !(s.Type == "number" && t == "integer") {
+			return fmt.Errorf("%s: expected %s, got %s", path, s.Type, t)
+		}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) && jsonType(e) == jsonType(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %v", path, s.Enum)
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		if s.MinLength != nil && len(val) < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && len(val) > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			return fmt.Errorf("%s: less than %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			return fmt.Errorf("%s: greater than %v", path, *s.Maximum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range val {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if pv, ok := val[name]; ok {
+				if err := prop.validate(path+"."+name, pv); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package dbserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[
+		{"prefix": "", "regex": "^.{0,64}$"},
+		{"prefix": "date:", "regex": "^\\d{4}-\\d{2}-\\d{2}$"},
+		{"prefix": "user:", "schema": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string", "minLength": 1},
+				"age": {"type": "integer", "minimum": 0}
+			}
+		}}
+	]`
+	if err := os.WriteFile(filename, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := loadValidator(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := [][]string{
+		{"date:today", "2024-05-01"},
+		{"user:1", `{"name": "bob", "age": 20}`},
+		{"other", "anything"},
+	}
+	for _, pair := range valid {
+		if err := v.Validate(pair[0], pair[1]); err != nil {
+			t.Errorf("Expected %s=%s to be valid, got %s", pair[0], pair[1], err)
+		}
+	}
+
+	invalid := [][]string{
+		{"date:today", "yesterday"},
+		{"user:1", "not json"},
+		{"user:1", `{"age": 20}`},
+		{"user:1", `{"name": "bob", "age": -1}`},
+		{"user:1", `{"name": "bob", "age": 1.5}`},
+	}
+	for _, pair := range invalid {
+		if err := v.Validate(pair[0], pair[1]); err == nil {
+			t.Errorf("Expected %s=%s to be rejected", pair[0], pair[1])
+		}
+	}
+}
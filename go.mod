@@ -2,8 +2,15 @@ module github.com/roman-mazur/architecture-practice-4-template
 
 go 1.22
 
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/kr/text v0.1.0 // indirect
-	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
@@ -0,0 +1,134 @@
+// Package httpclient creates the HTTP clients the services call each
+// other with. Unlike http.DefaultClient, every stage of their requests is
+// bounded: the dial, the TLS handshake, the wait for the response headers
+// and the whole request. The clients propagate the trace context of the
+// requests and may retry the failed ones.
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Options tune a client. The zero durations take the defaults, the
+// negative ones disable the timeouts.
+type Options struct {
+	// Timeout bounds a request from the dial to the end of the response
+	// body, with the retries, 10 seconds by default. Clients of streamed
+	// or long requests disable it and bound the requests with their
+	// contexts instead.
+	Timeout time.Duration
+	// DialTimeout bounds the connection, 2 seconds by default.
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period, 30 seconds by default. A
+	// negative one also disables the reuse of the connections.
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout bounds the handshake, 5 seconds by default.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the response headers
+	// once the request is sent, 10 seconds by default.
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout closes the connections idle for so long, 90
+	// seconds by default.
+	IdleConnTimeout time.Duration
+
+	// The limits of the connections, 100 idle ones and 32 idle ones per
+	// host by default. MaxConnsPerHost is unlimited if zero.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+
+	// TLSConfig is the configuration of the HTTPS connections.
+	TLSConfig *tls.Config
+	// NoRedirects returns the redirects to the caller instead of following
+	// them, e.g. for a proxy.
+	NoRedirects bool
+
+	// Retry retries the failed requests, none by default.
+	Retry RetryPolicy
+	// SpanName names the client spans of the requests, which are not
+	// recorded if empty. The trace context of the requests is propagated
+	// either way.
+	SpanName string
+}
+
+func (o Options) withDefaults() Options {
+	defaults := []struct {
+		d *time.Duration
+		v time.Duration
+	}{
+		{&o.Timeout, 10 * time.Second},
+		{&o.DialTimeout, 2 * time.Second},
+		{&o.KeepAlive, 30 * time.Second},
+		{&o.TLSHandshakeTimeout, 5 * time.Second},
+		{&o.ResponseHeaderTimeout, 10 * time.Second},
+		{&o.IdleConnTimeout, 90 * time.Second},
+	}
+	for _, d := range defaults {
+		if *d.d == 0 {
+			*d.d = d.v
+		}
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = 100
+	}
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = 32
+	}
+	return o
+}
+
+// positive turns the disabled timeouts into the zero net/http takes for
+// no timeout.
+func positive(d time.Duration) time.Duration {
+	return max(d, 0)
+}
+
+// NewTransport creates the transport of the options, without the retries
+// and the tracing of New.
+func NewTransport(opts Options) *http.Transport {
+	opts = opts.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   positive(opts.DialTimeout),
+		KeepAlive: opts.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       positive(opts.IdleConnTimeout),
+		TLSClientConfig:       opts.TLSConfig,
+		TLSHandshakeTimeout:   positive(opts.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: positive(opts.ResponseHeaderTimeout),
+		DisableKeepAlives:     opts.KeepAlive < 0,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// New creates a client of the options.
+func New(opts Options) *http.Client {
+	opts = opts.withDefaults()
+	c := &http.Client{
+		Timeout:   positive(opts.Timeout),
+		Transport: Wrap(NewTransport(opts), opts),
+	}
+	if opts.NoRedirects {
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return c
+}
+
+// Wrap adds the retries and the tracing of the options to a transport.
+func Wrap(transport http.RoundTripper, opts Options) http.RoundTripper {
+	if opts.Retry.Attempts > 0 {
+		transport = &retrying{next: transport, policy: opts.Retry}
+	}
+	return &traced{next: transport, spanName: opts.SpanName}
+}
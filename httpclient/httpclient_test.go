@@ -0,0 +1,108 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+func TestClient_Retry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(r.Method))
+	}))
+	defer srv.Close()
+	c := New(Options{Retry: RetryPolicy{Attempts: 2, Backoff: time.Millisecond}})
+
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("expected the third attempt to succeed, got %d after %d", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	resp, err = c.Post(srv.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("expected a POST not to be retried, got %d after %d", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	req, _ := http.NewRequest("PUT", srv.URL, strings.NewReader("body"))
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("expected a PUT with a replayable body to be retried, got %d after %d", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestClient_Timeouts(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := New(Options{ResponseHeaderTimeout: 50 * time.Millisecond})
+	started := time.Now()
+	_, err := c.Get(srv.URL)
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() || time.Since(started) > 2*time.Second {
+		t.Errorf("expected the response header timeout, got %v after %s", err, time.Since(started))
+	}
+
+	c = New(Options{Timeout: 50 * time.Millisecond, ResponseHeaderTimeout: -1})
+	if _, err := c.Get(srv.URL); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("expected the client timeout, got %v", err)
+	}
+}
+
+func TestClient_Tracing(t *testing.T) {
+	var traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		http.Redirect(rw, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	ctx, span := tracing.Start(context.Background(), "test", tracing.KindServer)
+	defer span.End()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := New(Options{SpanName: "call", NoRedirects: true}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected the redirect to be returned, got %d", resp.StatusCode)
+	}
+	got, _ := traceparent.Load().(string)
+	if !strings.Contains(got, span.Context().TraceID.String()) || strings.Contains(got, span.Context().SpanID.String()) {
+		t.Errorf("expected the trace of the span with the client span as the parent, got %q", got)
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("expected the request of the caller to be left intact")
+	}
+}
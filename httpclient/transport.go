@@ -0,0 +1,118 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+// RetryPolicy retries the requests failing with a transient error. Only
+// the idempotent requests whose body can be sent again are retried.
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first attempt.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled before each
+	// next one, 100 milliseconds if zero.
+	Backoff time.Duration
+	// Retryable tells whether the outcome of an attempt is transient. By
+	// default the errors other than the cancellations and the 502, 503
+	// and 504 responses are.
+	Retryable func(*http.Response, error) bool
+}
+
+// Retryable is the default of RetryPolicy.Retryable.
+func Retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+type retrying struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retrying) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := t.policy.Retryable
+	if retryable == nil {
+		retryable = Retryable
+	}
+	backoff := t.policy.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	canRetry := idempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	for attempt := 0; ; attempt++ {
+		res, err := t.next.RoundTrip(req)
+		if !canRetry || attempt >= t.policy.Attempts || !retryable(res, err) {
+			return res, err
+		}
+		if res != nil {
+			// The connection is reused only once the body is read.
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+			res.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// traced records a client span of the request, if named, and propagates
+// the trace context to the server.
+type traced struct {
+	next     http.RoundTripper
+	spanName string
+}
+
+func (t *traced) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	var span *tracing.Span
+	if t.spanName != "" {
+		ctx, span = tracing.Start(ctx, t.spanName, tracing.KindClient)
+		defer span.End()
+		span.SetAttribute("http.url", req.URL.String())
+	}
+	if tracing.SpanFromContext(ctx) != nil {
+		// A RoundTripper must not modify the request of the caller.
+		req = req.Clone(ctx)
+		tracing.Inject(ctx, req.Header)
+	}
+	res, err := t.next.RoundTrip(req)
+	if span != nil {
+		if err != nil {
+			span.SetError(err)
+		} else {
+			span.SetAttribute("http.status_code", res.StatusCode)
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,166 @@
+package httptools
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip,
+// either by name or through * when gzip is not listed.
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				q = 0
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// Gzip compresses the JSON responses for the clients accepting gzip.
+// Responses smaller than minSize bytes are sent as they are, since
+// compressing them saves next to nothing. A negative minSize disables
+// the compression.
+func Gzip(minSize int) Middleware {
+	return func(next http.Handler) http.Handler {
+		if minSize < 0 {
+			return next
+		}
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			gw := &gzipWriter{
+				ResponseWriter: rw,
+				accepts:        acceptsGzip(r.Header.Get("Accept-Encoding")),
+				minSize:        minSize,
+			}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// gzipWriter holds back the header and the first minSize bytes of a
+// compressible response until it is clear whether to compress it.
+type gzipWriter struct {
+	http.ResponseWriter
+	accepts bool
+	minSize int
+
+	status  int
+	pending bool
+	buf     []byte
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	h := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || !isJSON(h.Get("content-type")) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if !w.accepts {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.pending = true
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case !w.pending:
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *gzipWriter) startGzip() error {
+	w.pending = false
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.gz.Write(buf)
+	return err
+}
+
+// Flush compresses the response right away, as streamed responses cannot
+// wait for minSize bytes.
+func (w *gzipWriter) Flush() {
+	if w.pending {
+		_ = w.startGzip()
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipWriter) close() {
+	switch {
+	case w.pending:
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.buf)
+	case w.gz != nil:
+		_ = w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriters.Put(w.gz)
+	}
+}
@@ -0,0 +1,76 @@
+package httptools
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip;q=0.5":   true,
+		"GZIP":                  true,
+		"gzip;q=0":              false,
+		"br":                    false,
+		"*":                     true,
+		"*, gzip;q=0":           false,
+		"identity;q=1, *;q=0.1": true,
+	} {
+		if acceptsGzip(header) != expected {
+			t.Errorf("%q: expected %v", header, expected)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	large := `{"value":"` + strings.Repeat("a", 4096) + `"}`
+	handler := Gzip(1024)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("content-type", r.URL.Query().Get("type"))
+		body := large
+		if r.URL.Query().Has("small") {
+			body = `{}`
+		}
+		_, _ = io.WriteString(rw, body)
+	}))
+
+	for _, tc := range []struct {
+		target, encoding string
+		compressed       bool
+	}{
+		{"/?type=application/json", "gzip", true},
+		{"/?type=application/json%3B+charset=utf-8", "gzip, br", true},
+		{"/?type=application/json&small", "gzip", false},
+		{"/?type=application/json", "", false},
+		{"/?type=text/plain", "gzip", false},
+	} {
+		r := httptest.NewRequest("GET", tc.target, nil)
+		r.Header.Set("Accept-Encoding", tc.encoding)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+
+		if compressed := rw.Header().Get("Content-Encoding") == "gzip"; compressed != tc.compressed {
+			t.Errorf("%s with %q: expected compressed %v", tc.target, tc.encoding, tc.compressed)
+			continue
+		}
+		if json := strings.HasPrefix(tc.target, "/?type=application/json"); json != (rw.Header().Get("Vary") == "Accept-Encoding") {
+			t.Errorf("%s: unexpected Vary header %q", tc.target, rw.Header().Get("Vary"))
+		}
+		body := io.Reader(rw.Body)
+		if tc.compressed {
+			gz, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = gz
+		}
+		data, _ := io.ReadAll(body)
+		if len(data) < 2 || (!strings.Contains(tc.target, "small") && string(data) != large) {
+			t.Errorf("%s: unexpected body of %d bytes", tc.target, len(data))
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package httptools
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+// Middleware wraps a handler with a concern shared by the services, e.g.
+// logging or authentication.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps h with the middleware, the first one being the outermost,
+// i.e. seeing the requests first.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// responseRecorder notes the status and the size of a response on the
+// way to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 && status >= http.StatusOK {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps the streamed responses going through the handlers that
+// look for http.Flusher.
+func (r *responseRecorder) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// code is the status of the response, 200 OK if nothing was written.
+func (r *responseRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func record(rw http.ResponseWriter) *responseRecorder {
+	if rec, ok := rw.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: rw}
+}
+
+// Recover responds with 500 Internal Server Error to the requests whose
+// handler panics, logging the stack, instead of dropping the connection.
+// Panics with http.ErrAbortHandler still abort the response, as net/http
+// expects.
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rec := record(rw)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(v)
+				}
+				log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+				if rec.status != 0 {
+					// The client got a part of the response already.
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(rec, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// Logging logs every request with its status, response size and
+// duration, to the standard logger if logger is nil.
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := record(rw)
+			next.ServeHTTP(rec, r)
+			logger.Printf("%s %s %s %d %dB %s", r.RemoteAddr, r.Method, r.RequestURI, rec.code(), rec.bytes, time.Since(start))
+		})
+	}
+}
+
+// Metrics counts the requests and observes their durations in the
+// registry as http_requests_total and http_request_duration_seconds,
+// labelled by the route, the method and, for the counter, the status code.
+// route names the route of a request, e.g. MuxRoute. The metrics are
+// registered once per registry, the servers of a process using the same
+// one share them.
+func Metrics(registry *metrics.Registry, route func(*http.Request) string) Middleware {
+	m := registerHTTPMetrics(registry)
+	requests, duration := m.requests, m.duration
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := record(rw)
+			name := ""
+			if route != nil {
+				name = route(r)
+			}
+			defer func() {
+				// Aborted responses are counted too, with the status
+				// they started with.
+				requests.Inc(name, r.Method, strconv.Itoa(rec.code()))
+				duration.Observe(time.Since(start).Seconds(), name, r.Method)
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// httpMetrics are the collectors of Metrics in a registry.
+type httpMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+var (
+	httpMetricsMu       sync.Mutex
+	httpMetricsRegistry = map[*metrics.Registry]httpMetrics{}
+)
+
+func registerHTTPMetrics(registry *metrics.Registry) httpMetrics {
+	httpMetricsMu.Lock()
+	defer httpMetricsMu.Unlock()
+	if m, ok := httpMetricsRegistry[registry]; ok {
+		return m
+	}
+	m := httpMetrics{
+		requests: registry.NewCounter("http_requests_total",
+			"HTTP requests served, by route, method and status code.",
+			metrics.LabelRoute, metrics.LabelMethod, metrics.LabelCode),
+		duration: registry.NewHistogram("http_request_duration_seconds",
+			"Time taken to serve the HTTP requests, by route and method.", metrics.DefaultBuckets,
+			metrics.LabelRoute, metrics.LabelMethod),
+	}
+	httpMetricsRegistry[registry] = m
+	return m
+}
+
+// MuxRoute names the routes by the patterns of the mux, e.g.
+// "GET /db/{key}", and the requests it has no pattern for "unmatched".
+func MuxRoute(mux *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+		return "unmatched"
+	}
+}
+
+// ErrorWriter responds to a request with an error, e.g. http.Error
+// without the content type.
+type ErrorWriter func(rw http.ResponseWriter, status int, message string)
+
+func writeError(rw http.ResponseWriter, status int, message string) {
+	http.Error(rw, message, status)
+}
+
+// Authorize checks the bearer token of the request against the tokens,
+// letting any request through when there are none. Requests without a
+// token get 401 Unauthorized and ones with an unknown token 403
+// Forbidden, written by reject, or as plain text if it is nil.
+func Authorize(rw http.ResponseWriter, r *http.Request, realm string, tokens []string, reject ErrorWriter) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	if reject == nil {
+		reject = writeError
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+		reject(rw, http.StatusUnauthorized, "missing bearer token")
+		return false
+	}
+	// Every token is compared so that the time taken does not tell which
+	// one is close.
+	match := 0
+	for _, t := range tokens {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	if match != 1 {
+		reject(rw, http.StatusForbidden, "invalid token")
+		return false
+	}
+	return true
+}
+
+// BearerAuth lets through only the requests Authorize accepts.
+func BearerAuth(realm string, tokens []string, reject ErrorWriter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if Authorize(rw, r, realm, tokens, reject) {
+				next.ServeHTTP(rw, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package httptools
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(rw, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}), mw("first"), mw("second"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got := strings.Join(order, ","); got != "first,second,handler" {
+		t.Errorf("unexpected order %s", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	h := Recover()(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("written") {
+			rw.WriteHeader(http.StatusOK)
+		}
+		if r.URL.Query().Has("abort") {
+			panic(http.ErrAbortHandler)
+		}
+		panic("broken handler")
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rw.Code)
+	}
+
+	for _, target := range []string{"/?abort", "/?written"} {
+		func() {
+			defer func() {
+				if err, ok := recover().(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+					t.Errorf("%s: expected the response to be aborted, got %v", target, err)
+				}
+			}()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+		}()
+	}
+}
+
+func TestLoggingAndMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	registry := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/path/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			rw.WriteHeader(http.StatusCreated)
+		}
+		_, _ = io.WriteString(rw, "done")
+	})
+	h := Chain(mux, Logging(log.New(&logs, "", 0)), Metrics(registry, MuxRoute(mux)))
+
+	// Another server of the process shares the metrics of the registry.
+	other := Chain(mux, Metrics(registry, MuxRoute(mux)))
+
+	for _, target := range []string{"GET /path/a", "GET /path/b?q=1", "POST /path/a?q=1"} {
+		method, path, _ := strings.Cut(target, " ")
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+
+	if !strings.Contains(logs.String(), "POST /path/a?q=1 201 4B") {
+		t.Errorf("unexpected logs %q", logs.String())
+	}
+	var out bytes.Buffer
+	registry.Write(&out)
+	for _, line := range []string{
+		`http_requests_total{route="/path/{key}",method="GET",code="200"} 2`,
+		`http_requests_total{route="/path/{key}",method="POST",code="201"} 1`,
+		`http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`http_request_duration_seconds_count{route="/path/{key}",method="GET"} 2`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %s in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	serve := func(tokens []string, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rw := httptest.NewRecorder()
+		BearerAuth("test", tokens, nil)(ok).ServeHTTP(rw, r)
+		return rw
+	}
+
+	if rw := serve(nil, ""); rw.Code != http.StatusOK {
+		t.Errorf("expected no tokens to let anyone in, got %d", rw.Code)
+	}
+	tokens := []string{"a", "b"}
+	if rw := serve(tokens, ""); rw.Code != http.StatusUnauthorized || rw.Header().Get("WWW-Authenticate") != `Bearer realm="test"` {
+		t.Errorf("expected 401 with a challenge, got %d %v", rw.Code, rw.Header())
+	}
+	if rw := serve(tokens, "Bearer c"); rw.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an unknown token, got %d", rw.Code)
+	}
+	if rw := serve(tokens, "Bearer b"); rw.Code != http.StatusOK {
+		t.Errorf("expected 200 for a known token, got %d", rw.Code)
+	}
+}
+
+func TestCreateServerWith(t *testing.T) {
+	s := CreateServerWith(0, http.NotFoundHandler(), Options{WriteTimeout: -1, IdleTimeout: time.Second}).(server).httpServer
+	if s.WriteTimeout != 0 || s.IdleTimeout != time.Second || s.ReadTimeout != 10*time.Second ||
+		s.ReadHeaderTimeout != 5*time.Second || s.MaxHeaderBytes != 1<<20 {
+		t.Errorf("unexpected timeouts %+v", s)
+	}
+}
@@ -0,0 +1,204 @@
+package httptools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocol configures how a server accepts PROXY protocol (v1 and
+// v2) headers sent by an upstream TCP load balancer in front of it.
+type ProxyProtocol struct {
+	// Required rejects connections without a header.
+	Required bool
+	// Trusted reports whether headers from the peer are believed. Nil
+	// trusts any peer.
+	Trusted func(peer net.Addr) bool
+}
+
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WithProxyProtocol makes the server take client addresses from PROXY
+// protocol headers.
+func WithProxyProtocol(s Server, p ProxyProtocol) Server {
+	srv := s.(server)
+	srv.wrap = func(l net.Listener) net.Listener {
+		return ProxyProtocolListener(l, p)
+	}
+	return srv
+}
+
+// ProxyProtocolListener wraps accepted connections so that RemoteAddr
+// returns the client address from the PROXY protocol header. The header
+// is read lazily, so a slow peer does not block the accept loop.
+func ProxyProtocolListener(l net.Listener, p ProxyProtocol) net.Listener {
+	return proxyListener{l, p}
+}
+
+type proxyListener struct {
+	net.Listener
+	p ProxyProtocol
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), p: l.p}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	p      ProxyProtocol
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if c.p.Trusted != nil && !c.p.Trusted(c.remote) {
+			if c.p.Required {
+				c.err = fmt.Errorf("PROXY protocol header from untrusted peer %s", c.remote)
+			}
+			return
+		}
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+		addr, found, err := readProxyHeader(c.r)
+		switch {
+		case err != nil:
+			c.err = err
+		case !found && c.p.Required:
+			c.err = fmt.Errorf("missing PROXY protocol header from %s", c.remote)
+		case addr != nil:
+			c.remote = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader consumes a PROXY protocol header if the stream starts
+// with one. The returned address is nil for headers that do not carry
+// a client address (v1 UNKNOWN, v2 LOCAL or non-IP families).
+func readProxyHeader(r *bufio.Reader) (net.Addr, bool, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, false, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+			return nil, false, nil
+		}
+		addr, err := readProxyV1(r)
+		return addr, true, err
+	case '\r':
+		if prefix, err := r.Peek(len(proxyV2Signature)); err != nil || !bytes.Equal(prefix, proxyV2Signature) {
+			return nil, false, nil
+		}
+		addr, err := readProxyV2(r)
+		return addr, true, err
+	}
+	return nil, false, nil
+}
+
+// The longest v1 header, including CRLF, is 107 bytes.
+const proxyV1MaxLength = 107
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if len(line) > proxyV1MaxLength {
+			return nil, fmt.Errorf("PROXY v1 header is too long")
+		}
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+const (
+	proxyV2Local = 0x20
+	proxyV2Proxy = 0x21
+	proxyV2TCP4  = 0x11
+	proxyV2TCP6  = 0x21
+)
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	command, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch command {
+	case proxyV2Local:
+		return nil, nil
+	case proxyV2Proxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command 0x%x", command)
+	}
+	switch family {
+	case proxyV2TCP4:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short PROXY v2 IPv4 addresses")
+		}
+		ip := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(payload[8:]))), nil
+	case proxyV2TCP6:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short PROXY v2 IPv6 addresses")
+		}
+		ip := netip.AddrFrom16([16]byte(payload[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(payload[32:]))), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,90 @@
+package httptools
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(command, family byte, addrs ...byte) string {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, command, family, 0, 0)
+		binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+		return string(append(header, addrs...))
+	}
+
+	tests := []struct {
+		name, input, addr string
+		found, fails      bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /", "192.0.2.1:56324", true, false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET /", "[2001:db8::1]:56324", true, false},
+		{"v1 unknown", "PROXY UNKNOWN\r\nGET /", "", true, false},
+		{"v1 invalid", "PROXY TCP4 192.0.2.1\r\nGET /", "", true, true},
+		{"v1 mismatched family", "PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\nGET /", "", true, true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 200), "", true, true},
+		{"v2 tcp4", v2(proxyV2Proxy, proxyV2TCP4, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 1, 187) + "GET /", "192.0.2.1:56324", true, false},
+		{"v2 local", v2(proxyV2Local, 0) + "GET /", "", true, false},
+		{"v2 short", v2(proxyV2Proxy, proxyV2TCP4, 192, 0, 2) + "GET /", "", true, true},
+		{"no header", "GET / HTTP/1.1\r\n", "", false, false},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		addr, found, err := readProxyHeader(r)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if found != tt.found {
+			t.Errorf("%s: expected found=%t", tt.name, tt.found)
+		}
+		if tt.fails {
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.addr {
+			t.Errorf("%s: expected address %q, got %q", tt.name, tt.addr, got)
+		}
+		if rest, _ := io.ReadAll(r); !strings.HasPrefix(string(rest), "GET /") {
+			t.Errorf("%s: the request is not preserved: %q", tt.name, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ProxyProtocolListener(inner, ProxyProtocol{Required: true})
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello"))
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr().String(); addr != "192.0.2.1:56324" {
+		t.Errorf("unexpected remote address %s", addr)
+	}
+	data, _ := io.ReadAll(conn)
+	if string(data) != "hello" {
+		t.Errorf("unexpected data %q", data)
+	}
+}
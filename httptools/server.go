@@ -1,36 +1,139 @@
 package httptools
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
 
 type Server interface {
 	Start()
+	// Shutdown stops accepting connections and waits for the active
+	// requests to finish, or for the context to end.
+	Shutdown(ctx context.Context) error
 }
 
 type server struct {
 	httpServer *http.Server
+	listener   net.Listener
+	wrap       func(net.Listener) net.Listener
 }
 
 func (s server) Start() {
 	go func() {
-		log.Println("Staring the HTTP server...")
-		err := s.httpServer.ListenAndServe()
+		l, err := s.listener, error(nil)
+		if l == nil {
+			l, err = net.Listen("tcp", s.httpServer.Addr)
+		}
+		if err == nil {
+			if s.wrap != nil {
+				l = s.wrap(l)
+			}
+			if s.httpServer.TLSConfig != nil {
+				log.Println("Staring the HTTPS server...")
+				err = s.httpServer.ServeTLS(l, "", "")
+			} else {
+				log.Println("Staring the HTTP server...")
+				err = s.httpServer.Serve(l)
+			}
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
 		log.Fatalf("HTTP server finished: %s. Finishing the process.", err)
 	}()
 }
 
+func (s server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// WithListener makes the server accept connections from the listener,
+// e.g. one inherited from another process, instead of opening its own.
+func WithListener(s Server, l net.Listener) Server {
+	srv := s.(server)
+	srv.listener = l
+	return srv
+}
+
+// Options tune the HTTP server. The zero values take the defaults.
+type Options struct {
+	// ReadTimeout bounds the reading of a request, with its body, 10
+	// seconds by default.
+	ReadTimeout time.Duration
+	// ReadHeaderTimeout bounds the reading of the request headers, 5
+	// seconds by default, so that slow clients cannot hold connections.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout bounds the writing of a response, 10 seconds by
+	// default. A negative one leaves streamed responses unbounded.
+	WriteTimeout time.Duration
+	// IdleTimeout closes the keep-alive connections idle for so long, 2
+	// minutes by default.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request headers, 1 MB by
+	// default.
+	MaxHeaderBytes int
+	// Middleware wraps the handler, the first one being the outermost.
+	Middleware []Middleware
+}
+
+func (o Options) withDefaults() Options {
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = 10 * time.Second
+	}
+	if o.ReadHeaderTimeout == 0 {
+		o.ReadHeaderTimeout = 5 * time.Second
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = 10 * time.Second
+	} else if o.WriteTimeout < 0 {
+		o.WriteTimeout = 0
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = 2 * time.Minute
+	}
+	if o.MaxHeaderBytes == 0 {
+		o.MaxHeaderBytes = 1 << 20
+	}
+	return o
+}
+
 func CreateServer(port int, handler http.Handler) Server {
+	return CreateServerWith(port, handler, Options{})
+}
+
+// CreateServerWith creates a server of the handler wrapped with the
+// middleware of the options.
+func CreateServerWith(port int, handler http.Handler, opts Options) Server {
+	opts = opts.withDefaults()
 	return server{
 		httpServer: &http.Server{
-			Addr:           fmt.Sprintf(":%d", port),
-			Handler:        handler,
-			ReadTimeout:    10 * time.Second,
-			WriteTimeout:   10 * time.Second,
-			MaxHeaderBytes: 1 << 20,
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           Chain(handler, opts.Middleware...),
+			ReadTimeout:       opts.ReadTimeout,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+			MaxHeaderBytes:    opts.MaxHeaderBytes,
 		},
 	}
 }
+
+// CreateTLSServer creates a server accepting HTTPS connections. The
+// certificates are taken from the TLS config.
+func CreateTLSServer(port int, handler http.Handler, config *tls.Config) Server {
+	return CreateTLSServerWith(port, handler, config, Options{})
+}
+
+// CreateTLSServerWith is CreateTLSServer with the options of
+// CreateServerWith.
+func CreateTLSServerWith(port int, handler http.Handler, config *tls.Config, opts Options) Server {
+	s := CreateServerWith(port, handler, opts).(server)
+	s.httpServer.TLSConfig = config
+	return s
+}
@@ -0,0 +1,52 @@
+package httptools
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerWithListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	s := WithListener(CreateServer(0, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-released
+		_, _ = rw.Write([]byte("ok"))
+	})), l)
+	s.Start()
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(released)
+	if got := <-body; got != "ok" {
+		t.Errorf("active request got %q, expected it to finish", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown failed: %s", err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("the server still accepts connections")
+	}
+}
@@ -1,20 +1,45 @@
 package integration
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/integration/harness"
+	"github.com/roman-mazur/architecture-practice-4-template/loadgen"
 	. "gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { TestingT(t) }
 
+// TestMain starts the cluster the suite runs against.
+func TestMain(m *testing.M) {
+	cluster, err := harness.Start(harness.Options{
+		Servers:      len(servers),
+		BalancerArgs: []string{"-trace=true"},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot start the cluster: %s\n", err)
+		os.Exit(1)
+	}
+	baseAddress, dbAddress, servers = cluster.BalancerURL, cluster.DbURL, cluster.Servers
+	code := m.Run()
+	if err := cluster.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot stop the cluster: %s\n", err)
+	}
+	os.Exit(code)
+}
+
 type BalancerSuite struct{}
 
 var (
@@ -23,6 +48,7 @@ var (
 		Timeout: 3 * time.Second,
 	}
 	baseAddress = "http://balancer:8090"
+	dbAddress   = "http://db:5432"
 	servers     = []string{
 		"server1:8080",
 		"server2:8080",
@@ -32,10 +58,6 @@ var (
 )
 
 func (s *BalancerSuite) TestIpToHashNumber(c *C) {
-	if _, exists := os.LookupEnv("INTEGRATION_TEST"); !exists {
-		c.Skip("Integration test is not enabled")
-	}
-
 	ips := []string{
 		"87.154.128.68",
 		"55.234.146.40",
@@ -45,9 +67,9 @@ func (s *BalancerSuite) TestIpToHashNumber(c *C) {
 	}
 
 	expectedIpBindings := map[string][]string{
-		"server1:8080": {"55.234.146.40", "196.16.10.9", "106.246.220.17:2121"},
-		"server2:8080": {"93.167.203.49:8080"},
-		"server3:8080": {"87.154.128.68"},
+		servers[0]: {"55.234.146.40", "196.16.10.9", "106.246.220.17:2121"},
+		servers[1]: {"93.167.203.49:8080"},
+		servers[2]: {"87.154.128.68"},
 	}
 
 	getCorrectBinding := func(ip string) string {
@@ -105,93 +127,97 @@ func (s *BalancerSuite) TestIpToHashNumber(c *C) {
 	wg.Wait()
 }
 
-var (
-	parallel = 1000
-	interval = time.Second
-	total    = 10
-)
+func (s *BalancerSuite) TestStatus(c *C) {
+	resp, err := client.Get(baseAddress + "/lb/status")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
 
-func BenchmarkBalancer(b *testing.B) {
-	ips := []string{
-		"87.154.128.68",
-		"55.234.146.40",
-		"93.167.203.49",
+	var status struct {
+		Strategy string   `json:"strategy"`
+		Healthy  []string `json:"healthy"`
 	}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&status), IsNil)
+	c.Assert(status.Strategy, Equals, "ip-hash")
+	expected := slices.Clone(servers)
+	slices.Sort(expected)
+	slices.Sort(status.Healthy)
+	c.Assert(status.Healthy, DeepEquals, expected)
+}
 
-	var wg sync.WaitGroup
-	wg.Add(parallel)
-
-	start := make(chan struct{})
-
-	benchmarks := make([][]time.Duration, parallel)
-
-	for i := 0; i < parallel; i++ {
-		url := fmt.Sprintf("%s/api/v1/some-data?key=%s", baseAddress, teamName)
-		req, _ := http.NewRequest("GET", url, nil)
-
-		req.Header.Set("X-Forwarded-For", ips[i%len(ips)])
-
-		go func(r *http.Request) {
-			defer wg.Done()
-			var (
-				count     int
-				durations = make([]time.Duration, total)
-			)
-
-			<-start
-
-			for range time.Tick(interval) {
-				start := time.Now()
-				resp, fetchErr := client.Do(r)
-
-				if fetchErr != nil {
-					b.Logf("Failed to get response: %s", fetchErr)
-					return
-				}
-
-				resp.Body.Close()
-				durations[count] = time.Since(start)
-
-				if count++; count == total {
-					break
-				}
-			}
+// The benchmark exports its results for comparing the commits when these
+// are set.
+const (
+	// benchOutputEnv names the directory balancer.json, with the summary
+	// and every request, and balancer.csv are written to.
+	benchOutputEnv = "BENCH_OUTPUT"
+	// benchRunEnv names the run, e.g. by the commit, the time if unset.
+	benchRunEnv = "BENCH_RUN"
+	// benchPushEnv makes the summary be stored in the db under the run.
+	benchPushEnv = "BENCH_PUSH"
+)
 
-			benchmarks[i] = durations
-		}(req)
+func BenchmarkBalancer(b *testing.B) {
+	output := os.Getenv(benchOutputEnv)
+	res, err := loadgen.Run(context.Background(), loadgen.Config{
+		Target:      baseAddress,
+		RPS:         1000,
+		Concurrency: 1000,
+		Duration:    10 * time.Second,
+		Keys:        []string{teamName},
+		ClientIPs: []string{
+			"87.154.128.68",
+			"55.234.146.40",
+			"93.167.203.49",
+		},
+		Client:  &client,
+		Samples: output != "",
+	})
+	if err != nil {
+		b.Fatal(err)
 	}
-
-	close(start)
-
-	wg.Wait()
-
-	var result time.Duration
-
-	for i := 0; i < total; i++ {
-		var (
-			sum   time.Duration
-			count int
-		)
-
-		for j := 0; j < parallel; j++ {
-			benchmark := benchmarks[j]
-
-			if benchmark == nil {
-				continue
-			}
-
-			res := benchmark[i]
-
-			if res == 0 {
-				continue
-			}
-
-			sum += res
-			count++
+	var report strings.Builder
+	_ = res.WriteReport(&report)
+	b.Log("\n" + report.String())
+	b.ReportMetric(float64(res.Total.Percentile(50).Microseconds()), "p50-us")
+	b.ReportMetric(float64(res.Total.Percentile(99).Microseconds()), "p99-us")
+	b.ReportMetric(100*res.Total.ErrorRate(), "errors-%")
+
+	run := os.Getenv(benchRunEnv)
+	if run == "" {
+		run = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if output != "" {
+		if err := exportBenchmark(res, output, run); err != nil {
+			b.Fatal(err)
 		}
-
-		result += sum / time.Duration(count)
 	}
+	if os.Getenv(benchPushEnv) != "" {
+		db := dbclient.New(dbAddress+"/db", dbclient.Options{})
+		if err := res.Push(context.Background(), db, run); err != nil {
+			b.Fatalf("Cannot push the results of %s: %s", run, err)
+		}
+		b.Logf("Pushed the results under %s%s", loadgen.RunKeyPrefix, run)
+	}
+}
 
-	b.Logf("Average request duration: %v", time.Duration(result/time.Duration(total)))
+func exportBenchmark(res *loadgen.Result, dir, run string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	write := func(name string, export func(io.Writer) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := export(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := write("balancer.json", func(w io.Writer) error { return res.WriteJSON(w, run) }); err != nil {
+		return err
+	}
+	return write("balancer.csv", res.WriteCSV)
 }
@@ -0,0 +1,200 @@
+// Package harness runs the balancer, the app servers and the db in the
+// test process, without docker-compose. Each of them listens on an
+// ephemeral port of the loopback interface. The services keep their
+// state in package variables, so a process runs a single cluster: the
+// app servers are one server serving on several listeners.
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/balancer"
+	"github.com/roman-mazur/architecture-practice-4-template/dbserver"
+	"github.com/roman-mazur/architecture-practice-4-template/server"
+)
+
+type Options struct {
+	// Servers is the number of app servers, 3 if zero.
+	Servers int
+	// The arguments are added to the ones the harness sets, which they
+	// override.
+	DbArgs       []string
+	ServerArgs   []string
+	BalancerArgs []string
+	// StartTimeout bounds the wait for every service to get ready, 30
+	// seconds if zero.
+	StartTimeout time.Duration
+}
+
+// Cluster is a running balancer in front of the app servers and the db.
+type Cluster struct {
+	// Dir keeps the db segments.
+	Dir string
+	// BalancerURL and DbURL are the addresses of the balancer and the db,
+	// e.g. http://127.0.0.1:41234.
+	BalancerURL string
+	DbURL       string
+	// Servers are the host:port addresses of the app servers, in the order
+	// the balancer was given them.
+	Servers []string
+
+	services []*service
+}
+
+type service struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Start starts the cluster, returning once the balancer sees every server
+// healthy. The caller must Stop the cluster.
+func Start(opts Options) (*Cluster, error) {
+	if opts.Servers <= 0 {
+		opts.Servers = 3
+	}
+	if opts.StartTimeout <= 0 {
+		opts.StartTimeout = 30 * time.Second
+	}
+	dir, err := os.MkdirTemp("", "harness-")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{Dir: dir}
+	if err := c.start(opts); err != nil {
+		_ = c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cluster) start(opts Options) error {
+	listeners, err := listen(opts.Servers + 2)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(opts.StartTimeout)
+
+	dbListener := listeners[0]
+	c.DbURL = "http://" + dbListener.Addr().String()
+	db := c.run("db", func(ctx context.Context) error {
+		return dbserver.Serve(ctx, append([]string{"-dir", c.Dir}, opts.DbArgs...), dbListener)
+	})
+	if err := waitFor(db, c.DbURL+"/version", deadline, nil); err != nil {
+		return err
+	}
+
+	serverListeners := listeners[2:]
+	for _, l := range serverListeners {
+		c.Servers = append(c.Servers, l.Addr().String())
+	}
+	servers := c.run("server", func(ctx context.Context) error {
+		return server.Serve(ctx, append([]string{"-db-url", c.DbURL + "/db"}, opts.ServerArgs...), serverListeners...)
+	})
+	for _, addr := range c.Servers {
+		if err := waitFor(servers, "http://"+addr+"/ready", deadline, nil); err != nil {
+			return err
+		}
+	}
+
+	lbListener := listeners[1]
+	c.BalancerURL = "http://" + lbListener.Addr().String()
+	lb := c.run("lb", func(ctx context.Context) error {
+		return balancer.Serve(ctx, append([]string{
+			"-backends", strings.Join(c.Servers, ","),
+			"-health-interval", "1s",
+			"-health-jitter", "100ms",
+			// The suite stands in for a proxy, telling the client addresses
+			// in X-Forwarded-For.
+			"-trusted-proxies", "127.0.0.0/8",
+		}, opts.BalancerArgs...), lbListener)
+	})
+	return waitFor(lb, c.BalancerURL+"/lb/status", deadline, func(res *http.Response) bool {
+		var status struct {
+			Healthy []string `json:"healthy"`
+		}
+		return json.NewDecoder(res.Body).Decode(&status) == nil && len(status.Healthy) == len(c.Servers)
+	})
+}
+
+// listen opens n listeners on ephemeral ports of the loopback interface.
+func listen(n int) ([]net.Listener, error) {
+	listeners := make([]net.Listener, n)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			for _, l := range listeners[:i] {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners[i] = l
+	}
+	return listeners, nil
+}
+
+// run serves the service in a goroutine until it is stopped.
+func (c *Cluster) run(name string, serve func(context.Context) error) *service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &service{name: name, cancel: cancel, done: make(chan struct{})}
+	c.services = append(c.services, s)
+	go func() {
+		defer close(s.done)
+		s.err = serve(ctx)
+	}()
+	return s
+}
+
+// waitFor polls url until it responds with 200 OK and ready accepts the
+// response, if set.
+func waitFor(s *service, url string, deadline time.Time, ready func(*http.Response) bool) error {
+	client := &http.Client{Timeout: time.Second}
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			ok := res.StatusCode == http.StatusOK && (ready == nil || ready(res))
+			res.Body.Close()
+			if ok {
+				return nil
+			}
+		}
+		select {
+		case <-s.done:
+			return fmt.Errorf("%s stopped: %v", s.name, s.err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not ready at %s", s.name, url)
+		}
+	}
+}
+
+// Stop stops the services, the balancer first, and removes Dir. Services
+// not stopping within 5 seconds are left running.
+func (c *Cluster) Stop() error {
+	var errs []error
+	for i := len(c.services) - 1; i >= 0; i-- {
+		s := c.services[i]
+		s.cancel()
+		select {
+		case <-s.done:
+			if s.err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.name, s.err))
+			}
+		case <-time.After(5 * time.Second):
+			errs = append(errs, fmt.Errorf("%s did not stop", s.name))
+		}
+	}
+	c.services = nil
+	errs = append(errs, os.RemoveAll(c.Dir))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// RunKeyPrefix starts the db keys the summaries of the runs are pushed
+// under.
+const RunKeyPrefix = "loadgen-run:"
+
+// OpSummary is OpStats with the latency percentiles, in milliseconds.
+type OpSummary struct {
+	Requests  int         `json:"requests"`
+	Errors    int         `json:"errors"`
+	Misses    int         `json:"misses"`
+	ErrorRate float64     `json:"errorRate"`
+	Statuses  map[int]int `json:"statuses"`
+	P50Ms     float64     `json:"p50Ms"`
+	P90Ms     float64     `json:"p90Ms"`
+	P99Ms     float64     `json:"p99Ms"`
+	MaxMs     float64     `json:"maxMs"`
+}
+
+// Summary is the report of a run for tools comparing the runs, e.g. of
+// two commits.
+type Summary struct {
+	// Run names the run, e.g. by the commit tested.
+	Run            string           `json:"run,omitempty"`
+	ElapsedSeconds float64          `json:"elapsedSeconds"`
+	RPS            float64          `json:"rps"`
+	Total          OpSummary        `json:"total"`
+	Ops            map[Op]OpSummary `json:"ops"`
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (s *OpStats) summary() OpSummary {
+	return OpSummary{
+		Requests:  s.Requests,
+		Errors:    s.Errors,
+		Misses:    s.Misses,
+		ErrorRate: s.ErrorRate(),
+		Statuses:  s.Statuses,
+		P50Ms:     ms(s.Percentile(50)),
+		P90Ms:     ms(s.Percentile(90)),
+		P99Ms:     ms(s.Percentile(99)),
+		MaxMs:     ms(s.Percentile(100)),
+	}
+}
+
+// Summary sums up the run named run.
+func (r *Result) Summary(run string) Summary {
+	s := Summary{
+		Run:            run,
+		ElapsedSeconds: r.Elapsed.Seconds(),
+		RPS:            r.RPS(),
+		Total:          r.Total.summary(),
+		Ops:            map[Op]OpSummary{},
+	}
+	for op, stats := range r.Ops {
+		if stats.Requests > 0 {
+			s.Ops[op] = stats.summary()
+		}
+	}
+	return s
+}
+
+type sampleJSON struct {
+	StartMs   float64 `json:"startMs"`
+	Op        Op      `json:"op"`
+	Key       string  `json:"key"`
+	Status    int     `json:"status,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (s Sample) MarshalJSON() ([]byte, error) {
+	v := sampleJSON{StartMs: ms(s.Start), Op: s.Op, Key: s.Key, Status: s.Status, LatencyMs: ms(s.Latency)}
+	if s.Err != nil {
+		v.Error = s.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// WriteJSON writes the summary of the run with its samples as a JSON
+// object.
+func (r *Result) WriteJSON(w io.Writer, run string) error {
+	samples := r.Samples
+	if samples == nil {
+		samples = []Sample{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Summary
+		Samples []Sample `json:"samples"`
+	}{r.Summary(run), samples})
+}
+
+// WriteCSV writes a line per sample, after a header naming the columns.
+func (r *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"start_ms", "op", "key", "status", "latency_ms", "error"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, s := range r.Samples {
+		errText := ""
+		if s.Err != nil {
+			errText = s.Err.Error()
+		}
+		_ = cw.Write([]string{format(ms(s.Start)), string(s.Op), s.Key, strconv.Itoa(s.Status), format(ms(s.Latency)), errText})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Push stores the summary of the run in the db under RunKeyPrefix+run,
+// where the runs of earlier commits can be read back from.
+func (r *Result) Push(ctx context.Context, db *dbclient.Client, run string) error {
+	data, err := json.Marshal(r.Summary(run))
+	if err != nil {
+		return err
+	}
+	_, err = db.Put(ctx, RunKeyPrefix+run, string(data))
+	return err
+}
@@ -0,0 +1,194 @@
+// Package loadgen drives the some-data API of the balancer with a mix of
+// reads and writes and measures how it copes.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DistributionUniform = "uniform"
+	DistributionZipf    = "zipf"
+)
+
+// zipfS is the skew of the zipf distribution, its hottest key gets about
+// a third of the requests of a thousand keys.
+const zipfS = 1.1
+
+type Config struct {
+	// Target is the address of the balancer, e.g. http://localhost:8090.
+	Target string
+	// RPS is the rate requests are started at, 0 sends them as fast as
+	// Concurrency allows.
+	RPS float64
+	// Concurrency is the number of requests in flight at most.
+	Concurrency int
+	// Duration is how long the load lasts.
+	Duration time.Duration
+	// Keys are the keys requested, picked according to Distribution.
+	Keys         []string
+	Distribution string
+	// WriteRatio is the fraction of the requests writing a key.
+	WriteRatio float64
+	// ClientIPs are sent in X-Forwarded-For in turn, which spreads the
+	// requests across the servers under the ip-hash strategy.
+	ClientIPs []string
+	// Token is sent as the bearer token of every request if set.
+	Token string
+	// Client sends the requests, a client with a 10 seconds timeout if nil.
+	Client *http.Client
+	// Samples keeps every request in Result.Samples for the export of the
+	// latencies, at the cost of memory growing with the requests.
+	Samples bool
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Target == "":
+		return errors.New("no target")
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be positive")
+	case c.Duration <= 0:
+		return errors.New("duration must be positive")
+	case c.RPS < 0:
+		return errors.New("rate must not be negative")
+	case len(c.Keys) == 0:
+		return errors.New("no keys")
+	case c.WriteRatio < 0 || c.WriteRatio > 1:
+		return errors.New("write ratio must be between 0 and 1")
+	case c.Distribution != DistributionUniform && c.Distribution != DistributionZipf && c.Distribution != "":
+		return fmt.Errorf("unknown key distribution %q", c.Distribution)
+	}
+	return nil
+}
+
+// Run sends the requests until the duration passes or ctx is done.
+func Run(ctx context.Context, c Config) (*Result, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Duration)
+	defer cancel()
+
+	// Every request takes a ticket, which paces them when RPS is set.
+	tickets := make(chan int)
+	go func() {
+		defer close(tickets)
+		start := time.Now()
+		for i := 0; ; i++ {
+			if c.RPS > 0 {
+				next := start.Add(time.Duration(float64(i) / c.RPS * float64(time.Second)))
+				select {
+				case <-time.After(time.Until(next)):
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case tickets <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	res := newResult()
+	res.keepSamples = c.Samples
+	var wg sync.WaitGroup
+	started := time.Now()
+	for w := range c.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := newGenerator(c, uint64(w))
+			for i := range tickets {
+				op, key := g.next()
+				sent := time.Since(started)
+				status, d, err := c.send(ctx, op, key, i)
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+					// Cut short by the end of the run rather than failed.
+					continue
+				}
+				res.record(Sample{Start: sent, Op: op, Key: key, Status: status, Latency: d, Err: err})
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(started)
+	res.finish()
+	return res, nil
+}
+
+// generator picks the operations and keys of a worker.
+type generator struct {
+	c    Config
+	rand *rand.Rand
+	zipf *rand.Zipf
+}
+
+func newGenerator(c Config, seed uint64) *generator {
+	g := &generator{c: c, rand: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), seed))}
+	if c.Distribution == DistributionZipf && len(c.Keys) > 1 {
+		g.zipf = rand.NewZipf(g.rand, zipfS, 1, uint64(len(c.Keys)-1))
+	}
+	return g
+}
+
+func (g *generator) next() (Op, string) {
+	op := OpRead
+	if g.rand.Float64() < g.c.WriteRatio {
+		op = OpWrite
+	}
+	if g.zipf != nil {
+		return op, g.c.Keys[g.zipf.Uint64()]
+	}
+	return op, g.c.Keys[g.rand.IntN(len(g.c.Keys))]
+}
+
+func (c Config) send(ctx context.Context, op Op, key string, i int) (int, time.Duration, error) {
+	u := strings.TrimSuffix(c.Target, "/") + "/api/v1/some-data?key=" + url.QueryEscape(key)
+	var body io.Reader
+	method := "GET"
+	if op == OpWrite {
+		method = "POST"
+		b, _ := json.Marshal(struct {
+			Value string `json:"value"`
+		}{fmt.Sprintf("loadgen-%d", i)})
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if len(c.ClientIPs) > 0 {
+		req.Header.Set("X-Forwarded-For", c.ClientIPs[i%len(c.ClientIPs)])
+	}
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), nil
+}
@@ -0,0 +1,202 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	values := map[string]bool{}
+	forwarded := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		forwarded[r.Header.Get("X-Forwarded-For")] = true
+		key := r.URL.Query().Get("key")
+		switch {
+		case key == "broken":
+			rw.WriteHeader(http.StatusInternalServerError)
+		case r.Method == "POST":
+			values[key] = true
+			rw.WriteHeader(http.StatusCreated)
+		case !values[key]:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	res, err := Run(context.Background(), Config{
+		Target:      srv.URL,
+		Concurrency: 4,
+		Duration:    200 * time.Millisecond,
+		Keys:        []string{"a", "b", "broken"},
+		WriteRatio:  0.5,
+		ClientIPs:   []string{"10.0.0.1", "10.0.0.2"},
+		Token:       "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads, writes := res.Ops[OpRead], res.Ops[OpWrite]
+	if reads.Requests == 0 || writes.Requests == 0 || reads.Requests+writes.Requests != res.Total.Requests {
+		t.Fatalf("unexpected mix of %d reads and %d writes of %d requests", reads.Requests, writes.Requests, res.Total.Requests)
+	}
+	if res.Total.Errors == 0 || res.Total.Errors != res.Total.Statuses[http.StatusInternalServerError] {
+		t.Errorf("expected the 500s to be the errors, got %d errors and statuses %v", res.Total.Errors, res.Total.Statuses)
+	}
+	if reads.Misses != reads.Statuses[http.StatusNotFound] || writes.Misses != 0 {
+		t.Errorf("unexpected misses %d and %d, statuses %v", reads.Misses, writes.Misses, res.Total.Statuses)
+	}
+	if !forwarded["10.0.0.1"] || !forwarded["10.0.0.2"] || len(forwarded) != 2 {
+		t.Errorf("unexpected forwarded addresses %v", forwarded)
+	}
+	if p50, p99 := res.Total.Percentile(50), res.Total.Percentile(99); p50 <= 0 || p99 < p50 {
+		t.Errorf("unexpected percentiles p50 %s and p99 %s", p50, p99)
+	}
+
+	var report strings.Builder
+	if err := res.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"read", "write", "total", "p99", "500 x"} {
+		if !strings.Contains(report.String(), s) {
+			t.Errorf("report lacks %q:\n%s", s, report.String())
+		}
+	}
+}
+
+func TestRun_RPS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	res, err := Run(context.Background(), Config{
+		Target:       srv.URL,
+		RPS:          100,
+		Concurrency:  8,
+		Duration:     500 * time.Millisecond,
+		Keys:         []string{"a", "b", "c"},
+		Distribution: DistributionZipf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.Total.Requests; n < 40 || n > 55 {
+		t.Errorf("expected about 50 requests at 100 per second, got %d", n)
+	}
+	if res.Ops[OpWrite].Requests != 0 {
+		t.Errorf("expected only reads, got %d writes", res.Ops[OpWrite].Requests)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Target: "http://lb", Concurrency: 1, Duration: time.Second, Keys: []string{"k"}}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []func(*Config){
+		func(c *Config) { c.Target = "" },
+		func(c *Config) { c.Concurrency = 0 },
+		func(c *Config) { c.Duration = 0 },
+		func(c *Config) { c.RPS = -1 },
+		func(c *Config) { c.Keys = nil },
+		func(c *Config) { c.WriteRatio = 1.5 },
+		func(c *Config) { c.Distribution = "normal" },
+	} {
+		invalid := valid
+		c(&invalid)
+		if invalid.validate() == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	s := OpStats{}
+	if s.Percentile(50) != 0 {
+		t.Error("expected no latency without requests")
+	}
+	for i := 1; i <= 100; i++ {
+		s.latencies = append(s.latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{0: time.Millisecond, 50: 51 * time.Millisecond, 99: 100 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := s.Percentile(p); got != expected {
+			t.Errorf("Percentile(%v) = %s, expected %s", p, got, expected)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	res := newResult()
+	res.keepSamples = true
+	res.record(Sample{Start: 2 * time.Millisecond, Op: OpWrite, Key: "b", Status: http.StatusCreated, Latency: 3 * time.Millisecond})
+	res.record(Sample{Start: time.Millisecond, Op: OpRead, Key: "a", Status: http.StatusOK, Latency: 1500 * time.Microsecond})
+	res.record(Sample{Start: 3 * time.Millisecond, Op: OpRead, Key: "a", Err: errors.New("connection refused")})
+	res.Elapsed = time.Second
+	res.finish()
+
+	var out bytes.Buffer
+	if err := res.WriteJSON(&out, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	var exported struct {
+		Summary
+		Samples []sampleJSON `json:"samples"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Run != "abc123" || exported.Total.Requests != 3 || exported.Total.Errors != 1 ||
+		exported.Ops[OpRead].P50Ms != 1.5 || exported.Ops[OpWrite].MaxMs != 3 || exported.RPS != 3 {
+		t.Errorf("unexpected summary %+v", exported.Summary)
+	}
+	if len(exported.Samples) != 3 || exported.Samples[0].StartMs != 1 || exported.Samples[2].Error != "connection refused" {
+		t.Errorf("expected the samples in the order they were sent, got %+v", exported.Samples)
+	}
+
+	out.Reset()
+	if err := res.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "start_ms,op,key,status,latency_ms,error\n" +
+		"1.000,read,a,200,1.500,\n" +
+		"2.000,write,b,201,3.000,\n" +
+		"3.000,read,a,0,0.000,connection refused\n"
+	if out.String() != expected {
+		t.Errorf("unexpected CSV\n%s", out.String())
+	}
+
+	var pushed string
+	db := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/db/"+RunKeyPrefix+"abc123" {
+			http.NotFound(rw, r)
+			return
+		}
+		var entry dbclient.Entry
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+		pushed = entry.Value
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer db.Close()
+	if err := res.Push(context.Background(), dbclient.New(db.URL+"/db", dbclient.Options{}), "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	var summary Summary
+	if err := json.Unmarshal([]byte(pushed), &summary); err != nil || summary.Run != "abc123" || summary.Total.Requests != 3 {
+		t.Errorf("unexpected pushed summary %q, %v", pushed, err)
+	}
+}
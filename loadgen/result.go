@@ -0,0 +1,162 @@
+package loadgen
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type Op string
+
+const (
+	OpRead  Op = "read"
+	OpWrite Op = "write"
+)
+
+// OpStats are the outcomes of the requests of an operation. Requests
+// failing to get a response or getting one other than 2xx are errors,
+// except reads of missing keys, which are counted as misses.
+type OpStats struct {
+	Requests int
+	Errors   int
+	Misses   int
+	Statuses map[int]int
+	// latencies of all the requests getting a response, sorted once the
+	// run is over.
+	latencies []time.Duration
+}
+
+// ErrorRate is the fraction of the requests that failed.
+func (s *OpStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// Percentile returns the latency p percent of the requests were faster
+// than, e.g. Percentile(99).
+func (s *OpStats) Percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(s.latencies)))
+	return s.latencies[min(max(i, 0), len(s.latencies)-1)]
+}
+
+// Sample is a request of the run.
+type Sample struct {
+	// Start is when the request was sent since the start of the run.
+	Start   time.Duration
+	Op      Op
+	Key     string
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+type Result struct {
+	mu      sync.Mutex
+	Elapsed time.Duration
+	Ops     map[Op]*OpStats
+	Total   OpStats
+	// Samples are the requests in the order they were sent, if
+	// Config.Samples is set.
+	Samples []Sample
+
+	keepSamples bool
+}
+
+func newResult() *Result {
+	return &Result{Ops: map[Op]*OpStats{
+		OpRead:  {Statuses: map[int]int{}},
+		OpWrite: {Statuses: map[int]int{}},
+	}, Total: OpStats{Statuses: map[int]int{}}}
+}
+
+func (r *Result) record(sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keepSamples {
+		r.Samples = append(r.Samples, sample)
+	}
+	for _, s := range []*OpStats{r.Ops[sample.Op], &r.Total} {
+		s.Requests++
+		switch {
+		case sample.Err != nil:
+			s.Errors++
+			continue
+		case sample.Op == OpRead && sample.Status == http.StatusNotFound:
+			s.Misses++
+		case sample.Status < 200 || sample.Status >= 300:
+			s.Errors++
+		}
+		s.Statuses[sample.Status]++
+		s.latencies = append(s.latencies, sample.Latency)
+	}
+}
+
+func (r *Result) finish() {
+	slices.Sort(r.Total.latencies)
+	for _, s := range r.Ops {
+		slices.Sort(s.latencies)
+	}
+	slices.SortStableFunc(r.Samples, func(a, b Sample) int { return cmp.Compare(a.Start, b.Start) })
+}
+
+// RPS is the rate the requests were sent at.
+func (r *Result) RPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Total.Requests) / r.Elapsed.Seconds()
+}
+
+// WriteReport prints the latency percentiles and the error rates of the
+// operations and of all the requests.
+func (r *Result) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\trequests\terrors\tmisses\tp50\tp90\tp99\tmax\t\n")
+	row := func(name string, s *OpStats) {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%d\t%s\t%s\t%s\t%s\t\n", name, s.Requests, 100*s.ErrorRate(), s.Misses,
+			round(s.Percentile(50)), round(s.Percentile(90)), round(s.Percentile(99)), round(s.Percentile(100)))
+	}
+	for _, op := range []Op{OpRead, OpWrite} {
+		if s := r.Ops[op]; s.Requests > 0 {
+			row(string(op), s)
+		}
+	}
+	row("total", &r.Total)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%.1f requests/s over %s, statuses: %s\n", r.RPS(), round(r.Elapsed), statuses(r.Total.Statuses))
+	return err
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+func statuses(counts map[int]int) string {
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code) + " x" + strconv.Itoa(counts[code])
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
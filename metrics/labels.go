@@ -0,0 +1,33 @@
+package metrics
+
+import "os"
+
+// The labels shared by the metrics of all the services, so that the
+// dashboards can select and group the series of any of them alike.
+const (
+	// LabelService names the command exporting the series: lb, server or db.
+	LabelService = "service"
+	// LabelInstance tells the replicas of a service apart.
+	LabelInstance = "instance"
+	// LabelRoute is the route pattern of an HTTP request, never its path,
+	// which would make a series per key.
+	LabelRoute  = "route"
+	LabelMethod = "method"
+	// LabelCode is the HTTP status of a response.
+	LabelCode = "code"
+)
+
+// InstanceEnv overrides the instance label, which is the host name
+// otherwise, i.e. the container ID under docker-compose.
+const InstanceEnv = "METRICS_INSTANCE"
+
+// Configure labels the series of the Default registry with the service
+// and its instance. The scraping Prometheus keeps them as
+// exported_instance unless honor_labels is set.
+func Configure(service string) {
+	instance := os.Getenv(InstanceEnv)
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	Default.SetConstLabels(LabelService, service, LabelInstance, instance)
+}
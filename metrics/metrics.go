@@ -0,0 +1,297 @@
+// Package metrics exports the metrics of the services in the Prometheus
+// text format. The services label their series alike, see Configure and
+// the Label constants, and name the HTTP metrics the same through
+// httptools.Metrics, so one dashboard covers the whole stack.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency histogram buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	// write renders the series, with the const labels, name-value pairs,
+	// before their own.
+	write(w io.Writer, constLabels []string)
+}
+
+// Registry holds metrics and renders them in the Prometheus text format.
+type Registry struct {
+	mu          sync.Mutex
+	names       map[string]bool
+	collectors  []collector
+	constLabels []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+var Default = NewRegistry()
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// SetConstLabels labels every series of the registry with the name-value
+// pairs, e.g. SetConstLabels("service", "lb").
+func (r *Registry) SetConstLabels(pairs ...string) {
+	if len(pairs)%2 != 0 {
+		panic("const labels must be name-value pairs")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.constLabels = append([]string(nil), pairs...)
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	constLabels := r.constLabels
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w, constLabels)
+	}
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("content-type", "text/plain; version=0.0.4")
+	rw.WriteHeader(http.StatusOK)
+	r.Write(rw)
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(constLabels, names, values []string, extra ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(constLabels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", constLabels[i], constLabels[i+1]))
+	}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type value struct {
+	labels []string
+	v      float64
+}
+
+// values is a set of label values to number series shared by counters and gauges.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*value
+}
+
+func (s *values) get(labels []string) *value {
+	key := s.key(labels)
+	v, ok := s.series[key]
+	if !ok {
+		v = &value{labels: append([]string(nil), labels...)}
+		s.series[key] = v
+	}
+	return v
+}
+
+func (s *values) write(w io.Writer, constLabels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header(w)
+	keys := make([]string, 0, len(s.series))
+	for key := range s.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := s.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", s.name, formatLabels(constLabels, s.labels, v.labels), formatValue(v.v))
+	}
+}
+
+// CounterVec is a family of monotonically increasing counters.
+type CounterVec struct {
+	values
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{values{desc: desc{name, help, "counter", labels}, series: make(map[string]*value)}}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labels).v += delta
+}
+
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// GaugeVec is a family of values that can go up and down.
+type GaugeVec struct {
+	values
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{values{desc: desc{name, help, "gauge", labels}, series: make(map[string]*value)}}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labels).v = v
+}
+
+func (g *GaugeVec) Add(delta float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labels).v += delta
+}
+
+// Reset drops all the series, e.g. when the labelled objects are gone.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series = make(map[string]*value)
+}
+
+// GaugeFunc is a gauge family whose series are produced on every scrape.
+type GaugeFunc struct {
+	desc
+	collect func(emit func(v float64, labels ...string))
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(emit func(v float64, labels ...string))) *GaugeFunc {
+	g := &GaugeFunc{desc{name, help, "gauge", labels}, collect}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer, constLabels []string) {
+	g.header(w)
+	g.collect(func(v float64, labels ...string) {
+		g.key(labels)
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(constLabels, g.labels, labels), formatValue(v))
+	})
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a family of histograms with cumulative buckets.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer, constLabels []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(constLabels, h.labels, s.labels, "le", formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(constLabels, h.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(constLabels, h.labels, s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(constLabels, h.labels, s.labels), s.count)
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests.", "backend", "code")
+	inFlight := r.NewGauge("in_flight", "In-flight requests.", "backend")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "backend")
+	r.NewGaugeFunc("up", "Whether the backend is up.", []string{"backend"}, func(emit func(float64, ...string)) {
+		emit(1, "server1")
+	})
+
+	requests.Inc("server1", "200")
+	requests.Add(2, "server1", "200")
+	inFlight.Add(1, "server1")
+	latency.Observe(0.05, "server1")
+	latency.Observe(0.5, "server1")
+
+	var out strings.Builder
+	r.Write(&out)
+
+	expected := []string{
+		"# TYPE requests_total counter",
+		`requests_total{backend="server1",code="200"} 3`,
+		`in_flight{backend="server1"} 1`,
+		`latency_seconds_bucket{backend="server1",le="0.1"} 1`,
+		`latency_seconds_bucket{backend="server1",le="1"} 2`,
+		`latency_seconds_bucket{backend="server1",le="+Inf"} 2`,
+		`latency_seconds_sum{backend="server1"} 0.55`,
+		`latency_seconds_count{backend="server1"} 2`,
+		`up{backend="server1"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestRegistry_ConstLabels(t *testing.T) {
+	r := NewRegistry()
+	r.SetConstLabels(LabelService, "db", LabelInstance, "db-1")
+	r.NewCounter("requests_total", "Requests.", "code").Inc("200")
+	r.NewHistogram("latency_seconds", "Latency.", []float64{1}).Observe(0.5)
+	r.NewGaugeFunc("up", "Up.", nil, func(emit func(float64, ...string)) { emit(1) })
+
+	var out strings.Builder
+	r.Write(&out)
+	for _, line := range []string{
+		`requests_total{service="db",instance="db-1",code="200"} 1`,
+		`latency_seconds_bucket{service="db",instance="db-1",le="1"} 1`,
+		`latency_seconds_count{service="db",instance="db-1"} 1`,
+		`up{service="db",instance="db-1"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
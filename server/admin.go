@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	confResponseDelaySec = "CONF_RESPONSE_DELAY_SEC"
+	confHealthFailure    = "CONF_HEALTH_FAILURE"
+)
+
+// maxResponseDelay bounds the injected delay, longer ones would outlive
+// the timeouts of the balancer and the clients anyway.
+const maxResponseDelay = 5 * time.Minute
+
+var (
+	responseDelayFlag = flags.Duration("response-delay", envSeconds(confResponseDelaySec), "delay of every some-data response, defaults to $"+confResponseDelaySec+" seconds")
+	healthFailureFlag = flags.Bool("health-failure", os.Getenv(confHealthFailure) == "true", "report the server as not ready, defaults to $"+confHealthFailure)
+)
+
+func envSeconds(name string) time.Duration {
+	sec, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || sec <= 0 {
+		return 0
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// faults are the misbehaviours tests inject into the server. They start
+// from the flags and can be changed at runtime through /admin/faults.
+var faults struct {
+	responseDelay atomic.Int64
+	healthFailure atomic.Bool
+}
+
+func initFaults() {
+	faults.responseDelay.Store(int64(*responseDelayFlag))
+	faults.healthFailure.Store(*healthFailureFlag)
+}
+
+func responseDelay() time.Duration {
+	return time.Duration(faults.responseDelay.Load())
+}
+
+func validResponseDelay(d time.Duration) bool {
+	return d >= 0 && d <= maxResponseDelay
+}
+
+// faultState is the JSON form of faults. Updates leave the omitted fields
+// as they are.
+type faultState struct {
+	ResponseDelay *string `json:"responseDelay,omitempty"`
+	HealthFailure *bool   `json:"healthFailure,omitempty"`
+}
+
+func currentFaults() faultState {
+	delay, failure := responseDelay().String(), faults.healthFailure.Load()
+	return faultState{ResponseDelay: &delay, HealthFailure: &failure}
+}
+
+func serveFaults(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(currentFaults())
+}
+
+// updateFaults applies the state in the body, all of it or nothing.
+func updateFaults(rw http.ResponseWriter, r *http.Request) {
+	if !authorized(rw, r) {
+		return
+	}
+	var update faultState
+	dec := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxValueSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&update); err != nil {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid body: %s", err))
+		return
+	}
+	delay := responseDelay()
+	if update.ResponseDelay != nil {
+		d, err := time.ParseDuration(*update.ResponseDelay)
+		if err != nil || !validResponseDelay(d) {
+			writeJSONError(rw, http.StatusBadRequest, "response delay must be a duration between 0 and "+maxResponseDelay.String())
+			return
+		}
+		delay = d
+	}
+	faults.responseDelay.Store(int64(delay))
+	if update.HealthFailure != nil {
+		faults.healthFailure.Store(*update.HealthFailure)
+	}
+	serveFaults(rw, r)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateFaults(t *testing.T) {
+	defer func(delay time.Duration, failure bool, tokens []string) {
+		faults.responseDelay.Store(int64(delay))
+		faults.healthFailure.Store(failure)
+		apiTokens = tokens
+	}(responseDelay(), faults.healthFailure.Load(), apiTokens)
+	faults.responseDelay.Store(0)
+	faults.healthFailure.Store(false)
+	apiTokens = []string{"secret"}
+
+	update := func(body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		updateFaults(rw, r)
+		return rw
+	}
+
+	if rw := update(`{"healthFailure": true}`, "wrong"); rw.Code != http.StatusForbidden || faults.healthFailure.Load() {
+		t.Errorf("expected the update to be forbidden, got %d", rw.Code)
+	}
+	for _, body := range []string{`{"responseDelay": "1h"}`, `{"responseDelay": "-1s"}`, `{"responseDelay": 3}`, `{"delay": "1s"}`} {
+		if rw := update(body, "secret"); rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rw.Code)
+		}
+	}
+	if responseDelay() != 0 {
+		t.Errorf("rejected updates changed the delay to %s", responseDelay())
+	}
+
+	rw := update(`{"responseDelay": "2s", "healthFailure": true}`, "secret")
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"responseDelay":"2s"`) {
+		t.Errorf("unexpected response %d %s", rw.Code, rw.Body)
+	}
+	if responseDelay() != 2*time.Second || !faults.healthFailure.Load() {
+		t.Errorf("faults not updated: %s, %v", responseDelay(), faults.healthFailure.Load())
+	}
+
+	// Omitted fields are kept.
+	update(`{"healthFailure": false}`, "secret")
+	if responseDelay() != 2*time.Second || faults.healthFailure.Load() {
+		t.Errorf("unexpected faults: %s, %v", responseDelay(), faults.healthFailure.Load())
+	}
+}
+
+func TestEnvSeconds(t *testing.T) {
+	t.Setenv(confResponseDelaySec, "3")
+	if d := envSeconds(confResponseDelaySec); d != 3*time.Second {
+		t.Errorf("expected 3s, got %s", d)
+	}
+	t.Setenv(confResponseDelaySec, "soon")
+	if d := envSeconds(confResponseDelaySec); d != 0 {
+		t.Errorf("expected no delay, got %s", d)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+const (
+	apiTokenEnv      = "API_TOKEN"
+	apiTokensFileEnv = "API_TOKENS_FILE"
+)
+
+var (
+	apiToken      = flags.String("api-token", envOr(apiTokenEnv, ""), "comma-separated bearer tokens accepted by /api/v1/ and /admin/, overrides $"+apiTokenEnv+" (no tokens allow anyone)")
+	apiTokensFile = flags.String("api-tokens-file", envOr(apiTokensFileEnv, ""), "file with a bearer token per line accepted in addition to -api-token, overrides $"+apiTokensFileEnv)
+)
+
+// apiTokens are the accepted bearer tokens. It is set in run once the
+// flags are parsed.
+var apiTokens []string
+
+// loadTokens collects the tokens of -api-token and -api-tokens-file, in
+// which empty lines and lines starting with # are skipped.
+func loadTokens() ([]string, error) {
+	tokens := splitTokens(strings.Split(*apiToken, ","))
+	if *apiTokensFile == "" {
+		return tokens, nil
+	}
+	data, err := os.ReadFile(*apiTokensFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the tokens file: %w", err)
+	}
+	fromFile := splitTokens(strings.Split(string(data), "\n"))
+	if len(fromFile) == 0 {
+		return nil, fmt.Errorf("tokens file %s has no tokens", *apiTokensFile)
+	}
+	return append(tokens, fromFile...), nil
+}
+
+func splitTokens(lines []string) []string {
+	var tokens []string
+	for _, line := range lines {
+		if token := strings.TrimSpace(line); token != "" && !strings.HasPrefix(token, "#") {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// authorized checks the bearer token of the request, responding with 401
+// or 403 if it is not one of the configured ones.
+func authorized(rw http.ResponseWriter, r *http.Request) bool {
+	return httptools.Authorize(rw, r, "api", apiTokens, writeJSONError)
+}
+
+// requireToken lets only authorized requests through to next. The probes
+// are not behind it, so the balancer needs no token.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if authorized(rw, r) {
+			next.ServeHTTP(rw, r)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTokens(t *testing.T) {
+	defer func(token, file string) { *apiToken, *apiTokensFile = token, file }(*apiToken, *apiTokensFile)
+	file := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(file, []byte("# ci\nci-token\n\n  ops-token  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	*apiToken, *apiTokensFile = "a, b", file
+	tokens, err := loadTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b", "ci-token", "ops-token"}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+
+	*apiToken, *apiTokensFile = "", ""
+	if tokens, err := loadTokens(); err != nil || len(tokens) != 0 {
+		t.Errorf("expected no tokens, got %v, %v", tokens, err)
+	}
+
+	*apiTokensFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := loadTokens(); err == nil {
+		t.Error("expected an error for a missing tokens file")
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	defer func(tokens []string) { apiTokens = tokens }(apiTokens)
+	handler := requireToken(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw
+	}
+
+	apiTokens = nil
+	if rw := serve(""); rw.Code != http.StatusOK {
+		t.Errorf("expected open access without tokens, got %d", rw.Code)
+	}
+
+	apiTokens = []string{"ci-token", "ops-token"}
+	for authorization, status := range map[string]int{
+		"":                   http.StatusUnauthorized,
+		"Basic b3BzOm9wcw==": http.StatusUnauthorized,
+		"Bearer wrong":       http.StatusForbidden,
+		"Bearer ci-token":    http.StatusOK,
+		"Bearer ops-token":   http.StatusOK,
+	} {
+		rw := serve(authorization)
+		if rw.Code != status {
+			t.Errorf("%q: expected %d, got %d", authorization, status, rw.Code)
+		}
+		if status == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: missing WWW-Authenticate", authorization)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+var (
+	cacheTTL  = flags.Duration("cache-ttl", 2*time.Second, "how long db responses are served from the cache (0 disables the cache)")
+	cacheSize = flags.Int("cache-size", 1000, "maximum number of keys in the response cache")
+)
+
+// cachedResponse is a db response for a key, entry is nil if the key
+// was not found. Entries with validators are revalidated with the db
+// once they expire instead of refetched.
+type cachedResponse struct {
+	entry   *dbclient.Entry
+	expires time.Time
+}
+
+func (cr *cachedResponse) revalidatable() bool {
+	return cr.entry != nil && (cr.entry.ETag != "" || cr.entry.LastModified != "")
+}
+
+// responseCache keeps db responses per key for the TTL.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*cachedResponse
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	return &responseCache{ttl: ttl, size: size, entries: make(map[string]*cachedResponse)}
+}
+
+// get returns the cached response for key, if any, and whether it is
+// still fresh.
+func (c *responseCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if cr.expires.After(now) {
+		return cr, true
+	}
+	if !cr.revalidatable() {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cr, false
+}
+
+// set caches a copy of cr for the TTL. The response passed in may be one
+// returned by get and still read by other requests, so it is not changed.
+func (c *responseCache) set(key string, cr *cachedResponse, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	stored := &cachedResponse{entry: cr.entry, expires: now.Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[key] = stored
+}
+
+// evict drops the expired entries or, if there are none, an arbitrary one.
+func (c *responseCache) evict(now time.Time) {
+	var victim string
+	for key, cr := range c.entries {
+		if !cr.expires.After(now) {
+			delete(c.entries, key)
+		}
+		victim = key
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, victim)
+	}
+}
+
+func (c *responseCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// cache is replaced in run once the flags are parsed.
+var cache = newResponseCache(0, 0)
+
+func writeCached(rw http.ResponseWriter, cr *cachedResponse) {
+	if cr.entry == nil {
+		writeJSONError(rw, http.StatusNotFound, "key not found")
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(cr.entry)
+}
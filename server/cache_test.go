@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(time.Second, 2)
+	now := time.Now()
+	c.set("a", &cachedResponse{entry: &dbclient.Entry{Key: "a", Value: "a"}}, now)
+	c.set("b", &cachedResponse{entry: &dbclient.Entry{Key: "b", Value: "b", ETag: `"1"`}}, now)
+
+	if cr, fresh := c.get("a", now.Add(time.Millisecond)); !fresh || cr.entry.Value != "a" {
+		t.Errorf("expected a fresh entry, got %v %t", cr, fresh)
+	}
+	if cr, fresh := c.get("a", now.Add(2*time.Second)); fresh || cr != nil {
+		t.Errorf("expired entries without validators must be dropped")
+	}
+	if cr, fresh := c.get("b", now.Add(2*time.Second)); fresh || cr == nil || cr.entry.ETag != `"1"` {
+		t.Errorf("expired entries with validators must be kept for revalidation")
+	}
+
+	c.set("c", &cachedResponse{}, now)
+	c.set("d", &cachedResponse{}, now)
+	if len(c.entries) > 2 {
+		t.Errorf("the cache grew over its size: %d", len(c.entries))
+	}
+	c.invalidate("d")
+	if _, fresh := c.get("d", now); fresh {
+		t.Error("invalidated entry is still cached")
+	}
+
+	disabled := newResponseCache(0, 10)
+	disabled.set("a", &cachedResponse{}, now)
+	if cr, _ := disabled.get("a", now); cr != nil {
+		t.Error("a disabled cache must not keep entries")
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	dbUrlEnv    = "DB_URL"
+	teamNameEnv = "TEAM_NAME"
+)
+
+var (
+	dbUrl    = flags.String("db-url", envOr(dbUrlEnv, "http://db:5432/db"), "base URL of the db service API, overrides $"+dbUrlEnv)
+	teamName = flags.String("team-name", envOr(teamNameEnv, "breaking_code"), "team name the server registers in the db, overrides $"+teamNameEnv)
+)
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// validateConfig checks the flags and normalizes the db URL.
+func validateConfig() error {
+	u, err := url.Parse(*dbUrl)
+	if err != nil {
+		return fmt.Errorf("invalid db URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("db URL must be an absolute http(s) URL: %q", *dbUrl)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("db URL cannot have a query or fragment: %q", *dbUrl)
+	}
+	*dbUrl = strings.TrimSuffix(u.String(), "/")
+	if *debugPort != 0 && *debugPort == *port {
+		return fmt.Errorf("the debug port must differ from the server port")
+	}
+	if *dbTimeout <= 0 || *dbRetries < 0 {
+		return fmt.Errorf("db timeout must be positive and retries cannot be negative")
+	}
+	if *breakerFailures < 0 || (*breakerFailures > 0 && *breakerCooldown <= 0) {
+		return fmt.Errorf("breaker failures cannot be negative and its cooldown must be positive")
+	}
+	if *maxConcurrent < 0 || *limitRetryAfter < 0 {
+		return fmt.Errorf("concurrency limit and its retry after cannot be negative")
+	}
+	if !validResponseDelay(*responseDelayFlag) {
+		return fmt.Errorf("response delay must be between 0 and %s", maxResponseDelay)
+	}
+	if *teamName == "" || strings.ContainsAny(*teamName, "/?#") {
+		return fmt.Errorf("team name must be a non-empty key without /, ? or #: %q", *teamName)
+	}
+	return nil
+}
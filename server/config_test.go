@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestValidateConfig(t *testing.T) {
+	defer func(u, team string) { *dbUrl, *teamName = u, team }(*dbUrl, *teamName)
+
+	for _, tc := range []struct {
+		url, team string
+		valid     bool
+	}{
+		{"http://db:5432/db/", "breaking_code", true},
+		{"https://replica.local/db", "team", true},
+		{"db:5432/db", "team", false},
+		{"http:///db", "team", false},
+		{"http://db:5432/db?x=1", "team", false},
+		{"http://db:5432/db", "", false},
+		{"http://db:5432/db", "a/b", false},
+	} {
+		*dbUrl, *teamName = tc.url, tc.team
+		if err := validateConfig(); (err == nil) != tc.valid {
+			t.Errorf("validateConfig(%q, %q) = %v", tc.url, tc.team, err)
+		}
+	}
+
+	*dbUrl, *teamName = "http://db:5432/db/", "team"
+	if err := validateConfig(); err != nil || *dbUrl != "http://db:5432/db" {
+		t.Errorf("db URL is not normalized: %q, %v", *dbUrl, err)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// maxValueSize bounds the body of write requests.
+const maxValueSize = 1 << 20
+
+// dataValue is the body of write requests.
+type dataValue struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string]string{"error": message})
+}
+
+// maxKeyLen bounds the keys accepted by the API.
+const maxKeyLen = 256
+
+// validKey reports whether key can be used as a db key in a URL path.
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLen {
+		return false
+	}
+	for _, c := range key {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune("/?#%\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestContext is the context of the db requests made for r, which
+// carry its request ID. The ID is echoed in the response.
+func requestContext(rw http.ResponseWriter, r *http.Request) context.Context {
+	id := r.Header.Get(requestIdHeader)
+	if id == "" {
+		return r.Context()
+	}
+	rw.Header().Set(requestIdHeader, id)
+	return dbclient.WithRequestId(r.Context(), id)
+}
+
+// dbFailure responds to a failed request to the db: 503 when the circuit
+// breaker is open, 504 when the db timed out, the db's status when it
+// rejected the request and 502 otherwise.
+func dbFailure(rw http.ResponseWriter, err error) {
+	var se *dbclient.StatusError
+	switch {
+	case errors.Is(err, dbclient.ErrCircuitOpen):
+		writeUnavailable(rw)
+	case errors.Is(err, dbclient.ErrTimeout):
+		writeJSONError(rw, http.StatusGatewayTimeout, "db did not respond in time")
+	case errors.Is(err, dbclient.ErrNotFound):
+		writeJSONError(rw, http.StatusNotFound, "key not found")
+	case errors.As(err, &se) && se.Status < http.StatusInternalServerError:
+		writeJSONError(rw, se.Status, se.Message)
+	default:
+		writeJSONError(rw, http.StatusBadGateway, "db is unavailable")
+	}
+}
+
+// readData responds with the value of the key of the query, served from
+// the cache while it is fresh.
+func readData(rw http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !validKey(key) {
+		writeJSONError(rw, http.StatusBadRequest, "missing or invalid key")
+		return
+	}
+	ctx := requestContext(rw, r)
+	now := time.Now()
+	cached, fresh := cache.get(key, now)
+	if fresh {
+		rw.Header().Set("x-cache", "HIT")
+		writeCached(rw, cached)
+		return
+	}
+
+	var entry *dbclient.Entry
+	var err error
+	if cached != nil {
+		entry, err = db.Revalidate(ctx, cached.entry)
+	} else {
+		entry, err = db.Get(ctx, key)
+	}
+	switch {
+	case errors.Is(err, dbclient.ErrNotModified):
+		cache.set(key, cached, now)
+		rw.Header().Set("x-cache", "REVALIDATED")
+		writeCached(rw, cached)
+		return
+	case errors.Is(err, dbclient.ErrNotFound):
+		entry = nil
+	case err != nil:
+		dbFailure(rw, err)
+		return
+	}
+	fetched := &cachedResponse{entry: entry}
+	cache.set(key, fetched, now)
+	rw.Header().Set("x-cache", "MISS")
+	writeCached(rw, fetched)
+}
+
+// writeData stores the value of the JSON body under the key of the query
+// in the db.
+func writeData(rw http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !validKey(key) {
+		writeJSONError(rw, http.StatusBadRequest, "missing or invalid key")
+		return
+	}
+	if ct := r.Header.Get("content-type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		writeJSONError(rw, http.StatusUnsupportedMediaType, "expected a JSON body")
+		return
+	}
+	var data dataValue
+	dec := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxValueSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&data); err != nil {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid body: %s", err))
+		return
+	}
+	if dec.More() {
+		writeJSONError(rw, http.StatusBadRequest, "invalid body: trailing data")
+		return
+	}
+	if data.Key != "" && data.Key != key {
+		writeJSONError(rw, http.StatusBadRequest, "the body is for another key")
+		return
+	}
+
+	ctx := requestContext(rw, r)
+	created, err := db.Put(ctx, key, data.Value)
+	if err != nil {
+		dbFailure(rw, err)
+		return
+	}
+	cache.invalidate(key)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	data.Key = key
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(data)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestWriteData(t *testing.T) {
+	stored := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var v dataValue
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &v); err != nil || r.Method != "PUT" {
+			t.Errorf("unexpected request %s %s", r.Method, body)
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		if v.Value == "forbidden" {
+			http.Error(rw, "value is not allowed", http.StatusUnprocessableEntity)
+			return
+		}
+		_, existed := stored[key]
+		stored[key] = v.Value
+		if existed {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client) { db = c }(db)
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+
+	for _, tc := range []struct {
+		target, body string
+		status       int
+	}{
+		{"/api/v1/some-data?key=k1", `{"value":"v1"}`, http.StatusCreated},
+		{"/api/v1/some-data?key=k1", `{"key":"k1","value":"v2"}`, http.StatusOK},
+		{"/api/v1/some-data?key=k1", `{"key":"k2","value":"v2"}`, http.StatusBadRequest},
+		{"/api/v1/some-data", `{"value":"v1"}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k1", `{"value":1}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k1", `{"value":"v1","extra":true}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k1", `{"value":"v1"} {}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k1", `{"value":"forbidden"}`, http.StatusUnprocessableEntity},
+	} {
+		rw := httptest.NewRecorder()
+		writeData(rw, httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body)))
+		if rw.Code != tc.status {
+			t.Errorf("POST %s %s: expected %d, got %d %s", tc.target, tc.body, tc.status, rw.Code, rw.Body)
+		}
+	}
+	if stored["k1"] != "v2" {
+		t.Errorf("unexpected value %q", stored["k1"])
+	}
+}
+
+func TestReadData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/db/") {
+		case "present":
+			_, _ = rw.Write([]byte(`{"key":"present","value":"v"}`))
+		case "broken":
+			rw.WriteHeader(http.StatusInternalServerError)
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client) { db = c }(db)
+	db = dbclient.New(server.URL+"/db", dbclient.Options{Timeout: 50 * time.Millisecond})
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/some-data?key=present", http.StatusOK},
+		{"/api/v1/some-data?key=missing", http.StatusNotFound},
+		{"/api/v1/some-data", http.StatusBadRequest},
+		{"/api/v1/some-data?key=a%2Fb", http.StatusBadRequest},
+		{"/api/v1/some-data?key=broken", http.StatusBadGateway},
+		{"/api/v1/some-data?key=slow", http.StatusGatewayTimeout},
+	} {
+		rw := httptest.NewRecorder()
+		readData(rw, httptest.NewRequest("GET", tc.target, nil))
+		if rw.Code != tc.status {
+			t.Errorf("GET %s: expected %d, got %d %s", tc.target, tc.status, rw.Code, rw.Body)
+		}
+		if ct := rw.Header().Get("content-type"); ct != "application/json" {
+			t.Errorf("GET %s: unexpected content type %q", tc.target, ct)
+		}
+	}
+}
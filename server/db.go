@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+var (
+	dbTimeout       = flags.Duration("db-timeout", 5*time.Second, "timeout of requests to the db")
+	dbRetries       = flags.Int("db-retries", 1, "extra attempts of db requests failing with a timeout or a server error")
+	breakerFailures = flags.Int("breaker-failures", 5, "consecutive failed db requests that open the circuit breaker (0 disables it)")
+	breakerCooldown = flags.Duration("breaker-cooldown", 10*time.Second, "how long the open circuit breaker fails db requests before letting one through")
+)
+
+// dbRetryBackoff is the wait before the first retry of a db request.
+const dbRetryBackoff = 50 * time.Millisecond
+
+// db is used for every request to the db. It is replaced in run once
+// the flags are parsed.
+var db = newDb()
+
+func newDb() *dbclient.Client {
+	return dbclient.New(*dbUrl, dbclient.Options{
+		Timeout:         *dbTimeout,
+		Retries:         *dbRetries,
+		Backoff:         dbRetryBackoff,
+		BreakerFailures: *breakerFailures,
+		BreakerCooldown: *breakerCooldown,
+	})
+}
+
+// writeUnavailable responds to a request failed by the open breaker.
+func writeUnavailable(rw http.ResponseWriter) {
+	seconds := int((db.RetryAfter() + time.Second - 1) / time.Second)
+	rw.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	writeJSONError(rw, http.StatusServiceUnavailable, dbclient.ErrCircuitOpen.Error())
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+var debugPort = flags.Int("debug-port", 0, "port of the internal listener serving pprof profiles under /debug/pprof/ (0 disables it)")
+
+// debugHandler serves the profiles of net/http/pprof. It is not exposed
+// on the main port, which the balancer forwards clients to.
+func debugHandler() http.Handler {
+	h := http.NewServeMux()
+	h.HandleFunc("/debug/pprof/", pprof.Index)
+	h.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	h.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	h.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	h.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return h
+}
+
+// startDebugServer serves the profiles without the write timeout of the
+// regular servers, which would cut CPU profiles and traces short.
+func startDebugServer(port int) {
+	go func() {
+		log.Printf("Serving profiles on port %d", port)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", port), debugHandler())
+		log.Printf("Debug server finished: %s", err)
+	}()
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	debugHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("expected the goroutine profile, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+const readinessDbTimeout = time.Second
+
+// registered is set once the seed entries have been written to the db.
+var registered atomic.Bool
+
+func writeProbe(rw http.ResponseWriter, problem string) {
+	rw.Header().Set("content-type", "text/plain")
+	if problem != "" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("FAILURE: " + problem))
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+// serveLive reports that the process is up and serving.
+func serveLive(rw http.ResponseWriter, _ *http.Request) {
+	writeProbe(rw, "")
+}
+
+// serveReady reports whether the server should receive traffic: it is
+// registered, the db is reachable and no failure is configured.
+func serveReady(rw http.ResponseWriter, r *http.Request) {
+	writeProbe(rw, readinessProblem(r.Context()))
+}
+
+func readinessProblem(ctx context.Context) string {
+	if faults.healthFailure.Load() {
+		return "failure configured"
+	}
+	if !registered.Load() {
+		return "not registered in the db"
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessDbTimeout)
+	defer cancel()
+	if problem := dbProblem(ctx); problem != "" {
+		return "db is unreachable: " + problem
+	}
+	return ""
+}
+
+// dbProblem describes why the db cannot be read, if it cannot.
+func dbProblem(ctx context.Context) string {
+	if _, err := db.Get(ctx, *teamName); err != nil && !errors.Is(err, dbclient.ErrNotFound) {
+		return err.Error()
+	}
+	return ""
+}
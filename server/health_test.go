@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestLivenessAndReadiness(t *testing.T) {
+	dbUp := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !dbUp {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client, reg, failure bool) {
+		db = c
+		registered.Store(reg)
+		faults.healthFailure.Store(failure)
+	}(db, registered.Load(), faults.healthFailure.Load())
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+
+	probe := func(handler http.HandlerFunc) (int, string) {
+		rw := httptest.NewRecorder()
+		handler(rw, httptest.NewRequest("GET", "/", nil))
+		return rw.Code, rw.Body.String()
+	}
+	expect := func(handler http.HandlerFunc, status int, body string) {
+		t.Helper()
+		if code, text := probe(handler); code != status || !strings.Contains(text, body) {
+			t.Errorf("expected %d %q, got %d %q", status, body, code, text)
+		}
+	}
+
+	registered.Store(false)
+	expect(serveLive, http.StatusOK, "OK")
+	expect(serveReady, http.StatusServiceUnavailable, "not registered")
+
+	registered.Store(true)
+	expect(serveReady, http.StatusOK, "OK")
+
+	dbUp = false
+	expect(serveReady, http.StatusServiceUnavailable, "db is unreachable")
+	dbUp = true
+
+	faults.healthFailure.Store(true)
+	expect(serveReady, http.StatusServiceUnavailable, "failure configured")
+	expect(serveLive, http.StatusOK, "OK")
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// maxListedKeys is the largest page of keys the db returns.
+const maxListedKeys = 1000
+
+// listKeys responds with the keys starting with the prefix of the query.
+func listKeys(rw http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix != "" && !validKey(prefix) {
+		writeJSONError(rw, http.StatusBadRequest, "invalid prefix")
+		return
+	}
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListedKeys {
+			writeJSONError(rw, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxListedKeys))
+			return
+		}
+		limit = n
+	}
+	list, err := db.Keys(requestContext(rw, r), prefix, limit)
+	if err != nil {
+		dbFailure(rw, err)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(list)
+}
+
+// deleteData removes the key of the query from the db.
+func deleteData(rw http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !validKey(key) {
+		writeJSONError(rw, http.StatusBadRequest, "missing or invalid key")
+		return
+	}
+	err := db.Delete(requestContext(rw, r), key)
+	cache.invalidate(key)
+	if err != nil {
+		dbFailure(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
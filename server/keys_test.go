@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestKeysAndDelete(t *testing.T) {
+	values := map[string]bool{"user:1": true, "user:2": true}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/db":
+			_ = json.NewEncoder(rw).Encode(dbclient.KeyList{Keys: []string{"user:1", "user:2"}})
+		case r.Method == "DELETE" && values[r.URL.Path[len("/db/"):]]:
+			delete(values, r.URL.Path[len("/db/"):])
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client) { db = c }(db)
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+
+	send := func(method, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		rw := httptest.NewRecorder()
+		if method == "DELETE" {
+			deleteData(rw, r)
+		} else {
+			listKeys(rw, r)
+		}
+		return rw
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/api/v1/keys?prefix=user:", http.StatusOK},
+		{"GET", "/api/v1/keys?prefix=a/b", http.StatusBadRequest},
+		{"GET", "/api/v1/keys?limit=5000", http.StatusBadRequest},
+		{"DELETE", "/api/v1/some-data?key=user:1", http.StatusNoContent},
+		{"DELETE", "/api/v1/some-data?key=user:1", http.StatusNotFound},
+		{"DELETE", "/api/v1/some-data", http.StatusBadRequest},
+	} {
+		if rw := send(tc.method, tc.target); rw.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d %s", tc.method, tc.target, tc.status, rw.Code, rw.Body)
+		}
+	}
+
+	var list dbclient.KeyList
+	if err := json.Unmarshal(send("GET", "/api/v1/keys").Body.Bytes(), &list); err != nil || len(list.Keys) != 2 {
+		t.Errorf("unexpected list %+v, %v", list, err)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	maxConcurrent   = flags.Int("max-concurrent", 256, "some-data requests handled at once, more are rejected with 503 (0 disables the limit)")
+	limitRetryAfter = flags.Duration("limit-retry-after", time.Second, "Retry-After of the requests rejected by -max-concurrent")
+)
+
+// concurrencyLimit returns a middleware letting at most limit requests
+// through to the handlers it wraps, all of them sharing the slots.
+// Requests beyond it are rejected right away rather than queued: with a
+// response delay configured, waiting ones would pile up without bound.
+func concurrencyLimit(limit int) func(http.Handler) http.Handler {
+	if limit <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	slots := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(rw, r)
+			default:
+				rw.Header().Set("Retry-After", strconv.Itoa(int(max(limitRetryAfter.Seconds(), 1))))
+				writeJSONError(rw, http.StatusServiceUnavailable, "too many concurrent requests")
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	limit := concurrencyLimit(2)
+	handler := limit(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		entered <- struct{}{}
+		<-release
+		rw.WriteHeader(http.StatusOK)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+		return rw
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rw := serve(); rw.Code != http.StatusOK {
+				t.Errorf("expected 200, got %d", rw.Code)
+			}
+		}()
+		<-entered
+	}
+
+	rw := serve()
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After beyond the limit, got %d %v", rw.Code, rw.Header())
+	}
+	// Other handlers wrapped by the same limit share its slots.
+	other := httptest.NewRecorder()
+	limit(http.NotFoundHandler()).ServeHTTP(other, httptest.NewRequest("POST", "/api/v1/some-data", nil))
+	if other.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the slots to be shared, got %d", other.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	go func() { <-entered }()
+	if rw := serve(); rw.Code != http.StatusOK {
+		t.Errorf("expected a free slot after the requests finished, got %d", rw.Code)
+	}
+}
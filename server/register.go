@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+var (
+	registerTimeout  = flags.Duration("register-timeout", time.Minute, "how long the server retries registering in the db before it gives up")
+	registerDegraded = flags.Bool("register-degraded", false, "start serving right away and complete the registration in the background")
+)
+
+const (
+	initialRegisterBackoff = 200 * time.Millisecond
+	maxRegisterBackoff     = 10 * time.Second
+)
+
+// register writes the seed entries, by default the team name with the
+// current date, to the db.
+func register(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "register", tracing.KindInternal)
+	defer span.End()
+	items, err := expandSeeds(seeds, newSeedVars(time.Now()))
+	if err == nil {
+		span.SetAttribute("seed.entries", len(items))
+		err = applySeeds(ctx, items)
+	}
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	registered.Store(true)
+	return nil
+}
+
+// registerWithRetry retries the registration with exponential backoff
+// until it succeeds or ctx is done.
+func registerWithRetry(ctx context.Context) error {
+	backoff := initialRegisterBackoff
+	for {
+		err := register(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Registration in the db failed, retrying in %s: %s", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("giving up on the registration: %w", err)
+		}
+		backoff = min(2*backoff, maxRegisterBackoff)
+	}
+}
+
+// startRegistration registers the server, either before it starts
+// serving or, in the degraded mode, in the background.
+func startRegistration() error {
+	if *registerDegraded {
+		go func() {
+			_ = registerWithRetry(context.Background())
+			log.Printf("Registered %s in the db", *teamName)
+		}()
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *registerTimeout)
+	defer cancel()
+	return registerWithRetry(ctx)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestRegisterWithRetry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/db/team" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if attempts.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client, team string) { db, *teamName = c, team }(db, *teamName)
+	db, *teamName = dbclient.New(server.URL+"/db", dbclient.Options{}), "team"
+
+	if err := registerWithRetry(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	attempts.Store(-100)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := registerWithRetry(ctx); err == nil {
+		t.Error("expected the registration to give up")
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const reportMaxLen = 100
+
+// AuthorStats summarizes the requests made by an author.
+type AuthorStats struct {
+	Requests  int            `json:"requests"`
+	FirstSeen time.Time      `json:"firstSeen"`
+	LastSeen  time.Time      `json:"lastSeen"`
+	Statuses  map[string]int `json:"statuses"`
+}
+
+// Report lists the authors of the latest requests, oldest first, with
+// their statistics. It is safe for concurrent use.
+type Report struct {
+	mu       sync.Mutex
+	Requests []string                `json:"requests"`
+	Authors  map[string]*AuthorStats `json:"authors"`
+
+	subscribers map[chan ReportEvent]struct{}
+}
+
+// ReportEvent tells about a processed request: the new statistics of its
+// author and the author evicted to make room for it, if any.
+type ReportEvent struct {
+	Author  string      `json:"author"`
+	Stats   AuthorStats `json:"stats"`
+	Evicted string      `json:"evicted,omitempty"`
+}
+
+// reportEventBuffer is the number of events a subscriber may fall behind
+// before it is dropped.
+const reportEventBuffer = 64
+
+func (r *Report) Process(req *http.Request, status int) {
+	author := req.Header.Get("lb-author")
+	log.Printf("GET some-data from [%s] request", author)
+	if author == "" {
+		author = "unknown"
+	}
+	if team := req.Header.Get("lb-team"); team != "" {
+		author = team + "/" + author
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Authors == nil {
+		r.Authors = make(map[string]*AuthorStats)
+	}
+	event := ReportEvent{Author: author}
+	stats, ok := r.Authors[author]
+	if !ok {
+		if len(r.Requests) >= reportMaxLen {
+			event.Evicted = r.Requests[0]
+			delete(r.Authors, r.Requests[0])
+			r.Requests = r.Requests[1:]
+		}
+		r.Requests = append(r.Requests, author)
+		stats = &AuthorStats{FirstSeen: now, Statuses: make(map[string]int)}
+		r.Authors[author] = stats
+	}
+	stats.Requests++
+	stats.LastSeen = now
+	stats.Statuses[strconv.Itoa(status)]++
+	if len(r.subscribers) > 0 {
+		event.Stats = *stats
+		event.Stats.Statuses = maps.Clone(stats.Statuses)
+		r.publish(event)
+	}
+}
+
+// publish sends the event to the subscribers, closing the channels of
+// those too slow to keep up so that they can start over.
+func (r *Report) publish(event ReportEvent) {
+	for ch := range r.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the JSON of the current report and a channel of the
+// changes that follow it. The channel is closed when the subscriber falls
+// behind or cancel is called.
+func (r *Report) Subscribe() (snapshot []byte, events <-chan ReportEvent, cancel func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot, _ = json.Marshal(r)
+	ch := make(chan ReportEvent, reportEventBuffer)
+	if r.subscribers == nil {
+		r.subscribers = make(map[chan ReportEvent]struct{})
+	}
+	r.subscribers[ch] = struct{}{}
+	return snapshot, ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subscribers[ch]; ok {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscriberCount is the number of report streams being served.
+func (r *Report) subscriberCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.subscribers)
+}
+
+// latest copies the statistics of the authors, newest first.
+func (r *Report) latest() []AuthorRow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rows := make([]AuthorRow, 0, len(r.Requests))
+	for i := len(r.Requests) - 1; i >= 0; i-- {
+		stats := *r.Authors[r.Requests[i]]
+		stats.Statuses = maps.Clone(stats.Statuses)
+		rows = append(rows, AuthorRow{Name: r.Requests[i], AuthorStats: stats})
+	}
+	return rows
+}
+
+// reportQuery selects a page of the authors matching its filters.
+type reportQuery struct {
+	authors      []string
+	since, until time.Time
+	offset       int
+	limit        int
+}
+
+func parseReportQuery(values url.Values) (reportQuery, error) {
+	q := reportQuery{authors: values["author"]}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		if s := values.Get(bound.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+			}
+			*bound.t = t
+		}
+	}
+	for _, param := range []struct {
+		name string
+		n    *int
+		min  int
+	}{{"offset", &q.offset, 0}, {"limit", &q.limit, 1}} {
+		if s := values.Get(param.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < param.min {
+				return q, fmt.Errorf("%s must be an integer of at least %d", param.name, param.min)
+			}
+			*param.n = n
+		}
+	}
+	return q, nil
+}
+
+// matches reports whether the author made requests within the time range.
+func (q reportQuery) matches(author string, stats *AuthorStats) bool {
+	if len(q.authors) > 0 && !slices.Contains(q.authors, author) {
+		return false
+	}
+	if !q.since.IsZero() && stats.LastSeen.Before(q.since) {
+		return false
+	}
+	return q.until.IsZero() || !stats.FirstSeen.After(q.until)
+}
+
+// ReportPage is the part of the report selected by a query, oldest author
+// first. Total counts the matching authors, Next is the offset of the
+// following page, if there is one.
+type ReportPage struct {
+	Requests []string                `json:"requests"`
+	Authors  map[string]*AuthorStats `json:"authors"`
+	Total    int                     `json:"total"`
+	Next     *int                    `json:"next,omitempty"`
+}
+
+func (r *Report) page(q reportQuery) ReportPage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matching []string
+	for _, author := range r.Requests {
+		if q.matches(author, r.Authors[author]) {
+			matching = append(matching, author)
+		}
+	}
+	page := ReportPage{Requests: []string{}, Authors: make(map[string]*AuthorStats), Total: len(matching)}
+	end := len(matching)
+	if q.limit > 0 && q.offset+q.limit < end {
+		end = q.offset + q.limit
+		page.Next = &end
+	}
+	for _, author := range matching[min(q.offset, end):end] {
+		stats := *r.Authors[author]
+		stats.Statuses = maps.Clone(stats.Statuses)
+		page.Requests = append(page.Requests, author)
+		page.Authors[author] = &stats
+	}
+	return page
+}
+
+// ServeHTTP responds with the report. The query may filter it by author
+// (repeatable), since and until (RFC 3339 times the author was active
+// within) and select a page of it with offset and limit.
+func (r *Report) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	q, err := parseReportQuery(req.URL.Query())
+	if err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	page := r.page(q)
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(page)
+}
+
+// Reset forgets every author. The report streams are ended, so that their
+// clients reconnect to the empty report.
+func (r *Report) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests = nil
+	r.Authors = nil
+	for ch := range r.subscribers {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+}
+
+// serveReportReset resets the report for authorized requests, e.g.
+// between test runs.
+func serveReportReset(report *Report) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !authorized(rw, r) {
+			return
+		}
+		report.Reset()
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// statusRecorder remembers the status written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
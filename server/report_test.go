@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReportStatistics(t *testing.T) {
+	report := new(Report)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+			r.Header.Set("lb-author", "lb-1")
+			r.Header.Set("lb-team", "team")
+			status := http.StatusOK
+			if i%4 == 0 {
+				status = http.StatusNotFound
+			}
+			report.Process(r, status)
+		}()
+	}
+	wg.Wait()
+	report.Process(httptest.NewRequest("GET", "/api/v1/some-data", nil), http.StatusOK)
+
+	rw := httptest.NewRecorder()
+	report.ServeHTTP(rw, httptest.NewRequest("GET", "/report", nil))
+	var got struct {
+		Requests []string               `json:"requests"`
+		Authors  map[string]AuthorStats `json:"authors"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Requests) != "[team/lb-1 unknown]" {
+		t.Errorf("unexpected authors %v", got.Requests)
+	}
+	stats := got.Authors["team/lb-1"]
+	if stats.Requests != 20 || stats.Statuses["200"] != 15 || stats.Statuses["404"] != 5 {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+	if stats.FirstSeen.After(stats.LastSeen) {
+		t.Errorf("first seen %s after last seen %s", stats.FirstSeen, stats.LastSeen)
+	}
+}
+
+func TestReportEvictsOldestAuthors(t *testing.T) {
+	report := new(Report)
+	for i := 0; i <= reportMaxLen; i++ {
+		r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		r.Header.Set("lb-author", fmt.Sprint("lb-", i))
+		report.Process(r, http.StatusOK)
+	}
+	if len(report.Requests) != reportMaxLen || len(report.Authors) != reportMaxLen {
+		t.Fatalf("expected %d authors, got %d", reportMaxLen, len(report.Requests))
+	}
+	if _, ok := report.Authors["lb-0"]; ok || report.Requests[0] != "lb-1" {
+		t.Errorf("the oldest author was not evicted: %v", report.Requests[:2])
+	}
+}
+
+func TestReportQuery(t *testing.T) {
+	report := new(Report)
+	for _, author := range []string{"lb-1", "lb-2", "lb-3", "lb-4"} {
+		r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		r.Header.Set("lb-author", author)
+		report.Process(r, http.StatusOK)
+	}
+	// lb-1 was active long ago.
+	report.Authors["lb-1"].FirstSeen = time.Now().Add(-2 * time.Hour)
+	report.Authors["lb-1"].LastSeen = time.Now().Add(-time.Hour)
+	since := time.Now().Add(-time.Minute).Format(time.RFC3339)
+
+	query := func(rawQuery string) (int, ReportPage) {
+		rw := httptest.NewRecorder()
+		report.ServeHTTP(rw, httptest.NewRequest("GET", "/report?"+rawQuery, nil))
+		var page ReportPage
+		_ = json.Unmarshal(rw.Body.Bytes(), &page)
+		return rw.Code, page
+	}
+
+	for rawQuery, expected := range map[string]string{
+		"":                                "[lb-1 lb-2 lb-3 lb-4] 4 <nil>",
+		"limit=2":                         "[lb-1 lb-2] 4 2",
+		"limit=2&offset=2":                "[lb-3 lb-4] 4 <nil>",
+		"offset=10":                       "[] 4 <nil>",
+		"author=lb-2&author=lb-4":         "[lb-2 lb-4] 2 <nil>",
+		"since=" + since:                  "[lb-2 lb-3 lb-4] 3 <nil>",
+		"since=" + since + "&limit=1":     "[lb-2] 3 1",
+		"until=" + since + "&author=lb-1": "[lb-1] 1 <nil>",
+	} {
+		code, page := query(rawQuery)
+		next := "<nil>"
+		if page.Next != nil {
+			next = fmt.Sprint(*page.Next)
+		}
+		if got := fmt.Sprint(page.Requests, " ", page.Total, " ", next); code != http.StatusOK || got != expected {
+			t.Errorf("%q: expected %s, got %d %s", rawQuery, expected, code, got)
+		}
+		if len(page.Authors) != len(page.Requests) {
+			t.Errorf("%q: statistics of %d authors for %d requests", rawQuery, len(page.Authors), len(page.Requests))
+		}
+	}
+	for _, rawQuery := range []string{"limit=0", "offset=-1", "since=yesterday"} {
+		if code, _ := query(rawQuery); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", rawQuery, code)
+		}
+	}
+}
+
+func TestReportReset(t *testing.T) {
+	defer func(tokens []string) { apiTokens = tokens }(apiTokens)
+	apiTokens = []string{"secret"}
+	report := new(Report)
+	report.Process(httptest.NewRequest("GET", "/api/v1/some-data", nil), http.StatusOK)
+	_, events, cancel := report.Subscribe()
+	defer cancel()
+
+	reset := func(token string) int {
+		r := httptest.NewRequest("DELETE", "/report", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		serveReportReset(report)(rw, r)
+		return rw.Code
+	}
+	if code := reset("wrong"); code != http.StatusForbidden || len(report.Requests) != 1 {
+		t.Fatalf("expected an unauthorized reset to be rejected, got %d", code)
+	}
+	if code := reset("secret"); code != http.StatusNoContent || len(report.Requests) != 0 || len(report.Authors) != 0 {
+		t.Fatalf("expected the report reset, got %d %v", code, report.Requests)
+	}
+	if _, ok := <-events; ok {
+		t.Error("expected the report streams to be ended")
+	}
+	report.Process(httptest.NewRequest("GET", "/api/v1/some-data", nil), http.StatusOK)
+	if report.Authors["unknown"].Requests != 1 {
+		t.Error("expected the counters to start over")
+	}
+}
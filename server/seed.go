@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+const seedFileEnv = "SEED_FILE"
+
+var seedFile = flags.String("seed-file", envOr(seedFileEnv, ""), "JSON file with the entries written to the db at startup, overrides $"+seedFileEnv+" (by default the team name with the current date)")
+
+const (
+	// maxSeedEntries bounds the entries a seed file expands to.
+	maxSeedEntries = 10000
+	seedDateLayout = "2006-01-02"
+	seedDbTimeout  = 5 * time.Second
+)
+
+// SeedEntry is written to the db at startup. Key and Value are templates
+// (text/template) of seedVars, expanded Count times, once if it is 0.
+// Keys already in the db are kept unless Overwrite is set, so seeding
+// again on every start does not undo changes made through the API.
+type SeedEntry struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Count     int    `json:"count"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// seedVars are the data of the seed templates, e.g. "item-{{.Index}}".
+type seedVars struct {
+	Team     string
+	Hostname string
+	Date     string
+	Now      time.Time
+	Index    int
+}
+
+func newSeedVars(now time.Time) seedVars {
+	hostname, _ := os.Hostname()
+	return seedVars{Team: *teamName, Hostname: hostname, Date: now.Format(seedDateLayout), Now: now}
+}
+
+// defaultSeed registers the team with the date the server started.
+var defaultSeed = []SeedEntry{{Key: "{{.Team}}", Value: "{{.Date}}", Overwrite: true}}
+
+// seeds are written by register. They are replaced in run with the
+// entries of -seed-file.
+var seeds = defaultSeed
+
+// loadSeeds reads the seed file, either an array of SeedEntry or an
+// object of plain keys and values, and checks that it expands to valid
+// keys.
+func loadSeeds(path string) ([]SeedEntry, error) {
+	if path == "" {
+		return defaultSeed, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the seed file: %w", err)
+	}
+	var entries []SeedEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var values map[string]string
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("invalid seed file: %w", err)
+		}
+		for key, value := range values {
+			entries = append(entries, SeedEntry{Key: key, Value: value})
+		}
+		slices.SortFunc(entries, func(a, b SeedEntry) int { return strings.Compare(a.Key, b.Key) })
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid seed file: %w", err)
+	}
+	if _, err := expandSeeds(entries, newSeedVars(time.Now())); err != nil {
+		return nil, fmt.Errorf("invalid seed file: %w", err)
+	}
+	return entries, nil
+}
+
+type seedItem struct {
+	key, value string
+	overwrite  bool
+}
+
+func expandSeeds(entries []SeedEntry, vars seedVars) ([]seedItem, error) {
+	var items []seedItem
+	for i, entry := range entries {
+		if entry.Count < 0 {
+			return nil, fmt.Errorf("entry %d: count cannot be negative", i)
+		}
+		if len(items)+max(entry.Count, 1) > maxSeedEntries {
+			return nil, fmt.Errorf("more than %d entries", maxSeedEntries)
+		}
+		keyTmpl, err := template.New("key").Parse(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		valueTmpl, err := template.New("value").Parse(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		for index := range max(entry.Count, 1) {
+			vars.Index = index
+			var key, value strings.Builder
+			if err := keyTmpl.Execute(&key, vars); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			if err := valueTmpl.Execute(&value, vars); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			if !validKey(key.String()) {
+				return nil, fmt.Errorf("entry %d: invalid key %q", i, key.String())
+			}
+			items = append(items, seedItem{key.String(), value.String(), entry.Overwrite})
+		}
+	}
+	return items, nil
+}
+
+// applySeeds writes the items to the db, skipping the existing keys that
+// are not to be overwritten.
+func applySeeds(ctx context.Context, items []seedItem) error {
+	for _, item := range items {
+		if err := applySeed(ctx, item); err != nil {
+			return fmt.Errorf("seeding %s: %w", item.key, err)
+		}
+	}
+	return nil
+}
+
+func applySeed(ctx context.Context, item seedItem) error {
+	ctx, cancel := context.WithTimeout(ctx, seedDbTimeout)
+	defer cancel()
+	if item.overwrite {
+		_, err := db.Put(ctx, item.key, item.value)
+		return err
+	}
+	// The key is created only if it is missing, so that the replicas
+	// seeding at once keep whichever value was written first.
+	if err := db.Create(ctx, item.key, item.value); !errors.Is(err, dbclient.ErrExists) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func writeSeedFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "seed.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSeeds(t *testing.T) {
+	defer func(team string) { *teamName = team }(*teamName)
+	*teamName = "team"
+	vars := newSeedVars(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	entries, err := loadSeeds(writeSeedFile(t, `[
+		{"key": "{{.Team}}", "value": "{{.Date}}", "overwrite": true},
+		{"key": "item-{{.Index}}", "value": "value {{.Index}} of {{.Team}}", "count": 3}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := expandSeeds(entries, vars)
+	if got := fmt.Sprint(items); got != "[{team 2024-05-01 true} {item-0 value 0 of team false} {item-1 value 1 of team false} {item-2 value 2 of team false}]" {
+		t.Errorf("unexpected items %s", got)
+	}
+
+	entries, err = loadSeeds(writeSeedFile(t, `{"b": "2", "a": "1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := expandSeeds(entries, vars); fmt.Sprint(items) != "[{a 1 false} {b 2 false}]" {
+		t.Errorf("unexpected items %v", items)
+	}
+
+	if entries, err := loadSeeds(""); err != nil || len(entries) != 1 || !entries[0].Overwrite {
+		t.Errorf("expected the default seed, got %v, %v", entries, err)
+	}
+
+	for _, content := range []string{
+		`[{"key": "{{.Missing}}", "value": "v"}]`,
+		`[{"key": "{{.Index", "value": "v"}]`,
+		`[{"key": "a/{{.Index}}", "value": "v"}]`,
+		`[{"key": "k", "value": "v", "count": -1}]`,
+		`[{"key": "k{{.Index}}", "value": "v", "count": 10001}]`,
+		`{"key": 1}`,
+	} {
+		if _, err := loadSeeds(writeSeedFile(t, content)); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestApplySeeds(t *testing.T) {
+	var mu sync.Mutex
+	stored := map[string]string{"kept": "changed through the API"}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch r.Method {
+		case "GET":
+			value, ok := stored[key]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(dbclient.Entry{Key: key, Value: value})
+		case "POST":
+			if _, ok := stored[key]; ok {
+				rw.WriteHeader(http.StatusConflict)
+				return
+			}
+			var entry dbclient.Entry
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &entry)
+			stored[key] = entry.Value
+			rw.WriteHeader(http.StatusCreated)
+		case "PUT":
+			var entry dbclient.Entry
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &entry)
+			stored[key] = entry.Value
+			rw.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client) { db = c }(db)
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+
+	items := []seedItem{{"kept", "seed", false}, {"new", "seed", false}, {"replaced", "seed", true}}
+	stored["replaced"] = "old"
+	for i := 0; i < 2; i++ {
+		if err := applySeeds(context.Background(), items); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stored["kept"] != "changed through the API" || stored["new"] != "seed" || stored["replaced"] != "seed" {
+		t.Errorf("unexpected db contents %v", stored)
+	}
+}
@@ -0,0 +1,165 @@
+// Package server serves the some-data API of the team, backed by the db,
+// with its reports and admin endpoints. It is run by cmd/server and, in
+// process, by the integration tests.
+package server
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/chaos"
+	"github.com/roman-mazur/architecture-practice-4-template/config"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/metrics"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+)
+
+// flags are the settings of the server, parsed from the command line by
+// Main and from the arguments by Serve.
+var flags = flag.NewFlagSet("server", flag.ContinueOnError)
+
+var options = config.Options{
+	EnvPrefix: "SERVER_",
+	Secrets:   []string{"api-token"},
+	Validate:  validateConfig,
+}
+
+var (
+	port            = flags.Int("port", 8080, "server port")
+	shutdownTimeout = flags.Duration("shutdown-timeout", 30*time.Second, "how long in-flight requests may take to finish after SIGTERM")
+	gzipMinSize     = flags.Int("gzip-min-size", 1024, "smallest JSON response compressed with gzip for clients accepting it (negative disables compression)")
+
+	otlpEndpoint = flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector spans are exported to (empty disables the export)")
+	chaosConfig  = flags.String("chaos-config", os.Getenv("CHAOS_CONFIG"), "JSON file with the faults injected into the requests for resilience tests (empty disables them)")
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// Main runs the server configured by the command line until SIGINT or
+// SIGTERM.
+func Main() {
+	config.ParseSet(flags, options)
+	if err := run(signal.TerminationContext()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serve runs the server configured by args until ctx is done, serving on
+// each of the listeners instead of -port, e.g. as several servers
+// in-process in tests. The arguments are parsed into the flags of the
+// package, so Serve is called once per process.
+func Serve(ctx context.Context, args []string, listeners ...net.Listener) error {
+	if _, err := config.Load(flags, args, options); err != nil {
+		return err
+	}
+	return run(ctx, listeners...)
+}
+
+func run(ctx context.Context, listeners ...net.Listener) error {
+	tracing.Configure("server", *otlpEndpoint)
+	metrics.Configure("server")
+	initFaults()
+	db = newDb()
+	var err error
+	if apiTokens, err = loadTokens(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if seeds, err = loadSeeds(*seedFile); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	chaosRules, err := chaos.Load(*chaosConfig)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	h := new(http.ServeMux)
+
+	if err := startRegistration(); err != nil {
+		return fmt.Errorf("cannot register in the db: %w", err)
+	}
+
+	h.HandleFunc("/live", serveLive)
+	h.HandleFunc("/ready", serveReady)
+	// /health is what the balancer probes by default.
+	h.HandleFunc("/health", serveReady)
+
+	report := new(Report)
+	cache = newResponseCache(*cacheTTL, *cacheSize)
+
+	limit := concurrencyLimit(*maxConcurrent)
+	api := new(http.ServeMux)
+	api.Handle("GET /api/v1/some-data", limit(traced("read some-data", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if delay := responseDelay(); delay > 0 {
+			tracing.SpanFromContext(r.Context()).SetAttribute("response.delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		sr := &statusRecorder{ResponseWriter: rw}
+		rw = sr
+		defer func() { report.Process(r, cmp.Or(sr.status, http.StatusOK)) }()
+
+		readData(rw, r)
+	}))))
+
+	api.Handle("POST /api/v1/some-data", limit(traced("write some-data", http.HandlerFunc(writeData))))
+	api.Handle("DELETE /api/v1/some-data", limit(traced("delete some-data", http.HandlerFunc(deleteData))))
+	api.Handle("GET /api/v1/keys", traced("list keys", http.HandlerFunc(listKeys)))
+	h.Handle("/api/v1/", requireToken(api))
+
+	h.Handle("GET /report", report)
+	h.HandleFunc("DELETE /report", serveReportReset(report))
+	h.HandleFunc("GET /report/stream", serveReportStream(report))
+	h.HandleFunc("GET /status", serveStatus(report))
+	h.Handle("GET /version", version.Handler())
+	h.Handle("GET /metrics", metrics.Default)
+
+	h.HandleFunc("GET /admin/faults", serveFaults)
+	h.HandleFunc("PUT /admin/faults", updateFaults)
+
+	opts := httptools.Options{Middleware: []httptools.Middleware{
+		tracing.Middleware("server"),
+		httptools.Recover(),
+		httptools.Metrics(metrics.Default, httptools.MuxRoute(h)),
+		chaos.Middleware(chaosRules),
+		httptools.Gzip(*gzipMinSize),
+	}}
+	servers := []httptools.Server{httptools.CreateServerWith(*port, h, opts)}
+	if len(listeners) > 0 {
+		servers = servers[:0]
+		for _, l := range listeners {
+			servers = append(servers, httptools.WithListener(httptools.CreateServerWith(*port, h, opts), l))
+		}
+	}
+	for _, server := range servers {
+		server.Start()
+	}
+	if *debugPort != 0 {
+		startDebugServer(*debugPort)
+	}
+	<-ctx.Done()
+
+	// Shutdown refuses new connections right away and waits for the
+	// in-flight requests, including the delayed ones. Report streams never
+	// finish on their own, so they are ended first.
+	close(stopStreams)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("In-flight requests did not finish: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/version"
+)
+
+// statusTemplate renders statusDetails for people watching a demo, the
+// JSON of /report is meant for tools.
+const statusTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Team}} server status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.ok { color: green; } .fail { color: red; }
+</style>
+</head>
+<body>
+<h1>{{.Team}} server status</h1>
+<p>Rendered at {{.Now.Format "2006-01-02 15:04:05 MST"}}.</p>
+
+<h2>Health</h2>
+<table>
+<tr><th>Registered</th><td>{{if .Registered}}<span class="ok">yes</span>{{else}}<span class="fail">no</span>{{end}}</td></tr>
+<tr><th>Db</th><td>{{if .DbProblem}}<span class="fail">{{.DbProblem}}</span>{{else}}<span class="ok">reachable</span>{{end}}</td></tr>
+<tr><th>Response delay</th><td>{{.ResponseDelay}}</td></tr>
+<tr><th>Health failure</th><td>{{if .HealthFailure}}<span class="fail">on</span>{{else}}off{{end}}</td></tr>
+</table>
+
+<h2>Build</h2>
+<table>
+<tr><th>Version</th><td>{{.Build.Version}}</td></tr>
+<tr><th>Commit</th><td>{{or .Build.Commit "unknown"}}{{if .Build.Modified}} (modified){{end}}</td></tr>
+<tr><th>Built</th><td>{{or .Build.Date "unknown"}}</td></tr>
+<tr><th>Go</th><td>{{.Build.GoVersion}}</td></tr>
+</table>
+
+<h2>Requests</h2>
+{{if .Authors}}
+<table>
+<tr><th>Author</th><th>Requests</th><th>Statuses</th><th>First seen</th><th>Last seen</th></tr>
+{{range .Authors}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.Requests}}</td>
+<td>{{range $status, $count := .Statuses}}{{$status}}: {{$count}} {{end}}</td>
+<td>{{.FirstSeen.Format "15:04:05"}}</td>
+<td>{{.LastSeen.Format "15:04:05"}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No requests yet.</p>
+{{end}}
+</body>
+</html>
+`
+
+var statusPage = template.Must(template.New("status").Parse(statusTemplate))
+
+// AuthorRow is an author of the report with their statistics.
+type AuthorRow struct {
+	Name string
+	AuthorStats
+}
+
+type statusDetails struct {
+	Team          string
+	Now           time.Time
+	Registered    bool
+	DbProblem     string
+	ResponseDelay time.Duration
+	HealthFailure bool
+	Build         version.Info
+	Authors       []AuthorRow
+}
+
+// serveStatus renders the state of the server and its report as HTML.
+func serveStatus(report *Report) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessDbTimeout)
+		defer cancel()
+		details := statusDetails{
+			Team:          *teamName,
+			Now:           time.Now(),
+			Registered:    registered.Load(),
+			DbProblem:     dbProblem(ctx),
+			ResponseDelay: responseDelay(),
+			HealthFailure: faults.healthFailure.Load(),
+			Build:         version.Get(),
+			Authors:       report.latest(),
+		}
+		var buf bytes.Buffer
+		if err := statusPage.Execute(&buf, details); err != nil {
+			log.Printf("Cannot render the status page: %s", err)
+			http.Error(rw, "cannot render the status page", http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("content-type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(buf.Bytes())
+	}
+}
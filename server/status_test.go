@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestStatusPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client, delay time.Duration) {
+		db = c
+		faults.responseDelay.Store(int64(delay))
+	}(db, responseDelay())
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+	faults.responseDelay.Store(int64(3 * time.Second))
+
+	report := new(Report)
+	for _, author := range []string{"lb-1", "<script>"} {
+		r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		r.Header.Set("lb-author", author)
+		report.Process(r, http.StatusOK)
+	}
+
+	rw := httptest.NewRecorder()
+	serveStatus(report)(rw, httptest.NewRequest("GET", "/status", nil))
+	if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Header().Get("content-type"), "text/html") {
+		t.Fatalf("unexpected response %d %q", rw.Code, rw.Header().Get("content-type"))
+	}
+	page := rw.Body.String()
+	for _, expected := range []string{*teamName, "lb-1", "&lt;script&gt;", "3s", "200: 1", "500"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("the page does not contain %q", expected)
+		}
+	}
+	if strings.Contains(page, "<td><script>") {
+		t.Error("author names are not escaped")
+	}
+	if strings.Index(page, "&lt;script&gt;") > strings.Index(page, "lb-1") {
+		t.Error("expected the newest author first")
+	}
+}
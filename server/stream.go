@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxReportStreams bounds the connections held open by dashboards.
+	maxReportStreams = 100
+	// streamKeepAlive is the interval of comments keeping idle streams
+	// from being closed by proxies.
+	streamKeepAlive = 15 * time.Second
+)
+
+// stopStreams is closed on shutdown to end the streams, which would
+// otherwise keep the server waiting for them.
+var stopStreams = make(chan struct{})
+
+// serveReportStream sends the report and then every change to it as
+// server-sent events: a "report" event with the JSON of /report followed
+// by "request" events with a ReportEvent each. Clients falling behind are
+// disconnected and, like any EventSource, reconnect to a fresh report.
+func serveReportStream(report *Report) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if report.subscriberCount() >= maxReportStreams {
+			rw.Header().Set("Retry-After", "10")
+			writeJSONError(rw, http.StatusServiceUnavailable, "too many report streams")
+			return
+		}
+		rc := http.NewResponseController(rw)
+		// Streams outlive the write timeout of the server.
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		snapshot, events, cancel := report.Subscribe()
+		defer cancel()
+		rw.Header().Set("content-type", "text/event-stream")
+		rw.Header().Set("cache-control", "no-cache")
+		rw.WriteHeader(http.StatusOK)
+
+		id := 0
+		send := func(event string, data []byte) error {
+			id++
+			if _, err := fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		if send("report", snapshot) != nil {
+			return
+		}
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, _ := json.Marshal(event)
+				if send("request", data) != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-stopStreams:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+)
+
+func TestReportStream(t *testing.T) {
+	report := new(Report)
+	process := func(author string) {
+		r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		r.Header.Set("lb-author", author)
+		report.Process(r, http.StatusOK)
+	}
+	process("lb-1")
+
+	server := httptest.NewServer(httptools.Gzip(*gzipMinSize)(serveReportStream(report)))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected headers %v", resp.Header)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		t.Helper()
+		var event, data string
+		for lines.Scan() && lines.Text() != "" {
+			if v, ok := strings.CutPrefix(lines.Text(), "event: "); ok {
+				event = v
+			}
+			if v, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				data = v
+			}
+		}
+		return event, data
+	}
+
+	event, data := next()
+	if event != "report" || !strings.Contains(data, `"lb-1"`) {
+		t.Fatalf("expected the report first, got %s %s", event, data)
+	}
+	process("lb-2")
+	process("lb-1")
+	for _, expected := range []struct {
+		author   string
+		requests int
+	}{{"lb-2", 1}, {"lb-1", 2}} {
+		event, data := next()
+		var got ReportEvent
+		if err := json.Unmarshal([]byte(data), &got); event != "request" || err != nil {
+			t.Fatalf("unexpected event %s %s", event, data)
+		}
+		if got.Author != expected.author || got.Stats.Requests != expected.requests {
+			t.Errorf("expected %d requests of %s, got %+v", expected.requests, expected.author, got)
+		}
+	}
+}
+
+func TestReportDropsSlowSubscribers(t *testing.T) {
+	report := new(Report)
+	_, events, cancel := report.Subscribe()
+	defer cancel()
+	for i := 0; i <= reportEventBuffer; i++ {
+		report.Process(httptest.NewRequest("GET", "/api/v1/some-data", nil), http.StatusOK)
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != reportEventBuffer || report.subscriberCount() != 0 {
+		t.Errorf("expected the subscriber dropped after %d events, got %d", reportEventBuffer, n)
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+// traced records a span of the operation for the requests handled by
+// next. It is a child of the server span of the request, continuing the
+// trace of the balancer, and the parent of the spans of the db requests.
+func traced(operation string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), operation, tracing.KindInternal)
+		defer span.End()
+		if key := r.URL.Query().Get("key"); key != "" {
+			span.SetAttribute("db.key", key)
+		}
+		if id := r.Header.Get(requestIdHeader); id != "" {
+			span.SetAttribute("request.id", id)
+		}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		if result := rw.Header().Get("x-cache"); result != "" {
+			span.SetAttribute("cache", result)
+		}
+	})
+}
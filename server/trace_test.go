@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/tracing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	defer func(c *dbclient.Client, rc *responseCache) { db, cache = c, rc }(db, cache)
+	db = dbclient.New(server.URL+"/db", dbclient.Options{})
+	cache = newResponseCache(0, 0)
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil)
+	r.Header.Set("traceparent", incoming)
+	rw := httptest.NewRecorder()
+	tracing.Handler("server", traced("read some-data", http.HandlerFunc(readData))).ServeHTTP(rw, r)
+
+	parent, _ := tracing.ParseTraceparent(incoming)
+	sc, err := tracing.ParseTraceparent(received)
+	if err != nil {
+		t.Fatalf("the db request has no trace context: %q", received)
+	}
+	if sc.TraceID != parent.TraceID || sc.SpanID == parent.SpanID || !sc.Sampled {
+		t.Errorf("expected the trace of the balancer continued, got %s", received)
+	}
+}
@@ -0,0 +1,20 @@
+package signal
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// OnReload calls f every time the process receives SIGHUP.
+func OnReload(f func()) {
+	hupChannel := make(chan os.Signal, 1)
+	signal.Notify(hupChannel, syscall.SIGHUP)
+	go func() {
+		for range hupChannel {
+			log.Println("Reloading...")
+			f()
+		}
+	}()
+}
@@ -0,0 +1,20 @@
+package signal
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// OnUpgrade calls f every time the process receives SIGUSR2.
+func OnUpgrade(f func()) {
+	usr2Channel := make(chan os.Signal, 1)
+	signal.Notify(usr2Channel, syscall.SIGUSR2)
+	go func() {
+		for range usr2Channel {
+			log.Println("Upgrading...")
+			f()
+		}
+	}()
+}
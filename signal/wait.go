@@ -1,6 +1,7 @@
 package signal
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -8,8 +9,19 @@ import (
 )
 
 func WaitForTerminationSignal() {
-	intChannel := make(chan os.Signal)
+	intChannel := make(chan os.Signal, 1)
 	signal.Notify(intChannel, syscall.SIGINT, syscall.SIGTERM)
 	<-intChannel
 	log.Println("Shutting down...")
 }
+
+// TerminationContext returns a context done once the process receives
+// SIGINT or SIGTERM.
+func TerminationContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		WaitForTerminationSignal()
+		cancel()
+	}()
+	return ctx
+}
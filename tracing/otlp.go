@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+	queueLimit    = 8 * batchSize
+)
+
+// otlpExporter batches finished spans and posts them to an OTLP/HTTP
+// collector using the JSON encoding.
+type otlpExporter struct {
+	service string
+	url     string
+	client  *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+	flush   chan struct{}
+}
+
+func newOTLPExporter(service, endpoint string) *otlpExporter {
+	e := &otlpExporter{
+		service: service,
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client:  &http.Client{Timeout: 10 * time.Second},
+		flush:   make(chan struct{}, 1),
+	}
+	go e.loop()
+	return e
+}
+
+func (e *otlpExporter) export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.pending) >= queueLimit {
+		// The collector does not keep up, drop rather than grow forever.
+		return
+	}
+	e.pending = append(e.pending, s)
+	if len(e.pending) >= batchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *otlpExporter) loop() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		e.mu.Lock()
+		batch := e.pending
+		e.pending = nil
+		e.mu.Unlock()
+		if len(batch) > 0 {
+			if err := e.send(batch); err != nil {
+				log.Printf("Failed to export %d spans: %s", len(batch), err)
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) send(batch []*Span) error {
+	data, err := json.Marshal(encodeSpans(e.service, batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	statusOk    = 1
+	statusError = 2
+)
+
+func encodeSpans(service string, batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: statusOk},
+		}
+		if s.parent != (SpanID{}) {
+			span.ParentSpanID = s.parent.String()
+		}
+		for k, v := range s.attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: otlpValue{v}})
+		}
+		if s.err != nil {
+			span.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		spans = append(spans, span)
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{service}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/roman-mazur/architecture-practice-4-template/tracing"},
+			Spans: spans,
+		}},
+	}}}
+}
@@ -0,0 +1,250 @@
+// Package tracing implements a small subset of OpenTelemetry tracing:
+// W3C trace context propagation and span export over OTLP/HTTP (JSON).
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const traceparentHeader = "Traceparent"
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	var flags [1]byte
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid trace id in %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid span id in %q", s)
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, fmt.Errorf("invalid trace flags in %q", s)
+	}
+	if !sc.Valid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type SpanKind int
+
+// Span kinds as defined by OTLP.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Span is a timed operation within a trace.
+type Span struct {
+	mu         sync.Mutex
+	sc         SpanContext
+	parent     SpanID
+	name       string
+	kind       SpanKind
+	start, end time.Time
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+func (s *Span) Context() SpanContext { return s.sc }
+
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = fmt.Sprint(value)
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End finishes the span and hands it to the exporter if it is sampled.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		if e := currentExporter(); e != nil {
+			e.export(s)
+		}
+	}
+}
+
+type exporter interface {
+	export(s *Span)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exp        exporter
+)
+
+func currentExporter() exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exp
+}
+
+// Configure makes spans of the service exported to the OTLP/HTTP
+// collector at endpoint (e.g. http://collector:4318). Without an
+// endpoint trace context is still propagated, but nothing is exported.
+func Configure(service, endpoint string) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	if endpoint == "" {
+		exp = nil
+		return
+	}
+	exp = newOTLPExporter(service, endpoint)
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span stored in the context, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+type remoteKey struct{}
+
+// Start creates a span, a child of the span in the context or of the
+// remote parent extracted from an incoming request.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{name: name, kind: kind, start: time.Now(), attributes: map[string]string{}}
+	parent, hasParent := SpanContext{}, false
+	if p := SpanFromContext(ctx); p != nil {
+		parent, hasParent = p.sc, true
+	} else if p, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent, hasParent = p, true
+	}
+	if hasParent {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Extract stores the trace context of an incoming request in ctx.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(traceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject propagates the trace context of the span in ctx to an outgoing
+// request.
+func Inject(ctx context.Context, h http.Header) {
+	if s := SpanFromContext(ctx); s != nil {
+		h.Set(traceparentHeader, s.sc.Traceparent())
+	}
+}
+
+// Middleware is Handler for the middleware chains of httptools.
+func Middleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler { return Handler(name, next) }
+}
+
+// Handler wraps next, recording a server span for every request.
+func Handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(Extract(r.Context(), r.Header), name+" "+r.Method, KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%s", http.StatusText(rec.status)))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, buf, err
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	const value = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	if sc.Traceparent() != value {
+		t.Errorf("expected %s, got %s", value, sc.Traceparent())
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	}
+	for _, v := range invalid {
+		if _, err := ParseTraceparent(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	var outgoing http.Header
+	handler := Handler("server", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "db query", KindClient)
+		defer span.End()
+		outgoing = http.Header{}
+		Inject(ctx, outgoing)
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+
+	r := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	sc, err := ParseTraceparent(outgoing.Get("traceparent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id is not propagated: %s", sc.TraceID)
+	}
+	if sc.SpanID.String() == "00f067aa0ba902b7" {
+		t.Error("outgoing request must carry its own span id")
+	}
+}
+
+func TestEncodeSpans(t *testing.T) {
+	ctx, parent := Start(context.Background(), "lb GET", KindServer)
+	_, child := Start(ctx, "forward", KindClient)
+	child.SetAttribute("backend", "server1:8080")
+	child.SetError(fmt.Errorf("connection refused"))
+	child.End()
+
+	data, err := json.Marshal(encodeSpans("lb", []*Span{child}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req otlpRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != parent.Context().TraceID.String() || span.ParentSpanID != parent.Context().SpanID.String() {
+		t.Errorf("span is not linked to its parent: %+v", span)
+	}
+	if span.Kind != KindClient || span.Status.Code != statusError || span.Attributes[0].Value.StringValue != "server1:8080" {
+		t.Errorf("unexpected span %+v", span)
+	}
+	if req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "lb" {
+		t.Error("service name is not set")
+	}
+}
@@ -0,0 +1,66 @@
+// Package version describes the running build. Version, Commit and Date
+// are set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/roman-mazur/architecture-practice-4-template/version.Version=v1.2.0 \
+//	  -X github.com/roman-mazur/architecture-practice-4-template/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/roman-mazur/architecture-practice-4-template/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, the commit and its time come from the VCS information the
+// go command stamps into binaries built from a checkout.
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  string
+	Date    string
+)
+
+// Info is served as JSON by Handler.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the information about the running build.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: "unknown"}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// Handler serves the information about the running build.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("content-type", "application/json")
+		rw.Header().Set("cache-control", "no-cache")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(Get())
+	})
+}
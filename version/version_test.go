@@ -0,0 +1,22 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "v1.2.0", "abc123", "2024-05-01T10:00:00Z"
+
+	rw := httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest("GET", "/version", nil))
+	var info Info
+	if err := json.Unmarshal(rw.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "v1.2.0" || info.Commit != "abc123" || info.Date != "2024-05-01T10:00:00Z" || info.GoVersion == "" {
+		t.Errorf("unexpected info %+v", info)
+	}
+}